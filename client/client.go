@@ -0,0 +1,264 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+	"github.com/ashish-kamra/redis-clone/server"
+)
+
+type Kind int
+
+const (
+	Status Kind = iota
+	Integer
+	Bulk
+	Nil
+	Array
+	// ErrorReply only appears nested inside arrays, e.g. in EXEC replies;
+	// top-level error replies are returned by Do as an Error.
+	ErrorReply
+)
+
+type Reply struct {
+	Kind  Kind
+	Str   string
+	Int   int64
+	Elems []Reply
+}
+
+// Error is an error reply sent by the server, e.g. "ERR syntax error".
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+var ErrNil = errors.New("client: nil reply")
+
+func (r Reply) IsNil() bool {
+	return r.Kind == Nil
+}
+
+func (r Reply) Text() (string, error) {
+	switch r.Kind {
+	case Status, Bulk:
+		return r.Str, nil
+	case Integer:
+		return strconv.FormatInt(r.Int, 10), nil
+	case Nil:
+		return "", ErrNil
+	case ErrorReply:
+		return "", Error(r.Str)
+	}
+	return "", fmt.Errorf("client: unexpected array reply")
+}
+
+func (r Reply) Int64() (int64, error) {
+	switch r.Kind {
+	case Integer:
+		return r.Int, nil
+	case Status, Bulk:
+		return strconv.ParseInt(r.Str, 10, 64)
+	case Nil:
+		return 0, ErrNil
+	case ErrorReply:
+		return 0, Error(r.Str)
+	}
+	return 0, fmt.Errorf("client: unexpected array reply")
+}
+
+func (r Reply) Strings() ([]string, error) {
+	if r.Kind == Nil {
+		return nil, ErrNil
+	}
+	if r.Kind != Array {
+		return nil, fmt.Errorf("client: expected array reply")
+	}
+	strs := make([]string, len(r.Elems))
+	for i, elem := range r.Elems {
+		if elem.Kind == Nil {
+			continue
+		}
+		str, err := elem.Text()
+		if err != nil {
+			return nil, err
+		}
+		strs[i] = str
+	}
+	return strs, nil
+}
+
+// Client is a single connection to the server. It is safe for concurrent
+// use; commands are serialized on the connection.
+type Client struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *protocol.Reader
+	writer *protocol.Writer
+}
+
+// Dial connects over "tcp" or "unix".
+func Dial(ctx context.Context, network, address string) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	return New(conn), nil
+}
+
+// InProcess connects to srv over a net.Pipe, without touching the network.
+func InProcess(srv *server.Server) *Client {
+	clientConn, serverConn := net.Pipe()
+	srv.ServeConn(serverConn)
+	return New(clientConn)
+}
+
+func New(conn net.Conn) *Client {
+	return &Client{
+		conn:   conn,
+		reader: protocol.NewReader(conn),
+		writer: protocol.NewWriter(conn),
+	}
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Do sends a command and waits for its reply. If ctx is cancelled or its
+// deadline passes mid-request the connection is left unusable and should be
+// closed, as the reply can no longer be matched to its request.
+func (c *Client) Do(ctx context.Context, args ...interface{}) (Reply, error) {
+	if len(args) == 0 {
+		return Reply{}, fmt.Errorf("client: empty command")
+	}
+
+	req := make([]protocol.RESPObject, len(args))
+	for i, arg := range args {
+		str, err := toArg(arg)
+		if err != nil {
+			return Reply{}, err
+		}
+		req[i] = protocol.RESPObject{Type: protocol.BulkString, Value: str}
+	}
+
+	return c.roundTrip(ctx, &protocol.RESPObject{Type: protocol.Array, Value: req})
+}
+
+// Receive waits for the next reply without sending a command, for messages
+// pushed to a connection in pub/sub mode.
+func (c *Client) Receive(ctx context.Context) (Reply, error) {
+	return c.roundTrip(ctx, nil)
+}
+
+func (c *Client) roundTrip(ctx context.Context, req *protocol.RESPObject) (Reply, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deadline, _ := ctx.Deadline()
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return Reply{}, err
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.conn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+
+	if req != nil {
+		if err := c.writer.Write(*req); err != nil {
+			return Reply{}, ctxErr(ctx, err)
+		}
+	}
+	obj, err := c.reader.Deserialize()
+	if err != nil {
+		return Reply{}, ctxErr(ctx, err)
+	}
+	return fromRESP(obj)
+}
+
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func toArg(arg interface{}) (string, error) {
+	switch v := arg.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case time.Duration:
+		return strconv.FormatInt(v.Milliseconds(), 10), nil
+	case fmt.Stringer:
+		return v.String(), nil
+	}
+	return "", fmt.Errorf("client: unsupported argument type %T", arg)
+}
+
+func fromRESP(obj protocol.RESPObject) (Reply, error) {
+	switch obj.Type {
+	case protocol.SimpleString:
+		return Reply{Kind: Status, Str: fmt.Sprint(obj.Value)}, nil
+	case protocol.Error:
+		return Reply{}, Error(fmt.Sprint(obj.Value))
+	case protocol.Integer:
+		n, _ := obj.Value.(int64)
+		return Reply{Kind: Integer, Int: n}, nil
+	case protocol.BulkString:
+		str, ok := obj.Value.(string)
+		if !ok {
+			return Reply{Kind: Nil}, nil
+		}
+		return Reply{Kind: Bulk, Str: str}, nil
+	case protocol.Double:
+		f, _ := obj.Value.(float64)
+		return Reply{Kind: Bulk, Str: protocol.FormatDouble(f)}, nil
+	case protocol.Array, protocol.Map:
+		// A map's keys and values alternate, as in a RESP2 reply.
+		arr, ok := obj.Value.([]protocol.RESPObject)
+		if !ok {
+			return Reply{Kind: Nil}, nil
+		}
+		elems := make([]Reply, len(arr))
+		for i, item := range arr {
+			elem, err := fromRESP(item)
+			if err != nil {
+				var rerr Error
+				if errors.As(err, &rerr) {
+					elems[i] = Reply{Kind: ErrorReply, Str: string(rerr)}
+					continue
+				}
+				return Reply{}, err
+			}
+			elems[i] = elem
+		}
+		return Reply{Kind: Array, Elems: elems}, nil
+	}
+	return Reply{Kind: Nil}, nil
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+var (
+	host     = flag.String("h", "127.0.0.1", "Server hostname")
+	port     = flag.String("p", "6379", "Server port")
+	clients  = flag.Int("c", 50, "Number of parallel connections")
+	requests = flag.Int("n", 100000, "Total number of requests per test")
+	pipeline = flag.Int("P", 1, "Pipeline <numreq> requests per round trip")
+	keyspace = flag.Int("r", 0, "Use random keys in the range [0, keyspace) instead of a single key")
+	dataSize = flag.Int("d", 3, "Data size of SET/HSET values in bytes")
+	tests    = flag.String("t", "ping,set,get,hset,hget", "Comma separated list of tests to run")
+	mix      = flag.String("mix", "", "Run a single mixed workload instead, e.g. get=8,set=2")
+	quiet    = flag.Bool("q", false, "Quiet. Just show throughput and latency percentiles")
+)
+
+type commandFunc func(rng *rand.Rand) []string
+
+var value string
+
+func randKey(rng *rand.Rand, prefix string) string {
+	n := 0
+	if *keyspace > 0 {
+		n = rng.Intn(*keyspace)
+	}
+	return fmt.Sprintf("%s%012d", prefix, n)
+}
+
+var commands = map[string]commandFunc{
+	"ping": func(rng *rand.Rand) []string { return []string{"PING"} },
+	"set":  func(rng *rand.Rand) []string { return []string{"SET", randKey(rng, "key:"), value} },
+	"get":  func(rng *rand.Rand) []string { return []string{"GET", randKey(rng, "key:")} },
+	"hset": func(rng *rand.Rand) []string { return []string{"HSET", "myhash", randKey(rng, "element:"), value} },
+	"hget": func(rng *rand.Rand) []string { return []string{"HGET", "myhash", randKey(rng, "element:")} },
+}
+
+type weighted struct {
+	name   string
+	fn     commandFunc
+	weight int
+}
+
+// workload picks the next command, either a single test or a weighted mix.
+type workload struct {
+	name    string
+	choices []weighted
+	total   int
+}
+
+func (w *workload) next(rng *rand.Rand) []string {
+	if len(w.choices) == 1 {
+		return w.choices[0].fn(rng)
+	}
+	n := rng.Intn(w.total)
+	for _, c := range w.choices {
+		if n < c.weight {
+			return c.fn(rng)
+		}
+		n -= c.weight
+	}
+	return w.choices[len(w.choices)-1].fn(rng)
+}
+
+func parseMix(spec string) (*workload, error) {
+	w := &workload{name: "MIX(" + spec + ")"}
+	for _, part := range strings.Split(spec, ",") {
+		name, weightStr, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			weightStr = "1"
+		}
+		fn, found := commands[strings.ToLower(name)]
+		if !found {
+			return nil, fmt.Errorf("unknown command in mix: %s", name)
+		}
+		weight, err := strconv.Atoi(weightStr)
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("invalid weight for %s: %s", name, weightStr)
+		}
+		w.choices = append(w.choices, weighted{name: name, fn: fn, weight: weight})
+		w.total += weight
+	}
+	return w, nil
+}
+
+func main() {
+	log.SetFlags(0)
+	flag.Parse()
+
+	if *clients <= 0 || *requests <= 0 || *pipeline <= 0 {
+		log.Fatalf("Error: -c, -n and -P must be positive")
+	}
+	value = strings.Repeat("x", *dataSize)
+
+	var workloads []*workload
+	if *mix != "" {
+		w, err := parseMix(*mix)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		workloads = append(workloads, w)
+	} else {
+		for _, name := range strings.Split(*tests, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			fn, ok := commands[name]
+			if !ok {
+				log.Fatalf("Error: unknown test: %s", name)
+			}
+			workloads = append(workloads, &workload{name: strings.ToUpper(name), choices: []weighted{{name: name, fn: fn, weight: 1}}, total: 1})
+		}
+	}
+
+	addr := net.JoinHostPort(*host, *port)
+	for _, w := range workloads {
+		res, err := run(addr, w)
+		if err != nil {
+			log.Fatalf("Error running %s: %v", w.name, err)
+		}
+		res.report(w.name)
+	}
+}
+
+type result struct {
+	elapsed   time.Duration
+	latencies []time.Duration
+	errors    int64
+}
+
+func run(addr string, w *workload) (*result, error) {
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		remaining = int64(*requests)
+		res       = &result{}
+		firstErr  error
+	)
+
+	conns := make([]net.Conn, *clients)
+	for i := range conns {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			for _, c := range conns[:i] {
+				c.Close()
+			}
+			return nil, err
+		}
+		conns[i] = conn
+	}
+
+	start := time.Now()
+	for i, conn := range conns {
+		wg.Add(1)
+		go func(seed int64, conn net.Conn) {
+			defer wg.Done()
+			defer conn.Close()
+			latencies, errors, err := runClient(conn, w, rand.New(rand.NewSource(seed)), &remaining)
+
+			mu.Lock()
+			defer mu.Unlock()
+			res.latencies = append(res.latencies, latencies...)
+			res.errors += errors
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}(time.Now().UnixNano()+int64(i), conn)
+	}
+	wg.Wait()
+	res.elapsed = time.Since(start)
+
+	return res, firstErr
+}
+
+// runClient claims batches of up to -P requests from the shared budget,
+// writes the whole batch in one go and waits for all of its replies. Every
+// request in a batch is charged the batch's round trip time, as
+// redis-benchmark does.
+func runClient(conn net.Conn, w *workload, rng *rand.Rand, remaining *int64) ([]time.Duration, int64, error) {
+	writer := bufio.NewWriter(conn)
+	reader := protocol.NewReader(conn)
+	var latencies []time.Duration
+	var errors int64
+
+	for {
+		batch := int64(*pipeline)
+		left := atomic.AddInt64(remaining, -batch)
+		if left <= -batch {
+			return latencies, errors, nil
+		}
+		if left < 0 {
+			batch += left
+		}
+
+		start := time.Now()
+		for i := int64(0); i < batch; i++ {
+			args := w.next(rng)
+			req := make([]protocol.RESPObject, len(args))
+			for j, arg := range args {
+				req[j] = protocol.RESPObject{Type: protocol.BulkString, Value: arg}
+			}
+			if _, err := writer.WriteString(protocol.RESPObject{Type: protocol.Array, Value: req}.Serialize()); err != nil {
+				return latencies, errors, err
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			return latencies, errors, err
+		}
+
+		for i := int64(0); i < batch; i++ {
+			reply, err := reader.Deserialize()
+			if err != nil {
+				return latencies, errors, err
+			}
+			if reply.Type == protocol.Error {
+				errors++
+			}
+		}
+		latency := time.Since(start)
+		for i := int64(0); i < batch; i++ {
+			latencies = append(latencies, latency)
+		}
+	}
+}
+
+func (r *result) report(name string) {
+	sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+	total := len(r.latencies)
+	if total == 0 {
+		fmt.Printf("%s: no requests completed\n", name)
+		return
+	}
+
+	rps := float64(total) / r.elapsed.Seconds()
+	percentile := func(p float64) time.Duration {
+		idx := int(float64(total)*p/100+0.5) - 1
+		if idx < 0 {
+			idx = 0
+		}
+		if idx >= total {
+			idx = total - 1
+		}
+		return r.latencies[idx]
+	}
+	msec := func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+	}
+
+	if *quiet {
+		fmt.Printf("%s: %.2f requests per second, p50=%s msec, p99=%s msec\n", name, rps, msec(percentile(50)), msec(percentile(99)))
+		return
+	}
+
+	fmt.Printf("====== %s ======\n", name)
+	fmt.Printf("  %d requests completed in %.2f seconds\n", total, r.elapsed.Seconds())
+	fmt.Printf("  %d parallel clients\n", *clients)
+	fmt.Printf("  %d bytes payload\n", *dataSize)
+	fmt.Printf("  %d pipeline depth\n", *pipeline)
+	if r.errors > 0 {
+		fmt.Printf("  %d error replies\n", r.errors)
+	}
+	fmt.Println()
+	fmt.Println("Latency by percentile distribution (msec):")
+	for _, p := range []float64{50, 90, 95, 99, 99.9, 100} {
+		fmt.Printf("  %6.2f%% <= %s\n", p, msec(percentile(p)))
+	}
+	fmt.Println()
+	fmt.Printf("Throughput: %.2f requests per second\n\n", rps)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+func formatReply(obj protocol.RESPObject, raw bool) string {
+	if raw {
+		return formatRaw(obj)
+	}
+	return formatPretty(obj, "")
+}
+
+// formatPretty mirrors redis-cli's TTY output: quoted bulk strings, type hints
+// for integers, errors and nils, and numbered, indented nested arrays.
+func formatPretty(obj protocol.RESPObject, prefix string) string {
+	switch obj.Type {
+	case protocol.SimpleString:
+		return fmt.Sprintf("%v\n", obj.Value)
+	case protocol.Error:
+		return fmt.Sprintf("(error) %v\n", obj.Value)
+	case protocol.Integer:
+		return fmt.Sprintf("(integer) %v\n", obj.Value)
+	case protocol.BulkString:
+		str, ok := obj.Value.(string)
+		if !ok {
+			return "(nil)\n"
+		}
+		return quoteRepr(str) + "\n"
+	case protocol.Null:
+		return "(nil)\n"
+	case protocol.Double:
+		f, _ := obj.Value.(float64)
+		return fmt.Sprintf("(double) %s\n", protocol.FormatDouble(f))
+	case protocol.Array:
+		arr, ok := obj.Value.([]protocol.RESPObject)
+		if !ok {
+			return "(nil)\n"
+		}
+		if len(arr) == 0 {
+			return "(empty array)\n"
+		}
+		var sb strings.Builder
+		width := len(strconv.Itoa(len(arr)))
+		for i, item := range arr {
+			if i > 0 {
+				sb.WriteString(prefix)
+			}
+			idx := fmt.Sprintf("%*d) ", width, i+1)
+			sb.WriteString(idx)
+			sb.WriteString(formatPretty(item, prefix+strings.Repeat(" ", len(idx))))
+		}
+		return sb.String()
+	case protocol.Map:
+		// Like redis-cli: 1# "key" => value
+		pairs, _ := obj.Value.([]protocol.RESPObject)
+		if len(pairs) < 2 {
+			return "(empty hash)\n"
+		}
+		var sb strings.Builder
+		width := len(strconv.Itoa(len(pairs) / 2))
+		for i := 0; i+1 < len(pairs); i += 2 {
+			if i > 0 {
+				sb.WriteString(prefix)
+			}
+			idx := fmt.Sprintf("%*d# ", width, i/2+1)
+			key := strings.TrimSuffix(formatPretty(pairs[i], ""), "\n")
+			sb.WriteString(idx + key + " => ")
+			sb.WriteString(formatPretty(pairs[i+1], prefix+strings.Repeat(" ", len(idx))))
+		}
+		return sb.String()
+	}
+	return fmt.Sprintf("%v\n", obj.Value)
+}
+
+func formatRaw(obj protocol.RESPObject) string {
+	switch obj.Type {
+	case protocol.BulkString:
+		str, ok := obj.Value.(string)
+		if !ok {
+			return "\n"
+		}
+		return str + "\n"
+	case protocol.Null:
+		return "\n"
+	case protocol.Double:
+		f, _ := obj.Value.(float64)
+		return protocol.FormatDouble(f) + "\n"
+	case protocol.Array, protocol.Map:
+		arr, _ := obj.Value.([]protocol.RESPObject)
+		var sb strings.Builder
+		for _, item := range arr {
+			sb.WriteString(formatRaw(item))
+		}
+		return sb.String()
+	}
+	return fmt.Sprintf("%v\n", obj.Value)
+}
+
+func quoteRepr(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '\\', '"':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\n':
+			sb.WriteString("\\n")
+		case '\r':
+			sb.WriteString("\\r")
+		case '\t':
+			sb.WriteString("\\t")
+		case '\a':
+			sb.WriteString("\\a")
+		case '\b':
+			sb.WriteString("\\b")
+		default:
+			if c < 0x20 || c >= 0x7f {
+				fmt.Fprintf(&sb, "\\x%02x", c)
+			} else {
+				sb.WriteByte(c)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// splitArgs tokenizes a command line the way redis-cli does: whitespace
+// separated words, "double quoted" strings with C-style and \xHH escapes,
+// and 'single quoted' strings where only \' is special.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i >= len(line) {
+			return args, nil
+		}
+
+		var cur strings.Builder
+		inDouble, inSingle, done := false, false, false
+		for !done {
+			if inDouble {
+				switch {
+				case i >= len(line):
+					return nil, fmt.Errorf("unbalanced quotes")
+				case line[i] == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+					b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					cur.WriteByte(byte(b))
+					i += 3
+				case line[i] == '\\' && i+1 < len(line):
+					i++
+					switch line[i] {
+					case 'n':
+						cur.WriteByte('\n')
+					case 'r':
+						cur.WriteByte('\r')
+					case 't':
+						cur.WriteByte('\t')
+					case 'b':
+						cur.WriteByte('\b')
+					case 'a':
+						cur.WriteByte('\a')
+					default:
+						cur.WriteByte(line[i])
+					}
+				case line[i] == '"':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, fmt.Errorf("closing quote must be followed by a space")
+					}
+					done = true
+				default:
+					cur.WriteByte(line[i])
+				}
+			} else if inSingle {
+				switch {
+				case i >= len(line):
+					return nil, fmt.Errorf("unbalanced quotes")
+				case line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					cur.WriteByte('\'')
+				case line[i] == '\'':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, fmt.Errorf("closing quote must be followed by a space")
+					}
+					done = true
+				default:
+					cur.WriteByte(line[i])
+				}
+			} else {
+				switch {
+				case i >= len(line) || isSpace(line[i]):
+					done = true
+				case line[i] == '"':
+					inDouble = true
+				case line[i] == '\'':
+					inSingle = true
+				default:
+					cur.WriteByte(line[i])
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		args = append(args, cur.String())
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
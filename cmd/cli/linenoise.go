@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxHistory = 1000
+
+var errInterrupted = errors.New("interrupted")
+
+// lineEditor is a small linenoise-style single-line editor with emacs-style
+// shortcuts and persistent history, so the CLI doesn't need a readline
+// dependency.
+type lineEditor struct {
+	in          *bufio.Reader
+	out         io.Writer
+	fd          int
+	history     []string
+	historyFile string
+}
+
+func newLineEditor(historyFile string) *lineEditor {
+	e := &lineEditor{
+		in:          bufio.NewReader(os.Stdin),
+		out:         os.Stdout,
+		fd:          int(os.Stdin.Fd()),
+		historyFile: historyFile,
+	}
+	e.loadHistory()
+	return e
+}
+
+func (e *lineEditor) loadHistory() {
+	if e.historyFile == "" {
+		return
+	}
+	data, err := os.ReadFile(e.historyFile)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+func (e *lineEditor) addHistory(line string) {
+	if line == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[1:]
+	}
+	if e.historyFile == "" {
+		return
+	}
+	f, err := os.OpenFile(e.historyFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
+
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	if !isTerminal(e.fd) {
+		line, err := e.in.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	state, err := makeRaw(e.fd)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		restoreTerm(e.fd, state)
+		fmt.Fprint(e.out, "\n")
+	}()
+	return e.edit(prompt)
+}
+
+func (e *lineEditor) edit(prompt string) (string, error) {
+	var buf []rune
+	pos := 0
+	histIdx := len(e.history)
+	saved := ""
+
+	setLine := func(s string) {
+		buf = []rune(s)
+		pos = len(buf)
+	}
+
+	e.refresh(prompt, buf, pos)
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			return string(buf), nil
+		case 3: // Ctrl-C
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(buf) == 0 {
+				return "", io.EOF
+			}
+			if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+			}
+		case 127, 8: // Backspace, Ctrl-H
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+		case 1: // Ctrl-A
+			pos = 0
+		case 5: // Ctrl-E
+			pos = len(buf)
+		case 2: // Ctrl-B
+			if pos > 0 {
+				pos--
+			}
+		case 6: // Ctrl-F
+			if pos < len(buf) {
+				pos++
+			}
+		case 11: // Ctrl-K
+			buf = buf[:pos]
+		case 21: // Ctrl-U
+			buf, pos = buf[:0], 0
+		case 23: // Ctrl-W
+			start := pos
+			for start > 0 && buf[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && buf[start-1] != ' ' {
+				start--
+			}
+			buf = append(buf[:start], buf[pos:]...)
+			pos = start
+		case 12: // Ctrl-L
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case 16, 14: // Ctrl-P, Ctrl-N
+			histIdx, saved = e.moveHistory(histIdx, r == 16, string(buf), saved, setLine)
+		case 27: // Escape sequence
+			seq := e.readEscape()
+			switch seq {
+			case "[A", "OA":
+				histIdx, saved = e.moveHistory(histIdx, true, string(buf), saved, setLine)
+			case "[B", "OB":
+				histIdx, saved = e.moveHistory(histIdx, false, string(buf), saved, setLine)
+			case "[C", "OC":
+				if pos < len(buf) {
+					pos++
+				}
+			case "[D", "OD":
+				if pos > 0 {
+					pos--
+				}
+			case "[H", "OH", "[1~":
+				pos = 0
+			case "[F", "OF", "[4~":
+				pos = len(buf)
+			case "[3~":
+				if pos < len(buf) {
+					buf = append(buf[:pos], buf[pos+1:]...)
+				}
+			}
+		default:
+			if r >= 32 && r != utf8.RuneError {
+				buf = append(buf[:pos], append([]rune{r}, buf[pos:]...)...)
+				pos++
+			}
+		}
+		e.refresh(prompt, buf, pos)
+	}
+}
+
+func (e *lineEditor) readEscape() string {
+	first, _, err := e.in.ReadRune()
+	if err != nil {
+		return ""
+	}
+	second, _, err := e.in.ReadRune()
+	if err != nil {
+		return ""
+	}
+	seq := string([]rune{first, second})
+	if first == '[' && second >= '0' && second <= '9' {
+		third, _, err := e.in.ReadRune()
+		if err != nil {
+			return ""
+		}
+		seq += string(third)
+	}
+	return seq
+}
+
+func (e *lineEditor) moveHistory(idx int, up bool, current, saved string, setLine func(string)) (int, string) {
+	if idx == len(e.history) {
+		saved = current
+	}
+	switch {
+	case up && idx > 0:
+		idx--
+	case !up && idx < len(e.history):
+		idx++
+	default:
+		return idx, saved
+	}
+	if idx == len(e.history) {
+		setLine(saved)
+	} else {
+		setLine(e.history[idx])
+	}
+	return idx, saved
+}
+
+func (e *lineEditor) refresh(prompt string, buf []rune, pos int) {
+	cols := terminalWidth(e.fd)
+	plen := utf8.RuneCountInString(prompt)
+
+	// Scroll horizontally so the cursor always stays on screen.
+	start := 0
+	for plen+pos-start >= cols {
+		start++
+	}
+	end := len(buf)
+	for plen+end-start > cols {
+		end--
+	}
+
+	fmt.Fprintf(e.out, "\r%s%s\x1b[0K\r\x1b[%dC", prompt, string(buf[start:end]), plen+pos-start)
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+var (
+	host      = flag.String("h", "127.0.0.1", "Server hostname")
+	port      = flag.String("p", "6379", "Server port")
+	raw       = flag.Bool("raw", false, "Use raw formatting for replies (default when stdout is not a tty)")
+	noRaw     = flag.Bool("no-raw", false, "Force formatted output even when stdout is not a tty")
+	pipe      = flag.Bool("pipe", false, "Transfer raw RESP protocol from stdin to the server")
+	scanMode  = flag.Bool("scan", false, "List all keys using the SCAN command")
+	pattern   = flag.String("pattern", "*", "Keys pattern when using --scan")
+	count     = flag.Int("count", 100, "COUNT hint when using --scan")
+	bigKeys   = flag.Bool("bigkeys", false, "Report the biggest key of each type")
+	hotKeys   = flag.Bool("hotkeys", false, "Report the most frequently accessed keys")
+	noHistory = flag.Bool("no-history", false, "Don't load or save the REPL history file")
+)
+
+type client struct {
+	addr   string
+	conn   net.Conn
+	reader *protocol.Reader
+	writer *protocol.Writer
+}
+
+func (c *client) connect() error {
+	if c.conn != nil {
+		return nil
+	}
+	conn, err := net.Dial("tcp", c.addr)
+	if err != nil {
+		return fmt.Errorf("could not connect to %s: %w", c.addr, err)
+	}
+	c.conn = conn
+	c.reader = protocol.NewReader(conn)
+	c.writer = protocol.NewWriter(conn)
+	return nil
+}
+
+func (c *client) disconnect() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+func (c *client) do(args ...string) (protocol.RESPObject, error) {
+	if err := c.connect(); err != nil {
+		return protocol.RESPObject{}, err
+	}
+
+	req := make([]protocol.RESPObject, len(args))
+	for i, arg := range args {
+		req[i] = protocol.RESPObject{Type: protocol.BulkString, Value: arg}
+	}
+	if err := c.writer.Write(protocol.RESPObject{Type: protocol.Array, Value: req}); err != nil {
+		c.disconnect()
+		return protocol.RESPObject{}, err
+	}
+
+	reply, err := c.reader.Deserialize()
+	if err != nil {
+		c.disconnect()
+		return protocol.RESPObject{}, err
+	}
+	return reply, nil
+}
+
+func main() {
+	log.SetFlags(0)
+	flag.Parse()
+
+	useRaw := *raw || (!*noRaw && !isTerminal(int(os.Stdout.Fd())))
+	c := &client{addr: net.JoinHostPort(*host, *port)}
+	defer c.disconnect()
+
+	var err error
+	switch {
+	case *pipe:
+		err = runPipe(c)
+	case *scanMode:
+		err = runScan(c)
+	case *bigKeys:
+		err = runBigKeys(c)
+	case *hotKeys:
+		err = runHotKeys(c)
+	case flag.NArg() > 0:
+		err = runOnce(c, flag.Args(), useRaw)
+	default:
+		err = runRepl(c, useRaw)
+	}
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
+
+func runOnce(c *client, args []string, useRaw bool) error {
+	reply, err := c.do(args...)
+	if err != nil {
+		return err
+	}
+	fmt.Print(formatReply(reply, useRaw))
+	if reply.Type == protocol.Error {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func runRepl(c *client, useRaw bool) error {
+	historyFile := ""
+	if home, err := os.UserHomeDir(); err == nil && !*noHistory {
+		historyFile = filepath.Join(home, ".redis_clone_cli_history")
+	}
+	editor := newLineEditor(historyFile)
+	prompt := c.addr + "> "
+
+	if err := c.connect(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	for {
+		line, err := editor.readLine(prompt)
+		if err == errInterrupted {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		args, err := splitArgs(line)
+		if err != nil {
+			fmt.Println("Invalid argument(s)")
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		editor.addHistory(line)
+
+		switch strings.ToLower(args[0]) {
+		case "quit", "exit":
+			return nil
+		case "clear":
+			fmt.Print("\x1b[H\x1b[2J")
+			continue
+		}
+
+		reply, err := c.do(args...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			continue
+		}
+		fmt.Print(formatReply(reply, useRaw))
+	}
+}
+
+// runPipe streams stdin to the server verbatim, then sends an ECHO with a
+// random marker so it knows when the last reply has arrived.
+func runPipe(c *client) error {
+	if err := c.connect(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	marker := make([]byte, 20)
+	if _, err := rand.Read(marker); err != nil {
+		return err
+	}
+	magic := hex.EncodeToString(marker)
+
+	go func() {
+		w := bufio.NewWriter(c.conn)
+		if _, err := io.Copy(w, os.Stdin); err != nil {
+			done <- err
+			return
+		}
+		echo := protocol.RESPObject{Type: protocol.Array, Value: []protocol.RESPObject{
+			{Type: protocol.BulkString, Value: "ECHO"},
+			{Type: protocol.BulkString, Value: magic},
+		}}
+		if _, err := w.WriteString(echo.Serialize()); err != nil {
+			done <- err
+			return
+		}
+		fmt.Fprintln(os.Stderr, "All data transferred. Waiting for the last reply...")
+		done <- w.Flush()
+	}()
+
+	errors, replies := 0, 0
+	for {
+		reply, err := c.reader.Deserialize()
+		if err != nil {
+			return err
+		}
+		if reply.Value == magic {
+			break
+		}
+		replies++
+		if reply.Type == protocol.Error {
+			errors++
+			fmt.Fprintln(os.Stderr, reply.Value)
+		}
+	}
+	if err := <-done; err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stderr, "Last reply received from server.")
+	fmt.Fprintf(os.Stderr, "errors: %d, replies: %d\n", errors, replies)
+	if errors > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func runScan(c *client) error {
+	cursor := "0"
+	for {
+		reply, err := c.do("SCAN", cursor, "MATCH", *pattern, "COUNT", fmt.Sprint(*count))
+		if err != nil {
+			return err
+		}
+		if reply.Type == protocol.Error {
+			return fmt.Errorf("%v", reply.Value)
+		}
+
+		arr := reply.Value.([]protocol.RESPObject)
+		cursor = arr[0].Value.(string)
+		for _, key := range arr[1].Value.([]protocol.RESPObject) {
+			fmt.Println(key.Value)
+		}
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+func runBigKeys(c *client) error {
+	reply, err := c.do("DEBUG", "BIGKEYS")
+	if err != nil {
+		return err
+	}
+	if reply.Type == protocol.Error {
+		return fmt.Errorf("%v", reply.Value)
+	}
+
+	units := map[string]string{"string": "bytes", "hash": "fields", "list": "items"}
+	plurals := map[string]string{"string": "strings", "hash": "hashes", "list": "lists"}
+	entries, _ := reply.Value.([]protocol.RESPObject)
+	fmt.Println("# Scanning the entire keyspace to find biggest keys")
+	fmt.Println()
+	for _, entry := range entries {
+		e := entry.Value.([]protocol.RESPObject)
+		fmt.Printf("Biggest %6s found '%s' has %v %s\n", e[0].Value, e[1].Value, e[2].Value, units[e[0].Value.(string)])
+	}
+	fmt.Println()
+	for _, entry := range entries {
+		e := entry.Value.([]protocol.RESPObject)
+		fmt.Printf("%v %s\n", e[3].Value, plurals[e[0].Value.(string)])
+	}
+	return nil
+}
+
+func runHotKeys(c *client) error {
+	reply, err := c.do("DEBUG", "HOTKEYS", fmt.Sprint(*count))
+	if err != nil {
+		return err
+	}
+	if reply.Type == protocol.Error {
+		return fmt.Errorf("%v", reply.Value)
+	}
+
+	fmt.Println("# Scanning the entire keyspace to find hot keys")
+	fmt.Println()
+	entries, _ := reply.Value.([]protocol.RESPObject)
+	for _, entry := range entries {
+		e := entry.Value.([]protocol.RESPObject)
+		fmt.Printf("hot key found with counter: %v\tkeyname: %s\n", e[1].Value, e[0].Value)
+	}
+	return nil
+}
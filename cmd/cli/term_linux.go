@@ -0,0 +1,53 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+type termState struct {
+	termios syscall.Termios
+}
+
+func isTerminal(fd int) bool {
+	var t syscall.Termios
+	return ioctl(fd, syscall.TCGETS, &t) == nil
+}
+
+func makeRaw(fd int) (*termState, error) {
+	var old syscall.Termios
+	if err := ioctl(fd, syscall.TCGETS, &old); err != nil {
+		return nil, err
+	}
+
+	raw := old
+	raw.Iflag &^= syscall.BRKINT | syscall.ICRNL | syscall.INPCK | syscall.ISTRIP | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Cflag |= syscall.CS8
+	raw.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.IEXTEN | syscall.ISIG
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(fd, syscall.TCSETS, &raw); err != nil {
+		return nil, err
+	}
+	return &termState{termios: old}, nil
+}
+
+func restoreTerm(fd int, state *termState) error {
+	return ioctl(fd, syscall.TCSETS, &state.termios)
+}
+
+func terminalWidth(fd int) int {
+	var ws struct{ Row, Col, X, Y uint16 }
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws))); errno != 0 || ws.Col == 0 {
+		return 80
+	}
+	return int(ws.Col)
+}
+
+func ioctl(fd int, req uintptr, t *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, uintptr(unsafe.Pointer(t))); errno != 0 {
+		return errno
+	}
+	return nil
+}
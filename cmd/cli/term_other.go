@@ -0,0 +1,23 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+type termState struct{}
+
+func isTerminal(fd int) bool {
+	return false
+}
+
+func makeRaw(fd int) (*termState, error) {
+	return nil, errors.New("raw terminal mode is not supported on this platform")
+}
+
+func restoreTerm(fd int, state *termState) error {
+	return nil
+}
+
+func terminalWidth(fd int) int {
+	return 80
+}
@@ -0,0 +1,151 @@
+// Command dump exports the dataset stored in an AOF file as JSON lines or
+// CSV. The AOF is replayed in-process, so the server may keep running.
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/aof"
+	"github.com/ashish-kamra/redis-clone/internal/handler"
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+	"github.com/ashish-kamra/redis-clone/server"
+)
+
+var (
+	aofPath = flag.String("aof", "redis.aof", "AOF file to export")
+	format  = flag.String("format", "json", "Output format: json (one object per line) or csv")
+	pattern = flag.String("pattern", "*", "Only export keys matching this glob pattern")
+	output  = flag.String("o", "", "Output file (stdout if empty)")
+	plugins = flag.String("plugins", "", "Comma separated list of Go plugin .so files defining custom types")
+)
+
+type record struct {
+	Key   string      `json:"key"`
+	Type  string      `json:"type"`
+	TTL   int64       `json:"ttl_ms"`
+	Value interface{} `json:"value"`
+}
+
+func main() {
+	flag.Parse()
+	if *format != "json" && *format != "csv" {
+		log.Fatalf("Unknown format %q, expected json or csv", *format)
+	}
+	if *plugins != "" {
+		for _, path := range strings.Split(*plugins, ",") {
+			if err := server.LoadPlugin(path); err != nil {
+				log.Fatalf("Failed to load plugin: %v", err)
+			}
+		}
+	}
+
+	if err := load(*aofPath); err != nil {
+		log.Fatal(err)
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("Failed to create output file: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	w := bufio.NewWriter(out)
+
+	n, err := export(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		log.Fatalf("Export failed: %v", err)
+	}
+	log.Printf("Exported %d keys", n)
+}
+
+func load(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("cannot read AOF: %w", err)
+	}
+	f, err := aof.NewAof(path, true)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = f.Read(func(obj protocol.RESPObject) {
+		argv := obj.Value.([]protocol.RESPObject)
+		cmd, ok := handler.Lookup(argv[0].Value.(string))
+		if !ok {
+			log.Printf("Skipping unknown command in AOF: %s", argv[0].Value)
+			return
+		}
+		cmd.Handler(argv[1:])
+	})
+	var incomplete *aof.IncompleteTailError
+	if errors.As(err, &incomplete) {
+		// The server truncates it on its next start; the export skips it too.
+		log.Print(err)
+		return nil
+	}
+	return err
+}
+
+func export(w io.Writer) (int, error) {
+	var cw *csv.Writer
+	enc := json.NewEncoder(w)
+	if *format == "csv" {
+		cw = csv.NewWriter(w)
+		cw.Write([]string{"key", "type", "ttl_ms", "value"})
+	}
+
+	n := 0
+	var err error
+	now := time.Now()
+	handler.Snapshot(func(e handler.Entry) bool {
+		if !handler.MatchPattern(*pattern, e.Key) {
+			return true
+		}
+		rec := record{Key: e.Key, Type: e.Type, TTL: -1, Value: e.Value}
+		if !e.ExpiresAt.IsZero() {
+			rec.TTL = e.ExpiresAt.Sub(now).Milliseconds()
+		}
+		if e.Module != nil {
+			// Custom values are exported as the commands that recreate them.
+			rec.Value = e.Module.Rewrite(e.Key, e.Value)
+		}
+
+		if cw != nil {
+			value, ok := rec.Value.(string)
+			if !ok {
+				b, merr := json.Marshal(rec.Value)
+				if merr != nil {
+					err = merr
+					return false
+				}
+				value = string(b)
+			}
+			err = cw.Write([]string{rec.Key, rec.Type, fmt.Sprint(rec.TTL), value})
+		} else {
+			err = enc.Encode(rec)
+		}
+		n++
+		return err == nil
+	})
+	if cw != nil && err == nil {
+		cw.Flush()
+		err = cw.Error()
+	}
+	return n, err
+}
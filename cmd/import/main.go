@@ -0,0 +1,286 @@
+// Command import copies the dataset of a running Redis server into this
+// server, then keeps following changes through keyspace notifications until
+// it is interrupted, so clients can be cut over with minimal downtime.
+//
+// Keys are copied with type-specific reads (GET/PTTL, HGETALL) rather than
+// DUMP/RESTORE, whose payload is Redis' RDB encoding. Types the target
+// doesn't support are skipped.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/client"
+)
+
+var (
+	from     = flag.String("from", "127.0.0.1:6379", "Source Redis address")
+	to       = flag.String("to", "127.0.0.1:6380", "Target server address")
+	password = flag.String("from-password", "", "Password for the source server")
+	db       = flag.Int("db", 0, "Source database to copy")
+	match    = flag.String("pattern", "*", "Only copy keys matching this glob pattern")
+	count    = flag.Int("count", 1000, "SCAN COUNT hint for the initial copy")
+	once     = flag.Bool("once", false, "Exit after the initial copy instead of following changes")
+)
+
+const requestTimeout = 10 * time.Second
+
+type importer struct {
+	src, dst *client.Client
+	skipped  map[string]bool
+
+	mu      sync.Mutex
+	pending map[string]struct{}
+	wake    chan struct{}
+}
+
+func main() {
+	flag.Parse()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	src := mustDial(ctx, *from)
+	defer src.Close()
+	dst, err := client.Dial(ctx, "tcp", *to)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer dst.Close()
+
+	imp := &importer{src: src, dst: dst, skipped: map[string]bool{}, pending: map[string]struct{}{}, wake: make(chan struct{}, 1)}
+
+	// Subscribe before scanning, so writes racing with the initial copy are
+	// replayed afterwards instead of being lost.
+	if !*once {
+		sub := mustDial(ctx, *from)
+		defer sub.Close()
+		restore, err := enableNotifications(ctx, src)
+		if err != nil {
+			log.Fatalf("Failed to enable keyspace notifications on the source: %v", err)
+		}
+		defer restore()
+		prefix := fmt.Sprintf("__keyspace@%d__:", *db)
+		if _, err := do(ctx, sub, "PSUBSCRIBE", prefix+*match); err != nil {
+			log.Fatalf("Failed to subscribe to keyspace notifications: %v", err)
+		}
+		go imp.follow(ctx, sub, prefix)
+	}
+
+	start := time.Now()
+	n, err := imp.copyAll(ctx)
+	if err != nil {
+		log.Fatalf("Initial copy failed after %d keys: %v", n, err)
+	}
+	log.Printf("Initial copy of %d keys done in %v", n, time.Since(start).Round(time.Millisecond))
+	if *once {
+		return
+	}
+
+	log.Printf("Following changes, press Ctrl-C to stop once clients are cut over")
+	if err := imp.drain(ctx); err != nil && ctx.Err() == nil {
+		log.Fatalf("Following changes failed: %v", err)
+	}
+	log.Printf("Stopped")
+}
+
+func mustDial(ctx context.Context, addr string) *client.Client {
+	c, err := client.Dial(ctx, "tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *password != "" {
+		if _, err := do(ctx, c, "AUTH", *password); err != nil {
+			log.Fatalf("Failed to authenticate with %s: %v", addr, err)
+		}
+	}
+	if *db != 0 {
+		if _, err := do(ctx, c, "SELECT", *db); err != nil {
+			log.Fatalf("Failed to select database %d: %v", *db, err)
+		}
+	}
+	return c
+}
+
+func do(ctx context.Context, c *client.Client, args ...interface{}) (client.Reply, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	return c.Do(ctx, args...)
+}
+
+// enableNotifications turns on keyspace notifications for every event class
+// and returns a func restoring the previous setting.
+func enableNotifications(ctx context.Context, c *client.Client) (func(), error) {
+	reply, err := do(ctx, c, "CONFIG", "GET", "notify-keyspace-events")
+	if err != nil {
+		return nil, err
+	}
+	prev := ""
+	if vals, err := reply.Strings(); err == nil && len(vals) == 2 {
+		prev = vals[1]
+	}
+	if _, err := do(ctx, c, "CONFIG", "SET", "notify-keyspace-events", "KA"); err != nil {
+		return nil, err
+	}
+	return func() {
+		if _, err := do(context.Background(), c, "CONFIG", "SET", "notify-keyspace-events", prev); err != nil {
+			log.Printf("Failed to restore notify-keyspace-events: %v", err)
+		}
+	}, nil
+}
+
+func (imp *importer) copyAll(ctx context.Context) (int, error) {
+	n := 0
+	cursor := "0"
+	for {
+		reply, err := do(ctx, imp.src, "SCAN", cursor, "MATCH", *match, "COUNT", *count)
+		if err != nil {
+			return n, err
+		}
+		if len(reply.Elems) != 2 {
+			return n, fmt.Errorf("unexpected SCAN reply")
+		}
+		cursor, _ = reply.Elems[0].Text()
+		keys, err := reply.Elems[1].Strings()
+		if err != nil {
+			return n, err
+		}
+		for _, key := range keys {
+			if err := imp.copyKey(ctx, key); err != nil {
+				return n, fmt.Errorf("key %q: %w", key, err)
+			}
+			n++
+		}
+		if cursor == "0" {
+			return n, nil
+		}
+	}
+}
+
+// copyKey makes the target's copy of key match the source's current value.
+func (imp *importer) copyKey(ctx context.Context, key string) error {
+	reply, err := do(ctx, imp.src, "TYPE", key)
+	if err != nil {
+		return err
+	}
+	switch typ, _ := reply.Text(); typ {
+	case "none":
+		_, err = do(ctx, imp.dst, "DEL", key)
+	case "string":
+		err = imp.copyString(ctx, key)
+	case "hash":
+		err = imp.copyHash(ctx, key)
+	default:
+		if !imp.skipped[typ] {
+			log.Printf("Skipping keys of unsupported type %s, e.g. %q", typ, key)
+			imp.skipped[typ] = true
+		}
+	}
+	return err
+}
+
+func (imp *importer) copyString(ctx context.Context, key string) error {
+	val, err := do(ctx, imp.src, "GET", key)
+	if err != nil {
+		return err
+	}
+	ttl, err := do(ctx, imp.src, "PTTL", key)
+	if err != nil {
+		return err
+	}
+	if val.IsNil() || ttl.Int == -2 {
+		_, err = do(ctx, imp.dst, "DEL", key)
+		return err
+	}
+	args := []interface{}{"SET", key, val.Str}
+	if ttl.Int > 0 {
+		args = append(args, "PX", ttl.Int)
+	}
+	_, err = do(ctx, imp.dst, args...)
+	return err
+}
+
+func (imp *importer) copyHash(ctx context.Context, key string) error {
+	reply, err := do(ctx, imp.src, "HGETALL", key)
+	if err != nil {
+		return err
+	}
+	fields, err := reply.Strings()
+	if err != nil {
+		return err
+	}
+	if _, err := do(ctx, imp.dst, "DEL", key); err != nil {
+		return err
+	}
+	for i := 0; i+1 < len(fields); i += 2 {
+		if _, err := do(ctx, imp.dst, "HSET", key, fields[i], fields[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// follow queues every key named by a keyspace notification. Repeated events
+// for the same key collapse into one copy.
+func (imp *importer) follow(ctx context.Context, sub *client.Client, prefix string) {
+	for {
+		msg, err := sub.Receive(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Lost the keyspace notification subscription: %v", err)
+				os.Exit(1)
+			}
+			return
+		}
+		// ["pmessage", pattern, "__keyspace@0__:key", event]
+		parts, err := msg.Strings()
+		if err != nil || len(parts) != 4 || parts[0] != "pmessage" {
+			continue
+		}
+		imp.mu.Lock()
+		imp.pending[strings.TrimPrefix(parts[2], prefix)] = struct{}{}
+		imp.mu.Unlock()
+		select {
+		case imp.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (imp *importer) drain(ctx context.Context) error {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	synced := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if synced > 0 {
+				log.Printf("Synced %d changed keys", synced)
+				synced = 0
+			}
+		case <-imp.wake:
+		}
+
+		imp.mu.Lock()
+		keys := imp.pending
+		imp.pending = map[string]struct{}{}
+		imp.mu.Unlock()
+
+		for key := range keys {
+			if err := imp.copyKey(ctx, key); err != nil {
+				return fmt.Errorf("key %q: %w", key, err)
+			}
+			synced++
+		}
+	}
+}
@@ -1,110 +1,190 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"io"
 	"log"
-	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
-	"github.com/ashish-kamra/redis-clone/internal/aof"
-	"github.com/ashish-kamra/redis-clone/internal/handler"
-	"github.com/ashish-kamra/redis-clone/internal/protocol"
+	"github.com/ashish-kamra/redis-clone/server"
 )
 
-var port = flag.String("port", "6379", "Listening port address")
+var (
+	port     = flag.String("port", "6379", "Listening port address")
+	plugins  = flag.String("plugins", "", "Comma separated list of Go plugin .so files to load")
+	httpAddr = flag.String("http", "", "Address for the HTTP/JSON gateway, e.g. :8080 (disabled if empty)")
+	grpcAddr = flag.String("grpc", "", "Address for the gRPC service, e.g. :50051 (disabled if empty, requires -tls-cert/-tls-key)")
+	tlsCert  = flag.String("tls-cert", "", "TLS certificate file")
+	tlsKey   = flag.String("tls-key", "", "TLS private key file")
+	adminPW  = flag.String("admin-password", "", "Password for the /admin dashboard on the HTTP gateway (loopback only if empty)")
+	slowlog  = flag.Duration("slowlog-threshold", 10*time.Millisecond, "Log commands slower than this to the slowlog (negative disables)")
+
+	appendOnly  = flag.Bool("appendonly", true, "Persist writes to the AOF; when false the RDB snapshot is loaded at startup instead")
+	dir         = flag.String("dir", ".", "Directory holding the AOF and RDB files")
+	aofName     = flag.String("appendfilename", "redis.aof", "Name of the AOF in -dir")
+	rdbName     = flag.String("dbfilename", "dump.rdb", "Name of the RDB snapshot in -dir")
+	requirePass = flag.String("requirepass", "", "Require clients to AUTH with this password")
+	traceRate   = flag.Int("trace-sample-rate", 0, "Log one in every N commands with latency, keys and outcome (0 disables)")
+	failpoints  = flag.Bool("enable-failpoints", false, "Allow DEBUG FAILPOINT to inject faults (testing only)")
+	backingName = flag.String("backing-store", "", "Cache in front of this backing store: http, or one registered by a plugin")
+	backingDSN  = flag.String("backing-store-dsn", "", "Backing store address, e.g. http://localhost:9000/kv")
+	errLogBurst = flag.Int("error-log-burst", 10, "Log each kind of per-connection error at most this many times per 10s, then summarize (negative logs all)")
+	databases   = flag.Int("databases", 16, "Number of databases SELECT accepts (only database 0 holds keys)")
+	maxmemClnt  = flag.Int64("maxmemory-clients", 0, "Disconnect clients once all clients together use more than this many bytes (0 disables)")
+	outputLimit = flag.Int64("client-output-buffer-limit", 0, "Disconnect a client whose unsent replies grow past this many bytes (0 disables)")
+	evictPolicy = flag.String("client-eviction-policy", "largest", "Which clients -maxmemory-clients disconnects first: largest (most memory) or idle (idle the longest)")
+	maxInflight = flag.Int64("max-inflight-commands", 0, "Refuse commands with BUSY while this many are already running (0 disables)")
+	cluster     = flag.Bool("cluster-enabled", false, "Reject commands whose keys hash to different cluster slots with CROSSSLOT")
+	checkOnly   = flag.Bool("check-consistency", false, "Load the AOF and the RDB snapshot in -dir separately, print the keys that differ and exit (non-zero if any do)")
+
+	webhooks      stringList
+	tenants       stringList
+	webhookEvents = flag.String("webhook-events", "", "Comma separated keyspace events sent to webhooks, e.g. set,expired (all if empty)")
+	webhookMatch  = flag.String("webhook-pattern", "", "Only send events for keys matching this glob pattern to webhooks")
+)
 
-func main() {
-	log.Printf("Listening on port: %s", *port)
+func init() {
+	flag.Var(&webhooks, "webhook", "URL receiving keyspace events as JSON POSTs (repeatable)")
+	flag.Var(&tenants, "tenant", "Tenant user as name=N,password=P,prefix=P[,maxkeys=N][,maxmemory=BYTES] (repeatable)")
+}
 
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%s", *port))
-	if err != nil {
-		log.Fatalf("Failed to bind to port %s: %v", *port, err)
-	}
-	defer listener.Close()
+type stringList []string
 
-	aof, err := aof.NewAof("redis.aof", false)
-	if err != nil {
-		log.Fatalf("Failed to open/create AOF: %v", err)
-	}
-	defer aof.Close()
+func (l *stringList) String() string { return strings.Join(*l, ",") }
 
-	rebuildCacheFromAOF(aof)
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
 
-	for {
-		conn, err := listener.Accept()
+func parseTenant(spec string) (server.Tenant, error) {
+	var t server.Tenant
+	for _, opt := range strings.Split(spec, ",") {
+		name, val, _ := strings.Cut(opt, "=")
+		var err error
+		switch name {
+		case "name":
+			t.Name = val
+		case "password":
+			t.Password = val
+		case "prefix":
+			t.Prefix = val
+		case "maxkeys":
+			t.MaxKeys, err = strconv.ParseInt(val, 10, 64)
+		case "maxmemory":
+			t.MaxMemory, err = strconv.ParseInt(val, 10, 64)
+		default:
+			err = fmt.Errorf("unknown option %q", name)
+		}
 		if err != nil {
-			log.Printf("Error accepting connection: %v", err)
-			continue
+			return t, fmt.Errorf("invalid tenant %q: %v", spec, err)
 		}
-		go handleConnection(conn, aof)
 	}
+	return t, nil
 }
 
-func handleConnection(conn net.Conn, aof *aof.Aof) {
-	defer conn.Close()
-	reader := protocol.NewReader(conn)
-	writer := protocol.NewWriter(conn)
+func main() {
+	flag.Parse()
 
-	for {
-		respObject, err := reader.Deserialize()
-		if err != nil {
-			if err == io.EOF {
-				log.Printf("Connection closed %v", conn.RemoteAddr())
-			} else {
-				log.Printf("Error reading message: %v", err)
+	if *plugins != "" {
+		for _, path := range strings.Split(*plugins, ",") {
+			if err := server.LoadPlugin(path); err != nil {
+				log.Fatalf("Failed to load plugin: %v", err)
 			}
-			return
 		}
+	}
 
-		result := processCommand(respObject, aof)
-		if err := writer.Write(result); err != nil {
-			log.Printf("Error writing response: %v", err)
-			return
+	var hooks []server.Webhook
+	for _, u := range webhooks {
+		wh := server.Webhook{URL: u, Pattern: *webhookMatch}
+		if *webhookEvents != "" {
+			wh.Events = strings.Split(*webhookEvents, ",")
 		}
+		hooks = append(hooks, wh)
 	}
-}
 
-func processCommand(respObject protocol.RESPObject, aof *aof.Aof) protocol.RESPObject {
-	if respObject.Type != protocol.Array {
-		return protocol.RESPObject{Type: protocol.Error, Value: "Invalid request, expected array"}
+	var tenantList []server.Tenant
+	for _, spec := range tenants {
+		t, err := parseTenant(spec)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tenantList = append(tenantList, t)
 	}
 
-	respObjectVal := respObject.Value.([]protocol.RESPObject)
-	if len(respObjectVal) == 0 {
-		return protocol.RESPObject{Type: protocol.Error, Value: "Invalid request, expected array length > 0"}
+	var store server.BackingStore
+	if *backingName != "" {
+		var err error
+		if store, err = server.OpenBackingStore(*backingName, *backingDSN); err != nil {
+			log.Fatalf("Failed to open backing store: %v", err)
+		}
 	}
 
-	command := strings.ToUpper(respObjectVal[0].Value.(string))
-	args := respObjectVal[1:]
-
-	handler, ok := handler.Handlers[command]
-	if !ok {
-		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf("Invalid command: %s", command)}
+	for flagName, name := range map[string]string{"appendfilename": *aofName, "dbfilename": *rdbName} {
+		if name == "" || filepath.Base(name) != name {
+			log.Fatalf("-%s must be a file name, not a path; use -dir to choose the directory", flagName)
+		}
 	}
-
-	if command == "SET" || command == "HSET" {
-		if err := aof.Write(respObject); err != nil {
-			log.Printf("Error writing to AOF: %v", err)
+	aofPath := filepath.Join(*dir, *aofName)
+	if *checkOnly {
+		diverged, err := server.CheckConsistency(aofPath, filepath.Join(*dir, *rdbName), os.Stdout)
+		if err != nil {
+			log.Fatalf("Consistency check failed: %v", err)
+		}
+		if diverged > 0 {
+			os.Exit(1)
 		}
+		return
+	}
+	if !*appendOnly {
+		aofPath = ""
 	}
 
-	return handler(args)
-}
-
-func rebuildCacheFromAOF(aof *aof.Aof) {
-	err := aof.Read(func(respObject protocol.RESPObject) {
-		command := strings.ToUpper(respObject.Value.([]protocol.RESPObject)[0].Value.(string))
-		args := respObject.Value.([]protocol.RESPObject)[1:]
-		handler, ok := handler.Handlers[command]
-		if !ok {
-			log.Printf("Unknown command in AOF: %s", command)
-			return
-		}
-		handler(args)
+	srv := server.New(server.Config{
+		Addr:        ":" + *port,
+		AOFPath:     aofPath,
+		RDBPath:     filepath.Join(*dir, *rdbName),
+		HTTPAddr:    *httpAddr,
+		GRPCAddr:    *grpcAddr,
+		TLSCertFile: *tlsCert,
+		TLSKeyFile:  *tlsKey,
+
+		SlowlogThreshold:        *slowlog,
+		AdminPassword:           *adminPW,
+		Webhooks:                hooks,
+		RequirePass:             *requirePass,
+		Tenants:                 tenantList,
+		EnableFailpoints:        *failpoints,
+		TraceSampleRate:         *traceRate,
+		BackingStore:            store,
+		Databases:               *databases,
+		ErrorLogBurst:           *errLogBurst,
+		MaxMemoryClients:        *maxmemClnt,
+		ClientOutputBufferLimit: *outputLimit,
+		ClientEvictionPolicy:    *evictPolicy,
+		MaxInflightCommands:     *maxInflight,
+		ClusterEnabled:          *cluster,
 	})
-	if err != nil {
-		log.Printf("Error rebuilding cache from AOF: %v", err)
+	if err := srv.Start(context.Background()); err != nil {
+		log.Fatalf("Failed to start server: %v", err)
+	}
+	log.Printf("Listening on port: %s", *port)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+
+	log.Printf("Shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Error during shutdown: %v", err)
 	}
 }
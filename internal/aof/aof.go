@@ -3,12 +3,18 @@ package aof
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/ashish-kamra/redis-clone/internal/failpoint"
 	"github.com/ashish-kamra/redis-clone/internal/protocol"
 )
 
@@ -19,13 +25,31 @@ type Aof struct {
 	shouldFsync bool
 	ctx         context.Context
 	cancel      context.CancelFunc
+
+	// written and synced are byte offsets of the end of the last appended and
+	// the last fsynced command; syncCh is closed whenever synced advances.
+	written int64
+	synced  int64
+	syncCh  chan struct{}
+
+	// lastErr is the error of the last write or fsync, nil if it succeeded.
+	lastErr error
 }
 
 func NewAof(path string, shouldFsync bool) (*Aof, error) {
+	_, statErr := os.Stat(path)
 	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0666)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open AOF file: %w", err)
 	}
+	// A new AOF's directory entry is synced too, or a crash could lose the
+	// file along with writes already fsynced to it.
+	if errors.Is(statErr, fs.ErrNotExist) {
+		if err := syncDir(filepath.Dir(path)); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to sync AOF directory: %w", err)
+		}
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -36,6 +60,7 @@ func NewAof(path string, shouldFsync bool) (*Aof, error) {
 		shouldFsync: shouldFsync,
 		ctx:         ctx,
 		cancel:      cancel,
+		syncCh:      make(chan struct{}),
 	}
 
 	if !shouldFsync {
@@ -45,6 +70,15 @@ func NewAof(path string, shouldFsync bool) (*Aof, error) {
 	return aof, nil
 }
 
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
 func (aof *Aof) periodicSync() {
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
@@ -55,7 +89,7 @@ func (aof *Aof) periodicSync() {
 			return
 		case <-ticker.C:
 			if err := aof.sync(); err != nil {
-				fmt.Printf("Error during periodic sync: %v\n", err)
+				log.Printf("Error during periodic sync: %v", err)
 			}
 		}
 	}
@@ -64,7 +98,63 @@ func (aof *Aof) periodicSync() {
 func (aof *Aof) sync() error {
 	aof.mu.Lock()
 	defer aof.mu.Unlock()
-	return aof.file.Sync()
+	if aof.lastErr = failpoint.Eval(failpoint.AOFFsync); aof.lastErr != nil {
+		return aof.lastErr
+	}
+	if aof.lastErr = aof.file.Sync(); aof.lastErr != nil {
+		return aof.lastErr
+	}
+	aof.markSynced()
+	return nil
+}
+
+// LastError returns the error of the last write or fsync, nil if it
+// succeeded.
+func (aof *Aof) LastError() error {
+	aof.mu.RLock()
+	defer aof.mu.RUnlock()
+	return aof.lastErr
+}
+
+// markSynced must be called with mu held after a successful fsync.
+func (aof *Aof) markSynced() {
+	if aof.synced != aof.written {
+		aof.synced = aof.written
+		close(aof.syncCh)
+		aof.syncCh = make(chan struct{})
+	}
+}
+
+// Offset returns the offset just past the last appended command.
+func (aof *Aof) Offset() int64 {
+	aof.mu.RLock()
+	defer aof.mu.RUnlock()
+	return aof.written
+}
+
+// SyncedOffset returns the offset up to which the AOF has been fsynced.
+func (aof *Aof) SyncedOffset() int64 {
+	aof.mu.RLock()
+	defer aof.mu.RUnlock()
+	return aof.synced
+}
+
+// WaitSynced blocks until everything up to offset has been fsynced or ctx
+// is done.
+func (aof *Aof) WaitSynced(ctx context.Context, offset int64) error {
+	for {
+		aof.mu.RLock()
+		synced, ch := aof.synced, aof.syncCh
+		aof.mu.RUnlock()
+		if synced >= offset {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ch:
+		}
+	}
 }
 
 func (aof *Aof) Close() error {
@@ -74,28 +164,79 @@ func (aof *Aof) Close() error {
 	if err := aof.file.Sync(); err != nil {
 		return fmt.Errorf("failed to sync file before closing: %w", err)
 	}
+	aof.markSynced()
 	return aof.file.Close()
 }
 
-func (aof *Aof) Write(obj protocol.RESPObject) error {
+// Write appends objs with a single write, so a transaction's MULTI ... EXEC
+// block reaches the file together.
+func (aof *Aof) Write(objs ...protocol.RESPObject) error {
 	aof.mu.Lock()
 	defer aof.mu.Unlock()
+	aof.lastErr = aof.write(objs)
+	return aof.lastErr
+}
 
-	data := []byte(obj.Serialize())
-	_, err := aof.file.Write(data)
+func (aof *Aof) write(objs []protocol.RESPObject) error {
+	if err := failpoint.Eval(failpoint.AOFWrite); err != nil {
+		return fmt.Errorf("failed to write to AOF: %w", err)
+	}
+	var data []byte
+	for _, obj := range objs {
+		data = append(data, obj.Serialize()...)
+	}
+	n, err := aof.file.Write(data)
+	aof.written += int64(n)
 	if err != nil {
 		return fmt.Errorf("failed to write to AOF: %w", err)
 	}
 
 	if aof.shouldFsync {
+		if err := failpoint.Eval(failpoint.AOFFsync); err != nil {
+			return fmt.Errorf("failed to sync AOF: %w", err)
+		}
 		if err := aof.file.Sync(); err != nil {
 			return fmt.Errorf("failed to sync AOF: %w", err)
 		}
+		aof.markSynced()
 	}
 
 	return nil
 }
 
+// IncompleteTailError is returned by Read when the file ends with a torn
+// record or inside a MULTI block, e.g. after a crash mid-write. Offset is
+// the end of the last complete record outside a block, and Commands the
+// number of commands of the open block; Truncate(Offset) drops the tail so
+// later appends replay normally.
+type IncompleteTailError struct {
+	Offset   int64
+	Commands int
+}
+
+func (e *IncompleteTailError) Error() string {
+	if e.Commands == 0 {
+		return fmt.Sprintf("AOF ends with an incomplete record at offset %d", e.Offset)
+	}
+	return fmt.Sprintf("AOF ends inside a MULTI block at offset %d, discarded %d commands", e.Offset, e.Commands)
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Read calls fn with every command in the file. Commands inside a MULTI ...
+// EXEC block are only delivered once its EXEC is read, and the MULTI and
+// EXEC markers themselves are not delivered, so an interrupted transaction
+// at the end of the file is dropped as a whole.
 func (aof *Aof) Read(fn func(obj protocol.RESPObject)) error {
 	aof.mu.RLock()
 	defer aof.mu.RUnlock()
@@ -104,17 +245,65 @@ func (aof *Aof) Read(fn func(obj protocol.RESPObject)) error {
 		return fmt.Errorf("failed to seek to start of file: %w", err)
 	}
 
-	reader := protocol.NewReader(aof.file)
+	cr := &countingReader{r: aof.file}
+	reader := protocol.NewReader(cr)
+	// consumed is the offset of the end of the last record read.
+	consumed := func() int64 { return cr.n - int64(reader.Buffered()) }
+	var multi []protocol.RESPObject
+	var multiStart int64
+	inMulti := false
 	for {
+		start := consumed()
 		value, err := reader.Deserialize()
 		if err != nil {
-			if err == io.EOF {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return fmt.Errorf("failed to deserialize AOF entry at offset %d: %w", start, err)
+			}
+			if consumed() == start && !inMulti {
 				break
 			}
-			return fmt.Errorf("failed to deserialize AOF entry: %w", err)
+			if !inMulti {
+				multiStart = start
+			}
+			return &IncompleteTailError{Offset: multiStart, Commands: len(multi)}
+		}
+		switch commandName(value) {
+		case "MULTI":
+			inMulti, multi, multiStart = true, multi[:0], start
+		case "EXEC":
+			for _, obj := range multi {
+				fn(obj)
+			}
+			inMulti, multi = false, multi[:0]
+		default:
+			if inMulti {
+				multi = append(multi, value)
+			} else {
+				fn(value)
+			}
 		}
-		fn(value)
 	}
+	return nil
+}
 
+// Truncate cuts the file to size and continues appending from there.
+func (aof *Aof) Truncate(size int64) error {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	if err := aof.file.Truncate(size); err != nil {
+		return fmt.Errorf("failed to truncate AOF: %w", err)
+	}
+	if _, err := aof.file.Seek(size, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to truncate AOF: %w", err)
+	}
 	return nil
 }
+
+func commandName(obj protocol.RESPObject) string {
+	argv, ok := obj.Value.([]protocol.RESPObject)
+	if !ok || len(argv) == 0 {
+		return ""
+	}
+	name, _ := argv[0].Value.(string)
+	return strings.ToUpper(name)
+}
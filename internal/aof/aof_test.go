@@ -0,0 +1,93 @@
+package aof
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+func command(argv ...string) protocol.RESPObject {
+	elems := make([]protocol.RESPObject, len(argv))
+	for i, arg := range argv {
+		elems[i] = protocol.RESPObject{Type: protocol.BulkString, Value: arg}
+	}
+	return protocol.RESPObject{Type: protocol.Array, Value: elems}
+}
+
+func readAll(t *testing.T, f *Aof) ([]protocol.RESPObject, error) {
+	t.Helper()
+	var got []protocol.RESPObject
+	err := f.Read(func(obj protocol.RESPObject) { got = append(got, obj) })
+	return got, err
+}
+
+func TestBinarySafeRoundTrip(t *testing.T) {
+	f, err := NewAof(filepath.Join(t.TempDir(), "test.aof"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	want := []protocol.RESPObject{
+		command("SET", "key\r\n", "value\x00with\nbreaks\r"),
+		command("HSET", "h", "\r\n", ""),
+	}
+	if err := f.Write(want...); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readAll(t, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestTornTailIsTruncated(t *testing.T) {
+	complete := command("SET", "a", "1").Serialize()
+	multi := command("MULTI").Serialize() + command("SET", "b", "2").Serialize()
+	torn := command("SET", "c", "3").Serialize()
+	for name, tail := range map[string]string{
+		"mid-line":     torn[:5],
+		"mid-bulk":     torn[:len(torn)-4],
+		"missing-crlf": torn[:len(torn)-1],
+		"open-multi":   multi,
+		"torn-multi":   multi + torn[:7],
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.aof")
+			if err := os.WriteFile(path, []byte(complete+tail), 0666); err != nil {
+				t.Fatal(err)
+			}
+			f, err := NewAof(path, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			got, err := readAll(t, f)
+			var incomplete *IncompleteTailError
+			if !errors.As(err, &incomplete) {
+				t.Fatalf("got error %v, want IncompleteTailError", err)
+			}
+			if incomplete.Offset != int64(len(complete)) {
+				t.Fatalf("offset %d, want %d", incomplete.Offset, len(complete))
+			}
+			if len(got) != 1 {
+				t.Fatalf("replayed %d commands, want 1", len(got))
+			}
+			if err := f.Truncate(incomplete.Offset); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Write(command("SET", "d", "4")); err != nil {
+				t.Fatal(err)
+			}
+			if got, err = readAll(t, f); err != nil || len(got) != 2 {
+				t.Fatalf("after truncating: %d commands, error %v", len(got), err)
+			}
+		})
+	}
+}
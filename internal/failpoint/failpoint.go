@@ -0,0 +1,156 @@
+// Package failpoint injects faults at named points in the server so
+// persistence and latency edge cases can be reproduced deterministically.
+// Failpoints do nothing until enabled, and cost one atomic load when off.
+package failpoint
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The failpoints compiled into the server.
+const (
+	AOFWrite    = "aof-write"    // before appending a command to the AOF
+	AOFFsync    = "aof-fsync"    // before fsyncing the AOF
+	RDBSave     = "rdb-save"     // before writing an RDB snapshot
+	CommandExec = "command-exec" // before a command runs
+)
+
+var known = map[string]bool{AOFWrite: true, AOFFsync: true, RDBSave: true, CommandExec: true}
+
+// ErrInjected is wrapped by every error returned from a failpoint.
+var ErrInjected = errors.New("injected failure")
+
+type action struct {
+	spec  string
+	err   string
+	sleep time.Duration
+	count int64 // remaining evaluations, -1 for unlimited
+}
+
+var (
+	enabled atomic.Bool
+	mu      sync.Mutex
+	active  = map[string]*action{}
+	armed   atomic.Int32
+)
+
+func SetEnabled(on bool) { enabled.Store(on) }
+
+func Enabled() bool { return enabled.Load() }
+
+// Set arms a failpoint. spec is "off", "error", "error(msg)", "sleep(dur)"
+// or "sleep(dur)+error", optionally prefixed with "N*" to fire only N times.
+func Set(name, spec string) error {
+	if !Enabled() {
+		return errors.New("failpoints are disabled")
+	}
+	if !known[name] {
+		return fmt.Errorf("unknown failpoint %q", name)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := active[name]; ok {
+		delete(active, name)
+		armed.Add(-1)
+	}
+	if spec == "off" {
+		return nil
+	}
+
+	a := &action{spec: spec, count: -1}
+	rest := spec
+	if i := strings.IndexByte(rest, '*'); i >= 0 && !strings.Contains(rest[:i], "(") {
+		count, err := strconv.ParseInt(rest[:i], 10, 64)
+		if err != nil || count <= 0 {
+			return fmt.Errorf("invalid count in %q", spec)
+		}
+		a.count, rest = count, rest[i+1:]
+	}
+	for _, term := range strings.Split(rest, "+") {
+		verb, arg, hasArg := strings.Cut(term, "(")
+		if hasArg {
+			if !strings.HasSuffix(arg, ")") {
+				return fmt.Errorf("invalid action %q", term)
+			}
+			arg = strings.TrimSuffix(arg, ")")
+		}
+		switch verb {
+		case "error":
+			a.err = "injected failure"
+			if arg != "" {
+				a.err = arg
+			}
+		case "sleep":
+			d, err := time.ParseDuration(arg)
+			if err != nil {
+				return fmt.Errorf("invalid sleep duration in %q", term)
+			}
+			a.sleep = d
+		default:
+			return fmt.Errorf("invalid action %q", term)
+		}
+	}
+	active[name] = a
+	armed.Add(1)
+	return nil
+}
+
+// Eval runs the failpoint: it sleeps and returns an error if so configured.
+func Eval(name string) error {
+	if armed.Load() == 0 {
+		return nil
+	}
+	mu.Lock()
+	a, ok := active[name]
+	if ok && a.count > 0 {
+		if a.count--; a.count == 0 {
+			delete(active, name)
+			armed.Add(-1)
+		}
+	}
+	mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	if a.sleep > 0 {
+		time.Sleep(a.sleep)
+	}
+	if a.err != "" {
+		return fmt.Errorf("%w at %s: %s", ErrInjected, name, a.err)
+	}
+	return nil
+}
+
+// List returns "name spec" for every known failpoint, "off" if not armed.
+func List() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	var out []string
+	for name := range known {
+		spec := "off"
+		if a, ok := active[name]; ok {
+			spec = a.spec
+			if a.count > 0 {
+				spec += fmt.Sprintf(" (%d left)", a.count)
+			}
+		}
+		out = append(out, name+" "+spec)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func Reset() {
+	mu.Lock()
+	active = map[string]*action{}
+	armed.Store(0)
+	mu.Unlock()
+}
@@ -0,0 +1,293 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+const (
+	ErrSyntax       = "ERR syntax error"
+	ErrInvalidFloat = "ERR value is not a valid float"
+)
+
+type ArgType int
+
+const (
+	ArgString ArgType = iota
+	ArgKey
+	ArgInteger
+	ArgFloat
+	ArgPattern
+)
+
+// Arg is a positional argument. Multiple consumes every remaining argument
+// (at least one); Optional may only be used on the last positional.
+type Arg struct {
+	Name     string
+	Type     ArgType
+	Optional bool
+	Multiple bool
+}
+
+// Option is a keyword following the positionals, e.g. EX seconds.
+// Options sharing a Group are mutually exclusive and may appear only once;
+// ungrouped options may be repeated and the last one wins, unless Repeated
+// is set, which keeps the values of every occurrence in order, as for
+// SORT's GET.
+type Option struct {
+	Name     string
+	Args     []Arg
+	Group    string
+	Repeated bool
+}
+
+// ArgSchema declares a command's arguments so they can be validated and
+// parsed by Parse with the same error replies for every command.
+type ArgSchema struct {
+	Args    []Arg
+	Options []Option
+}
+
+// Parsed holds parsed arguments by name. Option values are stored under the
+// option's name for single-argument options and under "OPTION.arg" otherwise.
+type Parsed struct {
+	values  map[string][]string
+	ints    map[string]int64
+	floats  map[string]float64
+	options map[string]bool
+}
+
+func (p *Parsed) String(name string) string {
+	if v := p.values[name]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (p *Parsed) Strings(name string) []string { return p.values[name] }
+func (p *Parsed) Int(name string) int64        { return p.ints[name] }
+func (p *Parsed) Float(name string) float64    { return p.floats[name] }
+
+// Has reports whether a positional argument or an option was given.
+func (p *Parsed) Has(name string) bool {
+	_, ok := p.values[name]
+	return ok || p.options[name]
+}
+
+// Parse checks args, which exclude the command name, against the schema.
+// The error reply is nil on success.
+func (s *ArgSchema) Parse(command string, args []protocol.RESPObject) (*Parsed, *protocol.RESPObject) {
+	p := &Parsed{
+		values:  map[string][]string{},
+		ints:    map[string]int64{},
+		floats:  map[string]float64{},
+		options: map[string]bool{},
+	}
+	strs, errReply := argStrings(args)
+	if errReply != nil {
+		return nil, errReply
+	}
+
+	i := 0
+	for _, arg := range s.Args {
+		if i >= len(strs) {
+			if arg.Optional {
+				break
+			}
+			return nil, errorReply(fmt.Sprintf(ErrWrongArgCount, command))
+		}
+		n := 1
+		if arg.Multiple {
+			n = len(strs) - i
+		}
+		for _, v := range strs[i : i+n] {
+			if errReply := p.set(arg, arg.Name, v); errReply != nil {
+				return nil, errReply
+			}
+		}
+		i += n
+	}
+
+	groups := map[string]string{}
+	for ; i < len(strs); i++ {
+		opt := s.option(strs[i])
+		if opt == nil {
+			// Past an optional positional, e.g. PING's message, an extra
+			// word is one argument too many rather than an unknown option.
+			if len(s.Options) == 0 && len(s.Args) > 0 && s.Args[len(s.Args)-1].Optional {
+				return nil, errorReply(fmt.Sprintf(ErrWrongArgCount, command))
+			}
+			return nil, errorReply(ErrSyntax)
+		}
+		if opt.Group != "" {
+			if _, seen := groups[opt.Group]; seen {
+				return nil, errorReply(ErrSyntax)
+			}
+			groups[opt.Group] = opt.Name
+		}
+		if i+len(opt.Args) >= len(strs) {
+			return nil, errorReply(ErrSyntax)
+		}
+		p.options[opt.Name] = true
+		for j, arg := range opt.Args {
+			name := opt.Name
+			if len(opt.Args) > 1 {
+				name += "." + arg.Name
+			}
+			if !opt.Repeated {
+				delete(p.values, name)
+			}
+			if errReply := p.set(arg, name, strs[i+1+j]); errReply != nil {
+				return nil, errReply
+			}
+		}
+		i += len(opt.Args)
+	}
+	return p, nil
+}
+
+func (s *ArgSchema) option(word string) *Option {
+	for i := range s.Options {
+		if strings.EqualFold(s.Options[i].Name, word) {
+			return &s.Options[i]
+		}
+	}
+	return nil
+}
+
+func (p *Parsed) set(arg Arg, name, v string) *protocol.RESPObject {
+	switch arg.Type {
+	case ArgInteger:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return errorReply(ErrInvalidInt)
+		}
+		p.ints[name] = n
+	case ArgFloat:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return errorReply(ErrInvalidFloat)
+		}
+		p.floats[name] = f
+	}
+	p.values[name] = append(p.values[name], v)
+	return nil
+}
+
+// argStrings checks that every argument is a string, as sent by clients in
+// a RESP array of bulk strings.
+func argStrings(args []protocol.RESPObject) ([]string, *protocol.RESPObject) {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		s, ok := arg.Value.(string)
+		if !ok {
+			return nil, errorReply("ERR Protocol error: expected bulk string arguments")
+		}
+		strs[i] = s
+	}
+	return strs, nil
+}
+
+func errorReply(msg string) *protocol.RESPObject {
+	return &protocol.RESPObject{Type: protocol.Error, Value: msg}
+}
+
+var argTypeNames = map[ArgType]string{
+	ArgString:  "string",
+	ArgKey:     "key",
+	ArgInteger: "integer",
+	ArgFloat:   "double",
+	ArgPattern: "pattern",
+}
+
+// commandDocs describes cmd's arguments in the COMMAND DOCS format, with
+// each option group as a "oneof" block.
+func commandDocs(cmd *Command) protocol.RESPObject {
+	if cmd.Args == nil {
+		return array(nil)
+	}
+	var docs []protocol.RESPObject
+	for _, arg := range cmd.Args.Args {
+		docs = append(docs, argDoc(arg, "", arg.Optional))
+	}
+	groups := map[string][]protocol.RESPObject{}
+	for _, opt := range cmd.Args.Options {
+		if opt.Group != "" {
+			groups[opt.Group] = append(groups[opt.Group], optionDoc(opt, false))
+		}
+	}
+	for _, opt := range cmd.Args.Options {
+		if opt.Group == "" {
+			docs = append(docs, optionDoc(opt, true))
+			continue
+		}
+		if members, ok := groups[opt.Group]; ok {
+			docs = append(docs, array([]protocol.RESPObject{
+				bulk("name"), bulk(opt.Group), bulk("type"), bulk("oneof"),
+				bulk("flags"), array([]protocol.RESPObject{bulk("optional")}),
+				bulk("arguments"), array(members),
+			}))
+			delete(groups, opt.Group)
+		}
+	}
+	return array([]protocol.RESPObject{bulk("arguments"), array(docs)})
+}
+
+func argDoc(arg Arg, token string, optional bool) protocol.RESPObject {
+	fields := []protocol.RESPObject{bulk("name"), bulk(arg.Name), bulk("type"), bulk(argTypeNames[arg.Type])}
+	if token != "" {
+		fields = append(fields, bulk("token"), bulk(token))
+	}
+	return array(appendFlags(fields, optional, arg.Multiple))
+}
+
+func optionDoc(opt Option, optional bool) protocol.RESPObject {
+	if len(opt.Args) == 1 {
+		return argDoc(opt.Args[0], opt.Name, optional)
+	}
+	fields := []protocol.RESPObject{bulk("name"), bulk(strings.ToLower(opt.Name))}
+	if len(opt.Args) == 0 {
+		fields = append(fields, bulk("type"), bulk("pure-token"), bulk("token"), bulk(opt.Name))
+	} else {
+		block := make([]protocol.RESPObject, len(opt.Args))
+		for i, arg := range opt.Args {
+			block[i] = argDoc(arg, "", false)
+		}
+		fields = append(fields, bulk("type"), bulk("block"), bulk("token"), bulk(opt.Name), bulk("arguments"), array(block))
+	}
+	return array(appendFlags(fields, optional, false))
+}
+
+func appendFlags(fields []protocol.RESPObject, optional, multiple bool) []protocol.RESPObject {
+	var flags []protocol.RESPObject
+	if optional {
+		flags = append(flags, bulk("optional"))
+	}
+	if multiple {
+		flags = append(flags, bulk("multiple"))
+	}
+	if len(flags) == 0 {
+		return fields
+	}
+	return append(fields, bulk("flags"), array(flags))
+}
+
+func bulk(s string) protocol.RESPObject {
+	return protocol.RESPObject{Type: protocol.BulkString, Value: s}
+}
+
+func array(values []protocol.RESPObject) protocol.RESPObject {
+	if values == nil {
+		values = []protocol.RESPObject{}
+	}
+	return protocol.RESPObject{Type: protocol.Array, Value: values}
+}
+
+// stream replies with the array fn produces, encoded straight from the
+// dataset once the command returns instead of being built in full first.
+func stream(fn protocol.StreamFunc) protocol.RESPObject {
+	return protocol.RESPObject{Type: protocol.Stream, Value: fn}
+}
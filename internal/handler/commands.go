@@ -0,0 +1,274 @@
+package handler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+type HandlerFunc func([]protocol.RESPObject) protocol.RESPObject
+
+// Command describes a command for dispatch and introspection. Arity follows
+// the Redis convention: it counts the command name itself, and a negative
+// value means "at least -Arity arguments".
+type Command struct {
+	Name     string
+	Arity    int
+	Flags    []string
+	FirstKey int
+	LastKey  int
+	KeyStep  int
+	// MovableKeys, if set, returns the positions of the keys in args that
+	// follow the fixed ones, such as SORT's STORE destination.
+	MovableKeys func(args []protocol.RESPObject) []int
+	// Args optionally declares the arguments, see ArgSchema. Handlers
+	// parse with it; it is also reported by COMMAND DOCS.
+	Args    *ArgSchema
+	Handler HandlerFunc
+}
+
+func (c *Command) HasFlag(flag string) bool {
+	for _, f := range c.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckArity validates argc, which excludes the command name.
+func (c *Command) CheckArity(argc int) bool {
+	if c.Arity >= 0 {
+		return argc+1 == c.Arity
+	}
+	return argc+1 >= -c.Arity
+}
+
+// KeyIndexes returns the positions of the key arguments in args, which
+// excludes the command name. A negative LastKey counts from the end.
+func (c *Command) KeyIndexes(args []protocol.RESPObject) []int {
+	argc := len(args)
+	if c.FirstKey <= 0 {
+		return nil
+	}
+	last := c.LastKey
+	if last < 0 {
+		last = argc + 1 + last
+	}
+	step := c.KeyStep
+	if step <= 0 {
+		step = 1
+	}
+	var idx []int
+	for i := c.FirstKey; i <= last && i <= argc; i += step {
+		idx = append(idx, i-1)
+	}
+	if c.MovableKeys != nil {
+		idx = append(idx, c.MovableKeys(args)...)
+	}
+	return idx
+}
+
+var (
+	commandsMu sync.RWMutex
+	commands   = map[string]*Command{
+		"ECHO":         {Name: "echo", Arity: 2, Flags: []string{"fast"}, Args: echoArgs, Handler: echo},
+		"PING":         {Name: "ping", Arity: -1, Flags: []string{"fast"}, Args: pingArgs, Handler: ping},
+		"SET":          {Name: "set", Arity: -3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: setArgs, Handler: set},
+		"SETEX":        {Name: "setex", Arity: 4, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: setexArgs, Handler: setex},
+		"PSETEX":       {Name: "psetex", Arity: 4, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: psetexArgs, Handler: psetex},
+		"SETNX":        {Name: "setnx", Arity: 3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: setnxArgs, Handler: setnx},
+		"GET":          {Name: "get", Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: getArgs, Handler: get},
+		"DEL":          {Name: "del", Arity: -2, Flags: []string{"write"}, FirstKey: 1, LastKey: -1, KeyStep: 1, Args: delArgs, Handler: del},
+		"RENAME":       {Name: "rename", Arity: 3, Flags: []string{"write"}, FirstKey: 1, LastKey: 2, KeyStep: 1, Args: renameArgs, Handler: rename},
+		"RENAMENX":     {Name: "renamenx", Arity: 3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 2, KeyStep: 1, Args: renameArgs, Handler: renamenx},
+		"COPY":         {Name: "copy", Arity: -3, Flags: []string{"write"}, FirstKey: 1, LastKey: 2, KeyStep: 1, Args: copyArgs, Handler: copyKey},
+		"TOUCH":        {Name: "touch", Arity: -2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: -1, KeyStep: 1, Args: touchArgs, Handler: touch},
+		"UNLINK":       {Name: "unlink", Arity: -2, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: -1, KeyStep: 1, Args: delArgs, Handler: unlink},
+		"EXISTS":       {Name: "exists", Arity: -2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: -1, KeyStep: 1, Args: existsArgs, Handler: exists},
+		"HSET":         {Name: "hset", Arity: -4, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: hsetArgs, Handler: hset},
+		"HGET":         {Name: "hget", Arity: 3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: hgetArgs, Handler: hget},
+		"HGETALL":      {Name: "hgetall", Arity: 2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: hgetallArgs, Handler: hgetall},
+		"HEXISTS":      {Name: "hexists", Arity: 3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: hexistsArgs, Handler: hexists},
+		"HLEN":         {Name: "hlen", Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: hlenArgs, Handler: hlen},
+		"HSTRLEN":      {Name: "hstrlen", Arity: 3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: hstrlenArgs, Handler: hstrlen},
+		"HKEYS":        {Name: "hkeys", Arity: 2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: hkeysArgs, Handler: hkeys},
+		"HVALS":        {Name: "hvals", Arity: 2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: hvalsArgs, Handler: hvals},
+		"HMGET":        {Name: "hmget", Arity: -3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: hmgetArgs, Handler: hmget},
+		"HRANDFIELD":   {Name: "hrandfield", Arity: -2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: hrandfieldArgs, Handler: hrandfield},
+		"HSCAN":        {Name: "hscan", Arity: -3, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: hscanArgs, Handler: hscan},
+		"HDEL":         {Name: "hdel", Arity: -3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: hdelArgs, Handler: hdel},
+		"HINCRBY":      {Name: "hincrby", Arity: 4, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: hincrbyArgs, Handler: hincrby},
+		"HINCRBYFLOAT": {Name: "hincrbyfloat", Arity: 4, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: hincrbyfloatArgs, Handler: hincrbyfloat},
+		"HEXPIRE":      {Name: "hexpire", Arity: -6, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: hexpireArgs, Handler: hexpire},
+		"HPEXPIRE":     {Name: "hpexpire", Arity: -6, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: hpexpireArgs, Handler: hpexpire},
+		"HEXPIREAT":    {Name: "hexpireat", Arity: -6, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: hexpireatArgs, Handler: hexpireat},
+		"HPEXPIREAT":   {Name: "hpexpireat", Arity: -6, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: hpexpireatArgs, Handler: hpexpireat},
+		"HGETEX":       {Name: "hgetex", Arity: -5, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: hgetexArgs, Handler: hgetex},
+		"HGETDEL":      {Name: "hgetdel", Arity: -5, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: httlArgs, Handler: hgetdel},
+		"HTTL":         {Name: "httl", Arity: -5, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: httlArgs, Handler: httl},
+		"HPTTL":        {Name: "hpttl", Arity: -5, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: httlArgs, Handler: hpttl},
+		"HEXPIRETIME":  {Name: "hexpiretime", Arity: -5, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: httlArgs, Handler: hexpiretime},
+		"HPEXPIRETIME": {Name: "hpexpiretime", Arity: -5, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: httlArgs, Handler: hpexpiretime},
+		"HPERSIST":     {Name: "hpersist", Arity: -5, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: httlArgs, Handler: hpersist},
+		"LPUSH":        {Name: "lpush", Arity: -3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: pushArgs, Handler: lpush},
+		"RPUSH":        {Name: "rpush", Arity: -3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: pushArgs, Handler: rpush},
+		"LPOP":         {Name: "lpop", Arity: -2, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: popArgs, Handler: lpop},
+		"RPOP":         {Name: "rpop", Arity: -2, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: popArgs, Handler: rpop},
+		"LLEN":         {Name: "llen", Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: llenArgs, Handler: llen},
+		"LMOVE":        {Name: "lmove", Arity: 5, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 2, KeyStep: 1, Args: lmoveArgs, Handler: lmove},
+		"RPOPLPUSH":    {Name: "rpoplpush", Arity: 3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 2, KeyStep: 1, Args: rpoplpushArgs, Handler: rpoplpush},
+		"LREM":         {Name: "lrem", Arity: 4, Flags: []string{"write"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: lremArgs, Handler: lrem},
+		"LTRIM":        {Name: "ltrim", Arity: 4, Flags: []string{"write"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: ltrimArgs, Handler: ltrim},
+		"LINSERT":      {Name: "linsert", Arity: 5, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: linsertArgs, Handler: linsert},
+		"EXPIRE":       {Name: "expire", Arity: -3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: expireArgs, Handler: expire},
+		"PEXPIRE":      {Name: "pexpire", Arity: -3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: pexpireArgs, Handler: pexpire},
+		"EXPIREAT":     {Name: "expireat", Arity: -3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: expireatArgs, Handler: expireat},
+		"PEXPIREAT":    {Name: "pexpireat", Arity: -3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: pexpireatArgs, Handler: pexpireat},
+		"PERSIST":      {Name: "persist", Arity: 2, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: persistArgs, Handler: persist},
+		"TTL":          {Name: "ttl", Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: ttlArgs, Handler: ttl},
+		"PTTL":         {Name: "pttl", Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: ttlArgs, Handler: pttl},
+		"EXPIRETIME":   {Name: "expiretime", Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: ttlArgs, Handler: expiretime},
+		"PEXPIRETIME":  {Name: "pexpiretime", Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: ttlArgs, Handler: pexpiretime},
+		"INCR":         {Name: "incr", Arity: 2, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: incrArgs, Handler: incr},
+		"DECR":         {Name: "decr", Arity: 2, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: incrArgs, Handler: decr},
+		"INCRBY":       {Name: "incrby", Arity: 3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: incrbyArgs, Handler: incrby},
+		"DECRBY":       {Name: "decrby", Arity: 3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: decrbyArgs, Handler: decrby},
+		"INCRBYFLOAT":  {Name: "incrbyfloat", Arity: 3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: incrbyfloatArgs, Handler: incrbyfloat},
+		"APPEND":       {Name: "append", Arity: 3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: appendArgs, Handler: appendValue},
+		"STRLEN":       {Name: "strlen", Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: strlenArgs, Handler: strlen},
+		"GETRANGE":     {Name: "getrange", Arity: 4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: getrangeArgs, Handler: getrange},
+		"SORT":         {Name: "sort", Arity: -2, Flags: []string{"write", "denyoom", "movablekeys"}, FirstKey: 1, LastKey: 1, KeyStep: 1, MovableKeys: sortStoreKeys, Args: sortArgs, Handler: sortCommand},
+		"SORT_RO":      {Name: "sort_ro", Arity: -2, Flags: []string{"readonly", "movablekeys"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: sortROArgs, Handler: sortRO},
+		"LCS":          {Name: "lcs", Arity: -3, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 2, KeyStep: 1, Args: lcsArgs, Handler: lcs},
+		"SETRANGE":     {Name: "setrange", Arity: 4, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: setrangeArgs, Handler: setrange},
+		"MSET":         {Name: "mset", Arity: -3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: -1, KeyStep: 2, Args: msetArgs, Handler: mset},
+		"MSETNX":       {Name: "msetnx", Arity: -3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: -1, KeyStep: 2, Args: msetArgs, Handler: msetnx},
+		"MGET":         {Name: "mget", Arity: -2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: -1, KeyStep: 1, Args: mgetArgs, Handler: mget},
+		"GETSET":       {Name: "getset", Arity: 3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: getsetArgs, Handler: getset},
+		"GETDEL":       {Name: "getdel", Arity: 2, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: getdelArgs, Handler: getdel},
+		"GETEX":        {Name: "getex", Arity: -2, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: getexArgs, Handler: getex},
+		"TYPE":         {Name: "type", Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Args: typeArgs, Handler: keyType},
+		"DBSIZE":       {Name: "dbsize", Arity: 1, Flags: []string{"readonly", "fast"}, Args: dbsizeArgs, Handler: dbsize},
+		"FLUSHDB":      {Name: "flushdb", Arity: -1, Flags: []string{"write"}, Args: flushArgs, Handler: flushdb},
+		"FLUSHALL":     {Name: "flushall", Arity: -1, Flags: []string{"write"}, Args: flushArgs, Handler: flushall},
+		"RANDOMKEY":    {Name: "randomkey", Arity: 1, Flags: []string{"readonly"}, Args: randomkeyArgs, Handler: randomkey},
+		"KEYS":         {Name: "keys", Arity: 2, Flags: []string{"readonly"}, Args: keysArgs, Handler: keys},
+		"SCAN":         {Name: "scan", Arity: -2, Flags: []string{"readonly"}, Args: scanArgs, Handler: scan},
+		"DEBUG":        {Name: "debug", Arity: -2, Flags: []string{"admin"}, Handler: debug},
+		"OBJECT":       {Name: "object", Arity: -2, Flags: []string{"readonly"}, FirstKey: 2, LastKey: 2, KeyStep: 1, Handler: object},
+	}
+)
+
+func init() {
+	commands["COMMAND"] = &Command{Name: "command", Arity: -1, Handler: command}
+}
+
+func Lookup(name string) (*Command, bool) {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+	cmd, ok := commands[strings.ToUpper(name)]
+	return cmd, ok
+}
+
+func Register(cmd *Command) error {
+	if cmd.Name == "" || strings.ContainsAny(cmd.Name, " \t\r\n") {
+		return fmt.Errorf("invalid command name %q", cmd.Name)
+	}
+	if cmd.Arity == 0 {
+		return fmt.Errorf("command %s: arity must not be 0", cmd.Name)
+	}
+	if cmd.Handler == nil {
+		return fmt.Errorf("command %s: missing handler", cmd.Name)
+	}
+
+	commandsMu.Lock()
+	defer commandsMu.Unlock()
+	name := strings.ToUpper(cmd.Name)
+	if _, exists := commands[name]; exists {
+		return fmt.Errorf("command %s already registered", cmd.Name)
+	}
+	cmd.Name = strings.ToLower(cmd.Name)
+	commands[name] = cmd
+	return nil
+}
+
+func allCommands() []*Command {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+	all := make([]*Command, 0, len(commands))
+	for _, cmd := range commands {
+		all = append(all, cmd)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+func commandInfo(cmd *Command) protocol.RESPObject {
+	flags := make([]protocol.RESPObject, len(cmd.Flags))
+	for i, f := range cmd.Flags {
+		flags[i] = protocol.RESPObject{Type: protocol.SimpleString, Value: f}
+	}
+	return protocol.RESPObject{Type: protocol.Array, Value: []protocol.RESPObject{
+		{Type: protocol.BulkString, Value: cmd.Name},
+		{Type: protocol.Integer, Value: cmd.Arity},
+		{Type: protocol.Array, Value: flags},
+		{Type: protocol.Integer, Value: cmd.FirstKey},
+		{Type: protocol.Integer, Value: cmd.LastKey},
+		{Type: protocol.Integer, Value: cmd.KeyStep},
+	}}
+}
+
+func command(args []protocol.RESPObject) protocol.RESPObject {
+	if len(args) == 0 {
+		all := allCommands()
+		values := make([]protocol.RESPObject, len(all))
+		for i, cmd := range all {
+			values[i] = commandInfo(cmd)
+		}
+		return protocol.RESPObject{Type: protocol.Array, Value: values}
+	}
+
+	switch strings.ToUpper(args[0].Value.(string)) {
+	case "COUNT":
+		return protocol.RESPObject{Type: protocol.Integer, Value: len(allCommands())}
+	case "INFO":
+		values := make([]protocol.RESPObject, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			if cmd, ok := Lookup(arg.Value.(string)); ok {
+				values = append(values, commandInfo(cmd))
+			} else {
+				values = append(values, protocol.RESPObject{Type: protocol.Null})
+			}
+		}
+		return protocol.RESPObject{Type: protocol.Array, Value: values}
+	case "LIST":
+		all := allCommands()
+		values := make([]protocol.RESPObject, len(all))
+		for i, cmd := range all {
+			values[i] = protocol.RESPObject{Type: protocol.BulkString, Value: cmd.Name}
+		}
+		return protocol.RESPObject{Type: protocol.Array, Value: values}
+	case "DOCS":
+		// Only arguments are documented, for commands declaring an ArgSchema.
+		var cmds []*Command
+		if len(args) == 1 {
+			cmds = allCommands()
+		}
+		for _, arg := range args[1:] {
+			if cmd, ok := Lookup(arg.Value.(string)); ok {
+				cmds = append(cmds, cmd)
+			}
+		}
+		values := make([]protocol.RESPObject, 0, 2*len(cmds))
+		for _, cmd := range cmds {
+			values = append(values, bulk(cmd.Name), commandDocs(cmd))
+		}
+		return protocol.RESPObject{Type: protocol.Array, Value: values}
+	default:
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrUnknownSubcommand, args[0].Value, "COMMAND")}
+	}
+}
@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+const (
+	ErrOverflow = "ERR increment or decrement would overflow"
+	ErrNaN      = "ERR increment would produce NaN or Infinity"
+)
+
+var (
+	incrArgs   = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}}}
+	incrbyArgs = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "increment", Type: ArgInteger}}}
+	decrbyArgs = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "decrement", Type: ArgInteger}}}
+
+	incrbyfloatArgs  = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "increment", Type: ArgFloat}}}
+	hincrbyArgs      = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "field"}, {Name: "increment", Type: ArgInteger}}}
+	hincrbyfloatArgs = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "field"}, {Name: "increment", Type: ArgFloat}}}
+)
+
+func incr(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := incrArgs.Parse("incr", args)
+	if errReply != nil {
+		return *errReply
+	}
+	return incrBy(p.String("key"), 1)
+}
+
+func decr(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := incrArgs.Parse("decr", args)
+	if errReply != nil {
+		return *errReply
+	}
+	return incrBy(p.String("key"), -1)
+}
+
+func incrby(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := incrbyArgs.Parse("incrby", args)
+	if errReply != nil {
+		return *errReply
+	}
+	return incrBy(p.String("key"), p.Int("increment"))
+}
+
+func decrby(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := decrbyArgs.Parse("decrby", args)
+	if errReply != nil {
+		return *errReply
+	}
+	if p.Int("decrement") == math.MinInt64 {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR decrement would overflow"}
+	}
+	return incrBy(p.String("key"), -p.Int("decrement"))
+}
+
+// incrBy adds delta to the integer stored at key, a missing key counting as
+// 0. Concurrent increments are never lost, and like in Redis the key keeps
+// its TTL.
+func incrBy(key string, delta int64) protocol.RESPObject {
+	var n int64
+	errReply := ErrWrongType // unless updateString calls fn
+	updated := updateString(key, func(cur Value, exists bool) (Value, bool) {
+		if n, errReply = addInt(cur.Data, exists, delta, ErrInvalidInt); errReply != "" {
+			return cur, false
+		}
+		return Value{Data: strconv.FormatInt(n, 10), ExpiresAt: cur.ExpiresAt}, true
+	})
+	if !updated {
+		return protocol.RESPObject{Type: protocol.Error, Value: errReply}
+	}
+	notify("incrby", key)
+	return protocol.RESPObject{Type: protocol.Integer, Value: n}
+}
+
+// addInt parses cur, missing counting as 0, and adds delta, returning an
+// error reply if cur isn't an integer or the sum overflows.
+func addInt(cur string, exists bool, delta int64, notInt string) (int64, string) {
+	var n int64
+	if exists {
+		var err error
+		if n, err = strconv.ParseInt(cur, 10, 64); err != nil {
+			return 0, notInt
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, ErrOverflow
+	}
+	return n + delta, ""
+}
+
+func hincrby(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := hincrbyArgs.Parse("hincrby", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	hash := p.String("key")
+	if holdsNonHash(hash) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	var n int64
+	var errMsg string
+	updated := updateHashField(hash, p.String("field"), func(cur string, exists bool) (string, bool) {
+		n, errMsg = addInt(cur, exists, p.Int("increment"), "ERR hash value is not an integer")
+		return strconv.FormatInt(n, 10), errMsg == ""
+	})
+	if !updated {
+		return protocol.RESPObject{Type: protocol.Error, Value: errMsg}
+	}
+	notify("hincrby", hash)
+	return protocol.RESPObject{Type: protocol.Integer, Value: n}
+}
+
+// parseFloat parses a stored number, rejecting NaN and out of range values
+// like Redis does.
+func parseFloat(s string) (float64, bool) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, false
+	}
+	return f, true
+}
+
+// formatFloat formats f without an exponent or trailing zeroes, e.g. 3 and
+// 10.5, so that the result reads back as the same number.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// addFloat parses cur, missing counting as 0, and adds delta, returning an
+// error reply if either isn't a number or the sum isn't finite.
+func addFloat(cur string, exists bool, delta float64, notFloat string) (string, string) {
+	var f float64
+	if exists {
+		var ok bool
+		if f, ok = parseFloat(cur); !ok {
+			return "", notFloat
+		}
+	}
+	f += delta
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", ErrNaN
+	}
+	return formatFloat(f), ""
+}
+
+func incrbyfloat(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := incrbyfloatArgs.Parse("incrbyfloat", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	key := p.String("key")
+	result, errMsg := "", ErrWrongType // unless updateString calls fn
+	updated := updateString(key, func(cur Value, exists bool) (Value, bool) {
+		if result, errMsg = addFloat(cur.Data, exists, p.Float("increment"), ErrInvalidFloat); errMsg != "" {
+			return cur, false
+		}
+		return Value{Data: result, ExpiresAt: cur.ExpiresAt}, true
+	})
+	if !updated {
+		return protocol.RESPObject{Type: protocol.Error, Value: errMsg}
+	}
+	notify("incrbyfloat", key)
+	return protocol.RESPObject{Type: protocol.BulkString, Value: result}
+}
+
+func hincrbyfloat(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := hincrbyfloatArgs.Parse("hincrbyfloat", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	hash := p.String("key")
+	if holdsNonHash(hash) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	var result, errMsg string
+	updated := updateHashField(hash, p.String("field"), func(cur string, exists bool) (string, bool) {
+		result, errMsg = addFloat(cur, exists, p.Float("increment"), "ERR hash value is not a float")
+		return result, errMsg == ""
+	})
+	if !updated {
+		return protocol.RESPObject{Type: protocol.Error, Value: errMsg}
+	}
+	notify("hincrbyfloat", hash)
+	return protocol.RESPObject{Type: protocol.BulkString, Value: result}
+}
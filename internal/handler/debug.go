@@ -0,0 +1,331 @@
+package handler
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/failpoint"
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+	"github.com/ashish-kamra/redis-clone/internal/rdb"
+)
+
+const (
+	defaultHotKeysCount = 10
+	// sharedRefcount is the refcount Redis reports for its shared integers.
+	sharedRefcount = 2147483647
+	lruClockMax    = 1<<24 - 1
+)
+
+// noActiveExpire is set by DEBUG SET-ACTIVE-EXPIRE 0.
+var noActiveExpire atomic.Bool
+
+// ActiveExpire reports whether the server should run its active expire
+// cycle.
+func ActiveExpire() bool {
+	return !noActiveExpire.Load()
+}
+
+func debug(args []protocol.RESPObject) protocol.RESPObject {
+	if len(args) == 0 {
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrWrongArgCount, "debug")}
+	}
+
+	switch strings.ToUpper(args[0].Value.(string)) {
+	case "BIGKEYS":
+		return debugBigKeys(args[1:])
+	case "HOTKEYS":
+		return debugHotKeys(args[1:])
+	case "FAILPOINT":
+		return debugFailpoint(args[1:])
+	case "LISTPACK":
+		return debugListpack(args[1:])
+	case "HISTOGRAM":
+		return debugHistogram(args[1:])
+	case "OBJECT":
+		return debugObject(args[1:])
+	case "SET-ACTIVE-EXPIRE":
+		return debugSetActiveExpire(args[1:])
+	case "SCAN-CHECK":
+		return debugScanCheck(args[1:])
+	default:
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrUnknownSubcommand, args[0].Value, "DEBUG")}
+	}
+}
+
+type bigKey struct {
+	typ   string
+	key   string
+	size  int
+	count int
+}
+
+// debugBigKeys walks the keyspace once and reports the largest key of
+// each type: strings by byte length, hashes by field count. sync.Map.Range
+// visits entries without holding a lock over the whole map, so writers are
+// never blocked behind the scan.
+func debugBigKeys(args []protocol.RESPObject) protocol.RESPObject {
+	if len(args) != 0 {
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrWrongArgCount, "debug|bigkeys")}
+	}
+
+	now := time.Now()
+	str := bigKey{typ: "string"}
+	hash := bigKey{typ: "hash"}
+	list := bigKey{typ: "list"}
+	keyspace.Range(func(k, v interface{}) bool {
+		o := v.(typedValue)
+		if o.expired(now) {
+			return true
+		}
+		switch value := o.value.(type) {
+		case string:
+			str.count++
+			if len(value) > str.size || str.key == "" {
+				str.key, str.size = k.(string), len(value)
+			}
+		case *hashValue:
+			fields := 0
+			value.Range(func(_, _ interface{}) bool {
+				fields++
+				return true
+			})
+			hash.count++
+			if fields > hash.size || hash.key == "" {
+				hash.key, hash.size = k.(string), fields
+			}
+		case *listValue:
+			n := value.len()
+			list.count++
+			if n > list.size || list.key == "" {
+				list.key, list.size = k.(string), n
+			}
+		}
+		return true
+	})
+
+	var values []protocol.RESPObject
+	for _, bk := range []bigKey{str, hash, list} {
+		if bk.count == 0 {
+			continue
+		}
+		values = append(values, protocol.RESPObject{Type: protocol.Array, Value: []protocol.RESPObject{
+			{Type: protocol.BulkString, Value: bk.typ},
+			{Type: protocol.BulkString, Value: bk.key},
+			{Type: protocol.Integer, Value: bk.size},
+			{Type: protocol.Integer, Value: bk.count},
+		}})
+	}
+	return protocol.RESPObject{Type: protocol.Array, Value: values}
+}
+
+func debugHotKeys(args []protocol.RESPObject) protocol.RESPObject {
+	if len(args) > 1 {
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrWrongArgCount, "debug|hotkeys")}
+	}
+
+	count := defaultHotKeysCount
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0].Value.(string))
+		if err != nil || n <= 0 {
+			return protocol.RESPObject{Type: protocol.Error, Value: ErrInvalidInt}
+		}
+		count = n
+	}
+
+	type hotKey struct {
+		key  string
+		freq uint8
+	}
+	var hot []hotKey
+	Freqs.Range(func(k, v interface{}) bool {
+		key := k.(string)
+		if !KeyExists(key) {
+			return true
+		}
+		hot = append(hot, hotKey{key: key, freq: v.(*lfuCounter).freq()})
+		return true
+	})
+
+	sort.Slice(hot, func(i, j int) bool {
+		if hot[i].freq != hot[j].freq {
+			return hot[i].freq > hot[j].freq
+		}
+		return hot[i].key < hot[j].key
+	})
+	if len(hot) > count {
+		hot = hot[:count]
+	}
+
+	values := make([]protocol.RESPObject, 0, len(hot))
+	for _, hk := range hot {
+		values = append(values, protocol.RESPObject{Type: protocol.Array, Value: []protocol.RESPObject{
+			{Type: protocol.BulkString, Value: hk.key},
+			{Type: protocol.Integer, Value: int(hk.freq)},
+		}})
+	}
+	return protocol.RESPObject{Type: protocol.Array, Value: values}
+}
+
+// debugObject describes a key in the format of Redis' DEBUG OBJECT, with
+// its LFU frequency added. serializedlength is the size of the value in our
+// RDB files, which aren't compressed.
+func debugObject(args []protocol.RESPObject) protocol.RESPObject {
+	if len(args) != 1 {
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrWrongArgCount, "debug|object")}
+	}
+
+	key := args[0].Value.(string)
+	freq, lastAccess, _ := keyAccess(key)
+	var counter *lfuCounter
+	if c, ok := Freqs.Load(key); ok {
+		counter = c.(*lfuCounter)
+	}
+	encoding, ok := objectEncoding(key)
+	if !ok {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrNoSuchKey}
+	}
+
+	e, _ := Peek(key)
+	var length int
+	switch v := e.Value.(type) {
+	case string:
+		length = rdb.StringLength(v)
+	case map[string]string:
+		length = rdb.HashLength(v)
+	case []string:
+		length = rdb.ListLength(v)
+	default:
+		if e.Module != nil && e.Module.Marshal != nil {
+			if data, err := e.Module.Marshal(v); err == nil {
+				length = rdb.ModuleLength(e.Type, data)
+			}
+		}
+	}
+
+	return protocol.RESPObject{Type: protocol.SimpleString, Value: fmt.Sprintf(
+		"Value at:%p refcount:%d encoding:%s serializedlength:%d lru:%d lru_seconds_idle:%d freq:%d",
+		counter, objectRefcount(key, encoding), encoding, length, lastAccess.Unix()&lruClockMax, int64(idleTime(lastAccess).Seconds()), freq)}
+}
+
+// debugSetActiveExpire turns the server's active expire cycle off with 0
+// and back on with 1. Expired keys still read as missing while it is off.
+func debugSetActiveExpire(args []protocol.RESPObject) protocol.RESPObject {
+	if len(args) != 1 {
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrWrongArgCount, "debug|set-active-expire")}
+	}
+	switch args[0].Value.(string) {
+	case "0":
+		noActiveExpire.Store(true)
+	case "1":
+		noActiveExpire.Store(false)
+	default:
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrSyntax}
+	}
+	return protocol.RESPObject{Type: protocol.SimpleString, Value: "OK"}
+}
+
+// debugScanCheck implements DEBUG SCAN-CHECK [keys [count]]. It fills a
+// private keyspace with keys, SCANs it count at a time while another
+// goroutine keeps inserting and deleting keys, which also makes sync.Map
+// promote and rebuild its internal maps, and checks SCAN's guarantees: each
+// key present throughout is returned exactly once and the cursor only moves
+// forward. The live keyspace is not touched.
+func debugScanCheck(args []protocol.RESPObject) protocol.RESPObject {
+	if len(args) > 2 {
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrWrongArgCount, "debug|scan-check")}
+	}
+	limits := []int64{1000, defaultScanCount}
+	for i, arg := range args {
+		n, err := strconv.ParseInt(arg.Value.(string), 10, 64)
+		if err != nil || n < 1 {
+			return protocol.RESPObject{Type: protocol.Error, Value: ErrInvalidInt}
+		}
+		limits[i] = n
+	}
+	keys, count := int(limits[0]), int(limits[1])
+
+	m := newKeyMap()
+	for i := 0; i < keys; i++ {
+		m.Store(fmt.Sprintf("stable:%d", i), typedValue{value: "x"})
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			// Keep at most keys churned keys, so the scan can finish.
+			m.Store(fmt.Sprintf("churn:%d", i), typedValue{value: "x"})
+			if i >= keys {
+				m.Delete(fmt.Sprintf("churn:%d", i-keys))
+			}
+		}
+	}()
+
+	seen := map[string]bool{}
+	var violation string
+	for cursor := uint64(0); violation == ""; {
+		entries, next := scanBatch(m, cursor, count, "")
+		for _, e := range entries {
+			if seen[e.key] {
+				violation = fmt.Sprintf("key '%s' returned twice", e.key)
+				break
+			}
+			seen[e.key] = true
+		}
+		if next == 0 {
+			break
+		}
+		if next <= cursor {
+			violation = fmt.Sprintf("cursor moved back from %d to %d", cursor, next)
+		}
+		cursor = next
+	}
+	close(done)
+	wg.Wait()
+	for i := 0; i < keys && violation == ""; i++ {
+		if key := fmt.Sprintf("stable:%d", i); !seen[key] {
+			violation = fmt.Sprintf("key '%s' never returned", key)
+		}
+	}
+	if violation != "" {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR SCAN invariant violated: " + violation}
+	}
+	return protocol.RESPObject{Type: protocol.SimpleString, Value: "OK"}
+}
+
+// debugFailpoint implements DEBUG FAILPOINT LIST|RESET and
+// DEBUG FAILPOINT <name> <spec>, see failpoint.Set for the spec syntax.
+func debugFailpoint(args []protocol.RESPObject) protocol.RESPObject {
+	if !failpoint.Enabled() {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR failpoints are disabled, start the server with failpoints enabled"}
+	}
+	switch {
+	case len(args) == 1 && strings.EqualFold(args[0].Value.(string), "LIST"):
+		list := failpoint.List()
+		values := make([]protocol.RESPObject, len(list))
+		for i, fp := range list {
+			values[i] = protocol.RESPObject{Type: protocol.BulkString, Value: fp}
+		}
+		return protocol.RESPObject{Type: protocol.Array, Value: values}
+	case len(args) == 1 && strings.EqualFold(args[0].Value.(string), "RESET"):
+		failpoint.Reset()
+	case len(args) == 2:
+		if err := failpoint.Set(strings.ToLower(args[0].Value.(string)), args[1].Value.(string)); err != nil {
+			return protocol.RESPObject{Type: protocol.Error, Value: "ERR " + err.Error()}
+		}
+	default:
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR syntax error"}
+	}
+	return protocol.RESPObject{Type: protocol.SimpleString, Value: "OK"}
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+// Hashes are always stored as a sync.Map, but report the encoding Redis
+// would use: listpack while small, hashtable once they outgrow either limit.
+// As in Redis the conversion is one-way, and changing the limits only
+// affects hashes written afterwards.
+var (
+	hashMaxListpackEntries atomic.Int64
+	hashMaxListpackValue   atomic.Int64
+)
+
+func init() {
+	hashMaxListpackEntries.Store(128)
+	hashMaxListpackValue.Store(64)
+}
+
+func HashMaxListpackEntries() int64     { return hashMaxListpackEntries.Load() }
+func SetHashMaxListpackEntries(n int64) { hashMaxListpackEntries.Store(n) }
+func HashMaxListpackValue() int64       { return hashMaxListpackValue.Load() }
+func SetHashMaxListpackValue(n int64)   { hashMaxListpackValue.Store(n) }
+
+// updateHashEncoding converts h to hashtable after field was set to value
+// if the hash no longer fits the listpack limits.
+func updateHashEncoding(h *hashValue, field, value string) {
+	if h.hashtable.Load() {
+		return
+	}
+	maxValue := hashMaxListpackValue.Load()
+	convert := int64(len(field)) > maxValue || int64(len(value)) > maxValue
+	if !convert {
+		convert = h.fields.Load() > hashMaxListpackEntries.Load()
+	}
+	if convert {
+		h.hashtable.Store(true)
+	}
+}
+
+func hashEncoding(h *hashValue) string {
+	if h.hashtable.Load() {
+		return "hashtable"
+	}
+	return "listpack"
+}
+
+// debugListpack describes the listpack a small hash would be stored as:
+// its estimated size in bytes and the size of every entry, fields sorted.
+func debugListpack(args []protocol.RESPObject) protocol.RESPObject {
+	if len(args) != 1 {
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrWrongArgCount, "debug|listpack")}
+	}
+	o, ok := peek(args[0].Value.(string))
+	if !ok {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrNoSuchKey}
+	}
+	h, ok := o.value.(*hashValue)
+	if !ok || hashEncoding(h) != "listpack" {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR Not a listpack encoded object."}
+	}
+
+	type pair struct{ field, value string }
+	var pairs []pair
+	h.Range(func(f, v interface{}) bool {
+		pairs = append(pairs, pair{f.(string), v.(string)})
+		return true
+	})
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].field < pairs[j].field })
+
+	var b strings.Builder
+	total := 6 + 1 // header and end marker
+	for i, p := range pairs {
+		fsize, vsize := listpackEntrySize(p.field), listpackEntrySize(p.value)
+		total += fsize + vsize
+		fmt.Fprintf(&b, "{%d} field %q (%d bytes)\n", 2*i, p.field, fsize)
+		fmt.Fprintf(&b, "{%d} value %q (%d bytes)\n", 2*i+1, p.value, vsize)
+	}
+	header := fmt.Sprintf("listpack: %d entries, %d bytes, max entries %d, max value %d\n",
+		2*len(pairs), total, hashMaxListpackEntries.Load(), hashMaxListpackValue.Load())
+	return protocol.RESPObject{Type: protocol.BulkString, Value: header + b.String()}
+}
+
+// listpackEntrySize is the encoded size of s as a listpack entry: encoding
+// byte(s), data and the back-length, with integers stored compactly.
+func listpackEntrySize(s string) int {
+	var size int
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil && strconv.FormatInt(n, 10) == s {
+		switch {
+		case n >= 0 && n <= 127:
+			size = 1
+		case n >= -4096 && n <= 4095:
+			size = 2
+		case n >= -1<<15 && n < 1<<15:
+			size = 3
+		case n >= -1<<23 && n < 1<<23:
+			size = 4
+		case n >= -1<<31 && n < 1<<31:
+			size = 5
+		default:
+			size = 9
+		}
+	} else {
+		switch l := len(s); {
+		case l <= 63:
+			size = 1 + l
+		case l <= 4095:
+			size = 2 + l
+		default:
+			size = 5 + l
+		}
+	}
+	switch {
+	case size < 1<<7:
+		return size + 1
+	case size < 1<<14:
+		return size + 2
+	case size < 1<<21:
+		return size + 3
+	case size < 1<<28:
+		return size + 4
+	default:
+		return size + 5
+	}
+}
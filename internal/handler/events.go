@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"sync"
+)
+
+// KeyspaceEvent is emitted after a key is modified, deleted or expired. Event
+// names follow Redis keyspace notifications: "set", "hset", "del", "expired",
+// "expire", "persist", "incrby", "incrbyfloat", "hincrbyfloat", "append",
+// "setrange", "rename_from", "rename_to", "copy_to", "hdel", "hincrby",
+// "hexpire", "hpersist", "hexpired", "lpush", "rpush", "lpop", "rpop",
+// "lrem", "ltrim", "linsert", "sortstore". FLUSHALL and FLUSHDB emit a single "flush"
+// with an empty Key rather than one event per key.
+type KeyspaceEvent struct {
+	Event string
+	Key   string
+}
+
+var (
+	eventsMu    sync.RWMutex
+	subscribers = map[chan KeyspaceEvent]struct{}{}
+	hooks       = map[*func(KeyspaceEvent)]struct{}{}
+)
+
+// SubscribeEvents returns a channel receiving every keyspace event. Slow
+// subscribers never block writers: events that don't fit in the buffer are
+// dropped.
+func SubscribeEvents(buffer int) chan KeyspaceEvent {
+	ch := make(chan KeyspaceEvent, buffer)
+	eventsMu.Lock()
+	subscribers[ch] = struct{}{}
+	eventsMu.Unlock()
+	return ch
+}
+
+func UnsubscribeEvents(ch chan KeyspaceEvent) {
+	eventsMu.Lock()
+	delete(subscribers, ch)
+	eventsMu.Unlock()
+}
+
+// AddEventHook calls fn with every keyspace event, unlike a subscriber
+// without ever dropping one, and returns a function that removes it. fn runs
+// synchronously on the goroutine that made the change, right after it and
+// possibly with writeMu held, so it must return quickly and must not call
+// back into the keyspace.
+func AddEventHook(fn func(KeyspaceEvent)) (remove func()) {
+	eventsMu.Lock()
+	hooks[&fn] = struct{}{}
+	eventsMu.Unlock()
+	return func() {
+		eventsMu.Lock()
+		delete(hooks, &fn)
+		eventsMu.Unlock()
+	}
+}
+
+func notify(event, key string) {
+	eventsMu.RLock()
+	defer eventsMu.RUnlock()
+	for fn := range hooks {
+		(*fn)(KeyspaceEvent{Event: event, Key: key})
+	}
+	for ch := range subscribers {
+		select {
+		case ch <- KeyspaceEvent{Event: event, Key: key}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+var (
+	// Checked by expireCondition rather than grouped, for Redis' errors.
+	expireConditions = []Option{{Name: "NX"}, {Name: "XX"}, {Name: "GT"}, {Name: "LT"}}
+
+	expireArgs  = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "seconds", Type: ArgInteger}}, Options: expireConditions}
+	pexpireArgs = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "milliseconds", Type: ArgInteger}}, Options: expireConditions}
+	persistArgs = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}}}
+	ttlArgs     = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}}}
+
+	expireatArgs  = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "unix-time-seconds", Type: ArgInteger}}, Options: expireConditions}
+	pexpireatArgs = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "unix-time-milliseconds", Type: ArgInteger}}, Options: expireConditions}
+)
+
+// Expiration counters for INFO stats.
+var (
+	expiredKeys   atomic.Int64 // deleted for being past their TTL
+	expiredMisses atomic.Int64 // lookups that found their key expired
+)
+
+// ExpireStats returns how many keys were deleted for being past their TTL,
+// how many lookups missed because their key had expired, and the percentage
+// of keys with a TTL that are expired but not yet deleted.
+func ExpireStats() (expired, misses int64, stalePerc float64) {
+	ttls, stale := keyspace.volatileKeys(time.Now())
+	if ttls > 0 {
+		stalePerc = float64(stale) * 100 / float64(ttls)
+	}
+	return expiredKeys.Load(), expiredMisses.Load(), stalePerc
+}
+
+// ttlDuration converts n units to a Duration, failing if it overflows.
+func ttlDuration(n int64, unit time.Duration) (time.Duration, bool) {
+	limit := int64(math.MaxInt64 / unit)
+	if n > limit || n < -limit {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// ttlSample is how many keys with a TTL AverageTTL looks at.
+const ttlSample = 1000
+
+// AverageTTL estimates the mean remaining TTL of the live keys that have
+// one from a sample of them, zero if none do, so INFO keyspace stays cheap
+// however many keys there are.
+func AverageTTL() time.Duration {
+	ttls := keyspace.sampleTTLs(time.Now(), ttlSample)
+	if len(ttls) == 0 {
+		return 0
+	}
+	// Averaged in float64, a sum of long TTLs would overflow a Duration.
+	var mean float64
+	for i, ttl := range ttls {
+		mean += (float64(ttl) - mean) / float64(i+1)
+	}
+	return time.Duration(mean)
+}
+
+func isExpired(expiresAt, now time.Time) bool {
+	return !expiresAt.IsZero() && expiresAt.Before(now)
+}
+
+// ExpiresAt returns when key expires, zero if it has no TTL.
+func ExpiresAt(key string) time.Time {
+	if v, ok := keyspace.Load(key); ok {
+		return v.(typedValue).expiresAt
+	}
+	return time.Time{}
+}
+
+// SetExpire sets the TTL of the live key to expire at expiresAt, or removes
+// it if expiresAt is zero, leaving the value alone. It reports whether the
+// key exists.
+func SetExpire(key string, expiresAt time.Time) bool {
+	return changeExpire(key, expiresAt, nil)
+}
+
+// changeExpire is SetExpire, except that a non-zero expiresAt that is not in
+// the future deletes the key, and that allow, unless nil, must accept the
+// key's current expiry, zero for none, in the same atomic step. It reports
+// whether the key was changed.
+func changeExpire(key string, expiresAt time.Time, allow func(cur time.Time) bool) bool {
+	for {
+		v, ok := keyspace.Load(key)
+		if !ok {
+			return false
+		}
+		o := v.(typedValue)
+		now := time.Now()
+		if o.expired(now) || (allow != nil && !allow(o.expiresAt)) {
+			return false
+		}
+		if !expiresAt.IsZero() && !expiresAt.After(now) {
+			if keyspace.CompareAndDelete(key, v) {
+				Freqs.Delete(key)
+				MarkDirty()
+				return true
+			}
+			continue
+		}
+		if keyspace.CompareAndSwap(key, v, typedValue{value: o.value, expiresAt: expiresAt}) {
+			MarkDirty()
+			return true
+		}
+	}
+}
+
+// expireCondition returns the check of the NX, XX, GT and LT options against
+// a key's current expiry, where no TTL counts as an infinite one.
+func expireCondition(p *Parsed, expiresAt time.Time) (func(cur time.Time) bool, *protocol.RESPObject) {
+	nx, xx, gt, lt := p.Has("NX"), p.Has("XX"), p.Has("GT"), p.Has("LT")
+	if nx && (xx || gt || lt) {
+		return nil, errorReply("ERR NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if gt && lt {
+		return nil, errorReply("ERR GT and LT options at the same time are not compatible")
+	}
+	return expireAllowed(nx, xx, gt, lt, expiresAt), nil
+}
+
+// expireAllowed returns whether the NX, XX, GT and LT options that are set
+// allow replacing the expiry cur, zero for none, with expiresAt.
+func expireAllowed(nx, xx, gt, lt bool, expiresAt time.Time) func(cur time.Time) bool {
+	return func(cur time.Time) bool {
+		switch {
+		case nx:
+			return cur.IsZero()
+		case xx && cur.IsZero():
+			return false
+		case gt:
+			return !cur.IsZero() && expiresAt.After(cur)
+		case lt:
+			return cur.IsZero() || expiresAt.Before(cur)
+		}
+		return true
+	}
+}
+
+// expiredKey reports whether the value at key is past its TTL, deleting it
+// unless lazy expiry is off.
+func expiredKey(key string) bool {
+	v, ok := keyspace.Load(key)
+	if !ok || !v.(typedValue).expired(time.Now()) {
+		return false
+	}
+	if !noLazyExpire.Load() {
+		deleteExpired(key, v)
+	}
+	return true
+}
+
+// deleteExpired deletes key if it still holds the expired value v.
+func deleteExpired(key string, v interface{}) bool {
+	if !keyspace.CompareAndDelete(key, v) {
+		return false
+	}
+	Freqs.Delete(key)
+	MarkDirty()
+	expiredKeys.Add(1)
+	notify("expired", key)
+	return true
+}
+
+// ExpireKey deletes key if it is expired, whether or not lazy expiry is on,
+// and reports whether it did. Writers call it before modifying a key so the
+// deletion can be propagated ahead of the write.
+func ExpireKey(key string) bool {
+	v, ok := keyspace.Load(key)
+	return ok && v.(typedValue).expired(time.Now()) && deleteExpired(key, v)
+}
+
+func expire(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := expireArgs.Parse("expire", args)
+	if errReply != nil {
+		return *errReply
+	}
+	ttl, ok := ttlDuration(p.Int("seconds"), time.Second)
+	if !ok {
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrInvalidExpire, "expire")}
+	}
+	return expireKeyAt(p, time.Now().Add(ttl))
+}
+
+func pexpire(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := pexpireArgs.Parse("pexpire", args)
+	if errReply != nil {
+		return *errReply
+	}
+	ttl, ok := ttlDuration(p.Int("milliseconds"), time.Millisecond)
+	if !ok {
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrInvalidExpire, "pexpire")}
+	}
+	return expireKeyAt(p, time.Now().Add(ttl))
+}
+
+func expireat(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := expireatArgs.Parse("expireat", args)
+	if errReply != nil {
+		return *errReply
+	}
+	n := p.Int("unix-time-seconds")
+	if n > math.MaxInt64/1000 || n < math.MinInt64/1000 {
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrInvalidExpire, "expireat")}
+	}
+	return expireKeyAt(p, time.Unix(n, 0))
+}
+
+func pexpireat(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := pexpireatArgs.Parse("pexpireat", args)
+	if errReply != nil {
+		return *errReply
+	}
+	return expireKeyAt(p, time.UnixMilli(p.Int("unix-time-milliseconds")))
+}
+
+// expireKeyAt makes the key of an EXPIRE-family command expire at
+// expiresAt, if its NX, XX, GT or LT condition holds. As in Redis, a time
+// that is not in the future deletes the key right away.
+func expireKeyAt(p *Parsed, expiresAt time.Time) protocol.RESPObject {
+	allow, errReply := expireCondition(p, expiresAt)
+	if errReply != nil {
+		return *errReply
+	}
+	key := p.String("key")
+	if !changeExpire(key, expiresAt, allow) {
+		return protocol.RESPObject{Type: protocol.Integer, Value: 0}
+	}
+	if expiresAt.After(time.Now()) {
+		notify("expire", key)
+	} else {
+		notify("del", key)
+	}
+	return protocol.RESPObject{Type: protocol.Integer, Value: 1}
+}
+
+func persist(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := persistArgs.Parse("persist", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	key := p.String("key")
+	if !Exists(key) || ExpiresAt(key).IsZero() || !SetExpire(key, time.Time{}) {
+		return protocol.RESPObject{Type: protocol.Integer, Value: 0}
+	}
+	notify("persist", key)
+	return protocol.RESPObject{Type: protocol.Integer, Value: 1}
+}
+
+func ttl(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := ttlArgs.Parse("ttl", args)
+	if errReply != nil {
+		return *errReply
+	}
+	ms := remainingTTL(p.String("key"))
+	if ms > 0 {
+		ms = (ms + 500) / 1000
+	}
+	return protocol.RESPObject{Type: protocol.Integer, Value: ms}
+}
+
+func pttl(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := ttlArgs.Parse("pttl", args)
+	if errReply != nil {
+		return *errReply
+	}
+	return protocol.RESPObject{Type: protocol.Integer, Value: remainingTTL(p.String("key"))}
+}
+
+func expiretime(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := ttlArgs.Parse("expiretime", args)
+	if errReply != nil {
+		return *errReply
+	}
+	ms := expireTime(p.String("key"))
+	if ms > 0 {
+		ms /= 1000
+	}
+	return protocol.RESPObject{Type: protocol.Integer, Value: ms}
+}
+
+func pexpiretime(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := ttlArgs.Parse("pexpiretime", args)
+	if errReply != nil {
+		return *errReply
+	}
+	return protocol.RESPObject{Type: protocol.Integer, Value: expireTime(p.String("key"))}
+}
+
+// expireTime returns the unix time in milliseconds at which key expires, -1
+// if it has no TTL and -2 if it doesn't exist.
+func expireTime(key string) int64 {
+	if !Exists(key) {
+		return -2
+	}
+	expiresAt := ExpiresAt(key)
+	if expiresAt.IsZero() {
+		return -1
+	}
+	return expiresAt.UnixMilli()
+}
+
+// remainingTTL returns the milliseconds key has left to live, -1 if it has
+// no TTL and -2 if it doesn't exist.
+func remainingTTL(key string) int64 {
+	if !Exists(key) {
+		return -2
+	}
+	expiresAt := ExpiresAt(key)
+	if expiresAt.IsZero() {
+		return -1
+	}
+	ms := time.Until(expiresAt).Milliseconds()
+	if ms < 0 {
+		ms = 0
+	}
+	return ms
+}
@@ -0,0 +1,82 @@
+package handler
+
+// MatchPattern implements Redis glob-style matching: '*', '?', '[...]'
+// character classes (with '^' negation and 'a-z' ranges) and '\' escapes.
+// Unlike path.Match, '*' also matches '/' and other separators.
+func MatchPattern(pattern, str string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if MatchPattern(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+			str = str[1:]
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			match := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				switch {
+				case pattern[0] == '\\' && len(pattern) >= 2:
+					pattern = pattern[1:]
+					if pattern[0] == str[0] {
+						match = true
+					}
+				case len(pattern) >= 3 && pattern[1] == '-':
+					start, end := pattern[0], pattern[2]
+					if start > end {
+						start, end = end, start
+					}
+					if str[0] >= start && str[0] <= end {
+						match = true
+					}
+					pattern = pattern[2:]
+				default:
+					if pattern[0] == str[0] {
+						match = true
+					}
+				}
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				// Unterminated class: Redis treats the end of pattern as ']'.
+				pattern = "]"
+			}
+			if match == not {
+				return false
+			}
+			str = str[1:]
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+			str = str[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(str) == 0
+}
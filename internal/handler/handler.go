@@ -2,167 +2,697 @@ package handler
 
 import (
 	"fmt"
-	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/ashish-kamra/redis-clone/internal/protocol"
 )
 
+// Error replies match Redis' word for word, since clients match on them.
 const (
-	ErrWrongArgCount = "ERR wrong number of arguments for '%s' command"
-	ErrInvalidInt    = "ERR value is not an integer or out of range"
+	ErrWrongArgCount     = "ERR wrong number of arguments for '%s' command"
+	ErrInvalidInt        = "ERR value is not an integer or out of range"
+	ErrWrongType         = "WRONGTYPE Operation against a key holding the wrong kind of value"
+	ErrInvalidExpire     = "ERR invalid expire time in '%s' command"
+	ErrUnknownSubcommand = "ERR unknown subcommand '%.128s'. Try %s HELP."
+	ErrNoSuchKey         = "ERR no such key"
 )
 
-var Handlers = map[string]func([]protocol.RESPObject) protocol.RESPObject{
-	"COMMAND": command,
-	"ECHO":    echo,
-	"PING":    ping,
-	"SET":     set,
-	"GET":     get,
-	"HSET":    hset,
-	"HGET":    hget,
-	"KEYS":    keys,
-}
-
+// Value is a string and its TTL, as returned by PeekString.
 type Value struct {
 	Data      string
 	ExpiresAt time.Time
 }
 
 var (
-	SETs  = sync.Map{}
-	HSETs = sync.Map{}
-)
-
-func command(args []protocol.RESPObject) protocol.RESPObject {
-	if len(args) != 1 {
-		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrWrongArgCount, "command")}
+	echoArgs   = &ArgSchema{Args: []Arg{{Name: "message"}}}
+	pingArgs   = &ArgSchema{Args: []Arg{{Name: "message", Optional: true}}}
+	getArgs    = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}}}
+	getsetArgs = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "value"}}}
+	getdelArgs = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}}}
+	getexArgs  = &ArgSchema{
+		Args: []Arg{{Name: "key", Type: ArgKey}},
+		Options: []Option{
+			{Name: "EX", Args: []Arg{{Name: "seconds", Type: ArgInteger}}, Group: "expiration"},
+			{Name: "PX", Args: []Arg{{Name: "milliseconds", Type: ArgInteger}}, Group: "expiration"},
+			{Name: "EXAT", Args: []Arg{{Name: "unix-time-seconds", Type: ArgInteger}}, Group: "expiration"},
+			{Name: "PXAT", Args: []Arg{{Name: "unix-time-milliseconds", Type: ArgInteger}}, Group: "expiration"},
+			{Name: "PERSIST", Group: "expiration"},
+		},
 	}
-	return protocol.RESPObject{Type: protocol.SimpleString, Value: args[0].Value}
-}
+	appendArgs   = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "value"}}}
+	strlenArgs   = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}}}
+	getrangeArgs = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "start", Type: ArgInteger}, {Name: "end", Type: ArgInteger}}}
+	setrangeArgs = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "offset", Type: ArgInteger}, {Name: "value"}}}
+	msetArgs     = &ArgSchema{Args: []Arg{{Name: "data", Multiple: true}}}
+	mgetArgs     = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey, Multiple: true}}}
+	delArgs      = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey, Multiple: true}}}
+	renameArgs   = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "newkey", Type: ArgKey}}}
+	copyArgs     = &ArgSchema{
+		Args: []Arg{{Name: "source", Type: ArgKey}, {Name: "destination", Type: ArgKey}},
+		Options: []Option{
+			{Name: "DB", Args: []Arg{{Name: "destination-db", Type: ArgInteger}}},
+			{Name: "REPLACE"},
+		},
+	}
+	existsArgs = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey, Multiple: true}}}
+	touchArgs  = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey, Multiple: true}}}
+	hsetArgs   = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "data", Multiple: true}}}
+	hgetArgs   = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "field"}}}
+	hdelArgs   = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "field", Multiple: true}}}
+	keysArgs   = &ArgSchema{Args: []Arg{{Name: "pattern", Type: ArgPattern}}}
+	setexArgs  = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "seconds", Type: ArgInteger}, {Name: "value"}}}
+	psetexArgs = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "milliseconds", Type: ArgInteger}, {Name: "value"}}}
+	setnxArgs  = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "value"}}}
+	setArgs    = &ArgSchema{
+		Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "value"}},
+		Options: []Option{
+			{Name: "NX", Group: "condition"},
+			{Name: "XX", Group: "condition"},
+			{Name: "GET"},
+			{Name: "EX", Args: []Arg{{Name: "seconds", Type: ArgInteger}}, Group: "expire"},
+			{Name: "PX", Args: []Arg{{Name: "milliseconds", Type: ArgInteger}}, Group: "expire"},
+			{Name: "EXAT", Args: []Arg{{Name: "unix-time-seconds", Type: ArgInteger}}, Group: "expire"},
+			{Name: "PXAT", Args: []Arg{{Name: "unix-time-milliseconds", Type: ArgInteger}}, Group: "expire"},
+			{Name: "KEEPTTL", Group: "expire"},
+		},
+	}
+)
 
 func echo(args []protocol.RESPObject) protocol.RESPObject {
-	if len(args) != 1 {
-		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrWrongArgCount, "echo")}
+	p, errReply := echoArgs.Parse("echo", args)
+	if errReply != nil {
+		return *errReply
 	}
-	return protocol.RESPObject{Type: protocol.SimpleString, Value: args[0].Value}
+	return protocol.RESPObject{Type: protocol.BulkString, Value: p.String("message")}
 }
 
 func ping(args []protocol.RESPObject) protocol.RESPObject {
-	switch len(args) {
-	case 0:
-		return protocol.RESPObject{Type: protocol.SimpleString, Value: "PONG"}
-	case 1:
-		return protocol.RESPObject{Type: protocol.SimpleString, Value: args[0].Value}
-	default:
-		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrWrongArgCount, "ping")}
+	p, errReply := pingArgs.Parse("ping", args)
+	if errReply != nil {
+		return *errReply
+	}
+	if p.Has("message") {
+		return protocol.RESPObject{Type: protocol.BulkString, Value: p.String("message")}
 	}
+	return protocol.RESPObject{Type: protocol.SimpleString, Value: "PONG"}
 }
 
 func set(args []protocol.RESPObject) protocol.RESPObject {
-	if len(args) < 2 || len(args) > 4 {
-		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrWrongArgCount, "set")}
-	}
-
-	key, value := args[0].Value.(string), args[1].Value.(string)
-	var expiresAt time.Time
-	//TODO: Handle key expiration for AOF entries
-	if len(args) == 4 {
-		cmd := strings.ToUpper(args[2].Value.(string))
-		duration, err := strconv.ParseInt(args[3].Value.(string), 10, 64)
-		if err != nil {
-			return protocol.RESPObject{Type: protocol.Error, Value: ErrInvalidInt}
-		}
+	p, errReply := setArgs.Parse("set", args)
+	if errReply != nil {
+		return *errReply
+	}
 
-		switch cmd {
-		case "PX":
-			expiresAt = time.Now().Add(time.Duration(duration) * time.Millisecond)
-		case "EX":
-			expiresAt = time.Now().Add(time.Duration(duration) * time.Second)
-		default:
-			return protocol.RESPObject{Type: protocol.Error, Value: "ERR syntax error"}
+	key, value := p.String("key"), p.String("value")
+	expiresAt, errReply := expiryOption(p, "set")
+	if errReply != nil {
+		return *errReply
+	}
+	if p.Has("GET") && holdsNonString(key) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+
+	// The condition is checked against the value being replaced, so that a
+	// concurrent writer can't slip in between. SET replaces a value of any
+	// type.
+	var old typedValue
+	var existed bool
+	set := update(key, func(cur typedValue, exists bool) (typedValue, bool) {
+		old, existed = cur, exists
+		if (p.Has("NX") && exists) || (p.Has("XX") && !exists) {
+			return cur, false
+		}
+		if p.Has("KEEPTTL") {
+			return typedValue{value: value, expiresAt: cur.expiresAt}, true
 		}
+		return typedValue{value: value, expiresAt: expiresAt}, true
+	})
+	if set {
+		notify("set", key)
 	}
 
-	SETs.Store(key, Value{Data: value, ExpiresAt: expiresAt})
+	switch {
+	case p.Has("GET") && existed:
+		return protocol.RESPObject{Type: protocol.BulkString, Value: old.value}
+	case p.Has("GET") || !set:
+		return protocol.RESPObject{Type: protocol.Null}
+	}
 	return protocol.RESPObject{Type: protocol.SimpleString, Value: "OK"}
 }
 
 func get(args []protocol.RESPObject) protocol.RESPObject {
-	if len(args) != 1 {
-		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrWrongArgCount, "get")}
+	p, errReply := getArgs.Parse("get", args)
+	if errReply != nil {
+		return *errReply
 	}
 
-	key := args[0].Value.(string)
-	if val, ok := SETs.Load(key); ok {
-		value := val.(Value)
-		if !value.ExpiresAt.IsZero() && value.ExpiresAt.Before(time.Now()) {
-			SETs.Delete(key)
-			return protocol.RESPObject{Type: protocol.Null}
-		}
-		return protocol.RESPObject{Type: protocol.BulkString, Value: value.Data}
+	key := p.String("key")
+	if value, ok := GetString(key); ok {
+		return protocol.RESPObject{Type: protocol.BulkString, Value: value}
+	}
+	if Exists(key) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
 	}
 	return protocol.RESPObject{Type: protocol.Null}
 }
 
-func hset(args []protocol.RESPObject) protocol.RESPObject {
-	if len(args) != 3 {
-		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrWrongArgCount, "hset")}
+// appendValue appends to the string at key, creating it if missing, and
+// replies with the new length.
+func appendValue(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := appendArgs.Parse("append", args)
+	if errReply != nil {
+		return *errReply
 	}
 
-	hash, key, value := args[0].Value.(string), args[1].Value.(string), args[2].Value.(string)
+	key := p.String("key")
+	var length int
+	updated := updateString(key, func(cur Value, exists bool) (Value, bool) {
+		cur.Data += p.String("value")
+		length = len(cur.Data)
+		return cur, true
+	})
+	if !updated {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	notify("append", key)
+	return protocol.RESPObject{Type: protocol.Integer, Value: length}
+}
+
+func strlen(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := strlenArgs.Parse("strlen", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	key := p.String("key")
+	if value, ok := GetString(key); ok {
+		return protocol.RESPObject{Type: protocol.Integer, Value: len(value)}
+	}
+	if Exists(key) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	return protocol.RESPObject{Type: protocol.Integer, Value: 0}
+}
+
+// maxStringSize is Redis' default proto-max-bulk-len.
+const maxStringSize = 512 << 20
+
+// getrange returns the substring from start to end inclusive, counting
+// negative indexes from the end of the string.
+func getrange(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := getrangeArgs.Parse("getrange", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	key := p.String("key")
+	value, ok := GetString(key)
+	if !ok && Exists(key) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	n := int64(len(value))
+	start, end := p.Int("start"), p.Int("end")
+	if start < 0 {
+		start += n
+	}
+	if end < 0 {
+		end += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end >= n {
+		end = n - 1
+	}
+	if start > end || n == 0 {
+		return protocol.RESPObject{Type: protocol.BulkString, Value: ""}
+	}
+	return protocol.RESPObject{Type: protocol.BulkString, Value: value[start : end+1]}
+}
+
+// setrange overwrites the string at offset, padding it with zero bytes if
+// it is shorter, and keeps its TTL.
+func setrange(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := setrangeArgs.Parse("setrange", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	key, offset, patch := p.String("key"), p.Int("offset"), p.String("value")
+	if offset < 0 {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR offset is out of range"}
+	}
+	if patch == "" {
+		// Nothing to write: don't create the key or pad it.
+		var value string
+		if o, ok := lookup(key); ok {
+			if value, ok = o.value.(string); !ok {
+				return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+			}
+			touchKey(key)
+		}
+		return protocol.RESPObject{Type: protocol.Integer, Value: len(value)}
+	}
+	if offset > maxStringSize-int64(len(patch)) {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR string exceeds maximum allowed size (proto-max-bulk-len)"}
+	}
+	var length int
+	updated := updateString(key, func(cur Value, exists bool) (Value, bool) {
+		data := []byte(cur.Data)
+		if end := int(offset) + len(patch); end > len(data) {
+			data = append(data, make([]byte, end-len(data))...)
+		}
+		copy(data[offset:], patch)
+		cur.Data = string(data)
+		length = len(cur.Data)
+		return cur, true
+	})
+	if !updated {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	notify("setrange", key)
+	return protocol.RESPObject{Type: protocol.Integer, Value: length}
+}
 
-	hm, _ := HSETs.LoadOrStore(hash, &sync.Map{})
-	hm.(*sync.Map).Store(key, value)
+func mset(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := msetArgs.Parse("mset", args)
+	if errReply != nil {
+		return *errReply
+	}
+	pairs := p.Strings("data")
+	if len(pairs)%2 != 0 {
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrWrongArgCount, "mset")}
+	}
 
+	SetStrings(pairs, false)
+	for i := 0; i < len(pairs); i += 2 {
+		notify("set", pairs[i])
+	}
 	return protocol.RESPObject{Type: protocol.SimpleString, Value: "OK"}
 }
 
-func hget(args []protocol.RESPObject) protocol.RESPObject {
-	if len(args) != 2 {
-		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrWrongArgCount, "hget")}
+// msetnx sets all the keys or, if any of them exists, none of them.
+func msetnx(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := msetArgs.Parse("msetnx", args)
+	if errReply != nil {
+		return *errReply
+	}
+	pairs := p.Strings("data")
+	if len(pairs)%2 != 0 {
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrWrongArgCount, "msetnx")}
+	}
+
+	if !SetStrings(pairs, true) {
+		return protocol.RESPObject{Type: protocol.Integer, Value: 0}
+	}
+	for i := 0; i < len(pairs); i += 2 {
+		notify("set", pairs[i])
+	}
+	return protocol.RESPObject{Type: protocol.Integer, Value: 1}
+}
+
+// mget replies with null for missing keys and, as in Redis, for keys that
+// don't hold a string.
+func mget(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := mgetArgs.Parse("mget", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	keys := p.Strings("key")
+	values := make([]protocol.RESPObject, len(keys))
+	for i, key := range keys {
+		if value, ok := GetString(key); ok {
+			values[i] = protocol.RESPObject{Type: protocol.BulkString, Value: value}
+		} else {
+			values[i] = protocol.RESPObject{Type: protocol.Null}
+		}
+	}
+	return protocol.RESPObject{Type: protocol.Array, Value: values}
+}
+
+// getset is SET key value GET.
+func getset(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := getsetArgs.Parse("getset", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	key := p.String("key")
+	var old Value
+	var existed bool
+	updated := updateString(key, func(cur Value, exists bool) (Value, bool) {
+		old, existed = cur, exists
+		return Value{Data: p.String("value")}, true
+	})
+	if !updated {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	notify("set", key)
+	if !existed {
+		return protocol.RESPObject{Type: protocol.Null}
+	}
+	return protocol.RESPObject{Type: protocol.BulkString, Value: old.Data}
+}
+
+func getdel(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := getdelArgs.Parse("getdel", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	key := p.String("key")
+	if holdsNonString(key) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	old, loaded := keyspace.Load(key)
+	o, _ := old.(typedValue)
+	value, isString := o.value.(string)
+	if !loaded || o.expired(time.Now()) || !isString || !keyspace.CompareAndDelete(key, old) {
+		return protocol.RESPObject{Type: protocol.Null}
+	}
+	Freqs.Delete(key)
+	MarkDirty()
+	notify("del", key)
+	return protocol.RESPObject{Type: protocol.BulkString, Value: value}
+}
+
+// expiryOption returns the expiry time set by an EX, PX, EXAT or PXAT
+// option, zero if there is none.
+func expiryOption(p *Parsed, command string) (time.Time, *protocol.RESPObject) {
+	invalid := errorReply(fmt.Sprintf(ErrInvalidExpire, command))
+	switch {
+	case p.Has("EX"), p.Has("PX"):
+		ttl, ok := ttlDuration(p.Int("PX"), time.Millisecond)
+		if p.Has("EX") {
+			ttl, ok = ttlDuration(p.Int("EX"), time.Second)
+		}
+		if !ok || ttl <= 0 {
+			return time.Time{}, invalid
+		}
+		return time.Now().Add(ttl), nil
+	case p.Has("EXAT"):
+		if p.Int("EXAT") <= 0 {
+			return time.Time{}, invalid
+		}
+		return time.Unix(p.Int("EXAT"), 0), nil
+	case p.Has("PXAT"):
+		if p.Int("PXAT") <= 0 {
+			return time.Time{}, invalid
+		}
+		return time.UnixMilli(p.Int("PXAT")), nil
+	}
+	return time.Time{}, nil
+}
+
+// setex, psetex and setnx are the legacy forms of SET key value EX, PX and
+// NX, and run it with those options.
+func setex(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := setexArgs.Parse("setex", args)
+	if errReply != nil {
+		return *errReply
+	}
+	if ttl, ok := ttlDuration(p.Int("seconds"), time.Second); !ok || ttl <= 0 {
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrInvalidExpire, "setex")}
+	}
+	return set([]protocol.RESPObject{args[0], args[2], bulk("EX"), args[1]})
+}
+
+func psetex(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := psetexArgs.Parse("psetex", args)
+	if errReply != nil {
+		return *errReply
+	}
+	if ttl, ok := ttlDuration(p.Int("milliseconds"), time.Millisecond); !ok || ttl <= 0 {
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrInvalidExpire, "psetex")}
+	}
+	return set([]protocol.RESPObject{args[0], args[2], bulk("PX"), args[1]})
+}
+
+func setnx(args []protocol.RESPObject) protocol.RESPObject {
+	if _, errReply := setnxArgs.Parse("setnx", args); errReply != nil {
+		return *errReply
+	}
+	if reply := set([]protocol.RESPObject{args[0], args[1], bulk("NX")}); reply.Type == protocol.Null {
+		return protocol.RESPObject{Type: protocol.Integer, Value: 0}
+	}
+	return protocol.RESPObject{Type: protocol.Integer, Value: 1}
+}
+
+// getex returns the string at key and optionally changes its TTL, leaving
+// the value itself untouched. An absolute time in the past deletes the key.
+func getex(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := getexArgs.Parse("getex", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	key := p.String("key")
+	expiresAt, errReply := expiryOption(p, "getex")
+	if errReply != nil {
+		return *errReply
+	}
+
+	value, ok := PeekString(key)
+	if !ok {
+		if Exists(key) {
+			return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+		}
+		return protocol.RESPObject{Type: protocol.Null}
+	}
+	touchKey(key)
+	switch {
+	case !expiresAt.IsZero() && !expiresAt.After(time.Now()):
+		Delete(key)
+		notify("del", key)
+	case !expiresAt.IsZero():
+		if SetExpire(key, expiresAt) {
+			notify("expire", key)
+		}
+	case p.Has("PERSIST") && !value.ExpiresAt.IsZero():
+		if SetExpire(key, time.Time{}) {
+			notify("persist", key)
+		}
+	}
+	return protocol.RESPObject{Type: protocol.BulkString, Value: value.Data}
+}
+
+func del(args []protocol.RESPObject) protocol.RESPObject {
+	return deleteKeys("del", args)
+}
+
+// unlink is DEL. Removing a key takes constant time whatever the size of its
+// value, which is unreachable from then on and reclaimed by the garbage
+// collector running alongside, so a big hash never stalls the caller and
+// there is nothing left to free in the background.
+func unlink(args []protocol.RESPObject) protocol.RESPObject {
+	return deleteKeys("unlink", args)
+}
+
+func deleteKeys(name string, args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := delArgs.Parse(name, args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	deleted := 0
+	for _, key := range p.Strings("key") {
+		if Delete(key) {
+			notify("del", key)
+			deleted++
+		}
+	}
+	return protocol.RESPObject{Type: protocol.Integer, Value: deleted}
+}
+
+func rename(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := renameArgs.Parse("rename", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	src, dst := p.String("key"), p.String("newkey")
+	if found, _ := Rename(src, dst, false); !found {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrNoSuchKey}
+	}
+	notifyRename(src, dst)
+	return protocol.RESPObject{Type: protocol.SimpleString, Value: "OK"}
+}
+
+// renamenx renames key only if newkey doesn't exist.
+func renamenx(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := renameArgs.Parse("renamenx", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	src, dst := p.String("key"), p.String("newkey")
+	found, renamed := Rename(src, dst, true)
+	if !found {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrNoSuchKey}
+	}
+	if !renamed {
+		return protocol.RESPObject{Type: protocol.Integer, Value: 0}
+	}
+	notifyRename(src, dst)
+	return protocol.RESPObject{Type: protocol.Integer, Value: 1}
+}
+
+func notifyRename(src, dst string) {
+	if src != dst {
+		notify("rename_from", src)
+		notify("rename_to", dst)
+	}
+}
+
+// copyKey duplicates a key of any type. The keyspace is shared by every
+// database, so only DB 0 is accepted.
+func copyKey(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := copyArgs.Parse("copy", args)
+	if errReply != nil {
+		return *errReply
 	}
 
-	hash, key := args[0].Value.(string), args[1].Value.(string)
+	if p.Has("DB") && p.Int("DB") != 0 {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR only database 0 is supported"}
+	}
+	src, dst := p.String("source"), p.String("destination")
+	if src == dst {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR source and destination objects are the same"}
+	}
+	copied, err := Copy(src, dst, p.Has("REPLACE"))
+	if err != nil {
+		return protocol.RESPObject{Type: protocol.Error, Value: err.Error()}
+	}
+	if !copied {
+		return protocol.RESPObject{Type: protocol.Integer, Value: 0}
+	}
+	notify("copy_to", dst)
+	return protocol.RESPObject{Type: protocol.Integer, Value: 1}
+}
+
+// exists counts every argument, so a key given twice is counted twice.
+func exists(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := existsArgs.Parse("exists", args)
+	if errReply != nil {
+		return *errReply
+	}
 
-	if hm, ok := HSETs.Load(hash); ok {
-		if value, ok := hm.(*sync.Map).Load(key); ok {
-			return protocol.RESPObject{Type: protocol.BulkString, Value: value.(string)}
+	count := 0
+	for _, key := range p.Strings("key") {
+		if Exists(key) {
+			count++
 		}
 	}
+	return protocol.RESPObject{Type: protocol.Integer, Value: count}
+}
+
+// touch counts as an access to each key for LFU and LRU, as reading it would.
+func touch(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := touchArgs.Parse("touch", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	touched := 0
+	for _, key := range p.Strings("key") {
+		if _, ok := lookup(key); ok {
+			touchKey(key)
+			touched++
+		}
+	}
+	return protocol.RESPObject{Type: protocol.Integer, Value: touched}
+}
+
+func hset(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := hsetArgs.Parse("hset", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	pairs := p.Strings("data")
+	if len(pairs)%2 != 0 {
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrWrongArgCount, "hset")}
+	}
+	hash := p.String("key")
+	if holdsNonHash(hash) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	added, _ := HashSetFields(hash, pairs)
+	notify("hset", hash)
+
+	return protocol.RESPObject{Type: protocol.Integer, Value: added}
+}
+
+func hget(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := hgetArgs.Parse("hget", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	if holdsNonHash(p.String("key")) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	if value, ok := HashGet(p.String("key"), p.String("field")); ok {
+		return protocol.RESPObject{Type: protocol.BulkString, Value: value}
+	}
 	return protocol.RESPObject{Type: protocol.Null}
 }
 
+func hdel(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := hdelArgs.Parse("hdel", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	hash := p.String("key")
+	if holdsNonHash(hash) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	deleted, emptied := HashDelete(hash, p.Strings("field"))
+	if deleted > 0 {
+		notify("hdel", hash)
+	}
+	if emptied {
+		notify("del", hash)
+	}
+	return protocol.RESPObject{Type: protocol.Integer, Value: deleted}
+}
+
+// holdsNonHash reports whether key holds a live value that is not a hash.
+func holdsNonHash(key string) bool {
+	t := TypeOf(key)
+	return t != "none" && t != "hash"
+}
+
+// holdsNonString reports whether key holds a live value that is not a
+// string.
+func holdsNonString(key string) bool {
+	t := TypeOf(key)
+	return t != "none" && t != "string"
+}
+
 func keys(args []protocol.RESPObject) protocol.RESPObject {
-	if len(args) != 1 {
-		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrWrongArgCount, "keys")}
+	p, errReply := keysArgs.Parse("keys", args)
+	if errReply != nil {
+		return *errReply
 	}
 
-	pattern := args[0].Value.(string)
+	pattern := p.String("pattern")
 	var values []protocol.RESPObject
 
 	if strings.HasSuffix(pattern, "*") {
 		prefix := strings.TrimSuffix(pattern, "*")
-		SETs.Range(func(k, v interface{}) bool {
-			if strings.HasPrefix(k.(string), prefix) {
+		now := time.Now()
+		keyspace.Range(func(k, v interface{}) bool {
+			if strings.HasPrefix(k.(string), prefix) && !v.(typedValue).expired(now) {
 				values = append(values, protocol.RESPObject{Type: protocol.BulkString, Value: k.(string)})
 			}
 			return true
 		})
-		HSETs.Range(func(k, v interface{}) bool {
-			if strings.HasPrefix(k.(string), prefix) {
-				values = append(values, protocol.RESPObject{Type: protocol.BulkString, Value: k.(string)})
-			}
-			return true
-		})
-	} else {
-		if _, ok := SETs.Load(pattern); ok {
-			values = append(values, protocol.RESPObject{Type: protocol.BulkString, Value: pattern})
-		} else if _, ok := HSETs.Load(pattern); ok {
-			values = append(values, protocol.RESPObject{Type: protocol.BulkString, Value: pattern})
-		}
+	} else if Exists(pattern) {
+		values = append(values, protocol.RESPObject{Type: protocol.BulkString, Value: pattern})
 	}
 
 	return protocol.RESPObject{Type: protocol.Array, Value: values}
@@ -0,0 +1,22 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+// call runs a command as the server would, without its own commands.
+func call(t *testing.T, args ...string) protocol.RESPObject {
+	t.Helper()
+	cmd, ok := Lookup(strings.ToUpper(args[0]))
+	if !ok {
+		t.Fatalf("unknown command %s", args[0])
+	}
+	argv := make([]protocol.RESPObject, len(args)-1)
+	for i, a := range args[1:] {
+		argv[i] = bulk(a)
+	}
+	return protocol.Collect(cmd.Handler(argv))
+}
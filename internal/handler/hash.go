@@ -0,0 +1,213 @@
+package handler
+
+import (
+	"math"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+var (
+	hgetallArgs = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}}}
+	hexistsArgs = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "field"}}}
+	hlenArgs    = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}}}
+	hstrlenArgs = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "field"}}}
+	hkeysArgs   = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}}}
+	hvalsArgs   = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}}}
+	hmgetArgs   = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "field", Multiple: true}}}
+
+	hrandfieldArgs = &ArgSchema{
+		Args:    []Arg{{Name: "key", Type: ArgKey}, {Name: "count", Type: ArgInteger, Optional: true}},
+		Options: []Option{{Name: "WITHVALUES"}},
+	}
+)
+
+func hgetall(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := hgetallArgs.Parse("hgetall", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	if holdsNonHash(p.String("key")) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	hash := p.String("key")
+	return stream(func(begin func(int), emit func(protocol.RESPObject) bool) {
+		HashRange(hash, func(n int) { begin(2 * n) }, func(field, value string) bool {
+			return emit(bulk(field)) && emit(bulk(value))
+		})
+	})
+}
+
+func hmget(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := hmgetArgs.Parse("hmget", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	hash := p.String("key")
+	if holdsNonHash(hash) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	fields := p.Strings("field")
+	values := make([]protocol.RESPObject, len(fields))
+	for i, field := range fields {
+		values[i] = protocol.RESPObject{Type: protocol.Null}
+		if value, ok := HashGet(hash, field); ok {
+			values[i] = bulk(value)
+		}
+	}
+	return array(values)
+}
+
+// hgetdel returns the values of fields of a hash, nil for missing ones, and
+// deletes them.
+func hgetdel(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := httlArgs.Parse("hgetdel", args)
+	if errReply != nil {
+		return *errReply
+	}
+	_, fields, errReply := parseFieldsArg(p.Strings("fields"), false)
+	if errReply != nil {
+		return *errReply
+	}
+	hash := p.String("key")
+	if holdsNonHash(hash) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+
+	values, found, emptied := HashGetDelete(hash, fields)
+	deleted := false
+	for _, ok := range found {
+		deleted = deleted || ok
+	}
+	if deleted {
+		notify("hdel", hash)
+	}
+	if emptied {
+		notify("del", hash)
+	}
+	return fieldValues(values, found)
+}
+
+func fieldValues(values []string, found []bool) protocol.RESPObject {
+	replies := make([]protocol.RESPObject, len(values))
+	for i, value := range values {
+		replies[i] = protocol.RESPObject{Type: protocol.Null}
+		if found[i] {
+			replies[i] = bulk(value)
+		}
+	}
+	return array(replies)
+}
+
+// maxRandomCount bounds how many fields HRANDFIELD with a negative count
+// returns, as each is allocated upfront whatever the size of the hash.
+const maxRandomCount = 1 << 24
+
+// hrandfield returns a random field without a count, or up to count
+// distinct ones, or with a negative count exactly -count that may repeat.
+func hrandfield(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := hrandfieldArgs.Parse("hrandfield", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	hash := p.String("key")
+	if holdsNonHash(hash) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	if !p.Has("count") {
+		pairs, _ := HashRandomFields(hash, 1, false)
+		if len(pairs) == 0 {
+			return protocol.RESPObject{Type: protocol.Null}
+		}
+		return bulk(pairs[0])
+	}
+	count, withValues := p.Int("count"), p.Has("WITHVALUES")
+	if count == math.MinInt64 || count < -maxRandomCount {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR value is out of range"}
+	}
+	repeat := count < 0
+	if repeat {
+		count = -count
+	}
+	pairs, _ := HashRandomFields(hash, int(count), repeat)
+	values := make([]protocol.RESPObject, 0, len(pairs))
+	for i := 0; i < len(pairs); i += 2 {
+		values = append(values, bulk(pairs[i]))
+		if withValues {
+			values = append(values, bulk(pairs[i+1]))
+		}
+	}
+	return array(values)
+}
+
+func hkeys(args []protocol.RESPObject) protocol.RESPObject {
+	return hashHalf("hkeys", hkeysArgs, 0, args)
+}
+
+func hvals(args []protocol.RESPObject) protocol.RESPObject {
+	return hashHalf("hvals", hvalsArgs, 1, args)
+}
+
+// hashHalf replies with the fields of a hash when offset is 0, or with its
+// values when offset is 1.
+func hashHalf(name string, schema *ArgSchema, offset int, args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := schema.Parse(name, args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	if holdsNonHash(p.String("key")) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	hash := p.String("key")
+	return stream(func(begin func(int), emit func(protocol.RESPObject) bool) {
+		HashRange(hash, begin, func(field, value string) bool {
+			if offset == 0 {
+				return emit(bulk(field))
+			}
+			return emit(bulk(value))
+		})
+	})
+}
+
+func hexists(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := hexistsArgs.Parse("hexists", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	if holdsNonHash(p.String("key")) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	if _, ok := HashGet(p.String("key"), p.String("field")); ok {
+		return protocol.RESPObject{Type: protocol.Integer, Value: 1}
+	}
+	return protocol.RESPObject{Type: protocol.Integer, Value: 0}
+}
+
+func hlen(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := hlenArgs.Parse("hlen", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	if holdsNonHash(p.String("key")) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	return protocol.RESPObject{Type: protocol.Integer, Value: HashLen(p.String("key"))}
+}
+
+func hstrlen(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := hstrlenArgs.Parse("hstrlen", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	if holdsNonHash(p.String("key")) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	value, _ := HashGet(p.String("key"), p.String("field"))
+	return protocol.RESPObject{Type: protocol.Integer, Value: len(value)}
+}
@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+func TestHrandfieldCountOutOfRange(t *testing.T) {
+	Flush(false)
+	call(t, "HSET", "h", "f", "v")
+	for _, args := range [][]string{
+		{"HRANDFIELD", "h", "-9223372036854775808"},
+		{"HRANDFIELD", "h", "-9223372036854775808", "WITHVALUES"},
+		{"HRANDFIELD", "h", "-1000000000000"},
+		{"HRANDFIELD", "h", "-4611686018427387904", "WITHVALUES"},
+	} {
+		if reply := call(t, args...); reply.Type != protocol.Error || reply.Value != "ERR value is out of range" {
+			t.Errorf("%v = %#v", args, reply)
+		}
+	}
+	if got := values(t, call(t, "HRANDFIELD", "h", "-3", "WITHVALUES")); len(got) != 6 {
+		t.Errorf("HRANDFIELD h -3 WITHVALUES = %q, want 3 pairs", got)
+	}
+	if got := values(t, call(t, "HRANDFIELD", "h", "9223372036854775807")); len(got) != 1 {
+		t.Errorf("HRANDFIELD h MaxInt64 = %q, want the one field", got)
+	}
+}
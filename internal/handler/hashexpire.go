@@ -0,0 +1,503 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+// Fields with a TTL are hidden from reads once it passes, like expired keys,
+// and deleted by ExpireHashFields, which the server's expire cycle calls so
+// the deletion reaches the AOF as an HDEL. HLEN counts them until then, as
+// in Redis.
+
+var (
+	hexpireArgs    = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "seconds", Type: ArgInteger}, {Name: "fields", Multiple: true}}}
+	hpexpireArgs   = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "milliseconds", Type: ArgInteger}, {Name: "fields", Multiple: true}}}
+	hexpireatArgs  = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "unix-time-seconds", Type: ArgInteger}, {Name: "fields", Multiple: true}}}
+	hpexpireatArgs = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "unix-time-milliseconds", Type: ArgInteger}, {Name: "fields", Multiple: true}}}
+	httlArgs       = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "fields", Multiple: true}}}
+	// The options before FIELDS are parsed with getexArgs.
+	hgetexArgs = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "args", Multiple: true}}}
+)
+
+// volatileHashes holds the keys of hashes that have, or had, fields with a
+// TTL, for ExpireHashFields to visit. Keys that no longer hold any are
+// dropped when visited.
+var volatileHashes sync.Map // key -> struct{}
+
+// trackFieldTTLs registers key for ExpireHashFields if v is a hash with
+// fields that have a TTL.
+func trackFieldTTLs(key string, v interface{}) {
+	if h, ok := v.(*hashValue); ok && h.ttls.Load() > 0 {
+		volatileHashes.Store(key, struct{}{})
+	}
+}
+
+// fieldExpiry returns when field expires, zero if it has no TTL.
+func (h *hashValue) fieldExpiry(field string) time.Time {
+	if h.ttls.Load() == 0 {
+		return time.Time{}
+	}
+	lockValue(&h.mu)
+	defer h.mu.Unlock()
+	return h.expires[field]
+}
+
+func (h *hashValue) fieldExpired(field string, now time.Time) bool {
+	return isExpired(h.fieldExpiry(field), now)
+}
+
+// setFieldExpiry makes field expire at expiresAt, or removes its TTL if
+// expiresAt is zero.
+func (h *hashValue) setFieldExpiry(field string, expiresAt time.Time) {
+	if expiresAt.IsZero() && h.ttls.Load() == 0 {
+		return
+	}
+	lockValue(&h.mu)
+	defer h.mu.Unlock()
+	_, had := h.expires[field]
+	switch {
+	case expiresAt.IsZero():
+		if had {
+			delete(h.expires, field)
+			h.ttls.Add(-1)
+		}
+	default:
+		if h.expires == nil {
+			h.expires = map[string]time.Time{}
+		}
+		if !had {
+			h.ttls.Add(1)
+		}
+		h.expires[field] = expiresAt
+	}
+}
+
+// loadField returns the value of field unless it is missing or expired.
+func (h *hashValue) loadField(field string, now time.Time) (string, bool) {
+	v, ok := h.Load(field)
+	if !ok || h.fieldExpired(field, now) {
+		return "", false
+	}
+	return v.(string), true
+}
+
+func (h *hashValue) hasField(field string, now time.Time) bool {
+	_, ok := h.loadField(field, now)
+	return ok
+}
+
+func (h *hashValue) expiredFields(now time.Time) []string {
+	if h.ttls.Load() == 0 {
+		return nil
+	}
+	lockValue(&h.mu)
+	defer h.mu.Unlock()
+	var fields []string
+	for field, expiresAt := range h.expires {
+		if isExpired(expiresAt, now) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// ExpireHashFields deletes the expired fields of up to sample hashes, and
+// the hashes left empty, calling fn with each hash and its deleted fields.
+// It returns how many fields it deleted.
+func ExpireHashFields(sample int, fn func(hash string, fields []string)) int {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	now := time.Now()
+	visited, expired := 0, 0
+	volatileHashes.Range(func(k, _ interface{}) bool {
+		visited++
+		hash := k.(string)
+		o, ok := peek(hash)
+		h, isHash := o.value.(*hashValue)
+		if !ok || !isHash || h.ttls.Load() == 0 {
+			volatileHashes.Delete(hash)
+			return visited < sample
+		}
+		fields := h.expiredFields(now)
+		for _, field := range fields {
+			h.Delete(field)
+			h.removeField(field)
+		}
+		if len(fields) > 0 {
+			MarkDirty()
+			emptied := h.fields.Load() == 0
+			if emptied {
+				Delete(hash)
+			}
+			fn(hash, fields)
+			notify("hexpired", hash)
+			if emptied {
+				notify("del", hash)
+			}
+			expired += len(fields)
+		}
+		return visited < sample
+	})
+	return expired
+}
+
+// HashExpireFields sets the TTL of fields of the hash at key to expire at
+// expiresAt where allow, unless nil, accepts the current one, zero for none.
+// A time that is not in the future deletes the field instead. The codes are
+// Redis': -2 for a missing field, 0 if allow refused, 1 if the TTL was set
+// and 2 if the field was deleted. It also reports whether the hash was left
+// empty and deleted.
+func HashExpireFields(hash string, fields []string, expiresAt time.Time, allow func(cur time.Time) bool) ([]int64, bool) {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	codes := make([]int64, len(fields))
+	o, ok := lookup(hash)
+	h, isHash := o.value.(*hashValue)
+	if !ok || !isHash {
+		for i := range codes {
+			codes[i] = -2
+		}
+		return codes, false
+	}
+	now := time.Now()
+	changed := false
+	for i, field := range fields {
+		switch {
+		case !h.hasField(field, now):
+			codes[i] = -2
+		case allow != nil && !allow(h.fieldExpiry(field)):
+			codes[i] = 0
+		case !expiresAt.After(now):
+			h.Delete(field)
+			h.removeField(field)
+			codes[i], changed = 2, true
+		default:
+			h.setFieldExpiry(field, expiresAt)
+			codes[i], changed = 1, true
+		}
+	}
+	if !changed {
+		return codes, false
+	}
+	MarkDirty()
+	trackFieldTTLs(hash, h)
+	if h.fields.Load() == 0 {
+		Delete(hash)
+		return codes, true
+	}
+	touchKey(hash)
+	return codes, false
+}
+
+// HashGetExpire returns the values of fields of the hash at key, found
+// telling which exist, and makes them expire at expiresAt unless it is zero.
+// A time that is not in the future deletes them instead, and key itself once
+// the hash is empty, which it also reports. With persist their TTL is
+// removed. It reports whether any field changed.
+func HashGetExpire(hash string, fields []string, expiresAt time.Time, persist bool) (values []string, found []bool, changed, emptied bool) {
+	now := time.Now()
+	return hashGetFields(hash, fields, func(h *hashValue, field string) bool {
+		switch {
+		case !expiresAt.IsZero() && !expiresAt.After(now):
+			h.Delete(field)
+			h.removeField(field)
+		case !expiresAt.IsZero():
+			h.setFieldExpiry(field, expiresAt)
+			trackFieldTTLs(hash, h)
+		case persist && !h.fieldExpiry(field).IsZero():
+			h.setFieldExpiry(field, time.Time{})
+		default:
+			return false
+		}
+		return true
+	})
+}
+
+// HashFieldExpiry returns when a live field of the hash at key expires,
+// zero if it has no TTL, and whether the field exists.
+func HashFieldExpiry(hash, field string) (time.Time, bool) {
+	o, ok := lookup(hash)
+	if !ok {
+		return time.Time{}, false
+	}
+	h, ok := o.value.(*hashValue)
+	if !ok {
+		return time.Time{}, false
+	}
+	if !h.hasField(field, time.Now()) {
+		return time.Time{}, false
+	}
+	return h.fieldExpiry(field), true
+}
+
+// HashPersistFields removes the TTL of fields of the hash at key, returning
+// Redis' codes: -2 for a missing field, -1 if it had no TTL, 1 if removed.
+func HashPersistFields(hash string, fields []string) []int64 {
+	writeMu.RLock()
+	defer writeMu.RUnlock()
+	codes := make([]int64, len(fields))
+	o, ok := lookup(hash)
+	h, isHash := o.value.(*hashValue)
+	now := time.Now()
+	changed := false
+	for i, field := range fields {
+		switch {
+		case !ok || !isHash:
+			codes[i] = -2
+		case !h.hasField(field, now):
+			codes[i] = -2
+		case h.fieldExpiry(field).IsZero():
+			codes[i] = -1
+		default:
+			h.setFieldExpiry(field, time.Time{})
+			codes[i], changed = 1, true
+		}
+	}
+	if changed {
+		MarkDirty()
+		touchKey(hash)
+	}
+	return codes
+}
+
+// parseFieldsArg parses what follows the fixed arguments of the HEXPIRE and
+// HTTL families: an optional NX, XX, GT or LT condition if withCondition is
+// set, then FIELDS numfields field [field ...].
+func parseFieldsArg(rest []string, withCondition bool) (cond string, fields []string, errReply *protocol.RESPObject) {
+	if withCondition && len(rest) > 0 {
+		switch c := strings.ToUpper(rest[0]); c {
+		case "NX", "XX", "GT", "LT":
+			cond, rest = c, rest[1:]
+		}
+	}
+	if len(rest) < 2 || !strings.EqualFold(rest[0], "FIELDS") {
+		return "", nil, errorReply("ERR Mandatory argument FIELDS is missing or not at the right position")
+	}
+	n, err := strconv.ParseInt(rest[1], 10, 64)
+	if err != nil || n <= 0 {
+		return "", nil, errorReply("ERR Parameter `numFields` should be greater than 0")
+	}
+	if n != int64(len(rest)-2) {
+		return "", nil, errorReply("ERR The `numfields` parameter must match the number of arguments")
+	}
+	return cond, rest[2:], nil
+}
+
+func hexpire(args []protocol.RESPObject) protocol.RESPObject {
+	return hashExpire("hexpire", hexpireArgs, "seconds", args)
+}
+
+func hpexpire(args []protocol.RESPObject) protocol.RESPObject {
+	return hashExpire("hpexpire", hpexpireArgs, "milliseconds", args)
+}
+
+func hexpireat(args []protocol.RESPObject) protocol.RESPObject {
+	return hashExpire("hexpireat", hexpireatArgs, "unix-time-seconds", args)
+}
+
+func hpexpireat(args []protocol.RESPObject) protocol.RESPObject {
+	return hashExpire("hpexpireat", hpexpireatArgs, "unix-time-milliseconds", args)
+}
+
+// hashExpire implements the HEXPIRE family, whose time argument is named
+// after its unit and whether it is relative.
+func hashExpire(name string, schema *ArgSchema, timeArg string, args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := schema.Parse(name, args)
+	if errReply != nil {
+		return *errReply
+	}
+	cond, fields, errReply := parseFieldsArg(p.Strings("fields"), true)
+	if errReply != nil {
+		return *errReply
+	}
+
+	n := p.Int(timeArg)
+	var expiresAt time.Time
+	ok := true
+	switch timeArg {
+	case "seconds":
+		var ttl time.Duration
+		ttl, ok = ttlDuration(n, time.Second)
+		expiresAt = time.Now().Add(ttl)
+	case "milliseconds":
+		var ttl time.Duration
+		ttl, ok = ttlDuration(n, time.Millisecond)
+		expiresAt = time.Now().Add(ttl)
+	case "unix-time-seconds":
+		ok = n <= math.MaxInt64/1000 && n >= math.MinInt64/1000
+		expiresAt = time.Unix(n, 0)
+	default:
+		expiresAt = time.UnixMilli(n)
+	}
+	if !ok || n < 0 {
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrInvalidExpire, name)}
+	}
+
+	hash := p.String("key")
+	if holdsNonHash(hash) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	allow := expireAllowed(cond == "NX", cond == "XX", cond == "GT", cond == "LT", expiresAt)
+	codes, emptied := HashExpireFields(hash, fields, expiresAt, allow)
+	set, deleted := false, false
+	for _, code := range codes {
+		set = set || code == 1
+		deleted = deleted || code == 2
+	}
+	if set {
+		notify("hexpire", hash)
+	}
+	if deleted {
+		notify("hdel", hash)
+	}
+	if emptied {
+		notify("del", hash)
+	}
+	return integers(codes)
+}
+
+// hgetex returns the values of fields of a hash, nil for missing ones, and
+// sets their TTL like GETEX sets a key's.
+func hgetex(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := hgetexArgs.Parse("hgetex", args)
+	if errReply != nil {
+		return *errReply
+	}
+	rest := p.Strings("args")
+	// FIELDS ends the options, skipping the times they take.
+	i := 0
+	for i < len(rest) && !strings.EqualFold(rest[i], "FIELDS") {
+		switch strings.ToUpper(rest[i]) {
+		case "EX", "PX", "EXAT", "PXAT":
+			i++
+		}
+		i++
+	}
+	if i > len(rest) {
+		i = len(rest)
+	}
+	_, fields, errReply := parseFieldsArg(rest[i:], false)
+	if errReply != nil {
+		return *errReply
+	}
+	opts, errReply := getexArgs.Parse("hgetex", args[:1+i])
+	if errReply != nil {
+		return *errReply
+	}
+	expiresAt, errReply := expiryOption(opts, "hgetex")
+	if errReply != nil {
+		return *errReply
+	}
+	hash := p.String("key")
+	if holdsNonHash(hash) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+
+	values, found, changed, emptied := HashGetExpire(hash, fields, expiresAt, opts.Has("PERSIST"))
+	if changed {
+		switch {
+		case !expiresAt.IsZero() && !expiresAt.After(time.Now()):
+			notify("hdel", hash)
+		case !expiresAt.IsZero():
+			notify("hexpire", hash)
+		default:
+			notify("hpersist", hash)
+		}
+	}
+	if emptied {
+		notify("del", hash)
+	}
+	return fieldValues(values, found)
+}
+
+func httl(args []protocol.RESPObject) protocol.RESPObject {
+	return hashTTL("httl", args, func(expiresAt time.Time) int64 {
+		return (time.Until(expiresAt).Milliseconds() + 500) / 1000
+	})
+}
+
+func hpttl(args []protocol.RESPObject) protocol.RESPObject {
+	return hashTTL("hpttl", args, func(expiresAt time.Time) int64 {
+		return time.Until(expiresAt).Milliseconds()
+	})
+}
+
+func hexpiretime(args []protocol.RESPObject) protocol.RESPObject {
+	return hashTTL("hexpiretime", args, func(expiresAt time.Time) int64 { return expiresAt.Unix() })
+}
+
+func hpexpiretime(args []protocol.RESPObject) protocol.RESPObject {
+	return hashTTL("hpexpiretime", args, func(expiresAt time.Time) int64 { return expiresAt.UnixMilli() })
+}
+
+// hashTTL implements the HTTL family: -2 for a missing field, -1 for one
+// without a TTL, otherwise its expiry converted by unit.
+func hashTTL(name string, args []protocol.RESPObject, unit func(expiresAt time.Time) int64) protocol.RESPObject {
+	p, errReply := httlArgs.Parse(name, args)
+	if errReply != nil {
+		return *errReply
+	}
+	_, fields, errReply := parseFieldsArg(p.Strings("fields"), false)
+	if errReply != nil {
+		return *errReply
+	}
+	hash := p.String("key")
+	if holdsNonHash(hash) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+
+	codes := make([]int64, len(fields))
+	for i, field := range fields {
+		expiresAt, ok := HashFieldExpiry(hash, field)
+		switch {
+		case !ok:
+			codes[i] = -2
+		case expiresAt.IsZero():
+			codes[i] = -1
+		default:
+			if codes[i] = unit(expiresAt); codes[i] < 0 {
+				codes[i] = 0
+			}
+		}
+	}
+	return integers(codes)
+}
+
+func hpersist(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := httlArgs.Parse("hpersist", args)
+	if errReply != nil {
+		return *errReply
+	}
+	_, fields, errReply := parseFieldsArg(p.Strings("fields"), false)
+	if errReply != nil {
+		return *errReply
+	}
+	hash := p.String("key")
+	if holdsNonHash(hash) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+
+	codes := HashPersistFields(hash, fields)
+	for _, code := range codes {
+		if code == 1 {
+			notify("hpersist", hash)
+			break
+		}
+	}
+	return integers(codes)
+}
+
+func integers(ns []int64) protocol.RESPObject {
+	values := make([]protocol.RESPObject, len(ns))
+	for i, n := range ns {
+		values[i] = protocol.RESPObject{Type: protocol.Integer, Value: n}
+	}
+	return array(values)
+}
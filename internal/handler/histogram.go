@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"fmt"
+	"math/bits"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+// ttlBuckets are the upper bounds of the remaining TTL buckets reported by
+// DEBUG HISTOGRAM; keys beyond the last one are counted as ">1d".
+var ttlBuckets = []struct {
+	label string
+	max   time.Duration
+}{
+	{"<=1s", time.Second},
+	{"<=1m", time.Minute},
+	{"<=10m", 10 * time.Minute},
+	{"<=1h", time.Hour},
+	{"<=1d", 24 * time.Hour},
+}
+
+// debugHistogram buckets every live key by value size, in powers of two,
+// and by remaining TTL. It walks the keyspace with Snapshot, one key at a
+// time, so writers are never blocked behind it. Sizes are the bytes of the
+// value (fields and values for hashes, the marshaled form for custom
+// types); empty buckets are left out.
+func debugHistogram(args []protocol.RESPObject) protocol.RESPObject {
+	if len(args) != 0 {
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrWrongArgCount, "debug|histogram")}
+	}
+
+	var sizes [65]int
+	ttls := make([]int, len(ttlBuckets)+2) // no TTL, the buckets, >1d
+	keys := 0
+	now := time.Now()
+	Snapshot(func(e Entry) bool {
+		keys++
+		sizes[bits.Len(uint(entrySize(e)))]++
+		if e.ExpiresAt.IsZero() {
+			ttls[0]++
+			return true
+		}
+		ttl, i := e.ExpiresAt.Sub(now), 0
+		for i < len(ttlBuckets) && ttl > ttlBuckets[i].max {
+			i++
+		}
+		ttls[i+1]++
+		return true
+	})
+
+	var sizeValues []protocol.RESPObject
+	for i, n := range sizes {
+		if n == 0 {
+			continue
+		}
+		label := "0"
+		if i > 0 {
+			label = fmt.Sprintf("<=%d", uint64(1)<<i-1)
+		}
+		sizeValues = append(sizeValues, bulk(label), protocol.RESPObject{Type: protocol.Integer, Value: n})
+	}
+	var ttlValues []protocol.RESPObject
+	for i, n := range ttls {
+		if n == 0 {
+			continue
+		}
+		label := ">1d"
+		switch {
+		case i == 0:
+			label = "none"
+		case i <= len(ttlBuckets):
+			label = ttlBuckets[i-1].label
+		}
+		ttlValues = append(ttlValues, bulk(label), protocol.RESPObject{Type: protocol.Integer, Value: n})
+	}
+	return array([]protocol.RESPObject{
+		bulk("keys"), {Type: protocol.Integer, Value: keys},
+		bulk("sizes"), array(sizeValues),
+		bulk("ttls"), array(ttlValues),
+	})
+}
+
+func entrySize(e Entry) int {
+	switch v := e.Value.(type) {
+	case string:
+		return len(v)
+	case map[string]string:
+		n := 0
+		for f, fv := range v {
+			n += len(f) + len(fv)
+		}
+		return n
+	case []string:
+		n := 0
+		for _, elem := range v {
+			n += len(elem)
+		}
+		return n
+	}
+	if e.Module != nil && e.Module.Marshal != nil {
+		if data, err := e.Module.Marshal(e.Value); err == nil {
+			return len(data)
+		}
+	}
+	return 0
+}
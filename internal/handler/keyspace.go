@@ -0,0 +1,529 @@
+package handler
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+// keyspace maps every key to a single typedValue whatever its type, so a key
+// can't hold a string and a hash at once and one lookup tells its type.
+var keyspace = newKeyMap() // key -> typedValue
+
+// keyMap is a sync.Map that also keeps its keys in a slice, which sync.Map
+// can't index, so RANDOMKEY can pick one in constant time, in a scanIndex so
+// SCAN can resume from its cursor, and counts them, which sync.Map can't
+// either. The methods that store or delete a key update
+// the index under mu, including the set of keys with a TTL. The map itself
+// is swapped out whole by FLUSHALL.
+type keyMap struct {
+	data     atomic.Pointer[sync.Map]
+	mu       sync.Mutex
+	keys     []string
+	index    map[string]int // key -> position in keys
+	volatile map[string]struct{}
+	scan     scanIndex
+	size     atomic.Int64
+}
+
+// randomKeyTries bounds how many expired keys RANDOMKEY skips before giving
+// up, for a keyspace where nearly every key is expired.
+const randomKeyTries = 100
+
+// rangeChunk is how many keys Range visits before yielding.
+const rangeChunk = 4096
+
+var (
+	randomkeyArgs = &ArgSchema{}
+	dbsizeArgs    = &ArgSchema{}
+	flushArgs     = &ArgSchema{Options: []Option{{Name: "ASYNC", Group: "flush-type"}, {Name: "SYNC", Group: "flush-type"}}}
+)
+
+// typedValue is a value and its TTL, zero for none. value is a string, a
+// *hashValue, a *listValue or a *ModuleValue. Values are replaced rather
+// than modified, except for the fields of a hash and the elements of a list,
+// so writers can CompareAndSwap them.
+type typedValue struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+// hashValue holds the fields of a hash, how many there are, which sync.Map
+// can't tell, and whether it has outgrown the listpack encoding, see
+// updateHashEncoding. Like keyMap, it also keeps the field names in a slice
+// and a scanIndex under mu so HRANDFIELD can pick them in constant time and
+// HSCAN resume from its cursor. Fields are stored
+// before and deleted after they are indexed, by addField and removeField.
+// The TTLs of fields that have one are kept under mu too, see hashexpire.go.
+type hashValue struct {
+	sync.Map
+	fields    atomic.Int64
+	hashtable atomic.Bool
+
+	mu      sync.Mutex
+	names   []string
+	index   map[string]int       // field -> position in names
+	scan    scanIndex            // the names in HSCAN order
+	expires map[string]time.Time // field -> when it expires
+	ttls    atomic.Int64         // len(expires), read without mu
+}
+
+func (h *hashValue) addField(field string) {
+	lockValue(&h.mu)
+	defer h.mu.Unlock()
+	if h.index == nil {
+		h.index = map[string]int{}
+	}
+	h.index[field] = len(h.names)
+	h.names = append(h.names, field)
+	h.scan.add(field)
+	h.fields.Add(1)
+}
+
+// removeField swaps the last field into field's place.
+func (h *hashValue) removeField(field string) {
+	lockValue(&h.mu)
+	defer h.mu.Unlock()
+	i, ok := h.index[field]
+	if !ok {
+		return
+	}
+	last := len(h.names) - 1
+	h.names[i] = h.names[last]
+	h.index[h.names[i]] = i
+	h.names = h.names[:last]
+	delete(h.index, field)
+	h.scan.remove(field)
+	h.fields.Add(-1)
+	if _, ok := h.expires[field]; ok {
+		delete(h.expires, field)
+		h.ttls.Add(-1)
+	}
+}
+
+// randomFields returns count fields chosen uniformly at random, distinct
+// unless repeat is set, in which case fields may come up more than once.
+// Without repeat, count is capped at the number of fields.
+func (h *hashValue) randomFields(count int, repeat bool) []string {
+	lockValue(&h.mu)
+	defer h.mu.Unlock()
+	n := len(h.names)
+	if n == 0 {
+		return nil
+	}
+	if repeat {
+		fields := make([]string, count)
+		for i := range fields {
+			fields[i] = h.names[rand.Intn(n)]
+		}
+		return fields
+	}
+	if count >= n {
+		return append([]string(nil), h.names...)
+	}
+	// Drawing and retrying duplicates is cheap while count is small next to
+	// n; otherwise shuffle the first count positions of a copy.
+	if count*3 < n {
+		seen := make(map[int]struct{}, count)
+		fields := make([]string, 0, count)
+		for len(fields) < count {
+			i := rand.Intn(n)
+			if _, dup := seen[i]; !dup {
+				seen[i] = struct{}{}
+				fields = append(fields, h.names[i])
+			}
+		}
+		return fields
+	}
+	names := append([]string(nil), h.names...)
+	for i := 0; i < count; i++ {
+		j := i + rand.Intn(n-i)
+		names[i], names[j] = names[j], names[i]
+	}
+	return names[:count]
+}
+
+func newKeyMap() *keyMap {
+	m := &keyMap{index: map[string]int{}, volatile: map[string]struct{}{}}
+	m.data.Store(&sync.Map{})
+	return m
+}
+
+func (m *keyMap) Load(key interface{}) (interface{}, bool) {
+	return m.data.Load().Load(key)
+}
+
+// Range visits the keys in chunks of rangeChunk, yielding the processor
+// between chunks. sync.Map holds no lock while ranging, so writers are never
+// blocked by KEYS or SCAN, but a walk over millions of keys would otherwise
+// keep its processor from the connections waiting for it until preempted.
+func (m *keyMap) Range(f func(key, value interface{}) bool) {
+	n := 0
+	m.data.Load().Range(func(key, value interface{}) bool {
+		if n++; n%rangeChunk == 0 {
+			runtime.Gosched()
+		}
+		return f(key, value)
+	})
+}
+
+func (m *keyMap) Store(key, value interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data.Load().Store(key, value)
+	m.add(key.(string), value)
+}
+
+func (m *keyMap) LoadOrStore(key, value interface{}) (interface{}, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	actual, loaded := m.data.Load().LoadOrStore(key, value)
+	if !loaded {
+		m.add(key.(string), value)
+	}
+	return actual, loaded
+}
+
+func (m *keyMap) Swap(key, value interface{}) (interface{}, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	previous, loaded := m.data.Load().Swap(key, value)
+	m.add(key.(string), value)
+	return previous, loaded
+}
+
+func (m *keyMap) CompareAndSwap(key, old, next interface{}) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	swapped := m.data.Load().CompareAndSwap(key, old, next)
+	if swapped {
+		m.add(key.(string), next)
+	}
+	return swapped
+}
+
+func (m *keyMap) Delete(key interface{}) {
+	m.LoadAndDelete(key)
+}
+
+func (m *keyMap) LoadAndDelete(key interface{}) (interface{}, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, loaded := m.data.Load().LoadAndDelete(key)
+	if loaded {
+		m.remove(key.(string))
+	}
+	return v, loaded
+}
+
+func (m *keyMap) CompareAndDelete(key, old interface{}) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deleted := m.data.Load().CompareAndDelete(key, old)
+	if deleted {
+		m.remove(key.(string))
+	}
+	return deleted
+}
+
+// add indexes key, if it is new, and whether value has a TTL.
+func (m *keyMap) add(key string, value interface{}) {
+	if value.(typedValue).expiresAt.IsZero() {
+		delete(m.volatile, key)
+	} else {
+		m.volatile[key] = struct{}{}
+	}
+	if _, ok := m.index[key]; ok {
+		return
+	}
+	m.index[key] = len(m.keys)
+	m.keys = append(m.keys, key)
+	m.scan.add(key)
+	m.size.Add(1)
+}
+
+// remove swaps the last key into key's place.
+func (m *keyMap) remove(key string) {
+	i, ok := m.index[key]
+	if !ok {
+		return
+	}
+	last := len(m.keys) - 1
+	m.keys[i] = m.keys[last]
+	m.index[m.keys[i]] = i
+	m.keys = m.keys[:last]
+	delete(m.index, key)
+	delete(m.volatile, key)
+	m.scan.remove(key)
+	m.size.Add(-1)
+}
+
+// flush empties m, leaving the old map to the garbage collector.
+func (m *keyMap) flush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data.Store(&sync.Map{})
+	m.keys = nil
+	m.index = map[string]int{}
+	m.scan = scanIndex{}
+	m.volatile = map[string]struct{}{}
+	m.size.Store(0)
+}
+
+// volatileKeys returns how many keys have a TTL and how many of those are
+// expired but not yet deleted.
+func (m *keyMap) volatileKeys(now time.Time) (ttls, expired int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key := range m.volatile {
+		if v, ok := m.data.Load().Load(key); ok && v.(typedValue).expired(now) {
+			expired++
+		}
+	}
+	return len(m.volatile), expired
+}
+
+// sampleTTLs returns the remaining TTLs of up to n live keys that have one,
+// taken in map order, which Go randomizes on every walk.
+func (m *keyMap) sampleTTLs(now time.Time, n int) []time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ttls []time.Duration
+	for key := range m.volatile {
+		if len(ttls) == n {
+			break
+		}
+		if v, ok := m.data.Load().Load(key); ok {
+			if expiresAt := v.(typedValue).expiresAt; expiresAt.After(now) {
+				ttls = append(ttls, expiresAt.Sub(now))
+			}
+		}
+	}
+	return ttls
+}
+
+// DBSize returns the number of live keys. It only walks the keys that have a
+// TTL, to leave out those that are expired.
+func DBSize() int64 {
+	_, expired := keyspace.volatileKeys(time.Now())
+	return keyspace.size.Load() - int64(expired)
+}
+
+func dbsize(args []protocol.RESPObject) protocol.RESPObject {
+	if _, errReply := dbsizeArgs.Parse("dbsize", args); errReply != nil {
+		return *errReply
+	}
+	return protocol.RESPObject{Type: protocol.Integer, Value: DBSize()}
+}
+
+// flushdb and flushall are the same, as there is only one database.
+func flushdb(args []protocol.RESPObject) protocol.RESPObject {
+	return flushCommand("flushdb", args)
+}
+
+func flushall(args []protocol.RESPObject) protocol.RESPObject {
+	return flushCommand("flushall", args)
+}
+
+func flushCommand(name string, args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := flushArgs.Parse(name, args)
+	if errReply != nil {
+		return *errReply
+	}
+	Flush(p.Has("ASYNC"))
+	return protocol.RESPObject{Type: protocol.SimpleString, Value: "OK"}
+}
+
+// randomKey returns a key chosen uniformly at random, which may be expired.
+func (m *keyMap) randomKey() (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.keys) == 0 {
+		return "", false
+	}
+	return m.keys[rand.Intn(len(m.keys))], true
+}
+
+// RandomKey returns a live key chosen uniformly at random. Expired keys
+// drawn along the way are deleted as by any lookup.
+func RandomKey() (string, bool) {
+	for i := 0; i < randomKeyTries; i++ {
+		key, ok := keyspace.randomKey()
+		if !ok {
+			return "", false
+		}
+		if _, ok := lookup(key); ok {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+func randomkey(args []protocol.RESPObject) protocol.RESPObject {
+	if _, errReply := randomkeyArgs.Parse("randomkey", args); errReply != nil {
+		return *errReply
+	}
+	key, ok := RandomKey()
+	if !ok {
+		return protocol.RESPObject{Type: protocol.Null}
+	}
+	return protocol.RESPObject{Type: protocol.BulkString, Value: key}
+}
+
+var typeArgs = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}}}
+
+func (o typedValue) expired(now time.Time) bool {
+	return isExpired(o.expiresAt, now)
+}
+
+// typeName is the type of o as reported by TYPE.
+func (o typedValue) typeName() string {
+	switch v := o.value.(type) {
+	case string:
+		return "string"
+	case *hashValue:
+		return "hash"
+	case *listValue:
+		return "list"
+	case *ModuleValue:
+		return v.Type.Name
+	}
+	return "none"
+}
+
+// peek returns the live value at key without expiring it or touching its
+// LFU counter.
+func peek(key string) (typedValue, bool) {
+	v, ok := keyspace.Load(key)
+	if !ok {
+		return typedValue{}, false
+	}
+	o := v.(typedValue)
+	if o.expired(time.Now()) {
+		return typedValue{}, false
+	}
+	return o, true
+}
+
+// lookup is peek, except that it deletes an expired key unless lazy expiry
+// is off.
+func lookup(key string) (typedValue, bool) {
+	v, ok := keyspace.Load(key)
+	if !ok {
+		return typedValue{}, false
+	}
+	o := v.(typedValue)
+	if o.expired(time.Now()) {
+		expiredMisses.Add(1)
+		if !noLazyExpire.Load() {
+			deleteExpired(key, v)
+		}
+		return typedValue{}, false
+	}
+	return o, true
+}
+
+// replace stores next at key if key still holds old, or is still missing
+// when loaded is false.
+func replace(key string, old interface{}, loaded bool, next typedValue) bool {
+	if loaded {
+		return keyspace.CompareAndSwap(key, old, next)
+	}
+	_, dup := keyspace.LoadOrStore(key, next)
+	return !dup
+}
+
+// update atomically replaces the value at key with the one fn returns,
+// calling fn again if another writer changed the key meanwhile. exists is
+// false, and cur zero, for a missing or expired key; fn returns false to
+// leave the key alone.
+func update(key string, fn func(cur typedValue, exists bool) (typedValue, bool)) bool {
+	writeMu.RLock()
+	defer writeMu.RUnlock()
+	for {
+		old, loaded := keyspace.Load(key)
+		var cur typedValue
+		exists := false
+		if loaded {
+			if o := old.(typedValue); !o.expired(time.Now()) {
+				cur, exists = o, true
+			}
+		}
+		next, ok := fn(cur, exists)
+		if !ok {
+			return false
+		}
+		if replace(key, old, loaded, next) {
+			break
+		}
+	}
+	MarkDirty()
+	touchKey(key)
+	return true
+}
+
+// copyValue returns a deep copy of v. Strings are immutable and shared.
+func copyValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case *hashValue:
+		h := &hashValue{}
+		v.Range(func(f, fv interface{}) bool {
+			h.Store(f, fv)
+			h.addField(f.(string))
+			h.setFieldExpiry(f.(string), v.fieldExpiry(f.(string)))
+			return true
+		})
+		h.hashtable.Store(v.hashtable.Load())
+		return h, nil
+	case *listValue:
+		l := newList(v.elements())
+		l.quicklist = l.quicklist || v.encoding() == "quicklist"
+		return l, nil
+	case *ModuleValue:
+		t := v.Type
+		if t.Copy != nil {
+			return &ModuleValue{Type: t, Value: t.Copy(v.Value)}, nil
+		}
+		if t.Marshal == nil || t.Unmarshal == nil {
+			return nil, fmt.Errorf("ERR values of type %s can't be copied", t.Name)
+		}
+		data, err := t.Marshal(v.Value)
+		if err != nil {
+			return nil, fmt.Errorf("ERR %v", err)
+		}
+		cp, err := t.Unmarshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("ERR %v", err)
+		}
+		return &ModuleValue{Type: t, Value: cp}, nil
+	}
+	return v, nil
+}
+
+// TypeOf returns the type of the live value at key: "string", "hash",
+// "list", the name of a custom type, or "none".
+func TypeOf(key string) string {
+	o, ok := peek(key)
+	if !ok {
+		return "none"
+	}
+	return o.typeName()
+}
+
+func keyType(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := typeArgs.Parse("type", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	typ := "none"
+	if o, ok := lookup(p.String("key")); ok {
+		typ = o.typeName()
+	}
+	return protocol.RESPObject{Type: protocol.SimpleString, Value: typ}
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+var lcsArgs = &ArgSchema{
+	Args: []Arg{{Name: "key1", Type: ArgKey}, {Name: "key2", Type: ArgKey}},
+	Options: []Option{
+		{Name: "LEN"},
+		{Name: "IDX"},
+		{Name: "MINMATCHLEN", Args: []Arg{{Name: "min-match-len", Type: ArgInteger}}},
+		{Name: "WITHMATCHLEN"},
+	},
+}
+
+// lcs returns the longest common subsequence of two strings, its length
+// with LEN, or with IDX the ranges of it that each string holds, the last
+// first as Redis walks the table backwards. Missing keys count as empty.
+func lcs(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := lcsArgs.Parse("lcs", args)
+	if errReply != nil {
+		return *errReply
+	}
+	getLen, getIdx := p.Has("LEN"), p.Has("IDX")
+	if getLen && getIdx {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR If you want both the length and indexes, please just use IDX."}
+	}
+	keyA, keyB := p.String("key1"), p.String("key2")
+	if holdsNonString(keyA) || holdsNonString(keyB) {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR The specified keys must contain string values"}
+	}
+	a, _ := GetString(keyA)
+	b, _ := GetString(keyB)
+	minLen := p.Int("MINMATCHLEN")
+	if minLen < 0 {
+		minLen = 0
+	}
+
+	// table[i*w+j] is the LCS length of a[:i] and b[:j].
+	w := len(b) + 1
+	if int64(len(a)+1)*int64(w)*4 > maxStringSize {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR Insufficient memory, transient memory for LCS exceeds proto-max-bulk-len"}
+	}
+	table := make([]uint32, (len(a)+1)*w)
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			switch {
+			case a[i-1] == b[j-1]:
+				table[i*w+j] = table[(i-1)*w+j-1] + 1
+			case table[(i-1)*w+j] > table[i*w+j-1]:
+				table[i*w+j] = table[(i-1)*w+j]
+			default:
+				table[i*w+j] = table[i*w+j-1]
+			}
+		}
+	}
+	n := int(table[len(a)*w+len(b)])
+	if getLen {
+		return protocol.RESPObject{Type: protocol.Integer, Value: n}
+	}
+
+	result := make([]byte, n)
+	var matches []protocol.RESPObject
+	// aStart == len(a) means no range is open.
+	aStart, aEnd, bStart, bEnd := len(a), 0, 0, 0
+	for i, j, k := len(a), len(b), n; i > 0 && j > 0; {
+		emit := false
+		if a[i-1] == b[j-1] {
+			result[k-1] = a[i-1]
+			if aStart == len(a) {
+				aStart, aEnd, bStart, bEnd = i-1, i-1, j-1, j-1
+			} else if aStart == i && bStart == j {
+				aStart--
+				bStart--
+			} else {
+				emit = true
+			}
+			if aStart == 0 || bStart == 0 {
+				emit = true
+			}
+			i, j, k = i-1, j-1, k-1
+		} else {
+			if table[(i-1)*w+j] > table[i*w+j-1] {
+				i--
+			} else {
+				j--
+			}
+			if aStart != len(a) {
+				emit = true
+			}
+		}
+		if !emit {
+			continue
+		}
+		if matchLen := aEnd - aStart + 1; int64(matchLen) >= minLen {
+			match := []protocol.RESPObject{
+				array([]protocol.RESPObject{integer(aStart), integer(aEnd)}),
+				array([]protocol.RESPObject{integer(bStart), integer(bEnd)}),
+			}
+			if p.Has("WITHMATCHLEN") {
+				match = append(match, integer(matchLen))
+			}
+			matches = append(matches, array(match))
+		}
+		aStart = len(a)
+	}
+	if !getIdx {
+		return protocol.RESPObject{Type: protocol.BulkString, Value: string(result)}
+	}
+	return array([]protocol.RESPObject{bulk("matches"), array(matches), bulk("len"), integer(n)})
+}
+
+func integer(n int) protocol.RESPObject {
+	return protocol.RESPObject{Type: protocol.Integer, Value: n}
+}
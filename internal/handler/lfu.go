@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LFU counters follow the Redis scheme: an 8-bit logarithmic access counter
+// packed with the minute of the last decrement, so hot keys saturate slowly
+// and idle keys decay back down over time. Each counter also records the
+// last access, which serves as the LRU clock.
+const (
+	LFUInitVal   = 5
+	LFULogFactor = 10
+	LFUDecayTime = 1 // minutes
+)
+
+var Freqs = sync.Map{}
+
+type lfuCounter struct {
+	packed atomic.Uint32
+	access atomic.Int64 // unix seconds
+}
+
+func lfuMinutes() uint32 {
+	return uint32(time.Now().Unix()/60) & 0xFFFF
+}
+
+func newLFUCounter() *lfuCounter {
+	c := &lfuCounter{}
+	c.packed.Store(lfuMinutes()<<8 | LFUInitVal)
+	c.access.Store(time.Now().Unix())
+	return c
+}
+
+func (c *lfuCounter) decayed(packed uint32) uint8 {
+	counter := uint8(packed & 0xFF)
+	last := packed >> 8
+	now := lfuMinutes()
+	var elapsed uint32
+	if now >= last {
+		elapsed = now - last
+	} else {
+		elapsed = 0xFFFF - last + now
+	}
+	periods := elapsed / LFUDecayTime
+	if periods >= uint32(counter) {
+		return 0
+	}
+	return counter - uint8(periods)
+}
+
+func (c *lfuCounter) incr() {
+	c.access.Store(time.Now().Unix())
+	for {
+		old := c.packed.Load()
+		counter := c.decayed(old)
+		if counter < 255 {
+			baseval := float64(counter) - LFUInitVal
+			if baseval < 0 {
+				baseval = 0
+			}
+			if rand.Float64() < 1.0/(baseval*LFULogFactor+1) {
+				counter++
+			}
+		}
+		if c.packed.CompareAndSwap(old, lfuMinutes()<<8|uint32(counter)) {
+			return
+		}
+	}
+}
+
+func (c *lfuCounter) freq() uint8 {
+	return c.decayed(c.packed.Load())
+}
+
+// keyAccess returns the LFU frequency of key and when it was last accessed,
+// without counting as an access itself.
+func keyAccess(key string) (freq uint8, lastAccess time.Time, ok bool) {
+	c, ok := Freqs.Load(key)
+	if !ok {
+		return 0, time.Time{}, false
+	}
+	return c.(*lfuCounter).freq(), time.Unix(c.(*lfuCounter).access.Load(), 0), true
+}
+
+func touchKey(key string) {
+	if c, ok := Freqs.Load(key); ok {
+		c.(*lfuCounter).incr()
+		return
+	}
+	c, loaded := Freqs.LoadOrStore(key, newLFUCounter())
+	if loaded {
+		c.(*lfuCounter).incr()
+	}
+}
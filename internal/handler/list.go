@@ -0,0 +1,643 @@
+package handler
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+// listMaxListpackBytes is the size past which a list reports the quicklist
+// encoding rather than listpack, Redis' default list-max-listpack-size of
+// -2, 8 KB. As for hashes the conversion is one-way.
+const listMaxListpackBytes = 8 << 10
+
+var (
+	pushArgs      = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "element", Multiple: true}}}
+	popArgs       = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "count", Type: ArgInteger, Optional: true}}}
+	llenArgs      = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}}}
+	lremArgs      = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "count", Type: ArgInteger}, {Name: "element"}}}
+	ltrimArgs     = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "start", Type: ArgInteger}, {Name: "stop", Type: ArgInteger}}}
+	linsertArgs   = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "where"}, {Name: "pivot"}, {Name: "element"}}}
+	lmoveArgs     = &ArgSchema{Args: []Arg{{Name: "source", Type: ArgKey}, {Name: "destination", Type: ArgKey}, {Name: "wherefrom"}, {Name: "whereto"}}}
+	rpoplpushArgs = &ArgSchema{Args: []Arg{{Name: "source", Type: ArgKey}, {Name: "destination", Type: ArgKey}}}
+)
+
+// listValue holds the elements of a list in a ring buffer that doubles when
+// full, so pushing and popping at either end take constant time. Unlike the
+// fields of a hash, elements are only read and written under mu.
+type listValue struct {
+	mu        sync.Mutex
+	elems     []string
+	head      int // position of the first element in elems
+	n         int
+	bytes     int // total length of the elements
+	quicklist bool
+}
+
+// at returns the element at index i, counting from the head. mu must be
+// held.
+func (l *listValue) at(i int) string {
+	return l.elems[(l.head+i)%len(l.elems)]
+}
+
+// grow makes room for one more element. mu must be held.
+func (l *listValue) grow() {
+	if l.n < len(l.elems) {
+		return
+	}
+	size := 2 * len(l.elems)
+	if size == 0 {
+		size = 4
+	}
+	elems := make([]string, size)
+	for i := 0; i < l.n; i++ {
+		elems[i] = l.at(i)
+	}
+	l.elems, l.head = elems, 0
+}
+
+func (l *listValue) added(elem string) {
+	l.bytes += len(elem)
+	if l.bytes > listMaxListpackBytes {
+		l.quicklist = true
+	}
+}
+
+// push adds elems one by one at the head, so they end up in reverse order,
+// or at the tail, and returns the new length.
+func (l *listValue) push(elems []string, head bool) int {
+	lockValue(&l.mu)
+	defer l.mu.Unlock()
+	for _, elem := range elems {
+		l.grow()
+		if head {
+			l.head = (l.head + len(l.elems) - 1) % len(l.elems)
+			l.elems[l.head] = elem
+		} else {
+			l.elems[(l.head+l.n)%len(l.elems)] = elem
+		}
+		l.n++
+		l.added(elem)
+	}
+	return l.n
+}
+
+// pop removes and returns up to count elements from the head or the tail,
+// in the order they are removed.
+func (l *listValue) pop(count int, head bool) []string {
+	lockValue(&l.mu)
+	defer l.mu.Unlock()
+	if count > l.n {
+		count = l.n
+	}
+	popped := make([]string, count)
+	for i := range popped {
+		pos := (l.head + l.n - 1) % len(l.elems)
+		if head {
+			pos = l.head
+			l.head = (l.head + 1) % len(l.elems)
+		}
+		popped[i] = l.elems[pos]
+		l.elems[pos] = ""
+		l.n--
+		l.bytes -= len(popped[i])
+	}
+	return popped
+}
+
+func (l *listValue) len() int {
+	lockValue(&l.mu)
+	defer l.mu.Unlock()
+	return l.n
+}
+
+// elements returns a copy of the elements, head first.
+func (l *listValue) elements() []string {
+	lockValue(&l.mu)
+	defer l.mu.Unlock()
+	return l.copyElements()
+}
+
+// copyElements is elements with mu held.
+func (l *listValue) copyElements() []string {
+	elems := make([]string, l.n)
+	for i := range elems {
+		elems[i] = l.at(i)
+	}
+	return elems
+}
+
+// replace makes elems, head first, the elements of l. Changes in the middle
+// of a list rebuild it, which takes linear time as in Redis. mu must be
+// held.
+func (l *listValue) replace(elems []string) {
+	l.elems, l.head, l.n, l.bytes = make([]string, len(elems)), 0, len(elems), 0
+	for i, elem := range elems {
+		l.elems[i] = elem
+		l.added(elem)
+	}
+}
+
+// remove deletes the first count elements equal to elem from the head, or
+// with a negative count the last -count from the tail, or with a zero count
+// all of them, and returns how many it deleted.
+func (l *listValue) remove(count int, elem string) int {
+	lockValue(&l.mu)
+	defer l.mu.Unlock()
+	fromTail := count < 0
+	if fromTail {
+		count = -count
+	}
+	keep := make([]bool, l.n)
+	removed := 0
+	for j := 0; j < l.n; j++ {
+		i := j
+		if fromTail {
+			i = l.n - 1 - j
+		}
+		if (count == 0 || removed < count) && l.at(i) == elem {
+			removed++
+			continue
+		}
+		keep[i] = true
+	}
+	if removed == 0 {
+		return 0
+	}
+	elems := make([]string, 0, l.n-removed)
+	for i, ok := range keep {
+		if ok {
+			elems = append(elems, l.at(i))
+		}
+	}
+	l.replace(elems)
+	return removed
+}
+
+// trim keeps only the elements from start to stop, inclusive, which may
+// count from the tail when negative, and reports whether any went.
+func (l *listValue) trim(start, stop int64) bool {
+	lockValue(&l.mu)
+	defer l.mu.Unlock()
+	from, to, ok := listRange(start, stop, l.n)
+	if !ok {
+		from, to = 0, -1
+	}
+	if from == 0 && to == l.n-1 {
+		return false
+	}
+	elems := make([]string, 0, to-from+1)
+	for i := from; i <= to; i++ {
+		elems = append(elems, l.at(i))
+	}
+	l.replace(elems)
+	return true
+}
+
+// insert adds elem before or after the first element equal to pivot and
+// returns the new length, or -1 if there is no such element.
+func (l *listValue) insert(pivot, elem string, after bool) int {
+	lockValue(&l.mu)
+	defer l.mu.Unlock()
+	at := -1
+	for i := 0; i < l.n; i++ {
+		if l.at(i) == pivot {
+			at = i
+			break
+		}
+	}
+	if at < 0 {
+		return -1
+	}
+	if after {
+		at++
+	}
+	elems := make([]string, 0, l.n+1)
+	for i := 0; i < l.n; i++ {
+		if i == at {
+			elems = append(elems, elem)
+		}
+		elems = append(elems, l.at(i))
+	}
+	if at == l.n {
+		elems = append(elems, elem)
+	}
+	l.replace(elems)
+	return l.n
+}
+
+// listRange resolves start and stop, which count from the tail when
+// negative, to positions in a list of n elements, clamped to it. It returns
+// false if the range is empty.
+func listRange(start, stop int64, n int) (from, to int, ok bool) {
+	if start < 0 {
+		start += int64(n)
+	}
+	if stop < 0 {
+		stop += int64(n)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= int64(n) {
+		stop = int64(n) - 1
+	}
+	if start > stop || start >= int64(n) {
+		return 0, 0, false
+	}
+	return int(start), int(stop), true
+}
+
+func (l *listValue) encoding() string {
+	lockValue(&l.mu)
+	defer l.mu.Unlock()
+	if l.quicklist {
+		return "quicklist"
+	}
+	return "listpack"
+}
+
+// newList returns a list of elems, head first.
+func newList(elems []string) *listValue {
+	l := &listValue{}
+	l.push(elems, false)
+	return l
+}
+
+// listForWrite returns the list at key, creating an empty one if key is
+// missing or expired, or false if key holds another type. writeMu must be
+// held.
+func listForWrite(key string) (*listValue, bool) {
+	expiredKey(key)
+	for {
+		old, loaded := keyspace.Load(key)
+		if loaded {
+			if o := old.(typedValue); !o.expired(time.Now()) {
+				l, ok := o.value.(*listValue)
+				return l, ok
+			}
+		}
+		l := &listValue{}
+		if replace(key, old, loaded, typedValue{value: l}) {
+			return l, true
+		}
+	}
+}
+
+// ListPush adds elems to the head of the list at key, each in turn, or to
+// its tail, creating the list if missing. It returns the new length, or
+// false if key holds another type.
+func ListPush(key string, elems []string, head bool) (int, bool) {
+	writeMu.RLock()
+	defer writeMu.RUnlock()
+	l, ok := listForWrite(key)
+	if !ok {
+		return 0, false
+	}
+	n := l.push(elems, head)
+	MarkDirty()
+	touchKey(key)
+	return n, true
+}
+
+// ListPop removes up to count elements from the head or the tail of the
+// list at key. It returns the elements and whether key was deleted, see
+// updateList, or false if key is missing or holds another type.
+func ListPop(key string, count int, head bool) (popped []string, emptied, ok bool) {
+	ok, emptied = updateList(key, func(l *listValue) bool {
+		popped = l.pop(count, head)
+		return len(popped) > 0
+	})
+	return popped, emptied, ok
+}
+
+// ListRemove deletes elements equal to elem from the list at key, see
+// listValue.remove, returning how many and whether key was deleted.
+func ListRemove(key string, count int, elem string) (removed int, emptied bool) {
+	_, emptied = updateList(key, func(l *listValue) bool {
+		removed = l.remove(count, elem)
+		return removed > 0
+	})
+	return removed, emptied
+}
+
+// ListTrim keeps only the elements of the list at key from start to stop,
+// see listValue.trim, and reports whether key was deleted.
+func ListTrim(key string, start, stop int64) (emptied bool) {
+	_, emptied = updateList(key, func(l *listValue) bool {
+		return l.trim(start, stop)
+	})
+	return emptied
+}
+
+// ListInsert adds elem next to pivot in the list at key, see
+// listValue.insert. It returns the new length, -1 without pivot, or 0 if
+// key is missing or holds another type.
+func ListInsert(key, pivot, elem string, after bool) int {
+	n := 0
+	updateList(key, func(l *listValue) bool {
+		n = l.insert(pivot, elem, after)
+		return n > 0
+	})
+	return n
+}
+
+// ListMove pops an element from the head or the tail of the list at src and
+// pushes it to the head or the tail of the list at dst, creating it if
+// missing; src and dst may be the same list, which rotates it. It returns
+// the element and whether src was deleted, or false if src is missing or
+// either key holds another type. writeMu is held exclusively across both
+// keys, as for Rename, so no other write sees the element in neither list
+// or in both.
+func ListMove(src, dst string, srcHead, dstHead bool) (elem string, emptied, ok bool) {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	o, ok := lookup(src)
+	if !ok {
+		return "", false, false
+	}
+	from, ok := o.value.(*listValue)
+	if !ok {
+		return "", false, false
+	}
+	to, ok := listForWrite(dst)
+	if !ok {
+		return "", false, false
+	}
+	elem = from.pop(1, srcHead)[0]
+	to.push([]string{elem}, dstHead)
+	MarkDirty()
+	touchKey(dst)
+	if emptied = from.len() == 0; emptied {
+		Delete(src)
+	} else if src != dst {
+		touchKey(src)
+	}
+	return elem, emptied, true
+}
+
+// updateList calls fn with the list at key, which reports whether it
+// changed the list, and deletes key once the list is empty, as Redis never
+// keeps an empty list. It reports whether key holds a list and whether it
+// was deleted. writeMu is held exclusively so no element is pushed between
+// the change that empties the list and the key's removal.
+func updateList(key string, fn func(l *listValue) bool) (found, emptied bool) {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	o, ok := lookup(key)
+	if !ok {
+		return false, false
+	}
+	l, ok := o.value.(*listValue)
+	if !ok {
+		return false, false
+	}
+	if !fn(l) {
+		return true, false
+	}
+	MarkDirty()
+	if emptied = l.len() == 0; emptied {
+		Delete(key)
+	} else {
+		touchKey(key)
+	}
+	return true, emptied
+}
+
+// ListLen returns the length of the list at key, zero if it is missing or
+// holds another type.
+func ListLen(key string) int {
+	o, ok := lookup(key)
+	if !ok {
+		return 0
+	}
+	l, ok := o.value.(*listValue)
+	if !ok {
+		return 0
+	}
+	touchKey(key)
+	return l.len()
+}
+
+// holdsNonList reports whether key holds a live value that is not a list.
+func holdsNonList(key string) bool {
+	t := TypeOf(key)
+	return t != "none" && t != "list"
+}
+
+func lpush(args []protocol.RESPObject) protocol.RESPObject {
+	return push("lpush", args, true)
+}
+
+func rpush(args []protocol.RESPObject) protocol.RESPObject {
+	return push("rpush", args, false)
+}
+
+func push(name string, args []protocol.RESPObject, head bool) protocol.RESPObject {
+	p, errReply := pushArgs.Parse(name, args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	key := p.String("key")
+	n, ok := ListPush(key, p.Strings("element"), head)
+	if !ok {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	notify(name, key)
+	return integer(n)
+}
+
+func lpop(args []protocol.RESPObject) protocol.RESPObject {
+	return pop("lpop", args, true)
+}
+
+func rpop(args []protocol.RESPObject) protocol.RESPObject {
+	return pop("rpop", args, false)
+}
+
+// pop replies with the popped element without a count, and with an array
+// of up to count elements with one, nil if the key is missing either way.
+func pop(name string, args []protocol.RESPObject, head bool) protocol.RESPObject {
+	p, errReply := popArgs.Parse(name, args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	key := p.String("key")
+	count := 1
+	if p.Has("count") {
+		n := p.Int("count")
+		if n < 0 {
+			return protocol.RESPObject{Type: protocol.Error, Value: "ERR value is out of range, must be positive"}
+		}
+		count = int(n)
+	}
+	if holdsNonList(key) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	popped, emptied, ok := ListPop(key, count, head)
+	if !ok && p.Has("count") {
+		return protocol.RESPObject{Type: protocol.Array} // Null array
+	}
+	if !ok {
+		return protocol.RESPObject{Type: protocol.Null}
+	}
+	if len(popped) > 0 {
+		notify(name, key)
+	}
+	if emptied {
+		notify("del", key)
+	}
+	if !p.Has("count") {
+		return bulk(popped[0])
+	}
+	values := make([]protocol.RESPObject, len(popped))
+	for i, elem := range popped {
+		values[i] = bulk(elem)
+	}
+	return array(values)
+}
+
+func llen(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := llenArgs.Parse("llen", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	if holdsNonList(p.String("key")) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	return integer(ListLen(p.String("key")))
+}
+
+func lrem(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := lremArgs.Parse("lrem", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	key := p.String("key")
+	if holdsNonList(key) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	removed, emptied := ListRemove(key, int(p.Int("count")), p.String("element"))
+	if removed > 0 {
+		notify("lrem", key)
+	}
+	if emptied {
+		notify("del", key)
+	}
+	return integer(removed)
+}
+
+func ltrim(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := ltrimArgs.Parse("ltrim", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	key := p.String("key")
+	if holdsNonList(key) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	n := ListLen(key)
+	emptied := ListTrim(key, p.Int("start"), p.Int("stop"))
+	if n > 0 {
+		notify("ltrim", key)
+	}
+	if emptied {
+		notify("del", key)
+	}
+	return protocol.RESPObject{Type: protocol.SimpleString, Value: "OK"}
+}
+
+func linsert(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := linsertArgs.Parse("linsert", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	var after bool
+	switch strings.ToUpper(p.String("where")) {
+	case "BEFORE":
+	case "AFTER":
+		after = true
+	default:
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrSyntax}
+	}
+	key := p.String("key")
+	if holdsNonList(key) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	n := ListInsert(key, p.String("pivot"), p.String("element"), after)
+	if n > 0 {
+		notify("linsert", key)
+	}
+	return integer(n)
+}
+
+func lmove(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := lmoveArgs.Parse("lmove", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	srcHead, ok := listEnd(p.String("wherefrom"))
+	if !ok {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrSyntax}
+	}
+	dstHead, ok := listEnd(p.String("whereto"))
+	if !ok {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrSyntax}
+	}
+	return move(p.String("source"), p.String("destination"), srcHead, dstHead)
+}
+
+func rpoplpush(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := rpoplpushArgs.Parse("rpoplpush", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	return move(p.String("source"), p.String("destination"), false, true)
+}
+
+// listEnd parses LEFT or RIGHT, reporting whether it names the head.
+func listEnd(where string) (head, ok bool) {
+	switch strings.ToUpper(where) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
+
+// move replies with the moved element, or nil if src is missing. As in
+// Redis, the type of dst only matters when there is an element to move.
+func move(src, dst string, srcHead, dstHead bool) protocol.RESPObject {
+	if holdsNonList(src) || (TypeOf(src) == "list" && holdsNonList(dst)) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+	elem, emptied, ok := ListMove(src, dst, srcHead, dstHead)
+	if !ok {
+		return protocol.RESPObject{Type: protocol.Null}
+	}
+	if dstHead {
+		notify("lpush", dst)
+	} else {
+		notify("rpush", dst)
+	}
+	if srcHead {
+		notify("lpop", src)
+	} else {
+		notify("rpop", src)
+	}
+	if emptied {
+		notify("del", src)
+	}
+	return bulk(elem)
+}
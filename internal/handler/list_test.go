@@ -0,0 +1,21 @@
+package handler
+
+import "testing"
+
+func TestPopMissingKey(t *testing.T) {
+	Flush(false)
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"missing"}, "$-1\r\n"},
+		{[]string{"missing", "2"}, "*-1\r\n"},
+		{[]string{"missing", "0"}, "*-1\r\n"},
+	} {
+		for _, name := range []string{"LPOP", "RPOP"} {
+			if got := call(t, append([]string{name}, tc.args...)...).Serialize(); got != tc.want {
+				t.Errorf("%s %v = %q, want %q", name, tc.args, got, tc.want)
+			}
+		}
+	}
+}
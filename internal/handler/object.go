@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+const embstrSizeLimit = 44
+
+var objectHelp = []string{
+	"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ENCODING <key>",
+	"    Return the kind of internal representation used in order to store the value",
+	"    associated with a <key>.",
+	"FREQ <key>",
+	"    Return the access frequency index of the <key>. The returned integer is",
+	"    proportional to the logarithm of the recent access frequency of the key.",
+	"IDLETIME <key>",
+	"    Return the idle time of the <key>, that is the approximated number of",
+	"    seconds elapsed since the last access to the key.",
+	"REFCOUNT <key>",
+	"    Return the number of references of the value associated with the specified",
+	"    <key>.",
+	"HELP",
+	"    Print this help.",
+}
+
+// object replies Null for a missing key whatever the subcommand. Keys are
+// tracked for both LRU and LFU, so unlike Redis FREQ and IDLETIME don't
+// depend on the eviction policy.
+func object(args []protocol.RESPObject) protocol.RESPObject {
+	sub := strings.ToUpper(args[0].Value.(string))
+	if sub == "HELP" {
+		lines := make([]protocol.RESPObject, len(objectHelp))
+		for i, line := range objectHelp {
+			lines[i] = protocol.RESPObject{Type: protocol.SimpleString, Value: line}
+		}
+		return array(lines)
+	}
+	switch sub {
+	case "ENCODING", "FREQ", "IDLETIME", "REFCOUNT":
+	default:
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrUnknownSubcommand, args[0].Value, "OBJECT")}
+	}
+	if len(args) != 2 {
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(ErrWrongArgCount, "object|"+strings.ToLower(sub))}
+	}
+
+	key := args[1].Value.(string)
+	encoding, ok := objectEncoding(key)
+	if !ok {
+		return protocol.RESPObject{Type: protocol.Null}
+	}
+	freq, lastAccess, _ := keyAccess(key)
+	switch sub {
+	case "ENCODING":
+		return protocol.RESPObject{Type: protocol.BulkString, Value: encoding}
+	case "FREQ":
+		return protocol.RESPObject{Type: protocol.Integer, Value: int(freq)}
+	case "IDLETIME":
+		return protocol.RESPObject{Type: protocol.Integer, Value: int64(idleTime(lastAccess).Seconds())}
+	default:
+		return protocol.RESPObject{Type: protocol.Integer, Value: objectRefcount(key, encoding)}
+	}
+}
+
+// idleTime is the time since lastAccess, zero if the key was never accessed.
+func idleTime(lastAccess time.Time) time.Duration {
+	if lastAccess.IsZero() {
+		return 0
+	}
+	return time.Since(lastAccess)
+}
+
+// objectRefcount reports small non-negative integers as shared, as Redis
+// keeps one copy of each of them; every other value has a single owner.
+func objectRefcount(key, encoding string) int {
+	if v, ok := PeekString(key); ok && encoding == "int" {
+		if n, err := strconv.ParseInt(v.Data, 10, 64); err == nil && n >= 0 && n < 10000 {
+			return sharedRefcount
+		}
+	}
+	return 1
+}
+
+// objectEncoding doesn't count as an access to key, like OBJECT in Redis.
+func objectEncoding(key string) (string, bool) {
+	o, ok := peek(key)
+	if !ok {
+		return "", false
+	}
+	switch v := o.value.(type) {
+	case string:
+		if _, err := strconv.ParseInt(v, 10, 64); err == nil && len(v) <= 20 {
+			return "int", true
+		}
+		if len(v) <= embstrSizeLimit {
+			return "embstr", true
+		}
+		return "raw", true
+	case *hashValue:
+		return hashEncoding(v), true
+	case *listValue:
+		return v.encoding(), true
+	case *ModuleValue:
+		if v.Type.Encoding != nil {
+			return v.Type.Encoding(v.Value), true
+		}
+		return v.Type.Name, true
+	}
+	return "", false
+}
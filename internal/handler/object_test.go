@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+// debugObjectFields parses a DEBUG OBJECT reply into its name:value fields.
+func debugObjectFields(t *testing.T, key string) map[string]string {
+	t.Helper()
+	reply := call(t, "DEBUG", "OBJECT", key)
+	if reply.Type != protocol.SimpleString {
+		t.Fatalf("DEBUG OBJECT %s = %#v", key, reply)
+	}
+	fields := map[string]string{}
+	for _, f := range strings.Fields(reply.Value.(string)) {
+		if name, value, ok := strings.Cut(f, ":"); ok {
+			fields[name] = value
+		}
+	}
+	return fields
+}
+
+func integerReply(t *testing.T, args ...string) int64 {
+	t.Helper()
+	reply := call(t, args...)
+	if reply.Type != protocol.Integer {
+		t.Fatalf("%v = %#v, want an integer", args, reply)
+	}
+	switch v := reply.Value.(type) {
+	case int:
+		return int64(v)
+	case int64:
+		return v
+	}
+	t.Fatalf("%v = %#v, want an integer", args, reply)
+	return 0
+}
+
+func TestObjectFreqMatchesDebugObject(t *testing.T) {
+	Flush(false)
+	call(t, "SET", "k", "v")
+	if freq := integerReply(t, "OBJECT", "FREQ", "k"); freq != LFUInitVal {
+		t.Errorf("FREQ of a new key = %d, want %d", freq, LFUInitVal)
+	}
+	for i := 0; i < 1000; i++ {
+		call(t, "GET", "k")
+	}
+	freq := integerReply(t, "OBJECT", "FREQ", "k")
+	if freq <= LFUInitVal {
+		t.Errorf("FREQ after 1000 reads = %d, want more than %d", freq, LFUInitVal)
+	}
+	fields := debugObjectFields(t, "k")
+	if fields["freq"] != strconv.FormatInt(freq, 10) {
+		t.Errorf("DEBUG OBJECT freq:%s, OBJECT FREQ %d", fields["freq"], freq)
+	}
+	if fields["encoding"] != "embstr" {
+		t.Errorf("DEBUG OBJECT encoding:%s, want embstr", fields["encoding"])
+	}
+	if got := integerReply(t, "OBJECT", "FREQ", "k"); got != freq {
+		t.Errorf("OBJECT and DEBUG OBJECT counted as accesses: FREQ went from %d to %d", freq, got)
+	}
+}
+
+func TestObjectIdletimeMatchesDebugObject(t *testing.T) {
+	Flush(false)
+	call(t, "SET", "k", "v")
+	c, _ := Freqs.Load("k")
+	c.(*lfuCounter).access.Store(time.Now().Unix() - 100)
+
+	idle := integerReply(t, "OBJECT", "IDLETIME", "k")
+	if idle < 100 || idle > 101 {
+		t.Errorf("IDLETIME = %d, want 100", idle)
+	}
+	if got := debugObjectFields(t, "k")["lru_seconds_idle"]; got != strconv.FormatInt(idle, 10) {
+		t.Errorf("DEBUG OBJECT lru_seconds_idle:%s, OBJECT IDLETIME %d", got, idle)
+	}
+	call(t, "GET", "k")
+	if idle := integerReply(t, "OBJECT", "IDLETIME", "k"); idle != 0 {
+		t.Errorf("IDLETIME after a read = %d, want 0", idle)
+	}
+}
+
+func TestSetActiveExpire(t *testing.T) {
+	Flush(false)
+	defer call(t, "DEBUG", "SET-ACTIVE-EXPIRE", "1")
+	if reply := call(t, "DEBUG", "SET-ACTIVE-EXPIRE", "0"); reply.Value != "OK" || ActiveExpire() {
+		t.Fatalf("DEBUG SET-ACTIVE-EXPIRE 0 = %#v, active expire %v", reply, ActiveExpire())
+	}
+	call(t, "SET", "k", "v", "PX", "1")
+	time.Sleep(5 * time.Millisecond)
+
+	// The key is still stored, but reads as missing to OBJECT and DEBUG
+	// OBJECT alike.
+	if !KeyExists("k") {
+		t.Fatal("expired key deleted with active expire off")
+	}
+	if reply := call(t, "OBJECT", "FREQ", "k"); reply.Type != protocol.Null {
+		t.Errorf("OBJECT FREQ of an expired key = %#v", reply)
+	}
+	if reply := call(t, "DEBUG", "OBJECT", "k"); reply.Type != protocol.Error {
+		t.Errorf("DEBUG OBJECT of an expired key = %#v", reply)
+	}
+	if reply := call(t, "DEBUG", "SET-ACTIVE-EXPIRE", "1"); reply.Value != "OK" || !ActiveExpire() {
+		t.Errorf("DEBUG SET-ACTIVE-EXPIRE 1 = %#v, active expire %v", reply, ActiveExpire())
+	}
+}
@@ -0,0 +1,302 @@
+package handler
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+const defaultScanCount = 10
+
+var scanArgs = &ArgSchema{
+	Args: []Arg{{Name: "cursor"}},
+	Options: []Option{
+		{Name: "MATCH", Args: []Arg{{Name: "pattern", Type: ArgPattern}}},
+		{Name: "COUNT", Args: []Arg{{Name: "count", Type: ArgInteger}}},
+		{Name: "TYPE", Args: []Arg{{Name: "type"}}},
+	},
+}
+
+var hscanArgs = &ArgSchema{
+	Args: []Arg{{Name: "key", Type: ArgKey}, {Name: "cursor"}},
+	Options: []Option{
+		{Name: "MATCH", Args: []Arg{{Name: "pattern", Type: ArgPattern}}},
+		{Name: "COUNT", Args: []Arg{{Name: "count", Type: ArgInteger}}},
+		{Name: "NOVALUES"},
+	},
+}
+
+type scanEntry struct {
+	hash uint64
+	key  string
+}
+
+func scanHash(key string) uint64 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return uint64(h.Sum32()) + 1
+}
+
+// scanIndex keeps names in buckets that each cover an equal slice of the
+// scanHash space, in order, so a SCAN call only looks at the buckets from
+// its cursor on instead of walking and sorting every name. The number of
+// buckets follows the number of names, about one each; the cursor is a
+// hash, not a bucket, so it stays valid across resizes. Methods must be
+// called with the owner's mu held.
+type scanIndex struct {
+	buckets [][]string // names whose hash-1 has bucket index as its top bits
+	bits    uint       // log2(len(buckets))
+	n       int
+}
+
+// scanMaxBits caps the index at 2^24 buckets.
+const scanMaxBits = 24
+
+// scanMaxBuckets bounds, as a multiple of COUNT, the buckets one call
+// visits, like Redis' maxiterations, so a sparse index still answers fast.
+const scanMaxBuckets = 10
+
+func (x *scanIndex) bucket(hash uint64) uint64 {
+	return (hash - 1) >> (32 - x.bits)
+}
+
+func (x *scanIndex) add(name string) {
+	if x.buckets == nil {
+		x.buckets = make([][]string, 1)
+	}
+	b := x.bucket(scanHash(name))
+	x.buckets[b] = append(x.buckets[b], name)
+	if x.n++; x.n > 2*len(x.buckets) && x.bits < scanMaxBits {
+		x.resize(x.bits + 1)
+	}
+}
+
+func (x *scanIndex) remove(name string) {
+	if x.buckets == nil {
+		return
+	}
+	b := x.bucket(scanHash(name))
+	names := x.buckets[b]
+	for i, n := range names {
+		if n == name {
+			names[i] = names[len(names)-1]
+			x.buckets[b] = names[:len(names)-1]
+			x.n--
+			break
+		}
+	}
+	if x.n < len(x.buckets)/8 && x.bits > 0 {
+		x.resize(x.bits - 1)
+	}
+}
+
+func (x *scanIndex) resize(bits uint) {
+	old := x.buckets
+	x.buckets, x.bits = make([][]string, 1<<bits), bits
+	for _, names := range old {
+		for _, name := range names {
+			b := x.bucket(scanHash(name))
+			x.buckets[b] = append(x.buckets[b], name)
+		}
+	}
+}
+
+// batch returns the next count or so names from cursor on, in hash order,
+// and the cursor to resume from, zero at the end. It visits whole buckets
+// until it has count names, or scanMaxBuckets*count buckets, so it may
+// return fewer.
+func (x *scanIndex) batch(cursor uint64, count int) ([]scanEntry, uint64) {
+	if x.buckets == nil || cursor > 1<<32 {
+		return nil, 0
+	}
+	b := uint64(0)
+	if cursor > 0 {
+		b = x.bucket(cursor)
+	}
+	maxVisit := len(x.buckets)
+	if count < maxVisit/scanMaxBuckets {
+		maxVisit = scanMaxBuckets * count
+	}
+	var entries []scanEntry
+	visited := 0
+	for ; b < uint64(len(x.buckets)) && len(entries) < count && visited < maxVisit; b++ {
+		for _, name := range x.buckets[b] {
+			if h := scanHash(name); h >= cursor {
+				entries = append(entries, scanEntry{hash: h, key: name})
+			}
+		}
+		visited++
+	}
+	entries, next := nextBatch(entries, count)
+	if next == 0 && b < uint64(len(x.buckets)) {
+		next = b<<(32-x.bits) + 1 // the first hash of bucket b
+	}
+	return entries, next
+}
+
+// scan orders keys by their hash and uses the hash to resume from as the
+// cursor. This keeps the cursor stateless on the server while still
+// guaranteeing that every key present for the whole iteration is returned,
+// regardless of concurrent inserts and deletes.
+func scan(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := scanArgs.Parse("scan", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	cursor, err := strconv.ParseUint(p.String("cursor"), 10, 64)
+	if err != nil {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR invalid cursor"}
+	}
+
+	pattern, count, typ := "*", defaultScanCount, ""
+	if p.Has("MATCH") {
+		pattern = p.String("MATCH")
+	}
+	if p.Has("COUNT") {
+		if p.Int("COUNT") < 1 {
+			return protocol.RESPObject{Type: protocol.Error, Value: ErrSyntax}
+		}
+		count = int(p.Int("COUNT"))
+	}
+	if p.Has("TYPE") {
+		typ = strings.ToLower(p.String("TYPE"))
+	}
+
+	entries, next := scanBatch(keyspace, cursor, count, typ)
+	keys := []protocol.RESPObject{}
+	for _, e := range entries {
+		if MatchPattern(pattern, e.key) {
+			keys = append(keys, protocol.RESPObject{Type: protocol.BulkString, Value: e.key})
+		}
+	}
+
+	return protocol.RESPObject{Type: protocol.Array, Value: []protocol.RESPObject{
+		{Type: protocol.BulkString, Value: strconv.FormatUint(next, 10)},
+		{Type: protocol.Array, Value: keys},
+	}}
+}
+
+// scanBatch returns the live keys of m, of type typ unless empty, among the
+// next count or so from cursor on, and the cursor to resume from, zero at
+// the end.
+func scanBatch(m *keyMap, cursor uint64, count int, typ string) ([]scanEntry, uint64) {
+	m.mu.Lock()
+	entries, next := m.scan.batch(cursor, count)
+	m.mu.Unlock()
+
+	now := time.Now()
+	live := entries[:0]
+	for _, e := range entries {
+		v, ok := m.Load(e.key)
+		if !ok {
+			continue
+		}
+		if o := v.(typedValue); o.expired(now) || (typ != "" && o.typeName() != typ) {
+			continue
+		}
+		live = append(live, e)
+	}
+	return live, next
+}
+
+// hscan iterates over the fields of a hash the way scan does over keys.
+func hscan(args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := hscanArgs.Parse("hscan", args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	cursor, err := strconv.ParseUint(p.String("cursor"), 10, 64)
+	if err != nil {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR invalid cursor"}
+	}
+	pattern, count := "*", defaultScanCount
+	if p.Has("MATCH") {
+		pattern = p.String("MATCH")
+	}
+	if p.Has("COUNT") {
+		if p.Int("COUNT") < 1 {
+			return protocol.RESPObject{Type: protocol.Error, Value: ErrSyntax}
+		}
+		count = int(p.Int("COUNT"))
+	}
+	hash := p.String("key")
+	if holdsNonHash(hash) {
+		return protocol.RESPObject{Type: protocol.Error, Value: ErrWrongType}
+	}
+
+	pairs, next := HashScan(hash, cursor, count)
+	values := []protocol.RESPObject{}
+	for i := 0; i < len(pairs); i += 2 {
+		if !MatchPattern(pattern, pairs[i]) {
+			continue
+		}
+		values = append(values, bulk(pairs[i]))
+		if !p.Has("NOVALUES") {
+			values = append(values, bulk(pairs[i+1]))
+		}
+	}
+
+	return protocol.RESPObject{Type: protocol.Array, Value: []protocol.RESPObject{
+		{Type: protocol.BulkString, Value: strconv.FormatUint(next, 10)},
+		{Type: protocol.Array, Value: values},
+	}}
+}
+
+// HashScan returns the next count or so fields of the hash at key from
+// cursor on, each followed by its value, and the cursor to resume from, zero
+// at the end. Fields are ordered by scanHash, like keys in SCAN.
+func HashScan(hash string, cursor uint64, count int) ([]string, uint64) {
+	o, ok := lookup(hash)
+	if !ok {
+		return nil, 0
+	}
+	h, ok := o.value.(*hashValue)
+	if !ok {
+		return nil, 0
+	}
+	h.mu.Lock()
+	entries, next := h.scan.batch(cursor, count)
+	h.mu.Unlock()
+	now := time.Now()
+	pairs := make([]string, 0, 2*len(entries))
+	for _, e := range entries {
+		// Deleted since the batch was taken, or expired.
+		if v, ok := h.loadField(e.key, now); ok {
+			pairs = append(pairs, e.key, v)
+		}
+	}
+	touchKey(hash)
+	return pairs, next
+}
+
+// nextBatch sorts entries, all at or past the cursor, and returns the first
+// count or so of them with the cursor to resume from, zero at the end.
+func nextBatch(entries []scanEntry, count int) ([]scanEntry, uint64) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].hash != entries[j].hash {
+			return entries[i].hash < entries[j].hash
+		}
+		return entries[i].key < entries[j].key
+	})
+
+	// Never split keys sharing a hash across two batches, the cursor could
+	// not tell them apart.
+	end := len(entries)
+	var next uint64
+	if end > count {
+		end = count
+		for end < len(entries) && entries[end].hash == entries[end-1].hash {
+			end++
+		}
+		if end < len(entries) {
+			next = entries[end].hash
+		}
+	}
+	return entries[:end], next
+}
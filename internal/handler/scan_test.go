@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+func TestDebugScanCheck(t *testing.T) {
+	for _, args := range [][]string{{}, {"5000", "1"}, {"20000", "100"}} {
+		reply := call(t, append([]string{"DEBUG", "SCAN-CHECK"}, args...)...)
+		if reply.Type != protocol.SimpleString || reply.Value != "OK" {
+			t.Errorf("DEBUG SCAN-CHECK %v = %v", args, reply.Value)
+		}
+	}
+}
+
+// TestHashScanCheck checks HSCAN's guarantees like DEBUG SCAN-CHECK does
+// SCAN's: while fields are added and then deleted between calls, growing and
+// shrinking the hash's scan index, each field present throughout is returned
+// exactly once and the cursor only moves forward.
+func TestHashScanCheck(t *testing.T) {
+	Flush(false)
+	const fields = 500
+	for i := 0; i < fields; i++ {
+		call(t, "HSET", "h", fmt.Sprintf("stable:%d", i), "x")
+	}
+	index := func() *scanIndex {
+		o, _ := lookup("h")
+		return &o.value.(*hashValue).scan
+	}
+	startBits, maxBits := index().bits, index().bits
+	churned, deleted := 0, 0
+
+	seen := map[string]bool{}
+	for cursor, page := uint64(0), 0; ; page++ {
+		reply := call(t, "HSCAN", "h", strconv.FormatUint(cursor, 10), "COUNT", "1").Value.([]protocol.RESPObject)
+		next, err := strconv.ParseUint(reply[0].Value.(string), 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pairs := reply[1].Value.([]protocol.RESPObject)
+		for i := 0; i < len(pairs); i += 2 {
+			field := pairs[i].Value.(string)
+			if seen[field] {
+				t.Fatalf("field %s returned twice", field)
+			}
+			seen[field] = true
+		}
+		if next == 0 {
+			break
+		}
+		if next <= cursor {
+			t.Fatalf("cursor moved back from %d to %d", cursor, next)
+		}
+		cursor = next
+
+		if page < fields/4 {
+			for i := 0; i < 40; i++ {
+				call(t, "HSET", "h", fmt.Sprintf("churn:%d", churned), "x")
+				churned++
+			}
+		} else {
+			for i := 0; i < 80 && deleted < churned; i++ {
+				call(t, "HDEL", "h", fmt.Sprintf("churn:%d", deleted))
+				deleted++
+			}
+		}
+		if bits := index().bits; bits > maxBits {
+			maxBits = bits
+		}
+	}
+	for i := 0; i < fields; i++ {
+		if field := fmt.Sprintf("stable:%d", i); !seen[field] {
+			t.Errorf("field %s never returned", field)
+		}
+	}
+	if maxBits == startBits || index().bits == maxBits {
+		t.Errorf("scan index went from %d to %d bits and back to %d, want it to grow and shrink", startBits, maxBits, index().bits)
+	}
+}
+
+// TestScanHugeCount checks that a COUNT too large to multiply by the bucket
+// budget still returns everything in one call rather than no keys at all.
+func TestScanHugeCount(t *testing.T) {
+	Flush(false)
+	for i := 0; i < 100; i++ {
+		call(t, "SET", fmt.Sprintf("k:%d", i), "x")
+		call(t, "HSET", "h", fmt.Sprintf("f:%d", i), "x")
+	}
+	for _, args := range [][]string{{"SCAN", "0"}, {"HSCAN", "h", "0"}} {
+		reply := call(t, append(args, "COUNT", "9223372036854775807")...).Value.([]protocol.RESPObject)
+		n := len(reply[1].Value.([]protocol.RESPObject))
+		if args[0] == "HSCAN" {
+			n /= 2
+		}
+		if reply[0].Value != "0" || n < 100 {
+			t.Errorf("%v COUNT MaxInt64 = cursor %v with %d entries, want cursor 0 with all of them", args, reply[0].Value, n)
+		}
+	}
+}
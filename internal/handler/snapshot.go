@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Entry is a copy of one key taken by Snapshot. Value is a string for
+// "string", a map[string]string for "hash", with the expiry times of its
+// volatile fields in FieldExpires, a []string, head first, for "list" and
+// the module value for custom types, whose Type is set.
+type Entry struct {
+	Key          string
+	Type         string
+	ExpiresAt    time.Time
+	Value        interface{}
+	FieldExpires map[string]time.Time
+	Module       *DataType
+}
+
+// snapshotHeld is the lock of the value Snapshot is copying, if any.
+var snapshotHeld atomic.Pointer[sync.Mutex]
+
+// snapshotWaitHook is called with how long a command waited for a lock
+// Snapshot held.
+var snapshotWaitHook atomic.Pointer[func(time.Duration)]
+
+// SetSnapshotWaitHook makes fn, unless nil, receive how long each command
+// that found a hash or list locked by Snapshot waited for it, the cost a
+// snapshot taken alongside the commands puts on them.
+func SetSnapshotWaitHook(fn func(time.Duration)) {
+	if fn == nil {
+		snapshotWaitHook.Store(nil)
+		return
+	}
+	snapshotWaitHook.Store(&fn)
+}
+
+// lockValue takes mu, the lock of a hash or a list, for a command, timing
+// the wait if Snapshot holds it. The uncontended path costs a TryLock.
+func lockValue(mu *sync.Mutex) {
+	if mu.TryLock() {
+		return
+	}
+	hook := snapshotWaitHook.Load()
+	if hook == nil || snapshotHeld.Load() != mu {
+		mu.Lock()
+		return
+	}
+	start := time.Now()
+	mu.Lock()
+	(*hook)(time.Since(start))
+}
+
+func snapshotLock(mu *sync.Mutex) {
+	mu.Lock()
+	snapshotHeld.Store(mu)
+}
+
+func snapshotUnlock(mu *sync.Mutex) {
+	snapshotHeld.CompareAndSwap(mu, nil)
+	mu.Unlock()
+}
+
+// Snapshot calls fn with a copy of every live key until fn returns false.
+// Each entry is consistent on its own, but keys written during the walk may
+// or may not be visited. Neither expiry nor LFU counters are touched.
+func Snapshot(fn func(Entry) bool) {
+	now := time.Now()
+	keyspace.Range(func(k, v interface{}) bool {
+		o := v.(typedValue)
+		if o.expired(now) {
+			return true
+		}
+		return fn(newEntry(k.(string), o))
+	})
+}
+
+// Peek returns a copy of the live key, like Snapshot does, without
+// expiring it or touching its LFU counter.
+func Peek(key string) (Entry, bool) {
+	o, ok := peek(key)
+	if !ok {
+		return Entry{}, false
+	}
+	return newEntry(key, o), true
+}
+
+func newEntry(key string, o typedValue) Entry {
+	e := Entry{Key: key, Type: o.typeName(), ExpiresAt: o.expiresAt}
+	switch v := o.value.(type) {
+	case string:
+		e.Value = v
+	case *hashValue:
+		now := time.Now()
+		var ttls map[string]time.Time
+		if v.ttls.Load() > 0 {
+			snapshotLock(&v.mu)
+			ttls = make(map[string]time.Time, len(v.expires))
+			for field, at := range v.expires {
+				ttls[field] = at
+			}
+			snapshotUnlock(&v.mu)
+		}
+		fields := map[string]string{}
+		var expires map[string]time.Time
+		v.Range(func(f, fv interface{}) bool {
+			field := f.(string)
+			at, volatile := ttls[field]
+			if volatile && isExpired(at, now) {
+				return true
+			}
+			fields[field] = fv.(string)
+			if volatile {
+				if expires == nil {
+					expires = map[string]time.Time{}
+				}
+				expires[field] = at
+			}
+			return true
+		})
+		e.Value, e.FieldExpires = fields, expires
+	case *listValue:
+		snapshotLock(&v.mu)
+		e.Value = v.copyElements()
+		snapshotUnlock(&v.mu)
+	case *ModuleValue:
+		e.Value, e.Module = v.Value, v.Type
+	}
+	return e
+}
@@ -0,0 +1,284 @@
+package handler
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+var (
+	sortOptions = []Option{
+		{Name: "BY", Args: []Arg{{Name: "pattern", Type: ArgPattern}}},
+		{Name: "LIMIT", Args: []Arg{{Name: "offset", Type: ArgInteger}, {Name: "count", Type: ArgInteger}}},
+		{Name: "GET", Args: []Arg{{Name: "pattern", Type: ArgPattern}}, Repeated: true},
+		{Name: "ASC", Group: "order"},
+		{Name: "DESC", Group: "order"},
+		{Name: "ALPHA"},
+	}
+	sortArgs = &ArgSchema{
+		Args:    []Arg{{Name: "key", Type: ArgKey}},
+		Options: append(sortOptions, Option{Name: "STORE", Args: []Arg{{Name: "destination", Type: ArgKey}}}),
+	}
+	sortROArgs = &ArgSchema{Args: []Arg{{Name: "key", Type: ArgKey}}, Options: sortOptions}
+)
+
+const errSortScore = "ERR One or more scores can't be converted into double"
+
+// sortSpec is a parsed SORT or SORT_RO.
+type sortSpec struct {
+	by            string // "" sorts by the elements themselves
+	noSort        bool   // BY a pattern without *, which keeps the list's order
+	offset, count int64  // a negative count takes every element from offset
+	get           []string
+	desc, alpha   bool
+}
+
+// sortElem is an element with what it is compared by: its score, or with
+// ALPHA the text, missing if the BY key is.
+type sortElem struct {
+	elem   string
+	score  float64
+	cmp    string
+	hasCmp bool
+}
+
+// sortValue is an element or a value looked up by GET, missing if the key
+// or field is.
+type sortValue struct {
+	value string
+	ok    bool
+}
+
+// sortStoreKeys returns the position of SORT's STORE destination, the only
+// key not at a fixed position.
+func sortStoreKeys(args []protocol.RESPObject) []int {
+	for i := 1; i < len(args); i++ {
+		word, _ := args[i].Value.(string)
+		switch strings.ToUpper(word) {
+		case "BY", "GET":
+			i++
+		case "LIMIT":
+			i += 2
+		case "STORE":
+			if i+1 < len(args) {
+				return []int{i + 1}
+			}
+		}
+	}
+	return nil
+}
+
+// lookupByPattern returns the value of the key that pattern names for elem,
+// the first * replaced by elem, or of the hash field following "->" in it.
+// "#" stands for elem itself, and a pattern without * names no key.
+func lookupByPattern(pattern, elem string, now time.Time) (string, bool) {
+	if pattern == "#" {
+		return elem, true
+	}
+	star := strings.IndexByte(pattern, '*')
+	if star < 0 {
+		return "", false
+	}
+	key, field := pattern, ""
+	if arrow := strings.Index(pattern[star+1:], "->"); arrow >= 0 && star+1+arrow+2 < len(pattern) {
+		key, field = pattern[:star+1+arrow], pattern[star+1+arrow+2:]
+	}
+	key = key[:star] + elem + key[star+1:]
+	o, ok := lookup(key)
+	if !ok {
+		return "", false
+	}
+	if field == "" {
+		v, ok := o.value.(string)
+		return v, ok
+	}
+	h, ok := o.value.(*hashValue)
+	if !ok {
+		return "", false
+	}
+	return h.loadField(field, now)
+}
+
+// sortList sorts the list at key as spec says and returns what SORT replies
+// with: the elements, or the values GET looks up for each of them. With
+// store the result is stored at dst as a list instead, replacing whatever
+// dst held, or deleting it if the result is empty; stored reports whether
+// dst was written or deleted. The elements are a snapshot taken under the
+// list's lock; only with store is writeMu held exclusively, so that the
+// result replaces dst atomically, while SORT_RO shares it with other readers
+// and writers. An error reply is returned for a key holding another type or
+// a score that isn't a number.
+func sortList(key string, spec *sortSpec, dst string, store bool) (values []sortValue, stored bool, errReply *protocol.RESPObject) {
+	if store {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+	} else {
+		writeMu.RLock()
+		defer writeMu.RUnlock()
+	}
+	var elems []string
+	if o, ok := lookup(key); ok {
+		l, ok := o.value.(*listValue)
+		if !ok {
+			return nil, false, errorReply(ErrWrongType)
+		}
+		elems = l.elements()
+		touchKey(key)
+	}
+
+	now := time.Now()
+	start, end := sortRange(len(elems), spec.offset, spec.count)
+	if spec.noSort {
+		elems = elems[start:end]
+	} else {
+		sorted := make([]sortElem, len(elems))
+		for i, elem := range elems {
+			e := sortElem{elem: elem}
+			by, found := elem, true
+			if spec.by != "" {
+				by, found = lookupByPattern(spec.by, elem, now)
+			}
+			switch {
+			case spec.alpha:
+				e.cmp, e.hasCmp = by, found
+			case found:
+				score, ok := parseFloat(by)
+				if !ok {
+					return nil, false, errorReply(errSortScore)
+				}
+				e.score = score
+			}
+			sorted[i] = e
+		}
+		sort.SliceStable(sorted, func(i, j int) bool {
+			c := compareSortElems(&sorted[i], &sorted[j], spec)
+			if spec.desc {
+				return c > 0
+			}
+			return c < 0
+		})
+		elems = make([]string, 0, end-start)
+		for _, e := range sorted[start:end] {
+			elems = append(elems, e.elem)
+		}
+	}
+
+	for _, elem := range elems {
+		if len(spec.get) == 0 {
+			values = append(values, sortValue{elem, true})
+		}
+		for _, pattern := range spec.get {
+			v, ok := lookupByPattern(pattern, elem, now)
+			values = append(values, sortValue{v, ok})
+		}
+	}
+	if !store {
+		return values, false, nil
+	}
+	if len(values) == 0 {
+		return nil, Delete(dst), nil
+	}
+	list := make([]string, len(values))
+	for i, v := range values {
+		list[i] = v.value
+	}
+	keyspace.Store(dst, typedValue{value: newList(list)})
+	Freqs.Delete(dst)
+	MarkDirty()
+	touchKey(dst)
+	return values, true, nil
+}
+
+// sortRange returns the bounds of the elements LIMIT offset count picks out
+// of n, clamped like Redis does.
+func sortRange(n int, offset, count int64) (start, end int) {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= int64(n) {
+		return n, n
+	}
+	last := int64(n)
+	if count >= 0 && count < last-offset {
+		last = offset + count
+	}
+	return int(offset), int(last)
+}
+
+// compareSortElems orders by score, ties broken by the elements' bytes so
+// that numeric sorts are deterministic, or with ALPHA by text, a missing BY
+// key first.
+func compareSortElems(a, b *sortElem, spec *sortSpec) int {
+	if !spec.alpha {
+		switch {
+		case a.score < b.score:
+			return -1
+		case a.score > b.score:
+			return 1
+		}
+		return strings.Compare(a.elem, b.elem)
+	}
+	if spec.by == "" {
+		return strings.Compare(a.elem, b.elem)
+	}
+	switch {
+	case !a.hasCmp && !b.hasCmp:
+		return 0
+	case !a.hasCmp:
+		return -1
+	case !b.hasCmp:
+		return 1
+	}
+	return strings.Compare(a.cmp, b.cmp)
+}
+
+func sortCommand(args []protocol.RESPObject) protocol.RESPObject {
+	return sortReply("sort", sortArgs, args)
+}
+
+func sortRO(args []protocol.RESPObject) protocol.RESPObject {
+	return sortReply("sort_ro", sortROArgs, args)
+}
+
+// sortReply sorts the elements of a list. Sets and sorted sets, which SORT
+// also takes in Redis, don't exist yet and reply WRONGTYPE like strings and
+// hashes.
+func sortReply(name string, schema *ArgSchema, args []protocol.RESPObject) protocol.RESPObject {
+	p, errReply := schema.Parse(name, args)
+	if errReply != nil {
+		return *errReply
+	}
+
+	spec := &sortSpec{count: -1, get: p.Strings("GET"), desc: p.Has("DESC"), alpha: p.Has("ALPHA")}
+	if p.Has("BY") {
+		spec.by = p.String("BY")
+		spec.noSort = !strings.Contains(spec.by, "*")
+	}
+	if p.Has("LIMIT") {
+		spec.offset, spec.count = p.Int("LIMIT.offset"), p.Int("LIMIT.count")
+	}
+	dst := p.String("STORE")
+	values, stored, errReply := sortList(p.String("key"), spec, dst, p.Has("STORE"))
+	if errReply != nil {
+		return *errReply
+	}
+	if p.Has("STORE") {
+		switch {
+		case len(values) > 0:
+			notify("sortstore", dst)
+		case stored:
+			notify("del", dst)
+		}
+		return integer(len(values))
+	}
+	replies := make([]protocol.RESPObject, len(values))
+	for i, v := range values {
+		replies[i] = protocol.RESPObject{Type: protocol.Null}
+		if v.ok {
+			replies[i] = bulk(v.value)
+		}
+	}
+	return array(replies)
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+// values returns the bulk strings of an array reply, "<nil>" for nulls.
+func values(t *testing.T, reply protocol.RESPObject) []string {
+	t.Helper()
+	arr, ok := reply.Value.([]protocol.RESPObject)
+	if reply.Type != protocol.Array || !ok {
+		t.Fatalf("got %#v, want an array", reply)
+	}
+	out := make([]string, len(arr))
+	for i, v := range arr {
+		out[i] = "<nil>"
+		if s, ok := v.Value.(string); ok {
+			out[i] = s
+		}
+	}
+	return out
+}
+
+func TestSort(t *testing.T) {
+	Flush(false)
+	call(t, "RPUSH", "l", "3", "10", "1", "2")
+	call(t, "SET", "w_1", "40")
+	call(t, "SET", "w_2", "30")
+	call(t, "SET", "w_3", "20")
+	call(t, "HSET", "o_1", "name", "one")
+	call(t, "HSET", "o_10", "name", "ten")
+	call(t, "RPUSH", "words", "b", "c", "a")
+
+	for _, tc := range []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"l"}, []string{"1", "2", "3", "10"}},
+		{[]string{"l", "DESC"}, []string{"10", "3", "2", "1"}},
+		{[]string{"l", "ALPHA"}, []string{"1", "10", "2", "3"}},
+		{[]string{"l", "LIMIT", "1", "2"}, []string{"2", "3"}},
+		{[]string{"l", "LIMIT", "-5", "-1"}, []string{"1", "2", "3", "10"}},
+		{[]string{"l", "LIMIT", "4", "1"}, []string{}},
+		{[]string{"l", "LIMIT", "1", "9223372036854775807"}, []string{"2", "3", "10"}},
+		{[]string{"l", "LIMIT", "-9223372036854775808", "2"}, []string{"1", "2"}},
+		{[]string{"l", "BY", "nosort", "LIMIT", "1", "9223372036854775807"}, []string{"10", "1", "2"}},
+		// A missing weight counts as 0, ties go by the element.
+		{[]string{"l", "BY", "w_*"}, []string{"10", "3", "2", "1"}},
+		{[]string{"l", "BY", "nosort"}, []string{"3", "10", "1", "2"}},
+		{[]string{"l", "BY", "nosort", "LIMIT", "1", "2"}, []string{"10", "1"}},
+		{[]string{"l", "GET", "#", "GET", "o_*->name"}, []string{"1", "one", "2", "<nil>", "3", "<nil>", "10", "ten"}},
+		{[]string{"l", "BY", "o_*->name", "ALPHA", "GET", "o_*->name"}, []string{"<nil>", "<nil>", "one", "ten"}},
+		{[]string{"words", "ALPHA"}, []string{"a", "b", "c"}},
+		{[]string{"missing"}, []string{}},
+	} {
+		for _, name := range []string{"SORT", "SORT_RO"} {
+			got := values(t, call(t, append([]string{name}, tc.args...)...))
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("%s %v = %q, want %q", name, tc.args, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestSortErrors(t *testing.T) {
+	Flush(false)
+	call(t, "RPUSH", "words", "b", "a")
+	call(t, "SET", "s", "x")
+	for _, args := range [][]string{
+		{"SORT", "words"},
+		{"SORT", "s"},
+		{"SORT", "words", "LIMIT", "1"},
+		{"SORT", "words", "LIMIT", "x", "1"},
+		{"SORT_RO", "words", "ALPHA", "STORE", "dst"},
+	} {
+		if reply := call(t, args...); reply.Type != protocol.Error {
+			t.Errorf("%v = %#v, want an error", args, reply)
+		}
+	}
+}
+
+func TestSortStore(t *testing.T) {
+	Flush(false)
+	call(t, "RPUSH", "l", "2", "1")
+	call(t, "SET", "dst", "old", "EX", "100")
+	if n := integerReply(t, "SORT", "l", "GET", "#", "GET", "nokey_*", "STORE", "dst"); n != 4 {
+		t.Errorf("SORT STORE = %d, want 4", n)
+	}
+	if got, want := ListLen("dst"), 4; got != want {
+		t.Errorf("stored %d elements, want %d", got, want)
+	}
+	if ttl := integerReply(t, "TTL", "dst"); ttl != -1 {
+		t.Errorf("TTL of the stored list = %d, want -1", ttl)
+	}
+	if n := integerReply(t, "SORT", "missing", "STORE", "dst"); n != 0 || Exists("dst") {
+		t.Errorf("SORT of a missing key STORE = %d, destination exists %v", n, Exists("dst"))
+	}
+
+	cmd, _ := Lookup("SORT")
+	args := []protocol.RESPObject{bulk("l"), bulk("GET"), bulk("STORE"), bulk("LIMIT"), bulk("0"), bulk("1"), bulk("STORE"), bulk("dst")}
+	if got := cmd.KeyIndexes(args); !reflect.DeepEqual(got, []int{0, 7}) {
+		t.Errorf("SORT key positions = %v, want [0 7]", got)
+	}
+}
@@ -0,0 +1,529 @@
+package handler
+
+import (
+	rtdebug "runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Go-level accessors over the keyspace, shared by the command handlers and
+// by embedders going through the server package.
+
+var (
+	dirty        atomic.Uint64
+	noLazyExpire atomic.Bool
+	// writeMu is held shared by the accessors that create keys and
+	// exclusively by multi-key writes, so those are atomic to other writers.
+	// Readers don't take it.
+	writeMu sync.RWMutex
+)
+
+// SetLazyExpire controls whether reads delete the expired keys they come
+// across, which is the default. With it off, expired keys read as missing
+// but stay in memory until ExpireKeys removes them, so that whoever calls
+// ExpireKeys can propagate the deletions in order; a replica would do the
+// same and wait for its master's DEL.
+func SetLazyExpire(on bool) {
+	noLazyExpire.Store(!on)
+}
+
+// ExpireKeys visits up to sample keys, deletes the expired ones and calls
+// fn with each deleted key. It returns how many keys were visited and how
+// many of them expired; Range starts at a random key, so repeated calls
+// cover the whole keyspace.
+func ExpireKeys(sample int, fn func(key string)) (visited, expired int) {
+	now := time.Now()
+	keyspace.Range(func(k, v interface{}) bool {
+		visited++
+		if v.(typedValue).expired(now) && deleteExpired(k.(string), v) {
+			fn(k.(string))
+			expired++
+		}
+		return visited < sample
+	})
+	return visited, expired
+}
+
+// Dirty returns a counter bumped by every keyspace modification, so callers
+// can tell whether a command changed the dataset, and by how many keys a
+// flush deleted, like Redis' dirty counter. Writes that change nothing, such
+// as deleting a missing key, leave it alone.
+func Dirty() uint64 {
+	return dirty.Load()
+}
+
+// MarkDirty records a modification made outside the accessors below, such
+// as a custom type mutating its value in place.
+func MarkDirty() {
+	dirty.Add(1)
+}
+
+func GetString(key string) (string, bool) {
+	o, ok := lookup(key)
+	if !ok {
+		return "", false
+	}
+	value, ok := o.value.(string)
+	if !ok {
+		return "", false
+	}
+	touchKey(key)
+	return value, true
+}
+
+// PeekString returns the live string stored at key without expiring it or
+// touching its LFU counter.
+func PeekString(key string) (Value, bool) {
+	o, ok := peek(key)
+	if !ok {
+		return Value{}, false
+	}
+	value, ok := o.value.(string)
+	if !ok {
+		return Value{}, false
+	}
+	return Value{Data: value, ExpiresAt: o.expiresAt}, true
+}
+
+// SetString stores a string at key, replacing any value of any type.
+func SetString(key, value string, expiresAt time.Time) {
+	writeMu.RLock()
+	defer writeMu.RUnlock()
+	keyspace.Store(key, typedValue{value: value, expiresAt: expiresAt})
+	MarkDirty()
+	touchKey(key)
+}
+
+// SetStrings stores every key/value pair of pairs at once without a TTL.
+// With nx it stores nothing, and returns false, if any of the keys exists.
+func SetStrings(pairs []string, nx bool) bool {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	if nx {
+		for i := 0; i < len(pairs); i += 2 {
+			if Exists(pairs[i]) {
+				return false
+			}
+		}
+	}
+	for i := 0; i < len(pairs); i += 2 {
+		keyspace.Store(pairs[i], typedValue{value: pairs[i+1]})
+		touchKey(pairs[i])
+	}
+	dirty.Add(uint64(len(pairs) / 2))
+	return true
+}
+
+// updateString is update for strings: the key keeps its TTL unless fn
+// changes it. A key holding another type is left alone and false returned
+// without calling fn, which callers whose fn always succeeds answer with
+// WRONGTYPE.
+func updateString(key string, fn func(cur Value, exists bool) (Value, bool)) bool {
+	return update(key, func(cur typedValue, exists bool) (typedValue, bool) {
+		data, isString := cur.value.(string)
+		if exists && !isString {
+			return cur, false
+		}
+		next, ok := fn(Value{Data: data, ExpiresAt: cur.expiresAt}, exists)
+		return typedValue{value: next.Data, expiresAt: next.ExpiresAt}, ok
+	})
+}
+
+func HashGet(hash, field string) (string, bool) {
+	o, ok := lookup(hash)
+	if !ok {
+		return "", false
+	}
+	h, ok := o.value.(*hashValue)
+	if !ok {
+		return "", false
+	}
+	value, ok := h.loadField(field, time.Now())
+	if !ok {
+		return "", false
+	}
+	touchKey(hash)
+	return value, true
+}
+
+// HashRange calls begin with the number of live fields of the hash at key,
+// 0 if there is none, then fn with each of them and its value until fn
+// returns false. The fields are snapshotted with writeMu held exclusively,
+// so a reply produced this way never mixes fields from before and after a
+// concurrent write, and fn runs once it is released: writing a large reply
+// to a slow client holds up nobody else.
+func HashRange(hash string, begin func(n int), fn func(field, value string) bool) {
+	pairs := hashSnapshot(hash)
+	begin(len(pairs) / 2)
+	for i := 0; i < len(pairs); i += 2 {
+		if !fn(pairs[i], pairs[i+1]) {
+			return
+		}
+	}
+}
+
+// hashSnapshot returns the live fields of the hash at key, each followed by
+// its value. Only the strings' headers are copied, not their bytes.
+func hashSnapshot(hash string) []string {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	o, ok := lookup(hash)
+	h, isHash := o.value.(*hashValue)
+	if !ok || !isHash {
+		return nil
+	}
+	now := time.Now()
+	pairs := make([]string, 0, 2*h.fields.Load())
+	h.Range(func(f, v interface{}) bool {
+		if !h.fieldExpired(f.(string), now) {
+			pairs = append(pairs, f.(string), v.(string))
+		}
+		return true
+	})
+	touchKey(hash)
+	return pairs
+}
+
+// HashRandomFields returns count fields of the hash at key chosen at random,
+// each followed by its value, distinct unless repeat is set. Expired fields
+// picked are left out. writeMu is held so no field is deleted between being
+// picked and read.
+func HashRandomFields(hash string, count int, repeat bool) ([]string, bool) {
+	writeMu.RLock()
+	defer writeMu.RUnlock()
+	o, ok := lookup(hash)
+	if !ok {
+		return nil, false
+	}
+	h, ok := o.value.(*hashValue)
+	if !ok {
+		return nil, false
+	}
+	now := time.Now()
+	fields := h.randomFields(count, repeat)
+	pairs := make([]string, 0, 2*len(fields))
+	for _, field := range fields {
+		if value, ok := h.loadField(field, now); ok {
+			pairs = append(pairs, field, value)
+		}
+	}
+	touchKey(hash)
+	return pairs, true
+}
+
+// HashLen returns the number of fields of the hash at key, zero if it is
+// missing or holds another type.
+func HashLen(hash string) int64 {
+	o, ok := lookup(hash)
+	if !ok {
+		return 0
+	}
+	h, ok := o.value.(*hashValue)
+	if !ok {
+		return 0
+	}
+	touchKey(hash)
+	return h.fields.Load()
+}
+
+// HashSet sets a field of the hash at key, creating the hash if missing. It
+// does nothing if key holds another type.
+func HashSet(hash, field, value string) {
+	HashSetFields(hash, []string{field, value})
+}
+
+// HashSetFields sets the fields and values of pairs, alternating, in the
+// hash at key, creating the hash if missing. HGETALL sees either none or all
+// of them. It returns how many of the fields are new, or false if key holds
+// another type.
+func HashSetFields(hash string, pairs []string) (int, bool) {
+	writeMu.RLock()
+	defer writeMu.RUnlock()
+	h, ok := hashForWrite(hash)
+	if !ok {
+		return 0, false
+	}
+	added := 0
+	for i := 0; i < len(pairs); i += 2 {
+		value := pairs[i+1]
+		isNew, _ := updateField(h, pairs[i], false, func(string, bool) (string, bool) {
+			return value, true
+		})
+		if isNew {
+			added++
+		}
+	}
+	MarkDirty()
+	touchKey(hash)
+	return added, true
+}
+
+// hashForWrite returns the hash at key, creating an empty one if key is
+// missing or expired, or false if key holds another type. writeMu must be
+// held.
+func hashForWrite(key string) (*hashValue, bool) {
+	expiredKey(key)
+	for {
+		old, loaded := keyspace.Load(key)
+		if loaded {
+			if o := old.(typedValue); !o.expired(time.Now()) {
+				h, ok := o.value.(*hashValue)
+				return h, ok
+			}
+		}
+		h := &hashValue{}
+		if replace(key, old, loaded, typedValue{value: h}) {
+			return h, true
+		}
+	}
+}
+
+// updateHashField is updateString for a field of the hash at key, which is
+// created if missing.
+func updateHashField(hash, field string, fn func(cur string, exists bool) (string, bool)) bool {
+	writeMu.RLock()
+	defer writeMu.RUnlock()
+	h, ok := hashForWrite(hash)
+	if !ok {
+		return false
+	}
+	if _, ok := updateField(h, field, true, fn); !ok {
+		return false
+	}
+	MarkDirty()
+	touchKey(hash)
+	return true
+}
+
+// updateField replaces a field of h with the value fn returns, calling fn
+// again if another writer changed the field meanwhile. An expired field is
+// replaced as if missing. It reports whether the field is new and whether fn
+// accepted the change. The field's TTL is removed unless keepTTL is set.
+func updateField(h *hashValue, field string, keepTTL bool, fn func(cur string, exists bool) (string, bool)) (isNew, ok bool) {
+	var next string
+	for {
+		old, loaded := h.Load(field)
+		expired := loaded && h.fieldExpired(field, time.Now())
+		var cur string
+		if loaded && !expired {
+			cur = old.(string)
+		}
+		if next, ok = fn(cur, loaded && !expired); !ok {
+			return false, false
+		}
+		if loaded {
+			if h.CompareAndSwap(field, old, next) {
+				isNew = expired
+				if expired || !keepTTL {
+					h.setFieldExpiry(field, time.Time{})
+				}
+				break
+			}
+		} else if _, dup := h.LoadOrStore(field, next); !dup {
+			h.addField(field)
+			isNew = true
+			break
+		}
+	}
+	updateHashEncoding(h, field, next)
+	return isNew, true
+}
+
+// HashDelete deletes fields of the hash at key, and key itself once the hash
+// is empty, as Redis never keeps an empty hash. It returns how many fields
+// were deleted and whether key was. writeMu is held exclusively so that no
+// field is added between the last deletion and the key's removal.
+func HashDelete(hash string, fields []string) (deleted int, emptied bool) {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	o, ok := lookup(hash)
+	if !ok {
+		return 0, false
+	}
+	h, ok := o.value.(*hashValue)
+	if !ok {
+		return 0, false
+	}
+	now := time.Now()
+	for _, field := range fields {
+		expired := h.fieldExpired(field, now)
+		if _, ok := h.LoadAndDelete(field); ok {
+			h.removeField(field)
+			if expired {
+				MarkDirty()
+			} else {
+				deleted++
+			}
+		}
+	}
+	if deleted == 0 {
+		if h.fields.Load() == 0 {
+			Delete(hash)
+		}
+		return 0, false
+	}
+	MarkDirty()
+	if emptied = h.fields.Load() == 0; emptied {
+		Delete(hash)
+	} else {
+		touchKey(hash)
+	}
+	return deleted, emptied
+}
+
+// HashGetDelete returns the values of fields of the hash at key, found
+// telling which exist, and deletes them, and key itself once the hash is
+// empty, which it also reports.
+func HashGetDelete(hash string, fields []string) (values []string, found []bool, emptied bool) {
+	values, found, _, emptied = hashGetFields(hash, fields, func(h *hashValue, field string) bool {
+		h.Delete(field)
+		h.removeField(field)
+		return true
+	})
+	return values, found, emptied
+}
+
+// hashGetFields returns the values of the live fields of the hash at key
+// among fields, found telling which those are, calling update with each of
+// them after reading it. update reports whether it changed the field, and
+// hashGetFields whether any was. key is deleted if that left the hash empty,
+// which is reported too. writeMu is held exclusively so the reads and
+// updates are one atomic step.
+func hashGetFields(hash string, fields []string, update func(h *hashValue, field string) bool) (values []string, found []bool, changed, emptied bool) {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	values, found = make([]string, len(fields)), make([]bool, len(fields))
+	o, ok := lookup(hash)
+	if !ok {
+		return values, found, false, false
+	}
+	h, ok := o.value.(*hashValue)
+	if !ok {
+		return values, found, false, false
+	}
+	now := time.Now()
+	for i, field := range fields {
+		if values[i], found[i] = h.loadField(field, now); found[i] && update(h, field) {
+			changed = true
+		}
+	}
+	if !changed {
+		touchKey(hash)
+		return values, found, false, false
+	}
+	MarkDirty()
+	if emptied = h.fields.Load() == 0; emptied {
+		Delete(hash)
+	} else {
+		touchKey(hash)
+	}
+	return values, found, true, emptied
+}
+
+// Delete deletes key and reports whether it held a live value. An expired
+// key is expired instead, as a read would, and doesn't count.
+func Delete(key string) bool {
+	if expiredKey(key) {
+		return false
+	}
+	_, ok := keyspace.LoadAndDelete(key)
+	Freqs.Delete(key)
+	if ok {
+		MarkDirty()
+	}
+	return ok
+}
+
+// Rename moves the live value at src to dst with its TTL and LFU counter,
+// replacing whatever dst holds unless nx is set. It reports whether src
+// exists and whether the value was moved.
+func Rename(src, dst string, nx bool) (found, renamed bool) {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	o, ok := lookup(src)
+	if !ok {
+		return false, false
+	}
+	if src == dst {
+		return true, !nx
+	}
+	if nx && Exists(dst) {
+		return true, false
+	}
+	keyspace.Store(dst, o)
+	keyspace.Delete(src)
+	trackFieldTTLs(dst, o.value)
+	if c, ok := Freqs.LoadAndDelete(src); ok {
+		Freqs.Store(dst, c)
+	} else {
+		Freqs.Delete(dst)
+	}
+	MarkDirty()
+	return true, true
+}
+
+// Copy stores a deep copy of the live value at src, with its TTL, at dst
+// unless dst exists and replace is false. It reports whether it copied.
+func Copy(src, dst string, replace bool) (bool, error) {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	o, ok := lookup(src)
+	if !ok || (!replace && Exists(dst)) {
+		return false, nil
+	}
+	v, err := copyValue(o.value)
+	if err != nil {
+		return false, err
+	}
+	keyspace.Store(dst, typedValue{value: v, expiresAt: o.expiresAt})
+	trackFieldTTLs(dst, v)
+	Freqs.Delete(dst)
+	MarkDirty()
+	touchKey(dst)
+	return true, nil
+}
+
+// Flush deletes every key. The old keyspace is dropped in one go and left to
+// the garbage collector, which Flush then runs to return the memory to the
+// OS, before returning or, if async is set, in the background.
+func Flush(async bool) {
+	writeMu.Lock()
+	keys := keyspace.size.Load()
+	keyspace.flush()
+	Freqs.Range(func(k, _ interface{}) bool {
+		Freqs.Delete(k)
+		return true
+	})
+	// Plus one, so a flush of an empty keyspace is still propagated.
+	dirty.Add(uint64(keys) + 1)
+	notify("flush", "")
+	writeMu.Unlock()
+	if async {
+		go rtdebug.FreeOSMemory()
+	} else {
+		rtdebug.FreeOSMemory()
+	}
+}
+
+// KeyExists reports whether key is in the keyspace, even if expired.
+func KeyExists(key string) bool {
+	_, ok := keyspace.Load(key)
+	return ok
+}
+
+// Exists reports whether key holds a live value of any type, without
+// touching its LFU counter.
+func Exists(key string) bool {
+	_, ok := peek(key)
+	return ok
+}
+
+// KeyspaceSize returns the number of keys and how many of them carry a TTL.
+// Expired keys not yet reclaimed are still counted, as in Redis.
+func KeyspaceSize() (keys, ttls int) {
+	keyspace.mu.Lock()
+	ttls = len(keyspace.volatile)
+	keyspace.mu.Unlock()
+	return int(keyspace.size.Load()), ttls
+}
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+func TestSetrangeTooLarge(t *testing.T) {
+	Flush(false)
+	call(t, "SET", "k", "v")
+	for _, offset := range []int64{maxStringSize, 1<<63 - 1} {
+		reply := call(t, "SETRANGE", "k", strconv.FormatInt(offset, 10), "x")
+		if reply.Type != protocol.Error || reply.Value != "ERR string exceeds maximum allowed size (proto-max-bulk-len)" {
+			t.Errorf("SETRANGE k %d x = %#v", offset, reply)
+		}
+	}
+	if got, _ := GetString("k"); got != "v" {
+		t.Errorf("k = %q after the rejected SETRANGEs, want v", got)
+	}
+}
+
+func TestMsetDirty(t *testing.T) {
+	Flush(false)
+	before := Dirty()
+	call(t, "MSET", "a", "1", "b", "2", "c", "3")
+	if got := Dirty() - before; got != 3 {
+		t.Errorf("MSET of 3 keys made %d changes, want 3", got)
+	}
+	before = Dirty()
+	call(t, "MSETNX", "a", "1", "d", "4")
+	if got := Dirty() - before; got != 0 {
+		t.Errorf("a failed MSETNX made %d changes, want 0", got)
+	}
+}
+
+func TestStringUpdateWrongType(t *testing.T) {
+	Flush(false)
+	call(t, "HSET", "h", "f", "v")
+	for _, args := range [][]string{{"APPEND", "h", "x"}, {"SETRANGE", "h", "0", "x"}, {"SETRANGE", "h", "0", ""}, {"INCRBY", "h", "1"}, {"INCRBYFLOAT", "h", "1.5"}, {"GETSET", "h", "x"}} {
+		if reply := call(t, args...); reply.Type != protocol.Error || reply.Value != ErrWrongType {
+			t.Errorf("%v = %#v, want WRONGTYPE", args, reply)
+		}
+	}
+	if got := TypeOf("h"); got != "hash" {
+		t.Errorf("h is a %s after the rejected updates, want a hash", got)
+	}
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// DataType is a value type contributed by a module. Encoding backs OBJECT
+// ENCODING; Rewrite and Marshal/Unmarshal are the persistence hooks used
+// when the keyspace is serialized rather than replayed command by command.
+// Copy backs COPY.
+type DataType struct {
+	Name      string
+	Encoding  func(v interface{}) string
+	Rewrite   func(key string, v interface{}) [][]string
+	Marshal   func(v interface{}) ([]byte, error)
+	Unmarshal func(data []byte) (interface{}, error)
+	Copy      func(v interface{}) interface{}
+}
+
+type ModuleValue struct {
+	Type  *DataType
+	Value interface{}
+}
+
+var (
+	typesMu sync.RWMutex
+	types   = map[string]*DataType{}
+)
+
+var builtinTypes = map[string]bool{"string": true, "hash": true, "list": true, "set": true, "zset": true, "stream": true, "none": true}
+
+func RegisterType(t *DataType) error {
+	name := strings.ToLower(t.Name)
+	if name == "" || builtinTypes[name] {
+		return fmt.Errorf("invalid type name %q", t.Name)
+	}
+	if t.Rewrite == nil {
+		return fmt.Errorf("type %s: missing Rewrite hook", t.Name)
+	}
+
+	typesMu.Lock()
+	defer typesMu.Unlock()
+	if _, exists := types[name]; exists {
+		return fmt.Errorf("type %s already registered", t.Name)
+	}
+	t.Name = name
+	types[name] = t
+	return nil
+}
+
+func LookupType(name string) (*DataType, bool) {
+	typesMu.RLock()
+	defer typesMu.RUnlock()
+	t, ok := types[strings.ToLower(name)]
+	return t, ok
+}
+
+func GetModuleValue(key string) (*ModuleValue, bool) {
+	o, ok := lookup(key)
+	if !ok {
+		return nil, false
+	}
+	mv, ok := o.value.(*ModuleValue)
+	if !ok {
+		return nil, false
+	}
+	touchKey(key)
+	return mv, true
+}
+
+// SetModuleValue stores a custom type's value at key, replacing any value
+// of any type.
+func SetModuleValue(key string, t *DataType, value interface{}) {
+	writeMu.RLock()
+	defer writeMu.RUnlock()
+	keyspace.Store(key, typedValue{value: &ModuleValue{Type: t, Value: value}})
+	MarkDirty()
+	touchKey(key)
+}
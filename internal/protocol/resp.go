@@ -2,10 +2,14 @@ package protocol
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 type RESPType int
@@ -17,6 +21,27 @@ const (
 	BulkString
 	Array
 	Null
+	// Push is a RESP3 out-of-band message, such as a pub/sub message
+	// delivered between replies. It is encoded like an Array.
+	Push
+	// Attribute is a RESP3 map of metadata about the object that follows it,
+	// such as a client's request ID. Value holds the keys and values
+	// alternating, like HGETALL's reply.
+	Attribute
+	// Double is a RESP3 floating point number, such as a score, with a
+	// float64 Value. A Writer not switched to RESP3 sends it as a bulk
+	// string of the same text, as Redis does for RESP2 clients.
+	Double
+	// Map is a RESP3 map, such as HELLO's reply, with its keys and values
+	// alternating in Value like Attribute. A Writer not switched to RESP3
+	// sends it as a flat array, as Redis does for RESP2 clients.
+	Map
+	// Stream is an array whose elements are produced one at a time by a
+	// StreamFunc Value, so a large reply is never held as []RESPObject. See
+	// Render and Collect.
+	Stream
+	// Raw is a reply already encoded by Render, a []byte written as it is.
+	Raw
 )
 
 const (
@@ -25,9 +50,23 @@ const (
 	IntegerPrefix      = ':'
 	BulkStringPrefix   = '$'
 	ArrayPrefix        = '*'
+	PushPrefix         = '>'
+	AttributePrefix    = '|'
+	DoublePrefix       = ','
+	MapPrefix          = '%'
 	CRLF               = "\r\n"
 )
 
+// MaxBulkLen is the longest bulk string the Reader accepts, Redis' default
+// proto-max-bulk-len. Longer ones, and lengths below -1, are rejected
+// before anything is allocated for them.
+const MaxBulkLen = 512 << 20
+
+// maxPrealloc bounds the elements or bytes allocated for an array or a bulk
+// string upfront, so a client announcing a huge one must actually send it
+// before the memory is used.
+const maxPrealloc = 1 << 16
+
 type RESPObject struct {
 	Type  RESPType
 	Value interface{}
@@ -41,42 +80,115 @@ func NewReader(rd io.Reader) *Reader {
 	return &Reader{reader: bufio.NewReader(rd)}
 }
 
+// Buffered returns how many bytes were read from the underlying reader but
+// not consumed yet.
+func (r *Reader) Buffered() int {
+	return r.reader.Buffered()
+}
+
 type Writer struct {
 	writer *bufio.Writer
+	resp3  atomic.Bool
 }
 
 func NewWriter(w io.Writer) *Writer {
-	return &Writer{bufio.NewWriter(w)}
+	return &Writer{writer: bufio.NewWriter(w)}
+}
+
+// SetRESP3 switches w to RESP3 frames for types RESP2 lacks, or back.
+func (w *Writer) SetRESP3(on bool) {
+	w.resp3.Store(on)
 }
 
+// Serialize encodes obj with RESP3 frames for every type.
 func (obj RESPObject) Serialize() string {
 	var sb strings.Builder
+	obj.encode(&sb, true)
+	return sb.String()
+}
+
+// FormatDouble formats f as Redis replies with a double: the shortest text
+// that reads back as f, and "inf", "-inf" and "nan" for the non-finite
+// values, which every RESP3 client and strtod parse.
+func FormatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// lineSafe blanks out line breaks in simple strings and errors, which can't
+// hold them, as Redis does when an error quotes a client's argument.
+var lineSafe = strings.NewReplacer("\r", " ", "\n", " ")
+
+type stringWriter interface {
+	io.Writer
+	io.StringWriter
+}
+
+// encode writes obj to w element by element, so arrays are never
+// serialized into one string in full before being written. Without resp3,
+// doubles fall back to bulk strings.
+func (obj RESPObject) encode(w stringWriter, resp3 bool) {
 	switch obj.Type {
 	case SimpleString:
-		fmt.Fprintf(&sb, "%c%v%s", SimpleStringPrefix, obj.Value, CRLF)
+		fmt.Fprintf(w, "%c%s%s", SimpleStringPrefix, lineSafe.Replace(fmt.Sprint(obj.Value)), CRLF)
 	case Error:
-		fmt.Fprintf(&sb, "%c%v%s", ErrorPrefix, obj.Value, CRLF)
+		fmt.Fprintf(w, "%c%s%s", ErrorPrefix, lineSafe.Replace(fmt.Sprint(obj.Value)), CRLF)
 	case Integer:
-		fmt.Fprintf(&sb, "%c%v%s", IntegerPrefix, obj.Value, CRLF)
+		fmt.Fprintf(w, "%c%v%s", IntegerPrefix, obj.Value, CRLF)
 	case BulkString:
 		str, ok := obj.Value.(string)
 		if !ok {
-			return fmt.Sprintf("%c-1%s", BulkStringPrefix, CRLF) // Null bulk string
+			fmt.Fprintf(w, "%c-1%s", BulkStringPrefix, CRLF) // Null bulk string
+			return
 		}
-		fmt.Fprintf(&sb, "%c%d%s%s%s", BulkStringPrefix, len(str), CRLF, str, CRLF)
+		fmt.Fprintf(w, "%c%d%s", BulkStringPrefix, len(str), CRLF)
+		w.WriteString(str)
+		w.WriteString(CRLF)
 	case Null:
-		fmt.Fprintf(&sb, "%c-1%s", BulkStringPrefix, CRLF)
-	case Array:
+		fmt.Fprintf(w, "%c-1%s", BulkStringPrefix, CRLF)
+	case Double:
+		f, _ := obj.Value.(float64)
+		str := FormatDouble(f)
+		if !resp3 {
+			fmt.Fprintf(w, "%c%d%s%s%s", BulkStringPrefix, len(str), CRLF, str, CRLF)
+			return
+		}
+		fmt.Fprintf(w, "%c%s%s", DoublePrefix, str, CRLF)
+	case Stream:
+		fn, _ := obj.Value.(StreamFunc)
+		encodeStream(w, fn, resp3, nil)
+	case Raw:
+		b, _ := obj.Value.([]byte)
+		w.Write(b)
+	case Array, Push, Attribute, Map:
 		arr, ok := obj.Value.([]RESPObject)
 		if !ok {
-			return fmt.Sprintf("%c-1%s", ArrayPrefix, CRLF) // Null array
+			fmt.Fprintf(w, "%c-1%s", ArrayPrefix, CRLF) // Null array
+			return
+		}
+		prefix, n := ArrayPrefix, len(arr)
+		switch obj.Type {
+		case Push:
+			prefix = PushPrefix
+		case Attribute:
+			prefix, n = AttributePrefix, len(arr)/2
+		case Map:
+			if resp3 {
+				prefix, n = MapPrefix, len(arr)/2
+			}
 		}
-		fmt.Fprintf(&sb, "%c%d%s", ArrayPrefix, len(arr), CRLF)
+		fmt.Fprintf(w, "%c%d%s", prefix, n, CRLF)
 		for _, item := range arr {
-			sb.WriteString(item.Serialize())
+			item.encode(w, resp3)
 		}
 	}
-	return sb.String()
 }
 
 func (r *Reader) Deserialize() (RESPObject, error) {
@@ -89,7 +201,8 @@ func (r *Reader) Deserialize() (RESPObject, error) {
 	if err != nil {
 		return RESPObject{}, fmt.Errorf("failed to read line: %w", err)
 	}
-	line = strings.TrimSpace(line)
+	// Only the terminator is stripped: a simple string may end in spaces.
+	line = strings.TrimSuffix(line[:len(line)-1], "\r")
 
 	switch typeByte {
 	case SimpleStringPrefix:
@@ -106,6 +219,16 @@ func (r *Reader) Deserialize() (RESPObject, error) {
 		return r.deserializeBulkString(line)
 	case ArrayPrefix:
 		return r.deserializeArray(line)
+	case AttributePrefix:
+		return r.deserializePairs(line, Attribute)
+	case MapPrefix:
+		return r.deserializePairs(line, Map)
+	case DoublePrefix:
+		val, err := strconv.ParseFloat(line, 64)
+		if err != nil && !errors.Is(err, strconv.ErrRange) {
+			return RESPObject{}, fmt.Errorf("failed to parse double: %w", err)
+		}
+		return RESPObject{Type: Double, Value: val}, nil
 	default:
 		return RESPObject{}, fmt.Errorf("unknown RESP type: %c", typeByte)
 	}
@@ -120,18 +243,36 @@ func (r *Reader) deserializeBulkString(line string) (RESPObject, error) {
 	if length == -1 {
 		return RESPObject{Type: BulkString, Value: nil}, nil
 	}
+	if length < -1 || length > MaxBulkLen {
+		return RESPObject{}, fmt.Errorf("invalid bulk string length %d", length)
+	}
 
-	bulkStr := make([]byte, length)
-	_, err = io.ReadFull(r.reader, bulkStr)
+	var bulkStr []byte
+	if length <= maxPrealloc {
+		bulkStr = make([]byte, length)
+		_, err = io.ReadFull(r.reader, bulkStr)
+	} else {
+		var buf bytes.Buffer
+		var n int64
+		n, err = io.CopyN(&buf, r.reader, int64(length))
+		if err == io.EOF && n > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		bulkStr = buf.Bytes()
+	}
 	if err != nil {
 		return RESPObject{}, fmt.Errorf("failed to read bulk string: %w", err)
 	}
 
-	// Consume CRLF
-	_, err = r.reader.ReadString('\n')
-	if err != nil {
+	// The CRLF must follow right away, or the length was wrong and the
+	// value would silently lose or gain bytes.
+	var crlf [2]byte
+	if _, err := io.ReadFull(r.reader, crlf[:]); err != nil {
 		return RESPObject{}, fmt.Errorf("failed to consume CRLF: %w", err)
 	}
+	if string(crlf[:]) != CRLF {
+		return RESPObject{}, fmt.Errorf("bulk string of length %d not followed by CRLF", length)
+	}
 
 	return RESPObject{Type: BulkString, Value: string(bulkStr)}, nil
 }
@@ -145,23 +286,58 @@ func (r *Reader) deserializeArray(line string) (RESPObject, error) {
 	if count == -1 {
 		return RESPObject{Type: Array, Value: nil}, nil
 	}
+	if count < -1 || count > math.MaxInt32 {
+		return RESPObject{}, fmt.Errorf("invalid array length %d", count)
+	}
 
-	array := make([]RESPObject, count)
+	array := make([]RESPObject, 0, prealloc(count))
 	for i := 0; i < count; i++ {
 		obj, err := r.Deserialize()
 		if err != nil {
 			return RESPObject{}, fmt.Errorf("failed to deserialize array element %d: %w", i, err)
 		}
-		array[i] = obj
+		array = append(array, obj)
 	}
 
 	return RESPObject{Type: Array, Value: array}, nil
 }
 
+// deserializePairs reads the keys and values of an Attribute or a Map.
+func (r *Reader) deserializePairs(line string, typ RESPType) (RESPObject, error) {
+	name := "attribute"
+	if typ == Map {
+		name = "map"
+	}
+	count, err := strconv.Atoi(line)
+	if err != nil || count < 0 || count > math.MaxInt32/2 {
+		return RESPObject{}, fmt.Errorf("failed to parse %s length: %q", name, line)
+	}
+
+	pairs := make([]RESPObject, 0, prealloc(2*count))
+	for i := 0; i < 2*count; i++ {
+		obj, err := r.Deserialize()
+		if err != nil {
+			return RESPObject{}, fmt.Errorf("failed to deserialize %s element %d: %w", name, i, err)
+		}
+		pairs = append(pairs, obj)
+	}
+
+	return RESPObject{Type: typ, Value: pairs}, nil
+}
+
+func prealloc(n int) int {
+	if n > maxPrealloc {
+		return maxPrealloc
+	}
+	return n
+}
+
+// Write encodes respObj straight into the buffered connection, which is
+// flushed whenever it fills, so a large reply is never held in memory twice.
 func (w *Writer) Write(respObj RESPObject) error {
-	_, err := w.writer.WriteString(respObj.Serialize())
-	if err != nil {
+	respObj.encode(w.writer, w.resp3.Load())
+	if err := w.writer.Flush(); err != nil {
 		return fmt.Errorf("failed to write RESP object: %w", err)
 	}
-	return w.writer.Flush()
+	return nil
 }
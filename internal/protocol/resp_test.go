@@ -0,0 +1,102 @@
+package protocol
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// binaryValues are bulk strings a text protocol could mangle.
+var binaryValues = []string{"", "a\r\nb", "\r\n", "\n", "\r", "nul\x00byte", "\x00", "$3\r\nfoo\r\n", "\xff\xfe"}
+
+func TestBulkStringRoundTrip(t *testing.T) {
+	for _, v := range binaryValues {
+		obj := RESPObject{Type: BulkString, Value: v}
+		got, err := NewReader(strings.NewReader(obj.Serialize())).Deserialize()
+		if err != nil {
+			t.Fatalf("%q: %v", v, err)
+		}
+		if got.Type != BulkString || got.Value != v {
+			t.Errorf("%q came back as %#v", v, got)
+		}
+	}
+}
+
+func TestArrayRoundTripThroughWriter(t *testing.T) {
+	elems := make([]RESPObject, len(binaryValues))
+	for i, v := range binaryValues {
+		elems[i] = RESPObject{Type: BulkString, Value: v}
+	}
+	obj := RESPObject{Type: Array, Value: elems}
+	var buf bytes.Buffer
+	if err := NewWriter(&buf).Write(obj); err != nil {
+		t.Fatal(err)
+	}
+	r := NewReader(&buf)
+	got, err := r.Deserialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, obj) {
+		t.Errorf("got %#v, want %#v", got, obj)
+	}
+	if r.Buffered() != 0 {
+		t.Errorf("%d bytes left over", r.Buffered())
+	}
+}
+
+func TestDeserializeRejectsBadLengths(t *testing.T) {
+	for _, in := range []string{
+		"*-2\r\n",
+		"$-5\r\n",
+		"|-1\r\n",
+		"$9999999999\r\n",
+		"*9999999999\r\n",
+		"$3\r\nfoobar\r\n",
+	} {
+		if _, err := NewReader(strings.NewReader(in)).Deserialize(); err == nil {
+			t.Errorf("%q: no error", in)
+		}
+	}
+}
+
+func TestDeserializeLargeCountNeedsData(t *testing.T) {
+	// A huge announced array fails on the missing elements instead of
+	// allocating them all.
+	if _, err := NewReader(strings.NewReader("*2000000000\r\n$1\r\na\r\n")).Deserialize(); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestMapEncoding(t *testing.T) {
+	obj := RESPObject{Type: Map, Value: []RESPObject{
+		{Type: BulkString, Value: "proto"}, {Type: Integer, Value: int64(3)},
+		{Type: BulkString, Value: "modules"}, {Type: Array, Value: []RESPObject{}},
+	}}
+	for _, tc := range []struct {
+		resp3 bool
+		want  string
+		typ   RESPType
+	}{
+		{true, "%2\r\n$5\r\nproto\r\n:3\r\n$7\r\nmodules\r\n*0\r\n", Map},
+		{false, "*4\r\n$5\r\nproto\r\n:3\r\n$7\r\nmodules\r\n*0\r\n", Array},
+	} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.SetRESP3(tc.resp3)
+		if err := w.Write(obj); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tc.want {
+			t.Errorf("resp3 %v: wrote %q, want %q", tc.resp3, got, tc.want)
+		}
+		got, err := NewReader(&buf).Deserialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Type != tc.typ || !reflect.DeepEqual(got.Value, obj.Value) {
+			t.Errorf("resp3 %v: read back %#v", tc.resp3, got)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// A StreamFunc produces the elements of a Stream: it calls begin once with
+// their number, then emit with each of them in order, and stops as soon as
+// emit returns false.
+type StreamFunc func(begin func(n int), emit func(RESPObject) bool)
+
+// encodeStream encodes the array fn produces to w. check, if not nil, is
+// called after the header and after every element; an error from it stops
+// fn and is returned.
+func encodeStream(w stringWriter, fn StreamFunc, resp3 bool, check func() error) error {
+	if fn == nil {
+		fmt.Fprintf(w, "%c-1%s", ArrayPrefix, CRLF) // Null array
+		return nil
+	}
+	var err error
+	fn(func(n int) {
+		fmt.Fprintf(w, "%c%d%s", ArrayPrefix, n, CRLF)
+		if check != nil {
+			err = check()
+		}
+	}, func(item RESPObject) bool {
+		if err != nil {
+			return false
+		}
+		item.encode(w, resp3)
+		if check != nil {
+			err = check()
+		}
+		return err == nil
+	})
+	return err
+}
+
+// Render encodes obj for a client speaking RESP3 or RESP2, producing the
+// elements of a Stream while holding on to nothing but their encoding. grow
+// is called with the size of each piece added to the encoding as it grows;
+// once it returns an error, rendering stops and returns it, so a reply over
+// a client's output buffer limit is abandoned before it is built in full.
+// Anything other than a Stream is returned as it is.
+func Render(obj RESPObject, resp3 bool, grow func(n int) error) (RESPObject, error) {
+	if obj.Type != Stream {
+		return obj, nil
+	}
+	fn, _ := obj.Value.(StreamFunc)
+	var buf bytes.Buffer
+	size := 0
+	err := encodeStream(&buf, fn, resp3, func() error {
+		n := buf.Len() - size
+		size = buf.Len()
+		return grow(n)
+	})
+	if err != nil {
+		return RESPObject{}, err
+	}
+	return RESPObject{Type: Raw, Value: buf.Bytes()}, nil
+}
+
+// WriteStream writes obj, a Stream, as its elements are produced, the
+// connection's buffer sending them on whenever it fills, so no more of the
+// reply is held than fits in that buffer. buffered is called after the
+// header and after every element with the number of bytes waiting in the
+// buffer; an error from it, or from the connection, stops the stream and is
+// returned, leaving the reply cut short.
+func (w *Writer) WriteStream(obj RESPObject, buffered func(n int) error) error {
+	fn, _ := obj.Value.(StreamFunc)
+	err := encodeStream(w.writer, fn, w.resp3.Load(), func() error {
+		// An empty write returns the error the buffer keeps from the
+		// connection, if any.
+		if _, err := w.writer.Write(nil); err != nil {
+			return err
+		}
+		return buffered(w.writer.Buffered())
+	})
+	if err == nil {
+		err = w.writer.Flush()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write RESP object: %w", err)
+	}
+	return nil
+}
+
+// Collect expands a Stream into an Array, for consumers that need the
+// elements themselves rather than their encoding. Anything else is returned
+// as it is.
+func Collect(obj RESPObject) RESPObject {
+	if obj.Type != Stream {
+		return obj
+	}
+	fn, _ := obj.Value.(StreamFunc)
+	if fn == nil {
+		return RESPObject{Type: Array}
+	}
+	var arr []RESPObject
+	fn(func(n int) { arr = make([]RESPObject, 0, prealloc(n)) }, func(item RESPObject) bool {
+		arr = append(arr, item)
+		return true
+	})
+	return RESPObject{Type: Array, Value: arr}
+}
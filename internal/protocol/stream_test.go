@@ -0,0 +1,105 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// countStream streams the bulk strings "0" to n-1, recording how many it
+// produced in *produced.
+func countStream(n int, produced *int) RESPObject {
+	return RESPObject{Type: Stream, Value: StreamFunc(func(begin func(int), emit func(RESPObject) bool) {
+		begin(n)
+		for i := 0; i < n; i++ {
+			*produced++
+			if !emit(RESPObject{Type: BulkString, Value: string(rune('0' + i))}) {
+				return
+			}
+		}
+	})}
+}
+
+func TestRenderMatchesArray(t *testing.T) {
+	var produced int
+	stream := countStream(5, &produced)
+	raw, err := Render(stream, false, func(int) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Collect(stream).Serialize()
+	if got := string(raw.Value.([]byte)); raw.Type != Raw || got != want {
+		t.Fatalf("rendered %q, want %q", got, want)
+	}
+	got, err := NewReader(bytes.NewReader(raw.Value.([]byte))).Deserialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, Collect(stream)) {
+		t.Errorf("read back %#v", got)
+	}
+}
+
+func TestRenderStopsAtLimit(t *testing.T) {
+	var produced, size int
+	limit := errors.New("limit")
+	_, err := Render(countStream(1000, &produced), false, func(n int) error {
+		if size += n; size > 20 {
+			return limit
+		}
+		return nil
+	})
+	if err != limit {
+		t.Fatalf("got %v, want the limit error", err)
+	}
+	if produced > 10 {
+		t.Errorf("produced %d elements after the limit was reached", produced)
+	}
+}
+
+func TestRawInsideArray(t *testing.T) {
+	var produced int
+	raw, _ := Render(countStream(2, &produced), false, func(int) error { return nil })
+	obj := RESPObject{Type: Array, Value: []RESPObject{raw, {Type: Integer, Value: 1}}}
+	if got, want := obj.Serialize(), "*2\r\n*2\r\n$1\r\n0\r\n$1\r\n1\r\n:1\r\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWriteStreamMatchesArray(t *testing.T) {
+	var produced int
+	var buf bytes.Buffer
+	stream := countStream(5, &produced)
+	if err := NewWriter(&buf).WriteStream(stream, func(int) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), Collect(stream).Serialize(); got != want {
+		t.Errorf("wrote %q, want %q", got, want)
+	}
+}
+
+// failingWriter fails every write, like a connection the client closed.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("closed") }
+
+func TestWriteStreamStopsOnWriteError(t *testing.T) {
+	var produced, most int
+	const n = 100000
+	err := NewWriter(failingWriter{}).WriteStream(countStream(n, &produced), func(buffered int) error {
+		if buffered > most {
+			most = buffered
+		}
+		return nil
+	})
+	if err == nil {
+		t.Fatal("got no error")
+	}
+	if produced == n {
+		t.Errorf("produced every element after the connection failed")
+	}
+	if most > 4096 {
+		t.Errorf("%d bytes buffered, want at most the buffer's size", most)
+	}
+}
@@ -0,0 +1,696 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// maxStringLen bounds the strings the decoder allocates, matching Redis'
+// proto-max-bulk-len, so a corrupt length can't exhaust memory.
+const maxStringLen = 512 << 20
+
+// Entry is one key read from an RDB file. Value is a string for TypeString,
+// a map[string]string for TypeHash and TypeHashMetadata, a []string for
+// TypeList, a Module for values written by Encoder and nil for encodings
+// the server doesn't load (which are still verified).
+type Entry struct {
+	DB        int
+	Key       string
+	Type      byte
+	ExpiresAt time.Time
+	Value     interface{}
+	// FieldExpires holds the expiry times of the fields of a hash that
+	// have one.
+	FieldExpires map[string]time.Time
+	// Size is the serialized size of the value in bytes.
+	Size int64
+}
+
+type Module struct {
+	TypeName string
+	Data     []byte
+}
+
+// volatileHash is the value of a hash with field TTLs, split into Entry's
+// Value and FieldExpires.
+type volatileHash struct {
+	fields  map[string]string
+	expires map[string]time.Time
+}
+
+// Handler receives the contents of a file. Nil callbacks are skipped; an
+// error returned by Key aborts decoding.
+type Handler struct {
+	Aux func(key, value string)
+	DB  func(db int, keys, expires uint64)
+	Key func(e Entry) error
+}
+
+// CorruptError reports where decoding stopped.
+type CorruptError struct {
+	Offset int64
+	Err    error
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("corrupt RDB at offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *CorruptError) Unwrap() error { return e.Err }
+
+var ErrChecksum = errors.New("checksum mismatch")
+
+// Summary describes a successfully decoded file.
+type Summary struct {
+	Version int
+	// Checksummed is false when the file was written with checksums disabled.
+	Checksummed bool
+	Size        int64
+}
+
+type decoder struct {
+	r      *bufio.Reader
+	crc    uint64
+	offset int64
+}
+
+// Decode reads a complete RDB file, verifying its structure and checksum.
+func Decode(r io.Reader, h Handler) (Summary, error) {
+	d := &decoder{r: bufio.NewReader(r)}
+	sum, err := d.decode(h)
+	if err != nil {
+		var corrupt *CorruptError
+		if !errors.As(err, &corrupt) {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			err = &CorruptError{Offset: d.offset, Err: err}
+		}
+	}
+	sum.Size = d.offset
+	return sum, err
+}
+
+func (d *decoder) decode(h Handler) (Summary, error) {
+	var sum Summary
+	header, err := d.read(9)
+	if err != nil {
+		return sum, err
+	}
+	if string(header[:5]) != "REDIS" {
+		return sum, errors.New("missing REDIS signature")
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil || version < 1 || version > 12 {
+		return sum, fmt.Errorf("unsupported RDB version %q", header[5:])
+	}
+	sum.Version = version
+
+	db := 0
+	var expiresAt time.Time
+	for {
+		op, err := d.readByte()
+		if err != nil {
+			return sum, err
+		}
+		switch op {
+		case opEOF:
+			if version < 5 {
+				return sum, nil
+			}
+			expected := d.crc
+			raw := make([]byte, 8)
+			if _, err := io.ReadFull(d.r, raw); err != nil {
+				return sum, err
+			}
+			d.offset += 8
+			stored := binary.LittleEndian.Uint64(raw)
+			if stored == 0 {
+				return sum, nil
+			}
+			sum.Checksummed = true
+			if stored != expected {
+				return sum, &CorruptError{Offset: d.offset - 8, Err: fmt.Errorf("%w: file has %016x, computed %016x", ErrChecksum, stored, expected)}
+			}
+			return sum, nil
+		case opAux:
+			key, err := d.readString()
+			if err != nil {
+				return sum, err
+			}
+			value, err := d.readString()
+			if err != nil {
+				return sum, err
+			}
+			if h.Aux != nil {
+				h.Aux(key, value)
+			}
+		case opSelectDB:
+			n, err := d.readLen()
+			if err != nil {
+				return sum, err
+			}
+			db = int(n)
+		case opResizeDB:
+			keys, err := d.readLen()
+			if err != nil {
+				return sum, err
+			}
+			expires, err := d.readLen()
+			if err != nil {
+				return sum, err
+			}
+			if h.DB != nil {
+				h.DB(db, keys, expires)
+			}
+		case opExpireTimeMs:
+			b, err := d.read(8)
+			if err != nil {
+				return sum, err
+			}
+			expiresAt = time.UnixMilli(int64(binary.LittleEndian.Uint64(b)))
+		case opExpireTime:
+			b, err := d.read(4)
+			if err != nil {
+				return sum, err
+			}
+			expiresAt = time.Unix(int64(binary.LittleEndian.Uint32(b)), 0)
+		case opFreq:
+			if _, err := d.readByte(); err != nil {
+				return sum, err
+			}
+		case opIdle:
+			if _, err := d.readLen(); err != nil {
+				return sum, err
+			}
+		case opModuleAux:
+			if _, err := d.readLen(); err != nil { // module id
+				return sum, err
+			}
+			if _, err := d.readLen(); err != nil { // when opcode
+				return sum, err
+			}
+			if _, err := d.readLen(); err != nil { // when
+				return sum, err
+			}
+			if _, err := d.readModuleOpcodes(); err != nil {
+				return sum, err
+			}
+		case opFunction2:
+			if _, err := d.readString(); err != nil {
+				return sum, err
+			}
+		default:
+			start := d.offset - 1
+			key, err := d.readString()
+			if err != nil {
+				return sum, err
+			}
+			value, err := d.readValue(op)
+			if err != nil {
+				return sum, fmt.Errorf("key %q: %w", key, err)
+			}
+			if h.Key != nil {
+				e := Entry{DB: db, Key: key, Type: op, ExpiresAt: expiresAt, Value: value, Size: d.offset - start}
+				if h, ok := value.(volatileHash); ok {
+					e.Value, e.FieldExpires = h.fields, h.expires
+				}
+				if err := h.Key(e); err != nil {
+					return sum, err
+				}
+			}
+			expiresAt = time.Time{}
+		}
+	}
+}
+
+func (d *decoder) read(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return nil, err
+	}
+	d.crc = crcUpdate(d.crc, b)
+	d.offset += int64(n)
+	return b, nil
+}
+
+// readLarge reads n bytes without allocating them upfront, so a corrupt
+// length fails with EOF instead of a huge allocation.
+func (d *decoder) readLarge(n uint64) ([]byte, error) {
+	if n > maxStringLen {
+		return nil, fmt.Errorf("string length %d exceeds limit", n)
+	}
+	if n <= 1<<16 {
+		return d.read(int(n))
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, d.r, int64(n)); err != nil {
+		d.offset += int64(buf.Len())
+		return nil, err
+	}
+	d.crc = crcUpdate(d.crc, buf.Bytes())
+	d.offset += int64(n)
+	return buf.Bytes(), nil
+}
+
+func (d *decoder) readByte() (byte, error) {
+	b, err := d.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// readLenEnc returns a length, or the string encoding type when encoded is set.
+func (d *decoder) readLenEnc() (n uint64, encoded bool, err error) {
+	b, err := d.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3F), false, nil
+	case 1:
+		next, err := d.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(b&0x3F)<<8 | uint64(next), false, nil
+	case 2:
+		switch b {
+		case 0x80:
+			v, err := d.read(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(v)), false, nil
+		case 0x81:
+			v, err := d.read(8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(v), false, nil
+		}
+		return 0, false, fmt.Errorf("unknown length encoding 0x%02x", b)
+	default:
+		return uint64(b & 0x3F), true, nil
+	}
+}
+
+func (d *decoder) readLen() (uint64, error) {
+	n, encoded, err := d.readLenEnc()
+	if err == nil && encoded {
+		err = errors.New("unexpected encoded length")
+	}
+	return n, err
+}
+
+func (d *decoder) readString() (string, error) {
+	b, err := d.readStringBytes()
+	return string(b), err
+}
+
+func (d *decoder) readStringBytes() ([]byte, error) {
+	n, encoded, err := d.readLenEnc()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		return d.readLarge(n)
+	}
+	switch n {
+	case 0:
+		b, err := d.read(1)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int8(b[0])))), nil
+	case 1:
+		b, err := d.read(2)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int16(binary.LittleEndian.Uint16(b))))), nil
+	case 2:
+		b, err := d.read(4)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int32(binary.LittleEndian.Uint32(b))))), nil
+	case 3:
+		clen, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+		ulen, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+		if ulen > maxStringLen {
+			return nil, fmt.Errorf("string length %d exceeds limit", ulen)
+		}
+		compressed, err := d.readLarge(clen)
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, int(ulen))
+	}
+	return nil, fmt.Errorf("unknown string encoding %d", n)
+}
+
+func (d *decoder) readDouble() error {
+	n, err := d.readByte()
+	if err != nil {
+		return err
+	}
+	if n >= 253 { // NaN, +inf, -inf
+		return nil
+	}
+	_, err = d.read(int(n))
+	return err
+}
+
+func (d *decoder) skipStrings(n uint64) error {
+	for i := uint64(0); i < n; i++ {
+		if _, err := d.readStringBytes(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *decoder) readValue(typ byte) (interface{}, error) {
+	switch typ {
+	case TypeString:
+		return d.readString()
+	case TypeHash:
+		n, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+		fields := make(map[string]string)
+		for i := uint64(0); i < n; i++ {
+			f, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			fields[f] = v
+		}
+		return fields, nil
+	case TypeHashMetadata, TypeHashMetadataPreGA:
+		return d.readHashMetadata(typ == TypeHashMetadata)
+	case TypeList:
+		n, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+		var elems []string
+		for i := uint64(0); i < n; i++ {
+			elem, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, elem)
+		}
+		return elems, nil
+	case TypeSet:
+		n, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+		return nil, d.skipStrings(n)
+	case TypeZSet, TypeZSet2:
+		n, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, err := d.readStringBytes(); err != nil {
+				return nil, err
+			}
+			if typ == TypeZSet {
+				err = d.readDouble()
+			} else {
+				_, err = d.read(8)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case TypeHashZipmap, TypeListZiplist, TypeSetIntset, TypeZSetZiplist, TypeHashZiplist,
+		TypeHashListpack, TypeZSetListpack, TypeSetListpack, TypeHashListpackExPreGA:
+		return nil, d.skipStrings(1)
+	case TypeHashListpackEx:
+		if _, err := d.read(8); err != nil { // earliest field expiry
+			return nil, err
+		}
+		return nil, d.skipStrings(1)
+	case TypeListQuicklist, TypeListQuicklist2:
+		n, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < n; i++ {
+			if typ == TypeListQuicklist2 {
+				if _, err := d.readLen(); err != nil { // container
+					return nil, err
+				}
+			}
+			if _, err := d.readStringBytes(); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case TypeModule2:
+		if _, err := d.readLen(); err != nil { // module id
+			return nil, err
+		}
+		strs, err := d.readModuleOpcodes()
+		if err != nil {
+			return nil, err
+		}
+		if len(strs) == 2 {
+			return Module{TypeName: string(strs[0]), Data: strs[1]}, nil
+		}
+		return nil, nil
+	case TypeStreamListpacks, TypeStreamListpack2, TypeStreamListpack3:
+		return nil, d.skipStream(typ)
+	case TypeModule:
+		return nil, errors.New("module values of RDB type 6 can't be verified without the module")
+	}
+	return nil, fmt.Errorf("unknown value type %d", typ)
+}
+
+// readHashMetadata reads a hash with field TTLs. The GA format starts with
+// the earliest expiry, which the TTLs are relative to; the release
+// candidates' stores them as they are. A TTL of 0 means none.
+func (d *decoder) readHashMetadata(relative bool) (interface{}, error) {
+	var min int64
+	if relative {
+		b, err := d.read(8)
+		if err != nil {
+			return nil, err
+		}
+		min = int64(binary.LittleEndian.Uint64(b)) - 1
+	}
+	n, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	h := volatileHash{fields: make(map[string]string), expires: make(map[string]time.Time)}
+	for i := uint64(0); i < n; i++ {
+		ttl, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+		f, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		h.fields[f] = v
+		if ttl != 0 {
+			h.expires[f] = time.UnixMilli(min + int64(ttl))
+		}
+	}
+	return h, nil
+}
+
+// readModuleOpcodes walks a self-describing module value up to its EOF
+// opcode and returns the strings it contains.
+func (d *decoder) readModuleOpcodes() ([][]byte, error) {
+	var strs [][]byte
+	for {
+		op, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case moduleOpEOF:
+			return strs, nil
+		case moduleOpSInt, moduleOpUInt:
+			_, err = d.readLen()
+		case moduleOpFloat:
+			_, err = d.read(4)
+		case moduleOpDouble:
+			_, err = d.read(8)
+		case moduleOpString:
+			var s []byte
+			if s, err = d.readStringBytes(); err == nil {
+				strs = append(strs, s)
+			}
+		default:
+			err = fmt.Errorf("unknown module opcode %d", op)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (d *decoder) readLens(n int) error {
+	for i := 0; i < n; i++ {
+		if _, err := d.readLen(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *decoder) skipStream(typ byte) error {
+	nodes, err := d.readLen()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < nodes; i++ {
+		id, err := d.readStringBytes()
+		if err != nil {
+			return err
+		}
+		if len(id) != 16 {
+			return errors.New("stream node key is not a 128 bit ID")
+		}
+		if _, err := d.readStringBytes(); err != nil { // listpack
+			return err
+		}
+	}
+	// length, last id
+	if err := d.readLens(3); err != nil {
+		return err
+	}
+	if typ >= TypeStreamListpack2 {
+		// first id, max deleted id, entries added
+		if err := d.readLens(5); err != nil {
+			return err
+		}
+	}
+
+	groups, err := d.readLen()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < groups; i++ {
+		if _, err := d.readStringBytes(); err != nil { // name
+			return err
+		}
+		n := 2 // last id
+		if typ >= TypeStreamListpack2 {
+			n++ // entries read
+		}
+		if err := d.readLens(n); err != nil {
+			return err
+		}
+		pel, err := d.readLen()
+		if err != nil {
+			return err
+		}
+		for j := uint64(0); j < pel; j++ {
+			if _, err := d.read(16 + 8); err != nil { // id, delivery time
+				return err
+			}
+			if _, err := d.readLen(); err != nil { // delivery count
+				return err
+			}
+		}
+		consumers, err := d.readLen()
+		if err != nil {
+			return err
+		}
+		for j := uint64(0); j < consumers; j++ {
+			if _, err := d.readStringBytes(); err != nil { // name
+				return err
+			}
+			times := 8 // seen time
+			if typ >= TypeStreamListpack3 {
+				times += 8 // active time
+			}
+			if _, err := d.read(times); err != nil {
+				return err
+			}
+			cpel, err := d.readLen()
+			if err != nil {
+				return err
+			}
+			for k := uint64(0); k < cpel; k++ {
+				if _, err := d.read(16); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 {
+			n := ctrl + 1
+			if i+n > len(in) {
+				return nil, errors.New("invalid LZF literal run")
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, errors.New("invalid LZF back reference")
+			}
+			n += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errors.New("invalid LZF back reference")
+		}
+		ref := len(out) - ((ctrl&0x1F)<<8 | int(in[i])) - 1
+		i++
+		if ref < 0 {
+			return nil, errors.New("invalid LZF back reference")
+		}
+		for j := 0; j < n+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != outLen {
+		return nil, fmt.Errorf("LZF data decompressed to %d bytes, expected %d", len(out), outLen)
+	}
+	return out, nil
+}
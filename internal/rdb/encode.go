@@ -0,0 +1,268 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Encoder writes an RDB file. Call Close to append the EOF marker and
+// checksum; the output is not valid before that.
+type Encoder struct {
+	w   *bufio.Writer
+	crc uint64
+	err error
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	e := &Encoder{w: bufio.NewWriter(w)}
+	e.write([]byte(fmt.Sprintf("REDIS%04d", Version)))
+	return e
+}
+
+func (e *Encoder) write(p []byte) {
+	if e.err != nil {
+		return
+	}
+	e.crc = crcUpdate(e.crc, p)
+	_, e.err = e.w.Write(p)
+}
+
+func (e *Encoder) writeByte(b byte) {
+	e.write([]byte{b})
+}
+
+func (e *Encoder) writeLen(n uint64) {
+	switch {
+	case n < 1<<6:
+		e.writeByte(byte(n))
+	case n < 1<<14:
+		e.write([]byte{0x40 | byte(n>>8), byte(n)})
+	case n <= 0xFFFFFFFF:
+		b := []byte{0x80, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		e.write(b)
+	default:
+		b := []byte{0x81, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint64(b[1:], n)
+		e.write(b)
+	}
+}
+
+func (e *Encoder) writeString(s string) {
+	if len(s) <= 11 {
+		if n, err := strconv.ParseInt(s, 10, 32); err == nil && strconv.FormatInt(n, 10) == s {
+			e.writeInt(n)
+			return
+		}
+	}
+	e.writeLen(uint64(len(s)))
+	e.write([]byte(s))
+}
+
+// writeInt uses the integer string encodings, as Redis does for strings
+// that round-trip through an integer.
+func (e *Encoder) writeInt(n int64) {
+	switch {
+	case n >= -1<<7 && n < 1<<7:
+		e.write([]byte{0xC0, byte(int8(n))})
+	case n >= -1<<15 && n < 1<<15:
+		b := []byte{0xC1, 0, 0}
+		binary.LittleEndian.PutUint16(b[1:], uint16(int16(n)))
+		e.write(b)
+	default:
+		b := []byte{0xC2, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint32(b[1:], uint32(int32(n)))
+		e.write(b)
+	}
+}
+
+func (e *Encoder) WriteAux(key, value string) {
+	e.writeByte(opAux)
+	e.writeString(key)
+	e.writeString(value)
+}
+
+func (e *Encoder) SelectDB(db int, keys, expires uint64) {
+	e.writeByte(opSelectDB)
+	e.writeLen(uint64(db))
+	e.writeByte(opResizeDB)
+	e.writeLen(keys)
+	e.writeLen(expires)
+}
+
+func (e *Encoder) writeKey(typ byte, key string, expiresAt time.Time) {
+	if !expiresAt.IsZero() {
+		b := []byte{opExpireTimeMs, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint64(b[1:], uint64(expiresAt.UnixMilli()))
+		e.write(b)
+	}
+	e.writeByte(typ)
+	e.writeString(key)
+}
+
+func (e *Encoder) WriteString(key, value string, expiresAt time.Time) {
+	e.writeKey(TypeString, key, expiresAt)
+	e.writeString(value)
+}
+
+// WriteHash stores a hash. If fieldExpires holds the expiry times of some
+// fields it uses Redis 7.4's hash with metadata type, which Redis versions
+// before it can't load.
+func (e *Encoder) WriteHash(key string, fields map[string]string, fieldExpires map[string]time.Time, expiresAt time.Time) {
+	if len(fieldExpires) == 0 {
+		e.writeKey(TypeHash, key, expiresAt)
+		e.writeHashFields(fields)
+		return
+	}
+	e.writeKey(TypeHashMetadata, key, expiresAt)
+	e.writeHashMetadata(fields, fieldExpires)
+}
+
+func (e *Encoder) writeHashFields(fields map[string]string) {
+	e.writeLen(uint64(len(fields)))
+	for _, f := range sortedFields(fields) {
+		e.writeString(f)
+		e.writeString(fields[f])
+	}
+}
+
+// writeHashMetadata writes the earliest field expiry in milliseconds, then
+// each field preceded by its expiry relative to the earliest plus one, or 0
+// for a field without a TTL.
+func (e *Encoder) writeHashMetadata(fields map[string]string, fieldExpires map[string]time.Time) {
+	var min int64
+	for _, at := range fieldExpires {
+		if ms := at.UnixMilli(); min == 0 || ms < min {
+			min = ms
+		}
+	}
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(min))
+	e.write(b)
+	e.writeLen(uint64(len(fields)))
+	for _, f := range sortedFields(fields) {
+		var ttl uint64
+		if at, ok := fieldExpires[f]; ok {
+			ttl = uint64(at.UnixMilli()-min) + 1
+		}
+		e.writeLen(ttl)
+		e.writeString(f)
+		e.writeString(fields[f])
+	}
+}
+
+func sortedFields(fields map[string]string) []string {
+	names := make([]string, 0, len(fields))
+	for f := range fields {
+		names = append(names, f)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WriteList stores a list with the original list encoding, one string per
+// element, which every Redis version still loads.
+func (e *Encoder) WriteList(key string, elems []string, expiresAt time.Time) {
+	e.writeKey(TypeList, key, expiresAt)
+	e.writeListElements(elems)
+}
+
+func (e *Encoder) writeListElements(elems []string) {
+	e.writeLen(uint64(len(elems)))
+	for _, elem := range elems {
+		e.writeString(elem)
+	}
+}
+
+// WriteModule stores a custom type's marshalled value as a module value.
+// The payload starts with the type name, so a loader doesn't depend on the
+// 9-character module id Redis derives from it.
+func (e *Encoder) WriteModule(key, typeName string, data []byte, expiresAt time.Time) {
+	e.writeKey(TypeModule2, key, expiresAt)
+	e.writeModuleValue(typeName, data)
+}
+
+func (e *Encoder) writeModuleValue(typeName string, data []byte) {
+	e.writeLen(moduleID(typeName))
+	e.writeLen(moduleOpString)
+	e.writeString(typeName)
+	e.writeLen(moduleOpString)
+	e.writeLen(uint64(len(data)))
+	e.write(data)
+	e.writeLen(moduleOpEOF)
+}
+
+// StringLength, HashLength, ListLength and ModuleLength return the size of a value as
+// encoded in an RDB file, without its key, type and expiry.
+func StringLength(s string) int {
+	return valueLength(func(e *Encoder) { e.writeString(s) })
+}
+
+func HashLength(fields map[string]string) int {
+	return valueLength(func(e *Encoder) { e.writeHashFields(fields) })
+}
+
+func ListLength(elems []string) int {
+	return valueLength(func(e *Encoder) { e.writeListElements(elems) })
+}
+
+func ModuleLength(typeName string, data []byte) int {
+	return valueLength(func(e *Encoder) { e.writeModuleValue(typeName, data) })
+}
+
+type countingWriter int
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+func valueLength(write func(e *Encoder)) int {
+	var n countingWriter
+	e := &Encoder{w: bufio.NewWriter(&n)}
+	write(e)
+	e.w.Flush()
+	return int(n)
+}
+
+const moduleCharset = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+// moduleID packs a type name into Redis' module type id: nine 6-bit
+// characters followed by a 10-bit encoding version.
+func moduleID(name string) uint64 {
+	var id uint64
+	for i := 0; i < 9; i++ {
+		c := byte('-')
+		if i < len(name) {
+			c = name[i]
+		}
+		idx := 63
+		for j := 0; j < len(moduleCharset); j++ {
+			if moduleCharset[j] == c {
+				idx = j
+				break
+			}
+		}
+		id = id<<6 | uint64(idx)
+	}
+	return id << 10
+}
+
+// Close writes the EOF opcode and checksum and flushes the output.
+func (e *Encoder) Close() error {
+	e.writeByte(opEOF)
+	var sum [8]byte
+	binary.LittleEndian.PutUint64(sum[:], e.crc)
+	if e.err == nil {
+		_, e.err = e.w.Write(sum[:])
+	}
+	if e.err == nil {
+		e.err = e.w.Flush()
+	}
+	return e.err
+}
@@ -0,0 +1,58 @@
+package rdb
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBinarySafeRoundTrip(t *testing.T) {
+	expiresAt := time.UnixMilli(4102444800000)
+	fieldExpiry := time.UnixMilli(4102444800123)
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SelectDB(0, 4, 1)
+	enc.WriteString("str\r\n", "a\x00b\r\nc", expiresAt)
+	enc.WriteHash("hash", map[string]string{"\n": "\r", "f\x00": ""}, nil, time.Time{})
+	enc.WriteHash("volatile", map[string]string{"a": "1", "b\r\n": "2"}, map[string]time.Time{"b\r\n": fieldExpiry}, time.Time{})
+	enc.WriteList("list", []string{"\r\n", "\x00", "", "12"}, time.Time{})
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]Entry{}
+	if _, err := Decode(&buf, Handler{Key: func(e Entry) error {
+		e.Size = 0
+		got[e.Key] = e
+		return nil
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Entry{
+		"str\r\n": {Key: "str\r\n", Type: TypeString, ExpiresAt: expiresAt, Value: "a\x00b\r\nc"},
+		"hash":    {Key: "hash", Type: TypeHash, Value: map[string]string{"\n": "\r", "f\x00": ""}},
+		"volatile": {Key: "volatile", Type: TypeHashMetadata, Value: map[string]string{"a": "1", "b\r\n": "2"},
+			FieldExpires: map[string]time.Time{"b\r\n": fieldExpiry}},
+		"list": {Key: "list", Type: TypeList, Value: []string{"\r\n", "\x00", "", "12"}},
+	}
+	for key, w := range want {
+		g := got[key]
+		if !g.ExpiresAt.Equal(w.ExpiresAt) {
+			t.Errorf("%q: expires at %v, want %v", key, g.ExpiresAt, w.ExpiresAt)
+		}
+		g.ExpiresAt, w.ExpiresAt = time.Time{}, time.Time{}
+		if len(g.FieldExpires) != len(w.FieldExpires) {
+			t.Errorf("%q: %d field TTLs, want %d", key, len(g.FieldExpires), len(w.FieldExpires))
+		}
+		for f, at := range g.FieldExpires {
+			if !at.Equal(w.FieldExpires[f]) {
+				t.Errorf("%q: field %q expires at %v, want %v", key, f, at, w.FieldExpires[f])
+			}
+		}
+		g.FieldExpires, w.FieldExpires = nil, nil
+		if !reflect.DeepEqual(g, w) {
+			t.Errorf("%q: got %#v, want %#v", key, g, w)
+		}
+	}
+}
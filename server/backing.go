@@ -0,0 +1,270 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/handler"
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+// BackingStore is an external system of record the server caches in front
+// of. Reads of missing keys are loaded through Load, and string writes are
+// forwarded to Store and Delete in the background (write-behind). Only
+// string keys are forwarded; hashes and custom types stay in memory.
+type BackingStore interface {
+	// Load returns the value at key and its remaining TTL (zero for none);
+	// found is false if the store has no such key.
+	Load(ctx context.Context, key string) (value string, ttl time.Duration, found bool, err error)
+	Store(ctx context.Context, key, value string, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+var (
+	backingStoresMu sync.Mutex
+	backingStores   = map[string]func(dsn string) (BackingStore, error){}
+)
+
+// RegisterBackingStore makes a backing store implementation available to
+// OpenBackingStore under name. Plugins call it from their Init function,
+// e.g. to provide a SQL store with the driver compiled into the plugin.
+func RegisterBackingStore(name string, open func(dsn string) (BackingStore, error)) error {
+	backingStoresMu.Lock()
+	defer backingStoresMu.Unlock()
+	if _, exists := backingStores[name]; exists {
+		return fmt.Errorf("backing store %s already registered", name)
+	}
+	backingStores[name] = open
+	return nil
+}
+
+// OpenBackingStore opens the backing store registered under name.
+func OpenBackingStore(name, dsn string) (BackingStore, error) {
+	backingStoresMu.Lock()
+	open, ok := backingStores[name]
+	backingStoresMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown backing store %q", name)
+	}
+	return open(dsn)
+}
+
+type loadCall struct {
+	done chan struct{}
+	err  error
+}
+
+// pendingWrite is the latest state of a key waiting to be written behind;
+// deleted means the key is gone and should be removed from the store.
+type pendingWrite struct {
+	value     string
+	expiresAt time.Time
+	deleted   bool
+}
+
+type backing struct {
+	store    BackingStore
+	timeout  time.Duration
+	interval time.Duration
+
+	mu       sync.Mutex
+	loading  map[string]*loadCall
+	pending  map[string]pendingWrite
+	flushing map[string]pendingWrite
+
+	loads  atomic.Int64
+	misses atomic.Int64
+	errors atomic.Int64
+}
+
+func newBacking(cfg Config) *backing {
+	b := &backing{
+		store:    cfg.BackingStore,
+		timeout:  cfg.BackingStoreTimeout,
+		interval: cfg.WriteBehindInterval,
+		loading:  make(map[string]*loadCall),
+		pending:  make(map[string]pendingWrite),
+	}
+	if b.timeout <= 0 {
+		b.timeout = 5 * time.Second
+	}
+	if b.interval <= 0 {
+		b.interval = 100 * time.Millisecond
+	}
+	return b
+}
+
+// readThrough loads the missing keys of a read command from the backing
+// store before it runs.
+func (b *backing) readThrough(cmd *handler.Command, args []protocol.RESPObject) error {
+	for _, i := range cmd.KeyIndexes(args) {
+		key := args[i].Value.(string)
+		if handler.Exists(key) {
+			continue
+		}
+		if err := b.load(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load fetches key from the store. Concurrent misses on the same key share
+// a single Load call, so a cold key doesn't stampede the store.
+func (b *backing) load(key string) error {
+	b.mu.Lock()
+	if b.isPending(key) {
+		// The store is behind us: a pending delete means the key is really gone.
+		b.mu.Unlock()
+		return nil
+	}
+	if call, ok := b.loading[key]; ok {
+		b.mu.Unlock()
+		<-call.done
+		return call.err
+	}
+	call := &loadCall{done: make(chan struct{})}
+	b.loading[key] = call
+	b.mu.Unlock()
+
+	call.err = b.fetch(key)
+
+	b.mu.Lock()
+	delete(b.loading, key)
+	b.mu.Unlock()
+	close(call.done)
+	return call.err
+}
+
+func (b *backing) fetch(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	defer cancel()
+	value, ttl, found, err := b.store.Load(ctx, key)
+	if err != nil {
+		b.errors.Add(1)
+		return fmt.Errorf("backing store: %v", err)
+	}
+	if !found {
+		b.misses.Add(1)
+		return nil
+	}
+	b.loads.Add(1)
+	// A write that landed while loading wins over the stored value.
+	if !handler.Exists(key) {
+		var expiresAt time.Time
+		if ttl > 0 {
+			expiresAt = time.Now().Add(ttl)
+		}
+		handler.SetString(key, value, expiresAt)
+	}
+	return nil
+}
+
+// writeBehind queues the current state of the keys of a write command that
+// just ran. Repeated writes to a key before the next flush are coalesced.
+func (b *backing) writeBehind(cmd *handler.Command, args []protocol.RESPObject) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, i := range cmd.KeyIndexes(args) {
+		key := args[i].Value.(string)
+		if v, ok := handler.PeekString(key); ok {
+			b.pending[key] = pendingWrite{value: v.Data, expiresAt: v.ExpiresAt}
+		} else if !handler.KeyExists(key) {
+			b.pending[key] = pendingWrite{deleted: true}
+		}
+	}
+}
+
+func (s *Server) writeBehindLoop() {
+	defer s.acceptWg.Done()
+	ticker := time.NewTicker(s.backing.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.backing.flush(context.Background())
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// flush writes every pending key to the store. Failed writes are retried on
+// the next flush unless the key was written again in the meantime.
+func (b *backing) flush(ctx context.Context) {
+	b.mu.Lock()
+	batch := b.pending
+	if len(batch) == 0 {
+		b.mu.Unlock()
+		return
+	}
+	b.pending = make(map[string]pendingWrite)
+	b.flushing = batch
+	b.mu.Unlock()
+
+	keys := make([]string, 0, len(batch))
+	for key := range batch {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var failed []string
+	var lastErr error
+	for _, key := range keys {
+		if err := b.write(ctx, key, batch[key]); err != nil {
+			b.errors.Add(1)
+			failed = append(failed, key)
+			lastErr = err
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flushing = nil
+	if len(failed) == 0 {
+		return
+	}
+	log.Printf("Write-behind failed for %d keys: %v", len(failed), lastErr)
+	for _, key := range failed {
+		if _, ok := b.pending[key]; !ok {
+			b.pending[key] = batch[key]
+		}
+	}
+}
+
+// isPending reports whether key has writes not yet acknowledged by the
+// store. b.mu must be held.
+func (b *backing) isPending(key string) bool {
+	if _, ok := b.pending[key]; ok {
+		return true
+	}
+	_, ok := b.flushing[key]
+	return ok
+}
+
+func (b *backing) write(ctx context.Context, key string, w pendingWrite) error {
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+	var ttl time.Duration
+	if !w.expiresAt.IsZero() {
+		ttl = time.Until(w.expiresAt)
+		if ttl <= 0 {
+			w.deleted = true
+		}
+	}
+	if w.deleted {
+		return b.store.Delete(ctx, key)
+	}
+	return b.store.Store(ctx, key, w.value, ttl)
+}
+
+func (b *backing) pendingLen() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.pending)
+}
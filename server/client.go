@@ -0,0 +1,386 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/handler"
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+var nextClientID int64
+
+var errClientClosed = errors.New("client closed")
+
+// client is the per-connection state. HTTP and gRPC requests get a throwaway
+// client that is never registered with the server.
+type client struct {
+	id        int64
+	conn      net.Conn
+	addr      string
+	laddr     string
+	resp      atomic.Int32 // protocol version, set by HELLO
+	createdAt time.Time
+	// ctx is canceled when the client disconnects or is killed, or the
+	// server shuts down. Blocking commands wait on it, see blockingContext.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Only touched by the goroutine serving the connection.
+	authenticated bool
+	tenant        *tenant
+	aofOffset     int64 // AOF offset after the client's last write
+	multi         bool
+	multiFailed   bool
+	queued        []queuedCommand
+	queuedMem     int64 // objectSize of queued
+
+	// Set by handleConnection; gateway clients have no writer and can't
+	// subscribe. out is the push queue, see startPush.
+	w      *protocol.Writer
+	out    chan protocol.RESPObject
+	closed chan struct{}
+	// Guarded by Server.pubsub.mu, see subscribed.
+	subs  map[string]struct{}
+	psubs map[string]struct{}
+
+	// See evictClients.
+	queryMem atomic.Int64
+	outMem   atomic.Int64
+	noEvict  atomic.Bool
+
+	mu         sync.Mutex
+	name       string
+	libName    string // from CLIENT SETINFO
+	libVer     string
+	lastCmd    string
+	lastActive time.Time
+}
+
+func newClient(parent context.Context, conn net.Conn, addr string) *client {
+	now := time.Now()
+	ctx, cancel := context.WithCancel(parent)
+	var laddr string
+	if conn != nil {
+		laddr = addrString(conn.LocalAddr())
+	}
+	c := &client{
+		id:         atomic.AddInt64(&nextClientID, 1),
+		ctx:        ctx,
+		cancel:     cancel,
+		conn:       conn,
+		addr:       addr,
+		laddr:      laddr,
+		createdAt:  now,
+		lastActive: now,
+		subs:       map[string]struct{}{},
+		psubs:      map[string]struct{}{},
+	}
+	c.resp.Store(2)
+	return c
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+func (c *client) touch(cmd string) {
+	c.mu.Lock()
+	c.lastCmd = cmd
+	c.lastActive = time.Now()
+	c.mu.Unlock()
+}
+
+func (s *Server) trackClient(c *client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return false
+	}
+	s.conns[c.conn] = c
+	s.stats.connectionsReceived.Add(1)
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrackClient(c *client) {
+	c.cancel()
+	s.mu.Lock()
+	delete(s.conns, c.conn)
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
+// kill interrupts whatever c is blocked on and disconnects it. A client
+// killing itself is disconnected by handleConnection once the reply is
+// sent.
+func (c *client) kill(self bool) {
+	c.cancel()
+	if !self && c.conn != nil {
+		c.conn.Close()
+	}
+}
+
+// blockingContext returns the context a blocking command of c waits on:
+// it ends when c is killed or disconnects, or when the server shuts down,
+// which also covers gateway clients the server doesn't track.
+func (s *Server) blockingContext(c *client) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(c.ctx)
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+func (s *Server) clientCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// clients returns the connected clients ordered by id.
+func (s *Server) clients() []*client {
+	s.mu.Lock()
+	clients := make([]*client, 0, len(s.conns))
+	for _, cl := range s.conns {
+		clients = append(clients, cl)
+	}
+	s.mu.Unlock()
+	sort.Slice(clients, func(i, j int) bool { return clients[i].id < clients[j].id })
+	return clients
+}
+
+// clientKill implements both CLIENT KILL addr:port, which replies OK, and
+// CLIENT KILL [ID id] [TYPE type] [ADDR addr] [LADDR addr] [USER name] [SKIPME yes|no],
+// which replies with the number of clients killed. Filters are ANDed.
+func (s *Server) clientKill(c *client, args []protocol.RESPObject) protocol.RESPObject {
+	if len(args) == 1 {
+		addr := args[0].Value.(string)
+		for _, cl := range s.clients() {
+			if cl.addr == addr {
+				cl.kill(cl == c)
+				return protocol.RESPObject{Type: protocol.SimpleString, Value: "OK"}
+			}
+		}
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR No such client"}
+	}
+	if len(args)%2 != 0 {
+		return protocol.RESPObject{Type: protocol.Error, Value: handler.ErrSyntax}
+	}
+
+	var filters []func(cl *client) bool
+	skipMe := true
+	for i := 0; i < len(args); i += 2 {
+		v := args[i+1].Value.(string)
+		switch strings.ToUpper(args[i].Value.(string)) {
+		case "ID":
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || id <= 0 {
+				return protocol.RESPObject{Type: protocol.Error, Value: "ERR client-id should be greater than 0"}
+			}
+			filters = append(filters, func(cl *client) bool { return cl.id == id })
+		case "TYPE":
+			typ := strings.ToLower(v)
+			if !validClientType(typ) {
+				return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf("ERR Unknown client type '%s'", v)}
+			}
+			filters = append(filters, func(cl *client) bool { return s.clientType(cl) == typ })
+		case "ADDR":
+			filters = append(filters, func(cl *client) bool { return cl.addr == v })
+		case "LADDR":
+			filters = append(filters, func(cl *client) bool { return cl.conn != nil && cl.laddr == v })
+		case "USER":
+			filters = append(filters, func(cl *client) bool { return cl.user() == v })
+		case "SKIPME":
+			switch strings.ToLower(v) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return protocol.RESPObject{Type: protocol.Error, Value: handler.ErrSyntax}
+			}
+		default:
+			return protocol.RESPObject{Type: protocol.Error, Value: handler.ErrSyntax}
+		}
+	}
+
+	killed := 0
+	for _, cl := range s.clients() {
+		if skipMe && cl == c {
+			continue
+		}
+		match := true
+		for _, f := range filters {
+			match = match && f(cl)
+		}
+		if match {
+			cl.kill(cl == c)
+			killed++
+		}
+	}
+	return protocol.RESPObject{Type: protocol.Integer, Value: killed}
+}
+
+func (c *client) user() string {
+	if c.tenant != nil {
+		return c.tenant.Name
+	}
+	return "default"
+}
+
+// clientInfo formats the client like a line of CLIENT LIST.
+func (s *Server) clientInfo(c *client) string {
+	c.mu.Lock()
+	name, libName, libVer, lastCmd, lastActive := c.name, c.libName, c.libVer, c.lastCmd, c.lastActive
+	c.mu.Unlock()
+	s.pubsub.mu.Lock()
+	sub, psub := len(c.subs), len(c.psubs)
+	s.pubsub.mu.Unlock()
+
+	flags := "N"
+	if sub+psub > 0 {
+		flags = "P"
+	}
+	if c.noEvict.Load() {
+		flags += "e"
+	}
+	if lastCmd == "" {
+		lastCmd = "NULL"
+	}
+	qbuf, omem := c.queryMem.Load(), c.outMem.Load()
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=0 sub=%d psub=%d qbuf=%d omem=%d tot-mem=%d cmd=%s user=%s resp=%d lib-name=%s lib-ver=%s",
+		c.id, c.addr, c.laddr, name, int64(time.Since(c.createdAt).Seconds()), int64(time.Since(lastActive).Seconds()),
+		flags, sub, psub, qbuf, omem, qbuf+omem, lastCmd, c.user(), c.resp.Load(), libName, libVer)
+}
+
+// clientType returns the type CLIENT LIST TYPE and CLIENT KILL TYPE filter
+// on. There is no replication, so no client is a master or a replica.
+func (s *Server) clientType(c *client) string {
+	s.pubsub.mu.Lock()
+	defer s.pubsub.mu.Unlock()
+	if len(c.subs)+len(c.psubs) > 0 {
+		return "pubsub"
+	}
+	return "normal"
+}
+
+func validClientType(typ string) bool {
+	switch typ {
+	case "normal", "master", "replica", "slave", "pubsub":
+		return true
+	}
+	return false
+}
+
+// validClientInfo reports whether v can be shown in CLIENT LIST, which
+// separates fields with spaces.
+func validClientInfo(v string) bool {
+	return strings.IndexFunc(v, func(r rune) bool { return r <= ' ' || r > '~' }) < 0
+}
+
+// setInfo implements CLIENT SETINFO LIB-NAME name | LIB-VER version.
+func (c *client) setInfo(attr, v string) protocol.RESPObject {
+	attr = strings.ToLower(attr)
+	if attr != "lib-name" && attr != "lib-ver" {
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf("ERR Unrecognized option '%s'", attr)}
+	}
+	if !validClientInfo(v) {
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf("ERR %s cannot contain spaces, newlines or special characters.", attr)}
+	}
+	c.mu.Lock()
+	if attr == "lib-name" {
+		c.libName = v
+	} else {
+		c.libVer = v
+	}
+	c.mu.Unlock()
+	return protocol.RESPObject{Type: protocol.SimpleString, Value: "OK"}
+}
+
+// CLIENT ID | INFO | LIST | GETNAME | SETNAME name | SETINFO attr value |
+// NO-EVICT on|off | KILL filter...
+func (s *Server) clientCommand(c *client, args []protocol.RESPObject) protocol.RESPObject {
+	sub := strings.ToUpper(args[0].Value.(string))
+	// Negative arities are minimums, as in the command table.
+	arity := map[string]int{"ID": 1, "INFO": 1, "LIST": -1, "GETNAME": 1, "SETNAME": 2, "SETINFO": 3, "NO-EVICT": 2, "KILL": -2}
+	n, ok := arity[sub]
+	if !ok {
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(handler.ErrUnknownSubcommand, args[0].Value, "CLIENT")}
+	}
+	if (n > 0 && len(args) != n) || (n < 0 && len(args) < -n) {
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(handler.ErrWrongArgCount, "client|"+strings.ToLower(sub))}
+	}
+
+	switch sub {
+	case "ID":
+		return protocol.RESPObject{Type: protocol.Integer, Value: c.id}
+	case "INFO":
+		return protocol.RESPObject{Type: protocol.BulkString, Value: s.clientInfo(c) + "\n"}
+	case "KILL":
+		return s.clientKill(c, args[1:])
+	case "SETINFO":
+		return c.setInfo(args[1].Value.(string), args[2].Value.(string))
+	case "NO-EVICT":
+		switch strings.ToLower(args[1].Value.(string)) {
+		case "on":
+			c.noEvict.Store(true)
+		case "off":
+			c.noEvict.Store(false)
+		default:
+			return protocol.RESPObject{Type: protocol.Error, Value: handler.ErrSyntax}
+		}
+		return protocol.RESPObject{Type: protocol.SimpleString, Value: "OK"}
+	case "LIST":
+		var typ string
+		switch {
+		case len(args) == 3 && strings.EqualFold(args[1].Value.(string), "TYPE"):
+			typ = strings.ToLower(args[2].Value.(string))
+			if !validClientType(typ) {
+				return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf("ERR Unknown client type '%s'", args[2].Value)}
+			}
+		case len(args) != 1:
+			return protocol.RESPObject{Type: protocol.Error, Value: handler.ErrSyntax}
+		}
+		var b strings.Builder
+		for _, cl := range s.clients() {
+			if typ != "" && s.clientType(cl) != typ {
+				continue
+			}
+			b.WriteString(s.clientInfo(cl))
+			b.WriteString("\n")
+		}
+		return protocol.RESPObject{Type: protocol.BulkString, Value: b.String()}
+	case "GETNAME":
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.name == "" {
+			return protocol.RESPObject{Type: protocol.BulkString}
+		}
+		return protocol.RESPObject{Type: protocol.BulkString, Value: c.name}
+	default: // SETNAME
+		name := args[1].Value.(string)
+		if !validClientInfo(name) {
+			return protocol.RESPObject{Type: protocol.Error, Value: "ERR Client names cannot contain spaces, newlines or special characters."}
+		}
+		c.mu.Lock()
+		c.name = name
+		c.mu.Unlock()
+		return protocol.RESPObject{Type: protocol.SimpleString, Value: "OK"}
+	}
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+// A client's memory is what it holds on to beyond its fixed read and write
+// buffers: the request being executed and the commands queued by MULTI, and
+// the replies not yet written. Once the total across clients exceeds
+// maxmemory-clients, clients are disconnected in the order of
+// client-eviction-policy: those using the most first, or those idle the
+// longest.
+
+// Client eviction policies, by their index in clientEvictionPolicies.
+const (
+	evictLargest int32 = iota
+	evictIdle
+)
+
+var clientEvictionPolicies = []string{"largest", "idle"}
+
+func parseClientEvictionPolicy(v string) (int32, error) {
+	for i, name := range clientEvictionPolicies {
+		if strings.EqualFold(v, name) {
+			return int32(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid client eviction policy %q, must be largest or idle", v)
+}
+
+// objectSize estimates the memory held by obj.
+func objectSize(obj protocol.RESPObject) int64 {
+	switch v := obj.Value.(type) {
+	case string:
+		return int64(len(v))
+	case []byte:
+		return int64(len(v))
+	case []protocol.RESPObject:
+		n := int64(0)
+		for _, e := range v {
+			n += objectSize(e) + 16
+		}
+		return n
+	}
+	return 8
+}
+
+// errOutputLimit stops rendering a reply that took a client's output past
+// client-output-buffer-limit.
+var errOutputLimit = errors.New("client output buffer limit reached")
+
+// render encodes a streamed reply for c as it is produced, counting it as
+// c's output as it grows. Once that is over client-output-buffer-limit, the
+// rest of the reply is never produced and c is disconnected after an error
+// reply, as Redis does. It is used where a reply can't go straight to the
+// connection: in EXEC, which must produce each reply before the next command
+// runs, and once replies are queued behind pushed messages. Clients without
+// a RESP connection, those of the gateways, get the elements instead.
+func (s *Server) render(c *client, reply protocol.RESPObject) protocol.RESPObject {
+	if reply.Type != protocol.Stream {
+		return reply
+	}
+	if c.w == nil {
+		return protocol.Collect(reply)
+	}
+	limit := s.outputLimit.Load()
+	var size int64
+	defer func() { c.outMem.Add(-size) }()
+	out, err := protocol.Render(reply, c.resp.Load() == 3, func(n int) error {
+		size += int64(n)
+		if mem := c.outMem.Add(int64(n)); limit > 0 && mem > limit {
+			return errOutputLimit
+		}
+		return nil
+	})
+	if err != nil {
+		s.outputLimitReached(c, limit)
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR reply exceeds client-output-buffer-limit"}
+	}
+	return out
+}
+
+// writeStream writes a streamed reply straight to c's connection as it is
+// produced, so it is never held in full. The part waiting in the
+// connection's buffer counts as c's output, which is checked against
+// client-output-buffer-limit after every element. Past the limit, the reply
+// is cut short and c disconnected, as there is no sending an error reply in
+// the middle of one.
+func (s *Server) writeStream(c *client, reply protocol.RESPObject) error {
+	limit := s.outputLimit.Load()
+	var held int64
+	defer func() { c.outMem.Add(-held) }()
+	err := c.w.WriteStream(reply, func(n int) error {
+		mem := c.outMem.Add(int64(n) - held)
+		held = int64(n)
+		if limit > 0 && mem > limit {
+			return errOutputLimit
+		}
+		return nil
+	})
+	if errors.Is(err, errOutputLimit) {
+		s.outputLimitReached(c, limit)
+	}
+	return err
+}
+
+func (s *Server) outputLimitReached(c *client, limit int64) {
+	log.Printf("Closing client %s: reply over client-output-buffer-limit of %d bytes", c.addr, limit)
+	s.stats.outputLimitClients.Add(1)
+	c.kill(true)
+}
+
+func (c *client) memory() int64 {
+	return c.queryMem.Load() + c.outMem.Load()
+}
+
+// clientMemory returns the memory held by every connected client.
+func (s *Server) clientMemory() int64 {
+	var total int64
+	for _, c := range s.clients() {
+		total += c.memory()
+	}
+	return total
+}
+
+// evictClients disconnects clients holding memory, in the order of the
+// eviction policy, except those that turned on CLIENT NO-EVICT, until the
+// total is within the limit.
+func (s *Server) evictClients() {
+	limit := s.maxmemoryClients.Load()
+	if limit <= 0 {
+		return
+	}
+	type usage struct {
+		c          *client
+		mem        int64
+		lastActive time.Time
+	}
+	var clients []usage
+	var total int64
+	for _, c := range s.clients() {
+		mem := c.memory()
+		c.mu.Lock()
+		lastActive := c.lastActive
+		c.mu.Unlock()
+		clients = append(clients, usage{c, mem, lastActive})
+		total += mem
+	}
+	if total <= limit {
+		return
+	}
+	if s.clientEviction.Load() == evictIdle {
+		sort.Slice(clients, func(i, j int) bool { return clients[i].lastActive.Before(clients[j].lastActive) })
+	} else {
+		sort.Slice(clients, func(i, j int) bool { return clients[i].mem > clients[j].mem })
+	}
+	for _, u := range clients {
+		if total <= limit {
+			return
+		}
+		// Disconnecting a client that holds nothing frees nothing.
+		if u.mem == 0 || u.c.noEvict.Load() {
+			continue
+		}
+		log.Printf("Evicting client %s: %d bytes of client memory, maxmemory-clients is %d", u.c.addr, u.mem, limit)
+		u.c.kill(false)
+		total -= u.mem
+		s.stats.evictedClients.Add(1)
+	}
+}
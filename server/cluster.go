@@ -0,0 +1,58 @@
+package server
+
+import "strings"
+
+const (
+	clusterSlots = 16384
+	errCrossSlot = "CROSSSLOT Keys in request don't hash to the same slot"
+)
+
+// keyHashSlot returns the cluster slot of key. As in Redis, only the part
+// between the first { and the following } is hashed if it isn't empty, so
+// related keys can be forced into one slot with a hash tag like {user1}.
+func keyHashSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) % clusterSlots
+}
+
+// crc16 is CRC16-CCITT (XMODEM), the checksum Redis Cluster uses.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// crossSlot reports whether the keys of cmds, found through the key
+// positions of the command table, don't all hash to the same slot. It only
+// applies in cluster mode.
+func (s *Server) crossSlot(cmds []queuedCommand) bool {
+	if !s.cfg.ClusterEnabled {
+		return false
+	}
+	slot := -1
+	for _, q := range cmds {
+		args := q.argv[1:]
+		for _, i := range q.cmd.KeyIndexes(args) {
+			switch n := keyHashSlot(args[i].Value.(string)); {
+			case slot < 0:
+				slot = n
+			case n != slot:
+				return true
+			}
+		}
+	}
+	return false
+}
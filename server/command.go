@@ -0,0 +1,134 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/ashish-kamra/redis-clone/internal/handler"
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+// Command is a custom command registered by an embedder. Arity counts the
+// command name and is negative for "at least". Flags use the Redis names;
+// "write" commands are propagated to the AOF when they succeed and
+// "readonly" ones are not.
+type Command struct {
+	Name     string
+	Arity    int
+	Flags    []string
+	FirstKey int
+	LastKey  int
+	KeyStep  int
+	Handler  func(db *DB, args Args) (interface{}, error)
+}
+
+// Args are the command arguments, excluding the command name.
+type Args []string
+
+func (a Args) Int(i int) (int64, error) {
+	n, err := strconv.ParseInt(a[i], 10, 64)
+	if err != nil {
+		return 0, errors.New(handler.ErrInvalidInt)
+	}
+	return n, nil
+}
+
+func (a Args) Float(i int) (float64, error) {
+	f, err := strconv.ParseFloat(a[i], 64)
+	if err != nil {
+		return 0, errors.New(handler.ErrInvalidFloat)
+	}
+	return f, nil
+}
+
+// Status is a simple string reply such as "OK", as opposed to a bulk string.
+type Status string
+
+// RegisterCommand makes cmd available to every Server in the process. Handler
+// return values map onto replies: nil is a null reply, string and []byte are
+// bulk strings, Status is a simple string, integers are integer replies and
+// slices become arrays. A non-nil error is sent as an error reply, prefixed
+// with "ERR" unless it already starts with an upper-case error code.
+func RegisterCommand(cmd Command) error {
+	if cmd.Handler == nil {
+		return fmt.Errorf("command %s: missing handler", cmd.Name)
+	}
+	fn := cmd.Handler
+	write := false
+	for _, f := range cmd.Flags {
+		write = write || f == "write"
+	}
+	return handler.Register(&handler.Command{
+		Name:     cmd.Name,
+		Arity:    cmd.Arity,
+		Flags:    cmd.Flags,
+		FirstKey: cmd.FirstKey,
+		LastKey:  cmd.LastKey,
+		KeyStep:  cmd.KeyStep,
+		Handler: func(args []protocol.RESPObject) protocol.RESPObject {
+			strs := make(Args, len(args))
+			for i, arg := range args {
+				strs[i] = fmt.Sprint(arg.Value)
+			}
+			reply, err := fn(&DB{}, strs)
+			if err != nil {
+				return errorReply(err)
+			}
+			if write {
+				// Handlers may mutate custom values in place, which the
+				// keyspace can't see, so assume a successful write changed it.
+				handler.MarkDirty()
+			}
+			return toRESP(reply)
+		},
+	})
+}
+
+func errorReply(err error) protocol.RESPObject {
+	msg := err.Error()
+	code, _, _ := strings.Cut(msg, " ")
+	if code == "" || strings.IndexFunc(code, func(r rune) bool { return !unicode.IsUpper(r) }) >= 0 {
+		msg = "ERR " + msg
+	}
+	return protocol.RESPObject{Type: protocol.Error, Value: msg}
+}
+
+func toRESP(v interface{}) protocol.RESPObject {
+	switch val := v.(type) {
+	case nil:
+		return protocol.RESPObject{Type: protocol.Null}
+	case Status:
+		return protocol.RESPObject{Type: protocol.SimpleString, Value: string(val)}
+	case string:
+		return protocol.RESPObject{Type: protocol.BulkString, Value: val}
+	case []byte:
+		return protocol.RESPObject{Type: protocol.BulkString, Value: string(val)}
+	case int:
+		return protocol.RESPObject{Type: protocol.Integer, Value: val}
+	case int64:
+		return protocol.RESPObject{Type: protocol.Integer, Value: val}
+	case bool:
+		if val {
+			return protocol.RESPObject{Type: protocol.Integer, Value: 1}
+		}
+		return protocol.RESPObject{Type: protocol.Integer, Value: 0}
+	case error:
+		return errorReply(val)
+	case []string:
+		values := make([]protocol.RESPObject, len(val))
+		for i, s := range val {
+			values[i] = protocol.RESPObject{Type: protocol.BulkString, Value: s}
+		}
+		return protocol.RESPObject{Type: protocol.Array, Value: values}
+	case []interface{}:
+		values := make([]protocol.RESPObject, len(val))
+		for i, item := range val {
+			values[i] = toRESP(item)
+		}
+		return protocol.RESPObject{Type: protocol.Array, Value: values}
+	}
+	return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf("ERR unsupported reply type %T", v)}
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/handler"
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+// configParam is a setting exposed through CONFIG GET/SET.
+type configParam struct {
+	get func(s *Server) string
+	set func(s *Server, v string) error
+}
+
+var configParams = map[string]configParam{
+	// Microseconds, like Redis: 0 logs every command, negative disables.
+	"slowlog-log-slower-than": {
+		get: func(s *Server) string {
+			d := time.Duration(s.slowlogThreshold.Load())
+			if d < 0 {
+				return "-1"
+			}
+			return strconv.FormatInt(d.Microseconds(), 10)
+		},
+		set: func(s *Server, v string) error {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return errors.New("argument must be a number")
+			}
+			s.slowlogThreshold.Store(int64(time.Duration(n) * time.Microsecond))
+			return nil
+		},
+	},
+	// Milliseconds, like Redis: 0 disables the latency monitor.
+	"latency-monitor-threshold": {
+		get: func(s *Server) string {
+			return strconv.FormatInt(time.Duration(s.latencyThreshold.Load()).Milliseconds(), 10)
+		},
+		set: func(s *Server, v string) error {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return errors.New("argument must be a non-negative number")
+			}
+			s.latencyThreshold.Store(int64(time.Duration(n) * time.Millisecond))
+			return nil
+		},
+	},
+	"dir": {
+		get: func(s *Server) string {
+			dir, err := filepath.Abs(filepath.Dir(s.cfg.RDBPath))
+			if err != nil {
+				return filepath.Dir(s.cfg.RDBPath)
+			}
+			return dir
+		},
+		set: func(s *Server, v string) error { return errors.New("can't set immutable config") },
+	},
+	// Empty when the server runs without an AOF or RDB file.
+	"appendfilename": {
+		get: func(s *Server) string { return persistenceFileName(s.cfg.AOFPath) },
+		set: func(s *Server, v string) error { return errors.New("can't set immutable config") },
+	},
+	"dbfilename": {
+		get: func(s *Server) string { return persistenceFileName(s.cfg.RDBPath) },
+		set: func(s *Server, v string) error { return errors.New("can't set immutable config") },
+	},
+	"databases": {
+		get: func(s *Server) string { return strconv.Itoa(s.cfg.Databases) },
+		set: func(s *Server, v string) error { return errors.New("can't set immutable config") },
+	},
+	"cluster-enabled": {
+		get: func(s *Server) string {
+			if s.cfg.ClusterEnabled {
+				return "yes"
+			}
+			return "no"
+		},
+		set: func(s *Server, v string) error { return errors.New("can't set immutable config") },
+	},
+	// Negative logs every error.
+	"error-log-burst": {
+		get: func(s *Server) string {
+			if n := s.errLog.burst.Load(); n >= 0 {
+				return strconv.FormatInt(n, 10)
+			}
+			return "-1"
+		},
+		set: func(s *Server, v string) error {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return errors.New("argument must be a number")
+			}
+			s.errLog.burst.Store(n)
+			return nil
+		},
+	},
+	// Bytes, 0 for no limit.
+	"maxmemory-clients": {
+		get: func(s *Server) string { return strconv.FormatInt(s.maxmemoryClients.Load(), 10) },
+		set: func(s *Server, v string) error {
+			n, err := parseNonNegative(v)
+			if err == nil {
+				s.maxmemoryClients.Store(n)
+			}
+			return err
+		},
+	},
+	// Only the hard limit of normal clients: "normal <hard> 0 0".
+	"client-output-buffer-limit": {
+		get: func(s *Server) string { return fmt.Sprintf("normal %d 0 0", s.outputLimit.Load()) },
+		set: func(s *Server, v string) error {
+			f := strings.Fields(v)
+			if len(f) != 4 || !strings.EqualFold(f[0], "normal") {
+				return errors.New("only the limit of the normal class can be set")
+			}
+			if f[2] != "0" || f[3] != "0" {
+				return errors.New("soft limits are not supported")
+			}
+			n, err := parseNonNegative(f[1])
+			if err == nil {
+				s.outputLimit.Store(n)
+			}
+			return err
+		},
+	},
+	"max-inflight-commands": {
+		get: func(s *Server) string { return strconv.FormatInt(s.maxInflight.Load(), 10) },
+		set: func(s *Server, v string) error {
+			n, err := parseNonNegative(v)
+			if err == nil {
+				s.maxInflight.Store(n)
+			}
+			return err
+		},
+	},
+	"client-eviction-policy": {
+		get: func(s *Server) string { return clientEvictionPolicies[s.clientEviction.Load()] },
+		set: func(s *Server, v string) error {
+			policy, err := parseClientEvictionPolicy(v)
+			if err == nil {
+				s.clientEviction.Store(policy)
+			}
+			return err
+		},
+	},
+	"hash-max-listpack-entries": {
+		get: func(s *Server) string { return strconv.FormatInt(handler.HashMaxListpackEntries(), 10) },
+		set: func(s *Server, v string) error {
+			n, err := parseNonNegative(v)
+			if err == nil {
+				handler.SetHashMaxListpackEntries(n)
+			}
+			return err
+		},
+	},
+	"hash-max-listpack-value": {
+		get: func(s *Server) string { return strconv.FormatInt(handler.HashMaxListpackValue(), 10) },
+		set: func(s *Server, v string) error {
+			n, err := parseNonNegative(v)
+			if err == nil {
+				handler.SetHashMaxListpackValue(n)
+			}
+			return err
+		},
+	},
+	"trace-sample-rate": {
+		get: func(s *Server) string { return strconv.FormatInt(s.traceSampleRate.Load(), 10) },
+		set: func(s *Server, v string) error {
+			n, err := parseNonNegative(v)
+			if err == nil {
+				s.traceSampleRate.Store(n)
+			}
+			return err
+		},
+	},
+}
+
+func persistenceFileName(path string) string {
+	if path == "" {
+		return ""
+	}
+	return filepath.Base(path)
+}
+
+func parseNonNegative(v string) (int64, error) {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("argument must be a non-negative number")
+	}
+	return n, nil
+}
+
+func (s *Server) config(c *client, args []protocol.RESPObject) protocol.RESPObject {
+	switch strings.ToUpper(args[0].Value.(string)) {
+	case "GET":
+		if len(args) < 2 {
+			return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(handler.ErrWrongArgCount, "config|get")}
+		}
+		names := make([]string, 0, len(configParams))
+		for name := range configParams {
+			for _, pattern := range args[1:] {
+				if handler.MatchPattern(strings.ToLower(pattern.Value.(string)), name) {
+					names = append(names, name)
+					break
+				}
+			}
+		}
+		sort.Strings(names)
+		values := make([]protocol.RESPObject, 0, 2*len(names))
+		for _, name := range names {
+			values = append(values,
+				protocol.RESPObject{Type: protocol.BulkString, Value: name},
+				protocol.RESPObject{Type: protocol.BulkString, Value: configParams[name].get(s)})
+		}
+		return protocol.RESPObject{Type: protocol.Array, Value: values}
+	case "SET":
+		if len(args) < 3 || len(args)%2 == 0 {
+			return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(handler.ErrWrongArgCount, "config|set")}
+		}
+		// Validate everything first so a bad pair doesn't leave a partial update.
+		for i := 1; i < len(args); i += 2 {
+			if _, ok := configParams[strings.ToLower(args[i].Value.(string))]; !ok {
+				return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", args[i].Value)}
+			}
+		}
+		for i := 1; i < len(args); i += 2 {
+			name := strings.ToLower(args[i].Value.(string))
+			if err := configParams[name].set(s, args[i+1].Value.(string)); err != nil {
+				return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - %v", name, err)}
+			}
+		}
+		return protocol.RESPObject{Type: protocol.SimpleString, Value: "OK"}
+	default:
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(handler.ErrUnknownSubcommand, args[0].Value, "CONFIG")}
+	}
+}
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/aof"
+	"github.com/ashish-kamra/redis-clone/internal/failpoint"
+	"github.com/ashish-kamra/redis-clone/internal/handler"
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+func (s *Server) handleConnection(c *client) {
+	defer c.conn.Close()
+	reader := protocol.NewReader(c.conn)
+	c.w = protocol.NewWriter(c.conn)
+	c.closed = make(chan struct{})
+	defer close(c.closed)
+	defer s.unsubscribeAll(c)
+
+	var requestID string
+	for {
+		respObject, err := reader.Deserialize()
+		if err != nil {
+			if errors.Is(err, io.EOF) || s.isClosing() {
+				s.errLog.printf("connection closed", "Connection closed %v", c.addr)
+			} else {
+				s.errLog.printf("read error", "Error reading message: %v", err)
+			}
+			return
+		}
+		if respObject.Type == protocol.Attribute {
+			// It tags the command that follows, which gets no reply of its own.
+			requestID = attributeRequestID(respObject)
+			continue
+		}
+		ctx := commandContext(c, requestID)
+		requestID = ""
+
+		c.queryMem.Store(c.queuedMem + objectSize(respObject))
+		result := s.processCommand(ctx, c, respObject)
+		c.queryMem.Store(c.queuedMem)
+		if result.Type == protocol.Stream {
+			err = s.writeStream(c, result)
+		} else {
+			err = c.reply(result)
+		}
+		if err != nil {
+			s.errLog.printf("write error", "Error writing response: %v", err)
+			return
+		}
+		if c.ctx.Err() != nil {
+			// Killed, possibly by its own CLIENT KILL.
+			return
+		}
+	}
+}
+
+// processCommand runs a command for c under ctx, a commandContext.
+func (s *Server) processCommand(ctx context.Context, c *client, respObject protocol.RESPObject) protocol.RESPObject {
+	if respObject.Type != protocol.Array {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR Protocol error: expected array of bulk strings"}
+	}
+
+	respObjectVal := respObject.Value.([]protocol.RESPObject)
+	if len(respObjectVal) == 0 {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR Protocol error: invalid multibulk length"}
+	}
+
+	for _, arg := range respObjectVal {
+		if _, ok := arg.Value.(string); !ok {
+			return protocol.RESPObject{Type: protocol.Error, Value: "ERR Protocol error: expected bulk string arguments"}
+		}
+	}
+
+	command := strings.ToUpper(respObjectVal[0].Value.(string))
+	args := respObjectVal[1:]
+
+	cmd, ok := handler.Lookup(command)
+	if !ok {
+		c.flagMultiError()
+		return unknownCommand(respObjectVal)
+	}
+	if !cmd.CheckArity(len(args)) {
+		c.flagMultiError()
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(handler.ErrWrongArgCount, cmd.Name)}
+	}
+	if s.cfg.RequirePass != "" && !c.authenticated && !cmd.HasFlag("no-auth") {
+		c.flagMultiError()
+		return protocol.RESPObject{Type: protocol.Error, Value: "NOAUTH Authentication required."}
+	}
+	if errReply := s.stateReply(cmd); errReply != nil {
+		c.flagMultiError()
+		return *errReply
+	}
+	if s.crossSlot([]queuedCommand{{name: command, cmd: cmd, argv: respObjectVal}}) {
+		c.flagMultiError()
+		return protocol.RESPObject{Type: protocol.Error, Value: errCrossSlot}
+	}
+	if c.tenant != nil {
+		scoped, errReply := s.scopeToTenant(c.tenant, command, cmd, respObjectVal)
+		if errReply != nil {
+			c.flagMultiError()
+			return *errReply
+		}
+		respObjectVal, args = scoped, scoped[1:]
+	}
+	if reply, ok := pubsubModeReply(c, command, cmd, args); ok {
+		return reply
+	}
+	if c.multi && command != "EXEC" && command != "DISCARD" && command != "MULTI" {
+		c.queued = append(c.queued, queuedCommand{name: command, cmd: cmd, argv: respObjectVal, ctx: ctx})
+		c.queuedMem += objectSize(respObject)
+		return protocol.RESPObject{Type: protocol.SimpleString, Value: "QUEUED"}
+	}
+
+	if !s.admit(cmd) {
+		return protocol.RESPObject{Type: protocol.Error, Value: errBusy}
+	}
+	defer s.release()
+	c.touch(cmd.Name)
+	// Write commands run one at a time so the AOF records them in the order
+	// they were applied, and only once they succeeded and changed the dataset.
+	write := cmd.HasFlag("write")
+	if write {
+		s.lockWrites()
+	}
+	start := time.Now()
+	dirty := handler.Dirty()
+	result := s.execute(c, command, cmd, args)
+	d := time.Since(start)
+	if write {
+		if result.Type != protocol.Error && handler.Dirty() != dirty {
+			s.propagate(c, []queuedCommand{{name: command, cmd: cmd, argv: absoluteExpiry(command, respObjectVal, start), ctx: ctx}})
+		}
+		s.writeMu.Unlock()
+	}
+	s.recordCommand(ctx, c, cmd, respObjectVal, result, d)
+	if c.w == nil || c.out != nil {
+		// Otherwise handleConnection writes a streamed reply as it is produced.
+		result = s.render(c, result)
+	}
+
+	if c.tenant != nil {
+		result = unscopeReply(c.tenant, command, result)
+	}
+	return result
+}
+
+// stateReply refuses cmd while the dataset is loading, unless it is flagged
+// "loading", and refuses writes once the server is shutting down, as they
+// could no longer reach the AOF.
+func (s *Server) stateReply(cmd *handler.Command) *protocol.RESPObject {
+	switch s.state.Load() {
+	case stateLoading:
+		if !cmd.HasFlag("loading") {
+			return &protocol.RESPObject{Type: protocol.Error, Value: "LOADING Redis is loading the dataset in memory"}
+		}
+	case stateShuttingDown:
+		if cmd.HasFlag("write") {
+			return &protocol.RESPObject{Type: protocol.Error, Value: "ERR Server is shutting down"}
+		}
+	}
+	return nil
+}
+
+func (s *Server) execute(c *client, command string, cmd *handler.Command, args []protocol.RESPObject) protocol.RESPObject {
+	if err := s.evalCommandFailpoint(command); err != nil {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR " + err.Error()}
+	}
+	if err := s.readThrough(cmd, args); err != nil {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR " + err.Error()}
+	}
+	if s.aof != nil && cmd.HasFlag("write") {
+		s.expireWriteKeys(cmd, args)
+	}
+	if fn, ok := serverCommands[command]; ok {
+		return fn(s, c, args)
+	}
+	return cmd.Handler(args)
+}
+
+// propagate forwards write commands that modified the dataset to the AOF
+// and the backing store, wrapping several in MULTI ... EXEC so they are
+// replayed atomically. s.writeMu must be held.
+func (s *Server) propagate(c *client, cmds []queuedCommand) {
+	if len(cmds) == 0 {
+		return
+	}
+	if s.aof != nil {
+		objs := make([]protocol.RESPObject, 0, len(cmds)+2)
+		for _, q := range cmds {
+			objs = append(objs, protocol.RESPObject{Type: protocol.Array, Value: q.argv})
+		}
+		if len(cmds) > 1 {
+			objs = append(append([]protocol.RESPObject{commandObject("MULTI")}, objs...), commandObject("EXEC"))
+		}
+		if err := s.aof.Write(objs...); err != nil {
+			log.Printf("Error writing to AOF: %v %s", err, requestFromContext(cmds[0].ctx).logfmt())
+		}
+		c.aofOffset = s.aof.Offset()
+	}
+	if s.backing != nil {
+		for _, q := range cmds {
+			s.backing.writeBehind(q.cmd, q.argv[1:])
+		}
+	}
+}
+
+// unknownCommand builds Redis' reply for an unknown command, which quotes
+// the name and the start of the arguments, up to about 128 bytes.
+func unknownCommand(argv []protocol.RESPObject) protocol.RESPObject {
+	var quoted strings.Builder
+	for _, arg := range argv[1:] {
+		if quoted.Len() >= 128 {
+			break
+		}
+		v := arg.Value.(string)
+		if n := 128 - quoted.Len(); len(v) > n {
+			v = v[:n]
+		}
+		fmt.Fprintf(&quoted, "'%s' ", v)
+	}
+	return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf("ERR unknown command '%.128s', with args beginning with: %s", argv[0].Value, quoted.String())}
+}
+
+func commandObject(args ...string) protocol.RESPObject {
+	argv := make([]protocol.RESPObject, len(args))
+	for i, arg := range args {
+		argv[i] = protocol.RESPObject{Type: protocol.BulkString, Value: arg}
+	}
+	return protocol.RESPObject{Type: protocol.Array, Value: argv}
+}
+
+// evalCommandFailpoint exempts DEBUG, so a failpoint can always be disarmed.
+func (s *Server) evalCommandFailpoint(command string) error {
+	if command == "DEBUG" {
+		return nil
+	}
+	return failpoint.Eval(failpoint.CommandExec)
+}
+
+func (s *Server) readThrough(cmd *handler.Command, args []protocol.RESPObject) error {
+	if s.backing == nil || !cmd.HasFlag("readonly") {
+		return nil
+	}
+	return s.backing.readThrough(cmd, args)
+}
+
+func (s *Server) recordCommand(ctx context.Context, c *client, cmd *handler.Command, argv []protocol.RESPObject, result protocol.RESPObject, d time.Duration) {
+	s.stats.commandsProcessed.Add(1)
+	ri := requestFromContext(ctx)
+	s.trace(ri, c, cmd, argv, result, d)
+	if threshold := time.Duration(s.slowlogThreshold.Load()); threshold < 0 || d < threshold || cmd.HasFlag("skip-slowlog") {
+		return
+	}
+	args := make([]string, len(argv))
+	for i, arg := range argv {
+		args[i] = fmt.Sprint(arg.Value)
+	}
+	s.slowlog.add(args, d, c, ri.requestID)
+}
+
+func (s *Server) rebuildCacheFromAOF() {
+	err := s.aof.Read(func(respObject protocol.RESPObject) {
+		command := strings.ToUpper(respObject.Value.([]protocol.RESPObject)[0].Value.(string))
+		args := respObject.Value.([]protocol.RESPObject)[1:]
+		cmd, ok := handler.Lookup(command)
+		if !ok {
+			log.Printf("Unknown command in AOF: %s", command)
+			return
+		}
+		cmd.Handler(args)
+	})
+	var incomplete *aof.IncompleteTailError
+	if errors.As(err, &incomplete) {
+		log.Printf("%v, truncating", err)
+		err = s.aof.Truncate(incomplete.Offset)
+	}
+	if err != nil {
+		log.Printf("Error rebuilding cache from AOF: %v", err)
+	}
+}
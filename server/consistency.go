@@ -0,0 +1,135 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/aof"
+	"github.com/ashish-kamra/redis-clone/internal/handler"
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+// ttlTolerance is how far apart the two files may put a key's expiry, as
+// an AOF written before TTLs were logged as absolute times replays them
+// relative to now.
+const ttlTolerance = time.Second
+
+// CheckConsistency loads the AOF at aofPath and the RDB file at rdbPath one
+// after the other into the keyspace, which is left empty, and writes a line
+// to w for every key that is missing from one of them or differs in type,
+// value or TTL. It returns the number of such keys. Run it before a server
+// is started in the same process.
+func CheckConsistency(aofPath, rdbPath string, w io.Writer) (int, error) {
+	for _, path := range []string{aofPath, rdbPath} {
+		if _, err := os.Stat(path); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := replayAOF(aofPath); err != nil {
+		return 0, fmt.Errorf("failed to replay AOF: %w", err)
+	}
+	fromAOF := snapshotEntries()
+	handler.Flush(false)
+	if err := loadRDB(rdbPath); err != nil {
+		return 0, err
+	}
+	fromRDB := snapshotEntries()
+	handler.Flush(false)
+
+	keys := make([]string, 0, len(fromAOF))
+	for key := range fromAOF {
+		keys = append(keys, key)
+	}
+	for key := range fromRDB {
+		if _, ok := fromAOF[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	diverged := 0
+	for _, key := range keys {
+		if msg := entryDiff(fromAOF[key], fromRDB[key]); msg != "" {
+			fmt.Fprintf(w, "%q: %s\n", key, msg)
+			diverged++
+		}
+	}
+	fmt.Fprintf(w, "%d keys in the AOF, %d in the RDB, %d differ\n", len(fromAOF), len(fromRDB), diverged)
+	return diverged, nil
+}
+
+// replayAOF runs every command of the AOF at path against the keyspace,
+// skipping a torn last record or a trailing MULTI that was never committed.
+func replayAOF(path string) error {
+	f, err := aof.NewAof(path, true)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = f.Read(func(obj protocol.RESPObject) {
+		argv := obj.Value.([]protocol.RESPObject)
+		if cmd, ok := handler.Lookup(argv[0].Value.(string)); ok {
+			cmd.Handler(argv[1:])
+		}
+	})
+	var incomplete *aof.IncompleteTailError
+	if errors.As(err, &incomplete) {
+		return nil
+	}
+	return err
+}
+
+func snapshotEntries() map[string]handler.Entry {
+	entries := map[string]handler.Entry{}
+	handler.Snapshot(func(e handler.Entry) bool {
+		entries[e.Key] = e
+		return true
+	})
+	return entries
+}
+
+// entryDiff describes how the AOF's copy of a key differs from the RDB's,
+// either of which may be missing, or returns "" if they match. Custom types
+// are compared through the commands their Rewrite hook returns.
+func entryDiff(a, r handler.Entry) string {
+	switch {
+	case a.Key == "":
+		return "missing from the AOF"
+	case r.Key == "":
+		return "missing from the RDB"
+	case a.Type != r.Type:
+		return fmt.Sprintf("type %s in the AOF, %s in the RDB", a.Type, r.Type)
+	case !reflect.DeepEqual(comparableValue(a), comparableValue(r)):
+		return "values differ"
+	case a.ExpiresAt.IsZero() != r.ExpiresAt.IsZero() || absDuration(a.ExpiresAt.Sub(r.ExpiresAt)) > ttlTolerance:
+		return fmt.Sprintf("expires at %s in the AOF, %s in the RDB", expiryString(a.ExpiresAt), expiryString(r.ExpiresAt))
+	}
+	return ""
+}
+
+func comparableValue(e handler.Entry) interface{} {
+	if e.Module != nil {
+		return e.Module.Rewrite(e.Key, e.Value)
+	}
+	return e.Value
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+func expiryString(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/handler"
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+const (
+	previewMaxBytes  = 1024
+	previewMaxFields = 100
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// The admin dashboard is served under /admin/ on the HTTP gateway. The page
+// polls the JSON endpoints below; none of them go through the command
+// dispatcher, so browsing doesn't show up in the stats or touch LFU counters.
+//
+//	GET /admin/                        the dashboard page
+//	GET /admin/api/stats               INFO sections as JSON objects
+//	GET /admin/api/slowlog             slowlog entries, newest first
+//	GET /admin/api/keys?cursor=&match= one SCAN page with key types
+//	GET /admin/api/key?name=           value preview of a single key
+func (s *Server) registerDashboard(mux *http.ServeMux) {
+	mux.Handle("/admin/", s.adminAuth(http.HandlerFunc(s.handleDashboard)))
+	mux.Handle("/admin/api/stats", s.adminAuth(http.HandlerFunc(s.handleAdminStats)))
+	mux.Handle("/admin/api/slowlog", s.adminAuth(http.HandlerFunc(s.handleAdminSlowlog)))
+	mux.Handle("/admin/api/keys", s.adminAuth(http.HandlerFunc(s.handleAdminKeys)))
+	mux.Handle("/admin/api/key", s.adminAuth(http.HandlerFunc(s.handleAdminKey)))
+}
+
+// adminAuth requires basic auth with Config.AdminPassword (any user name),
+// or a loopback client when no password is configured. There is no ACL
+// layer yet, so the password grants full read access to the keyspace.
+func (s *Server) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.AdminPassword == "" {
+			host, _, _ := net.SplitHostPort(r.RemoteAddr)
+			if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+				writeJSON(w, http.StatusForbidden, map[string]interface{}{"error": "the dashboard is only available from localhost unless an admin password is set"})
+				return
+			}
+		} else if _, pass, ok := r.BasicAuth(); !ok || subtle.ConstantTimeCompare([]byte(pass), []byte(s.cfg.AdminPassword)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="redis-clone admin"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"error": "unauthorized"})
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "method not allowed"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/admin/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboardHTML)
+}
+
+func (s *Server) handleAdminStats(w http.ResponseWriter, r *http.Request) {
+	result := map[string]interface{}{}
+	for _, section := range infoSections {
+		fields := map[string]string{}
+		for _, f := range s.infoSection(section, nil) {
+			fields[f[0]] = f[1]
+		}
+		result[section] = fields
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) handleAdminSlowlog(w http.ResponseWriter, r *http.Request) {
+	entries := s.slowlog.get(-1)
+	result := make([]map[string]interface{}, len(entries))
+	for i, e := range entries {
+		result[i] = map[string]interface{}{
+			"id":          e.id,
+			"time":        e.time.Unix(),
+			"duration_us": e.duration.Microseconds(),
+			"args":        e.args,
+			"client":      e.clientAddr,
+			"client_name": e.clientName,
+			"request_id":  e.requestID,
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) handleAdminKeys(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	args := []protocol.RESPObject{{Type: protocol.BulkString, Value: "0"}}
+	if cursor := q.Get("cursor"); cursor != "" {
+		args[0].Value = cursor
+	}
+	if match := q.Get("match"); match != "" {
+		args = append(args, protocol.RESPObject{Type: protocol.BulkString, Value: "MATCH"}, protocol.RESPObject{Type: protocol.BulkString, Value: match})
+	}
+	count := "50"
+	if c := q.Get("count"); c != "" {
+		count = c
+	}
+	args = append(args, protocol.RESPObject{Type: protocol.BulkString, Value: "COUNT"}, protocol.RESPObject{Type: protocol.BulkString, Value: count})
+
+	scan, _ := handler.Lookup("SCAN")
+	reply := scan.Handler(args)
+	if reply.Type == protocol.Error {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": reply.Value})
+		return
+	}
+	page := reply.Value.([]protocol.RESPObject)
+	keys := []map[string]string{}
+	for _, k := range page[1].Value.([]protocol.RESPObject) {
+		name := k.Value.(string)
+		keys = append(keys, map[string]string{"name": name, "type": handler.TypeOf(name)})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"cursor": page[0].Value, "keys": keys})
+}
+
+func (s *Server) handleAdminKey(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "missing key name"})
+		return
+	}
+	preview, ok := keyPreview(name)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": "not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, preview)
+}
+
+// keyPreview reads the value directly from the store, without touching LFU
+// counters or expiring keys, and truncates large values.
+func keyPreview(key string) (map[string]interface{}, bool) {
+	e, ok := handler.Peek(key)
+	if !ok {
+		return nil, false
+	}
+	ttl := int64(-1)
+	if !e.ExpiresAt.IsZero() {
+		if ttl = time.Until(e.ExpiresAt).Milliseconds(); ttl <= 0 {
+			return nil, false
+		}
+	}
+	preview := map[string]interface{}{"name": key, "type": e.Type, "ttl_ms": ttl}
+	switch v := e.Value.(type) {
+	case string:
+		preview["size"] = len(v)
+		preview["value"], preview["truncated"] = truncate(v)
+	case map[string]string:
+		fields := map[string]string{}
+		for f, fv := range v {
+			if len(fields) >= previewMaxFields {
+				break
+			}
+			fields[f], _ = truncate(fv)
+		}
+		preview["size"], preview["value"], preview["truncated"] = len(v), fields, len(fields) < len(v)
+	case []string:
+		elems := []string{}
+		for _, elem := range v {
+			if len(elems) >= previewMaxFields {
+				break
+			}
+			elem, _ = truncate(elem)
+			elems = append(elems, elem)
+		}
+		preview["size"], preview["value"], preview["truncated"] = len(v), elems, len(elems) < len(v)
+	default:
+		preview["value"], preview["truncated"] = truncate(fmt.Sprintf("%v", v))
+	}
+	return preview, true
+}
+
+func truncate(s string) (string, bool) {
+	if len(s) <= previewMaxBytes {
+		return s, false
+	}
+	return s[:previewMaxBytes] + "... (" + strconv.Itoa(len(s)-previewMaxBytes) + " more bytes)", true
+}
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/ashish-kamra/redis-clone/internal/handler"
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+// selectDB validates the index against Config.Databases. The keyspace is a
+// single set of maps shared by the whole process, so only database 0 can
+// actually be selected.
+func (s *Server) selectDB(c *client, args []protocol.RESPObject) protocol.RESPObject {
+	n, err := strconv.ParseInt(args[0].Value.(string), 10, 64)
+	if err != nil {
+		return protocol.RESPObject{Type: protocol.Error, Value: handler.ErrInvalidInt}
+	}
+	if n < 0 || n >= int64(s.cfg.Databases) {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR DB index is out of range"}
+	}
+	if n != 0 {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR only database 0 is supported"}
+	}
+	return protocol.RESPObject{Type: protocol.SimpleString, Value: "OK"}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/handler"
+)
+
+// DB is the keyspace handle passed to custom command handlers.
+type DB struct{}
+
+func (db *DB) Get(key string) (string, bool) {
+	return handler.GetString(key)
+}
+
+// Set stores a string value; a zero ttl means the key never expires.
+func (db *DB) Set(key, value string, ttl time.Duration) {
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+	handler.SetString(key, value, expiresAt)
+}
+
+func (db *DB) HGet(key, field string) (string, bool) {
+	return handler.HashGet(key, field)
+}
+
+// HSet sets a hash field, creating the hash if missing. It does nothing if
+// key holds another type.
+func (db *DB) HSet(key, field, value string) {
+	handler.HashSet(key, field, value)
+}
+
+func (db *DB) Delete(key string) bool {
+	return handler.Delete(key)
+}
+
+// GetValue returns the value stored at key by a custom type, along with the
+// type's name.
+func (db *DB) GetValue(key string) (string, interface{}, bool) {
+	mv, ok := handler.GetModuleValue(key)
+	if !ok {
+		return "", nil, false
+	}
+	return mv.Type.Name, mv.Value, true
+}
+
+// SetValue stores v at key as a value of the registered type typeName,
+// replacing whatever was stored there before.
+func (db *DB) SetValue(key, typeName string, v interface{}) error {
+	t, ok := handler.LookupType(typeName)
+	if !ok {
+		return fmt.Errorf("unknown type %s", typeName)
+	}
+	handler.Delete(key)
+	handler.SetModuleValue(key, t, v)
+	return nil
+}
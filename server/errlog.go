@@ -0,0 +1,96 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errorLog rate-limits the log lines clients can trigger at will, such as
+// protocol errors and failed writes. Each kind of error is logged at most
+// burst times per interval; the rest are only counted, and a summary with
+// the count and the last message is logged when the interval ends.
+type errorLog struct {
+	burst    atomic.Int64 // negative means unlimited
+	interval time.Duration
+
+	mu    sync.Mutex
+	kinds map[string]*errorKind
+}
+
+type errorKind struct {
+	logged     int64
+	suppressed int64
+	last       string
+}
+
+func newErrorLog(burst int, interval time.Duration) *errorLog {
+	if burst == 0 {
+		burst = 10
+	}
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	l := &errorLog{interval: interval, kinds: make(map[string]*errorKind)}
+	l.burst.Store(int64(burst))
+	return l
+}
+
+func (l *errorLog) printf(kind, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	burst := l.burst.Load()
+	l.mu.Lock()
+	k, ok := l.kinds[kind]
+	if !ok {
+		k = &errorKind{}
+		l.kinds[kind] = k
+	}
+	if burst >= 0 && k.logged >= burst {
+		k.suppressed++
+		k.last = msg
+		l.mu.Unlock()
+		return
+	}
+	k.logged++
+	l.mu.Unlock()
+	log.Print(msg)
+}
+
+// flush ends the current interval, logging a summary for every kind of
+// error that had lines suppressed.
+func (l *errorLog) flush() {
+	l.mu.Lock()
+	kinds := l.kinds
+	l.kinds = make(map[string]*errorKind)
+	l.mu.Unlock()
+
+	names := make([]string, 0, len(kinds))
+	for name, k := range kinds {
+		if k.suppressed > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		k := kinds[name]
+		log.Printf("Suppressed %d similar %s messages in the last %v, last: %s", k.suppressed, name, l.interval, k.last)
+	}
+}
+
+func (s *Server) errorLogLoop() {
+	defer s.acceptWg.Done()
+	ticker := time.NewTicker(s.errLog.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.errLog.flush()
+		case <-s.done:
+			s.errLog.flush()
+			return
+		}
+	}
+}
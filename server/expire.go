@@ -0,0 +1,165 @@
+package server
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/handler"
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+const (
+	expireInterval = 100 * time.Millisecond
+	expireSample   = 200
+	expireBudget   = 25 * time.Millisecond
+)
+
+// expireLoop actively deletes expired keys and hash fields, with or without
+// an AOF: nothing else reclaims a field no command reads. With an AOF it
+// appends a DEL for them, the way a Redis master propagates expirations,
+// and reads leave expired keys alone (see handler.SetLazyExpire), so every
+// deletion goes through here in order with the other writes, and replaying
+// the AOF deletes the key at the same point.
+func (s *Server) expireLoop() {
+	defer s.acceptWg.Done()
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.expireCycle()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// expireCycle keeps sampling while more than a quarter of the sampled keys
+// were expired, within a time budget, like Redis' active expire cycle.
+func (s *Server) expireCycle() {
+	if !handler.ActiveExpire() {
+		return
+	}
+	start := time.Now()
+	defer func() { s.stats.expireCycleTime.Add(int64(time.Since(start))) }()
+	s.expireHashFields()
+	for {
+		if time.Since(start) >= expireBudget {
+			s.stats.expireTimeCapReached.Add(1)
+			return
+		}
+		var keys []string
+		s.writeMu.Lock()
+		visited, expired := handler.ExpireKeys(expireSample, func(key string) {
+			keys = append(keys, key)
+		})
+		if len(keys) > 0 {
+			s.logExpired(append([]string{"DEL"}, keys...))
+		}
+		s.writeMu.Unlock()
+		if expired*4 <= visited {
+			return
+		}
+	}
+}
+
+// expireHashFields deletes expired hash fields, appending an HDEL for them
+// to the AOF for the same reason expireCycle appends a DEL.
+func (s *Server) expireHashFields() {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	handler.ExpireHashFields(expireSample, func(hash string, fields []string) {
+		s.logExpired(append([]string{"HDEL", hash}, fields...))
+	})
+}
+
+// logExpired appends argv, the deletion of expired keys or fields, to the
+// AOF if there is one.
+func (s *Server) logExpired(argv []string) {
+	if s.aof == nil {
+		return
+	}
+	if err := s.aof.Write(commandObject(argv...)); err != nil {
+		log.Printf("Error writing to AOF: %v", err)
+	}
+}
+
+// expireWriteKeys deletes the expired keys a write command is about to
+// modify and logs their DEL ahead of it, so the write starts from an empty
+// key both now and when the AOF is replayed. s.writeMu must be held.
+func (s *Server) expireWriteKeys(cmd *handler.Command, args []protocol.RESPObject) {
+	var keys []string
+	for _, i := range cmd.KeyIndexes(args) {
+		if key := args[i].Value.(string); handler.ExpireKey(key) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	if err := s.aof.Write(commandObject(append([]string{"DEL"}, keys...)...)); err != nil {
+		log.Printf("Error writing to AOF: %v", err)
+	}
+}
+
+// absoluteExpiry rewrites a command that set a TTL relative to now, when it
+// ran, to the equivalent absolute PEXPIREAT, HPEXPIREAT or PXAT form for the
+// AOF, so replaying it after a restart doesn't extend the key's life. Other
+// commands are returned as they are. cmd has already succeeded, so its TTL is valid.
+func absoluteExpiry(name string, argv []protocol.RESPObject, now time.Time) []protocol.RESPObject {
+	at := func(n string, unit time.Duration) protocol.RESPObject {
+		v, _ := strconv.ParseInt(n, 10, 64)
+		return protocol.RESPObject{Type: protocol.BulkString, Value: strconv.FormatInt(now.Add(time.Duration(v)*unit).UnixMilli(), 10)}
+	}
+	switch name {
+	case "EXPIRE", "PEXPIRE":
+		unit := time.Second
+		if name == "PEXPIRE" {
+			unit = time.Millisecond
+		}
+		rewritten := []protocol.RESPObject{{Type: protocol.BulkString, Value: "PEXPIREAT"}, argv[1], at(argv[2].Value.(string), unit)}
+		return append(rewritten, argv[3:]...)
+	case "HEXPIRE", "HPEXPIRE":
+		unit := time.Second
+		if name == "HPEXPIRE" {
+			unit = time.Millisecond
+		}
+		rewritten := []protocol.RESPObject{{Type: protocol.BulkString, Value: "HPEXPIREAT"}, argv[1], at(argv[2].Value.(string), unit)}
+		return append(rewritten, argv[3:]...)
+	case "SETEX", "PSETEX":
+		unit := time.Second
+		if name == "PSETEX" {
+			unit = time.Millisecond
+		}
+		return []protocol.RESPObject{{Type: protocol.BulkString, Value: "SET"}, argv[1], argv[3], {Type: protocol.BulkString, Value: "PXAT"}, at(argv[2].Value.(string), unit)}
+	case "SET", "GETEX", "HGETEX":
+		// Options start after SET's value and GETEX's and HGETEX's key.
+		first := 3
+		if name != "SET" {
+			first = 2
+		}
+		for i := first; i+1 < len(argv); i++ {
+			opt := strings.ToUpper(argv[i].Value.(string))
+			if name == "HGETEX" && opt == "FIELDS" {
+				break
+			}
+			if opt != "EX" && opt != "PX" && opt != "EXAT" && opt != "PXAT" {
+				continue
+			}
+			if opt == "EX" || opt == "PX" {
+				unit := time.Second
+				if opt == "PX" {
+					unit = time.Millisecond
+				}
+				rewritten := append([]protocol.RESPObject(nil), argv...)
+				rewritten[i] = protocol.RESPObject{Type: protocol.BulkString, Value: "PXAT"}
+				rewritten[i+1] = at(argv[i+1].Value.(string), unit)
+				return rewritten
+			}
+			i++
+		}
+	}
+	return argv
+}
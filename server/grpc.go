@@ -0,0 +1,236 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ashish-kamra/redis-clone/internal/handler"
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+// gRPC status codes used by the service.
+const (
+	grpcOK                = 0
+	grpcInvalidArgument   = 3
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+	grpcUnauthenticated   = 16
+)
+
+const (
+	grpcExecutePath = "/redisclone.RedisClone/Execute"
+	grpcWatchPath   = "/redisclone.RedisClone/Watch"
+	watchBuffer     = 1024
+)
+
+// The gRPC service (api/redisclone.proto) is served with net/http's built-in
+// HTTP/2 support, which requires TLS, and a hand-rolled protobuf codec.
+func (s *Server) startGRPC() {
+	s.grpcServer = &http.Server{Handler: http.HandlerFunc(s.serveGRPC)}
+	s.acceptWg.Add(1)
+	go func() {
+		defer s.acceptWg.Done()
+		err := s.grpcServer.ServeTLS(s.grpcListen, s.cfg.TLSCertFile, s.cfg.TLSKeyFile)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("gRPC service stopped: %v", err)
+		}
+	}()
+}
+
+func (s *Server) serveGRPC(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requires HTTP/2 and an application/grpc content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.WriteHeader(http.StatusOK)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	var code int
+	var msg string
+	switch r.URL.Path {
+	case grpcExecutePath:
+		code, msg = s.grpcExecute(w, r)
+	case grpcWatchPath:
+		code, msg = s.grpcWatch(w, r)
+	default:
+		code, msg = grpcUnimplemented, fmt.Sprintf("unknown method %s", r.URL.Path)
+	}
+
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", msg)
+	}
+}
+
+func (s *Server) grpcExecute(w http.ResponseWriter, r *http.Request) (int, string) {
+	c, err := s.requestClient(r)
+	if err != nil {
+		return grpcUnauthenticated, err.Error()
+	}
+	for {
+		msg, code, err := readGRPCMessage(r.Body)
+		if err == io.EOF {
+			return grpcOK, ""
+		}
+		if err != nil {
+			return code, err.Error()
+		}
+
+		var args []string
+		err = decodeFields(msg, func(field int, data []byte) error {
+			if field == 1 {
+				args = append(args, string(data))
+			}
+			return nil
+		})
+		if err != nil {
+			return grpcInvalidArgument, err.Error()
+		}
+		if len(args) == 0 {
+			return grpcInvalidArgument, "empty command"
+		}
+
+		if err := writeGRPCMessage(w, encodeReply(nil, s.execArgs(c, args...))); err != nil {
+			return grpcInternal, err.Error()
+		}
+	}
+}
+
+func (s *Server) grpcWatch(w http.ResponseWriter, r *http.Request) (int, string) {
+	msg, code, err := readGRPCMessage(r.Body)
+	if err == io.EOF {
+		return grpcInvalidArgument, "missing WatchRequest"
+	}
+	if err != nil {
+		return code, err.Error()
+	}
+
+	pattern := ""
+	events := map[string]bool{}
+	err = decodeFields(msg, func(field int, data []byte) error {
+		switch field {
+		case 1:
+			pattern = string(data)
+		case 2:
+			events[strings.ToLower(string(data))] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return grpcInvalidArgument, err.Error()
+	}
+
+	if s.state.Load() == stateLoading {
+		// Replaying the dataset would show up as a flood of events.
+		return grpcUnavailable, "LOADING Redis is loading the dataset in memory"
+	}
+	ch := handler.SubscribeEvents(watchBuffer)
+	defer handler.UnsubscribeEvents(ch)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return grpcOK, ""
+		case <-s.done:
+			return grpcUnavailable, "server shutting down"
+		case ev := <-ch:
+			if len(events) > 0 && !events[ev.Event] {
+				continue
+			}
+			if pattern != "" && !handler.MatchPattern(pattern, ev.Key) {
+				continue
+			}
+			var b []byte
+			b = appendBytesField(b, 1, []byte(ev.Event))
+			b = appendBytesField(b, 2, []byte(ev.Key))
+			if err := writeGRPCMessage(w, b); err != nil {
+				return grpcInternal, err.Error()
+			}
+		}
+	}
+}
+
+// readGRPCMessage reads one length-prefixed message: a compression flag byte
+// followed by a big-endian uint32 length.
+func readGRPCMessage(r io.Reader) ([]byte, int, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			return nil, grpcOK, io.EOF
+		}
+		return nil, grpcInternal, fmt.Errorf("failed to read message header: %w", err)
+	}
+	if header[0] != 0 {
+		return nil, grpcUnimplemented, errors.New("compressed messages are not supported")
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > maxHTTPBody {
+		return nil, grpcResourceExhausted, fmt.Errorf("message of %d bytes exceeds limit", length)
+	}
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, grpcInternal, fmt.Errorf("failed to read message: %w", err)
+	}
+	return msg, grpcOK, nil
+}
+
+func writeGRPCMessage(w http.ResponseWriter, msg []byte) error {
+	var header [5]byte
+	binary.BigEndian.PutUint32(header[1:], uint32(len(msg)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func encodeReply(b []byte, obj protocol.RESPObject) []byte {
+	switch obj.Type {
+	case protocol.SimpleString:
+		return appendBytesField(b, 1, []byte(fmt.Sprint(obj.Value)))
+	case protocol.Error:
+		return appendBytesField(b, 2, []byte(fmt.Sprint(obj.Value)))
+	case protocol.Integer:
+		var n int64
+		switch v := obj.Value.(type) {
+		case int:
+			n = int64(v)
+		case int64:
+			n = v
+		}
+		return appendVarintField(b, 3, uint64(n))
+	case protocol.BulkString:
+		if str, ok := obj.Value.(string); ok {
+			return appendBytesField(b, 4, []byte(str))
+		}
+	case protocol.Double:
+		f, _ := obj.Value.(float64)
+		return appendBytesField(b, 4, []byte(protocol.FormatDouble(f)))
+	case protocol.Array, protocol.Map:
+		if arr, ok := obj.Value.([]protocol.RESPObject); ok {
+			var elems []byte
+			for _, item := range arr {
+				elems = appendBytesField(elems, 1, encodeReply(nil, item))
+			}
+			return appendBytesField(b, 6, elems)
+		}
+	}
+	return appendVarintField(b, 5, 1)
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+// hello implements HELLO [protover [AUTH username password] [SETNAME name]].
+// protover 3 switches the connection to RESP3, where pub/sub messages are
+// push frames and a subscribed client can run any command. Nothing is
+// applied unless every clause is valid and the credentials, if any, are
+// accepted.
+func (s *Server) hello(c *client, args []protocol.RESPObject) protocol.RESPObject {
+	ver := int64(c.resp.Load())
+	if len(args) > 0 {
+		var err error
+		ver, err = strconv.ParseInt(args[0].Value.(string), 10, 64)
+		if err != nil {
+			return protocol.RESPObject{Type: protocol.Error, Value: "ERR Protocol version is not an integer or out of range"}
+		}
+		if ver != 2 && ver != 3 {
+			return protocol.RESPObject{Type: protocol.Error, Value: "NOPROTO unsupported protocol version"}
+		}
+	}
+
+	var user, pass, name string
+	auth, setName := false, false
+	for i := 1; i < len(args); i++ {
+		opt, more := strings.ToUpper(args[i].Value.(string)), len(args)-i-1
+		switch {
+		case opt == "AUTH" && more >= 2:
+			user, pass, auth = args[i+1].Value.(string), args[i+2].Value.(string), true
+			i += 2
+		case opt == "SETNAME" && more >= 1:
+			name, setName = args[i+1].Value.(string), true
+			i++
+		default:
+			return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf("ERR Syntax error in HELLO option '%s'", args[i].Value)}
+		}
+	}
+	if setName && !validClientInfo(name) {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR Client names cannot contain spaces, newlines or special characters."}
+	}
+	if auth {
+		if err := s.authenticate(c, user, pass); err != nil {
+			return protocol.RESPObject{Type: protocol.Error, Value: err.Error()}
+		}
+	} else if s.cfg.RequirePass != "" && !c.authenticated {
+		return protocol.RESPObject{Type: protocol.Error, Value: "NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time"}
+	}
+	if setName {
+		c.mu.Lock()
+		c.name = name
+		c.mu.Unlock()
+	}
+	c.resp.Store(int32(ver))
+	if c.w != nil { // gateway requests have no connection
+		c.w.SetRESP3(ver == 3)
+	}
+
+	str := func(v string) protocol.RESPObject { return protocol.RESPObject{Type: protocol.BulkString, Value: v} }
+	typ := protocol.Array
+	if ver == 3 {
+		typ = protocol.Map
+	}
+	return protocol.RESPObject{Type: typ, Value: []protocol.RESPObject{
+		str("server"), str("redis"),
+		str("version"), str(version),
+		str("proto"), {Type: protocol.Integer, Value: ver},
+		str("id"), {Type: protocol.Integer, Value: c.id},
+		str("mode"), str("standalone"),
+		str("role"), str("master"),
+		str("modules"), {Type: protocol.Array, Value: []protocol.RESPObject{}},
+	}}
+}
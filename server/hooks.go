@@ -0,0 +1,60 @@
+package server
+
+import "github.com/ashish-kamra/redis-clone/internal/handler"
+
+// KeyHooks lets a program embedding the server mirror keyspace changes
+// into its own systems, without subscribing to keyspace notifications,
+// which drop events for slow consumers. Its methods are called
+// synchronously, in the order of the changes, right after each one and
+// before the command that made it replies. They may run with the write lock
+// held, so they must return quickly and must not run commands against the
+// server themselves; hand the work to another goroutine if it can block.
+//
+// The keyspace is shared by every Server in the process, so the hooks also
+// see changes made through the others. Keys loaded at Start are not
+// reported.
+type KeyHooks interface {
+	// OnSet is called after key was created or modified, with the keyspace
+	// event naming the change: "set", "hset", "lpush", "expire",
+	// "rename_to" and so on. A command that empties a hash or a list
+	// reports the change, then OnDelete.
+	OnSet(key, event string)
+	// OnDelete is called after key was deleted by a command, or renamed
+	// away from.
+	OnDelete(key string)
+	// OnExpire is called after key was deleted because its TTL passed.
+	OnExpire(key string)
+	// OnFieldExpire is called after fields of the hash at key were deleted
+	// because their TTL passed, followed by OnDelete if that emptied it.
+	OnFieldExpire(key string)
+	// OnEvict is called after key was evicted to free memory. There is no
+	// maxmemory eviction yet, so it is never called for now.
+	OnEvict(key string)
+	// OnFlush is called after FLUSHALL or FLUSHDB deleted every key, which
+	// are not reported one by one.
+	OnFlush()
+}
+
+// startKeyHooks registers Config.KeyHooks, if set, until Shutdown.
+func (s *Server) startKeyHooks() {
+	h := s.cfg.KeyHooks
+	if h == nil {
+		return
+	}
+	s.removeKeyHooks = handler.AddEventHook(func(e handler.KeyspaceEvent) {
+		switch e.Event {
+		case "del", "rename_from":
+			h.OnDelete(e.Key)
+		case "expired":
+			h.OnExpire(e.Key)
+		case "hexpired":
+			h.OnFieldExpire(e.Key)
+		case "flush":
+			h.OnFlush()
+		case "evicted":
+			h.OnEvict(e.Key)
+		default:
+			h.OnSet(e.Key, e.Event)
+		}
+	})
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+const maxHTTPBody = 16 << 20
+
+// The HTTP gateway maps a small REST surface onto the regular command
+// dispatcher, so commands issued over HTTP are persisted and validated
+// exactly as RESP ones.
+//
+//	GET    /keys/{key}          GET key
+//	PUT    /keys/{key}[?ex=N]   SET key <body> [EX N]  (or ?px=N)
+//	DELETE /keys/{key}          DEL key
+//	POST   /command             body: ["CMD", "arg", ...]
+//	GET    /ws                  WebSocket upgrade, see handleWebSocket
+//	GET    /admin/              admin dashboard, see registerDashboard
+func (s *Server) httpHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/keys/", s.handleKeys)
+	mux.HandleFunc("/command", s.handleCommand)
+	mux.HandleFunc("/ws", s.handleWebSocket)
+	s.registerDashboard(mux)
+	return mux
+}
+
+func (s *Server) startHTTP() {
+	s.httpServer = &http.Server{Handler: s.httpHandler()}
+	s.acceptWg.Add(1)
+	go func() {
+		defer s.acceptWg.Done()
+		if err := s.httpServer.Serve(s.httpListen); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("HTTP gateway stopped: %v", err)
+		}
+	}()
+}
+
+func (s *Server) execArgs(c *client, args ...string) protocol.RESPObject {
+	req := make([]protocol.RESPObject, len(args))
+	for i, arg := range args {
+		req[i] = protocol.RESPObject{Type: protocol.BulkString, Value: arg}
+	}
+	return s.processCommand(commandContext(c, ""), c, protocol.RESPObject{Type: protocol.Array, Value: req})
+}
+
+// requestClient returns the client a gateway request runs as, logged in with
+// the request's basic auth credentials, if any.
+func (s *Server) requestClient(r *http.Request) (*client, error) {
+	c := newClient(r.Context(), nil, r.RemoteAddr)
+	if user, pass, ok := r.BasicAuth(); ok {
+		if err := s.authenticate(c, user, pass); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (s *Server) handleKeys(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/keys/")
+	if key == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "missing key"})
+		return
+	}
+
+	c, err := s.requestClient(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	var result protocol.RESPObject
+	switch r.Method {
+	case http.MethodGet:
+		result = s.execArgs(c, "GET", key)
+		if result.Type == protocol.Null {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": "not found"})
+			return
+		}
+	case http.MethodPut:
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxHTTPBody))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		args := []string{"SET", key, string(body)}
+		if ex := r.URL.Query().Get("ex"); ex != "" {
+			args = append(args, "EX", ex)
+		} else if px := r.URL.Query().Get("px"); px != "" {
+			args = append(args, "PX", px)
+		}
+		result = s.execArgs(c, args...)
+	case http.MethodDelete:
+		result = s.execArgs(c, "DEL", key)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "method not allowed"})
+		return
+	}
+	writeResult(w, result)
+}
+
+func (s *Server) handleCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "method not allowed"})
+		return
+	}
+
+	c, err := s.requestClient(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	var args []string
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxHTTPBody)).Decode(&args); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": fmt.Sprintf("expected a JSON array of strings: %v", err)})
+		return
+	}
+	if len(args) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "empty command"})
+		return
+	}
+	writeResult(w, s.execArgs(c, args...))
+}
+
+func writeResult(w http.ResponseWriter, result protocol.RESPObject) {
+	if result.Type == protocol.Error {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": result.Value})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"result": toJSON(result)})
+}
+
+func toJSON(obj protocol.RESPObject) interface{} {
+	switch obj.Type {
+	case protocol.BulkString, protocol.SimpleString, protocol.Integer:
+		return obj.Value
+	case protocol.Double:
+		// JSON has no infinities or NaN, so doubles go as text.
+		f, _ := obj.Value.(float64)
+		return protocol.FormatDouble(f)
+	case protocol.Error:
+		return map[string]interface{}{"error": obj.Value}
+	case protocol.Array:
+		arr, ok := obj.Value.([]protocol.RESPObject)
+		if !ok {
+			return nil
+		}
+		values := make([]interface{}, len(arr))
+		for i, item := range arr {
+			values[i] = toJSON(item)
+		}
+		return values
+	case protocol.Map:
+		pairs, _ := obj.Value.([]protocol.RESPObject)
+		m := make(map[string]interface{}, len(pairs)/2)
+		for i := 0; i+1 < len(pairs); i += 2 {
+			m[fmt.Sprint(pairs[i].Value)] = toJSON(pairs[i+1])
+		}
+		return m
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing HTTP response: %v", err)
+	}
+}
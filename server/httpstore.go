@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterBackingStore("http", openHTTPStore)
+}
+
+// httpStore is a backing store reached over a minimal REST protocol rooted
+// at the DSN URL:
+//
+//	GET    {dsn}/{key}   200 with the value as body, 404 if missing
+//	PUT    {dsn}/{key}   body is the value
+//	DELETE {dsn}/{key}
+//
+// TTLs travel in the X-TTL header as milliseconds, in both directions.
+type httpStore struct {
+	base   string
+	client *http.Client
+}
+
+func openHTTPStore(dsn string) (BackingStore, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid http backing store URL %q", dsn)
+	}
+	return &httpStore{base: strings.TrimSuffix(dsn, "/"), client: &http.Client{}}, nil
+}
+
+func (h *httpStore) do(ctx context.Context, method, key string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, h.base+"/"+url.PathEscape(key), body)
+	if err != nil {
+		return nil, err
+	}
+	return h.client.Do(req)
+}
+
+func (h *httpStore) Load(ctx context.Context, key string) (string, time.Duration, bool, error) {
+	resp, err := h.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return "", 0, false, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", 0, false, nil
+	case resp.StatusCode != http.StatusOK:
+		return "", 0, false, fmt.Errorf("GET %s: %s", key, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHTTPBody))
+	if err != nil {
+		return "", 0, false, err
+	}
+	var ttl time.Duration
+	if v := resp.Header.Get("X-TTL"); v != "" {
+		ms, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return "", 0, false, fmt.Errorf("GET %s: invalid X-TTL %q", key, v)
+		}
+		ttl = time.Duration(ms) * time.Millisecond
+	}
+	return string(body), ttl, true, nil
+}
+
+func (h *httpStore) Store(ctx context.Context, key, value string, ttl time.Duration) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, h.base+"/"+url.PathEscape(key), strings.NewReader(value))
+	if err != nil {
+		return err
+	}
+	if ttl > 0 {
+		req.Header.Set("X-TTL", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("PUT %s: %s", key, resp.Status)
+	}
+	return nil
+}
+
+func (h *httpStore) Delete(ctx context.Context, key string) error {
+	resp, err := h.do(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("DELETE %s: %s", key, resp.Status)
+	}
+	return nil
+}
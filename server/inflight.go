@@ -0,0 +1,26 @@
+package server
+
+import "github.com/ashish-kamra/redis-clone/internal/handler"
+
+// errBusy is the reply to a command shed because max-inflight-commands are
+// already running.
+const errBusy = "BUSY Server is overloaded with commands in flight, try again later"
+
+// admit counts cmd as in flight, unless max-inflight-commands are already
+// running, in which case it is shed: an overloaded server answers at once
+// with errBusy instead of queueing every client behind the write lock and
+// the CPU. Admin commands always get in, so an operator can still change
+// the limit. Every admitted command must be released.
+func (s *Server) admit(cmd *handler.Command) bool {
+	n := s.inflight.Add(1)
+	if limit := s.maxInflight.Load(); limit > 0 && n > limit && !cmd.HasFlag("admin") {
+		s.inflight.Add(-1)
+		s.stats.shedCommands.Add(1)
+		return false
+	}
+	return true
+}
+
+func (s *Server) release() {
+	s.inflight.Add(-1)
+}
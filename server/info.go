@@ -0,0 +1,246 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/handler"
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+const version = "0.1.0"
+
+var infoSections = []string{"server", "clients", "memory", "persistence", "stats", "tenants", "keyspace"}
+
+func (s *Server) info(c *client, args []protocol.RESPObject) protocol.RESPObject {
+	sections := infoSections
+	if len(args) > 0 {
+		sections = nil
+		for _, arg := range args {
+			switch name := strings.ToLower(arg.Value.(string)); name {
+			case "all", "default", "everything":
+				sections = infoSections
+			default:
+				sections = append(sections, name)
+			}
+		}
+	}
+
+	var b strings.Builder
+	for _, section := range sections {
+		fields := s.infoSection(section, c.tenant)
+		if fields == nil {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(&b, "# %s\r\n", strings.ToUpper(section[:1])+section[1:])
+		for _, f := range fields {
+			fmt.Fprintf(&b, "%s:%s\r\n", f[0], f[1])
+		}
+	}
+	return protocol.RESPObject{Type: protocol.BulkString, Value: b.String()}
+}
+
+// infoSection returns the name/value pairs of one INFO section, or nil if
+// the section doesn't exist. Tenants only see their own keyspace.
+func (s *Server) infoSection(section string, t *tenant) [][2]string {
+	switch section {
+	case "server":
+		uptime := time.Since(s.stats.startTime)
+		return [][2]string{
+			{"redis_version", version},
+			{"go_version", runtime.Version()},
+			{"os", runtime.GOOS + " " + runtime.GOARCH},
+			{"process_id", strconv.Itoa(os.Getpid())},
+			{"tcp_addr", addrString(s.Addr())},
+			{"uptime_in_seconds", strconv.FormatInt(int64(uptime.Seconds()), 10)},
+			{"uptime_in_days", strconv.FormatInt(int64(uptime.Hours()/24), 10)},
+		}
+	case "clients":
+		pubsubClients, _, _ := s.pubsubCounts()
+		return [][2]string{
+			{"connected_clients", strconv.Itoa(s.clientCount())},
+			{"pubsub_clients", strconv.Itoa(pubsubClients)},
+		}
+	case "memory":
+		m := s.stats.memory()
+		peak, peakRSS := s.stats.peakMemory.Load(), s.stats.peakRSS.Load()
+		var fragmentation float64
+		if m.used > 0 {
+			fragmentation = float64(m.rss) / float64(m.used)
+		}
+		return [][2]string{
+			{"used_memory", strconv.FormatUint(m.used, 10)},
+			{"used_memory_human", humanBytes(m.used)},
+			{"used_memory_rss", strconv.FormatUint(m.rss, 10)},
+			{"used_memory_rss_human", humanBytes(m.rss)},
+			{"used_memory_peak", strconv.FormatUint(peak, 10)},
+			{"used_memory_peak_human", humanBytes(peak)},
+			{"used_memory_peak_perc", fmt.Sprintf("%.2f%%", float64(m.used)*100/float64(peak))},
+			{"used_memory_rss_peak", strconv.FormatUint(peakRSS, 10)},
+			{"used_memory_rss_peak_human", humanBytes(peakRSS)},
+			{"used_memory_sys", strconv.FormatUint(m.sys, 10)},
+			{"used_memory_sys_human", humanBytes(m.sys)},
+			{"mem_fragmentation_ratio", fmt.Sprintf("%.2f", fragmentation)},
+			{"gc_runs", strconv.FormatUint(uint64(m.gcRuns), 10)},
+			{"mem_clients_normal", strconv.FormatInt(s.clientMemory(), 10)},
+			{"maxmemory_clients", strconv.FormatInt(s.maxmemoryClients.Load(), 10)},
+		}
+	case "persistence":
+		rdbStatus, aofStatus := "ok", "ok"
+		if s.lastSaveFailed.Load() {
+			rdbStatus = "err"
+		}
+		bgsave, aofEnabled := 0, 0
+		if s.bgsaveInProgress.Load() {
+			bgsave = 1
+		}
+		if s.aof != nil {
+			aofEnabled = 1
+			if s.aof.LastError() != nil {
+				aofStatus = "err"
+			}
+		}
+		healthy := 0
+		if rdbStatus == "ok" && aofStatus == "ok" {
+			healthy = 1
+		}
+		return [][2]string{
+			{"rdb_changes_since_last_save", strconv.FormatUint(handler.Dirty()-s.lastSaveDirty.Load(), 10)},
+			{"rdb_bgsave_in_progress", strconv.Itoa(bgsave)},
+			{"rdb_last_save_time", strconv.FormatInt(s.lastSave.Load(), 10)},
+			{"rdb_last_bgsave_status", rdbStatus},
+			{"rdb_last_bgsave_time_sec", strconv.FormatInt(lastBgsaveSeconds(s.lastBgsaveTime.Load()), 10)},
+			{"rdb_bgsave_lock_waits", strconv.FormatInt(s.stats.bgsaveLockWaits.Load(), 10)},
+			{"rdb_bgsave_lock_wait_usec", strconv.FormatInt(time.Duration(s.stats.bgsaveLockWait.Load()).Microseconds(), 10)},
+			{"aof_enabled", strconv.Itoa(aofEnabled)},
+			// There is no AOF rewrite yet, so it never fails.
+			{"aof_last_bgrewrite_status", "ok"},
+			{"aof_last_write_status", aofStatus},
+			{"persistence_healthy", strconv.Itoa(healthy)},
+		}
+	case "stats":
+		fields := [][2]string{
+			{"total_connections_received", strconv.FormatInt(s.stats.connectionsReceived.Load(), 10)},
+			{"total_commands_processed", strconv.FormatInt(s.stats.commandsProcessed.Load(), 10)},
+			{"instantaneous_ops_per_sec", strconv.FormatInt(s.stats.opsPerSec(), 10)},
+			{"slowlog_len", strconv.Itoa(s.slowlog.len())},
+			{"write_lock_waits", strconv.FormatInt(s.stats.writeLockWaits.Load(), 10)},
+			{"write_lock_wait_usec", strconv.FormatInt(time.Duration(s.stats.writeLockWait.Load()).Microseconds(), 10)},
+		}
+		expired, misses, stalePerc := handler.ExpireStats()
+		fields = append(fields,
+			[2]string{"expired_keys", strconv.FormatInt(expired, 10)},
+			[2]string{"expired_stale_perc", fmt.Sprintf("%.2f", stalePerc)},
+			[2]string{"expired_time_cap_reached_count", strconv.FormatInt(s.stats.expireTimeCapReached.Load(), 10)},
+			[2]string{"expire_cycle_cpu_milliseconds", strconv.FormatInt(time.Duration(s.stats.expireCycleTime.Load()).Milliseconds(), 10)},
+			[2]string{"keyspace_misses_expired", strconv.FormatInt(misses, 10)},
+			[2]string{"evicted_clients", strconv.FormatInt(s.stats.evictedClients.Load(), 10)},
+			[2]string{"client_output_buffer_limit_disconnections", strconv.FormatInt(s.stats.outputLimitClients.Load(), 10)},
+			[2]string{"inflight_commands", strconv.FormatInt(s.inflight.Load(), 10)},
+			[2]string{"shed_commands", strconv.FormatInt(s.stats.shedCommands.Load(), 10)},
+		)
+		_, channels, patterns := s.pubsubCounts()
+		fields = append(fields,
+			[2]string{"pubsub_channels", strconv.Itoa(channels)},
+			[2]string{"pubsub_patterns", strconv.Itoa(patterns)},
+		)
+		if b := s.backing; b != nil {
+			fields = append(fields,
+				[2]string{"backing_store_loads", strconv.FormatInt(b.loads.Load(), 10)},
+				[2]string{"backing_store_misses", strconv.FormatInt(b.misses.Load(), 10)},
+				[2]string{"backing_store_errors", strconv.FormatInt(b.errors.Load(), 10)},
+				[2]string{"backing_store_pending_writes", strconv.Itoa(b.pendingLen())},
+			)
+		}
+		return fields
+	case "tenants":
+		if len(s.tenants) == 0 {
+			return nil
+		}
+		return s.tenantInfo(t)
+	case "keyspace":
+		if t != nil {
+			return [][2]string{{"db0", fmt.Sprintf("keys=%d", t.keys.Load())}}
+		}
+		// Only non-empty databases are listed, and only db0 can hold keys.
+		keys, expires := handler.KeyspaceSize()
+		if keys == 0 {
+			return [][2]string{}
+		}
+		return [][2]string{
+			{"db0", fmt.Sprintf("keys=%d,expires=%d,avg_ttl=%d", keys, expires, handler.AverageTTL().Milliseconds())},
+		}
+	}
+	return nil
+}
+
+// lastBgsaveSeconds returns d, a time.Duration or -1, in seconds, rounding
+// up so a BGSAVE that ran at all doesn't look like none did.
+func lastBgsaveSeconds(d int64) int64 {
+	if d < 0 {
+		return -1
+	}
+	return int64((time.Duration(d) + time.Second - 1) / time.Second)
+}
+
+func humanBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatUint(n, 10) + "B"
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f%c", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func (s *Server) slowlogCommand(c *client, args []protocol.RESPObject) protocol.RESPObject {
+	switch strings.ToUpper(args[0].Value.(string)) {
+	case "GET":
+		n := 10
+		if len(args) > 1 {
+			var err error
+			if n, err = strconv.Atoi(args[1].Value.(string)); err != nil || n < -1 {
+				return protocol.RESPObject{Type: protocol.Error, Value: "ERR count should be greater than or equal to -1"}
+			}
+		}
+		entries := s.slowlog.get(n)
+		values := make([]protocol.RESPObject, len(entries))
+		for i, e := range entries {
+			argv := make([]protocol.RESPObject, len(e.args))
+			for j, arg := range e.args {
+				argv[j] = protocol.RESPObject{Type: protocol.BulkString, Value: arg}
+			}
+			entry := []protocol.RESPObject{
+				{Type: protocol.Integer, Value: e.id},
+				{Type: protocol.Integer, Value: e.time.Unix()},
+				{Type: protocol.Integer, Value: e.duration.Microseconds()},
+				{Type: protocol.Array, Value: argv},
+				{Type: protocol.BulkString, Value: e.clientAddr},
+				{Type: protocol.BulkString, Value: e.clientName},
+			}
+			// Only tagged entries get a seventh field, which Redis doesn't have.
+			if e.requestID != "" {
+				entry = append(entry, protocol.RESPObject{Type: protocol.BulkString, Value: e.requestID})
+			}
+			values[i] = protocol.RESPObject{Type: protocol.Array, Value: entry}
+		}
+		return protocol.RESPObject{Type: protocol.Array, Value: values}
+	case "LEN":
+		return protocol.RESPObject{Type: protocol.Integer, Value: s.slowlog.len()}
+	case "RESET":
+		s.slowlog.reset()
+		return protocol.RESPObject{Type: protocol.SimpleString, Value: "OK"}
+	default:
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(handler.ErrUnknownSubcommand, args[0].Value, "SLOWLOG")}
+	}
+}
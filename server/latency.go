@@ -0,0 +1,192 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/handler"
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+const latencyHistoryLen = 160
+
+// Latency events. There is no fork, so instead of Redis' fork event this
+// records how long a BGSAVE takes and how long commands wait for a hash or
+// a list it is copying.
+const (
+	latencyBgsave         = "bgsave"
+	latencyBgsaveLockWait = "bgsave-lock-wait"
+)
+
+type latencySample struct {
+	time int64 // unix seconds
+	ms   int64
+}
+
+type latencyEvent struct {
+	history []latencySample // ring of the last latencyHistoryLen samples
+	next    int
+	max     int64
+}
+
+// latencyMonitor keeps the spikes of each event at or above
+// latency-monitor-threshold, like Redis' LATENCY command.
+type latencyMonitor struct {
+	mu     sync.Mutex
+	events map[string]*latencyEvent
+}
+
+// record adds a sample of d for event if it reaches threshold, which is
+// disabled when zero.
+func (l *latencyMonitor) record(event string, d, threshold time.Duration) {
+	if threshold <= 0 || d < threshold {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.events == nil {
+		l.events = map[string]*latencyEvent{}
+	}
+	e := l.events[event]
+	if e == nil {
+		e = &latencyEvent{}
+		l.events[event] = e
+	}
+	sample := latencySample{time: time.Now().Unix(), ms: d.Milliseconds()}
+	if len(e.history) < latencyHistoryLen {
+		e.history = append(e.history, sample)
+	} else {
+		e.history[e.next] = sample
+	}
+	e.next = (e.next + 1) % latencyHistoryLen
+	if sample.ms > e.max {
+		e.max = sample.ms
+	}
+}
+
+// latest returns the event names, sorted, with their last sample and their
+// highest one.
+func (l *latencyMonitor) latest() []protocol.RESPObject {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	names := make([]string, 0, len(l.events))
+	for name := range l.events {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	values := make([]protocol.RESPObject, len(names))
+	for i, name := range names {
+		e := l.events[name]
+		last := e.history[(e.next+len(e.history)-1)%len(e.history)]
+		values[i] = protocol.RESPObject{Type: protocol.Array, Value: []protocol.RESPObject{
+			{Type: protocol.BulkString, Value: name},
+			{Type: protocol.Integer, Value: last.time},
+			{Type: protocol.Integer, Value: last.ms},
+			{Type: protocol.Integer, Value: e.max},
+		}}
+	}
+	return values
+}
+
+// history returns the samples of event, oldest first.
+func (l *latencyMonitor) history(event string) []protocol.RESPObject {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e := l.events[event]
+	if e == nil {
+		return []protocol.RESPObject{}
+	}
+	samples := e.history
+	if len(samples) == latencyHistoryLen {
+		samples = append(append([]latencySample{}, samples[e.next:]...), samples[:e.next]...)
+	}
+	values := make([]protocol.RESPObject, len(samples))
+	for i, sample := range samples {
+		values[i] = protocol.RESPObject{Type: protocol.Array, Value: []protocol.RESPObject{
+			{Type: protocol.Integer, Value: sample.time},
+			{Type: protocol.Integer, Value: sample.ms},
+		}}
+	}
+	return values
+}
+
+// reset drops the samples of the given events, or of all of them if none
+// are given, and returns how many events it dropped.
+func (l *latencyMonitor) reset(events []string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(events) == 0 {
+		n := len(l.events)
+		l.events = nil
+		return n
+	}
+	n := 0
+	for _, event := range events {
+		if _, ok := l.events[event]; ok {
+			delete(l.events, event)
+			n++
+		}
+	}
+	return n
+}
+
+// lockWrites takes s.writeMu for a write command, counting how long it
+// waited. The snapshot never takes it.
+func (s *Server) lockWrites() {
+	start := time.Now()
+	s.writeMu.Lock()
+	wait := time.Since(start)
+	s.stats.writeLockWaits.Add(1)
+	s.stats.writeLockWait.Add(int64(wait))
+}
+
+// snapshotWait counts a command's wait for a value locked by a BGSAVE, the
+// locks a snapshot shares with the commands, so INFO and LATENCY show what
+// a concurrent snapshot costs them.
+func (s *Server) snapshotWait(wait time.Duration) {
+	if !s.bgsaveInProgress.Load() {
+		return
+	}
+	s.stats.bgsaveLockWaits.Add(1)
+	s.stats.bgsaveLockWait.Add(int64(wait))
+	s.latency.record(latencyBgsaveLockWait, wait, time.Duration(s.latencyThreshold.Load()))
+}
+
+var latencyHelp = []string{
+	"LATENCY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"LATEST",
+	"    Return the latest latency samples for all events.",
+	"HISTORY <event>",
+	"    Return time-latency samples for the <event> class.",
+	"RESET [<event> ...]",
+	"    Reset latency data of one or more <event> classes.",
+	"    (default: reset all data for all event classes)",
+	"HELP",
+	"    Print this help.",
+}
+
+func (s *Server) latencyCommand(c *client, args []protocol.RESPObject) protocol.RESPObject {
+	switch sub := strings.ToUpper(args[0].Value.(string)); {
+	case sub == "LATEST" && len(args) == 1:
+		return protocol.RESPObject{Type: protocol.Array, Value: s.latency.latest()}
+	case sub == "HISTORY" && len(args) == 2:
+		return protocol.RESPObject{Type: protocol.Array, Value: s.latency.history(args[1].Value.(string))}
+	case sub == "RESET":
+		events := make([]string, len(args)-1)
+		for i, arg := range args[1:] {
+			events[i] = arg.Value.(string)
+		}
+		return protocol.RESPObject{Type: protocol.Integer, Value: s.latency.reset(events)}
+	case sub == "HELP" && len(args) == 1:
+		lines := make([]protocol.RESPObject, len(latencyHelp))
+		for i, line := range latencyHelp {
+			lines[i] = protocol.RESPObject{Type: protocol.SimpleString, Value: line}
+		}
+		return protocol.RESPObject{Type: protocol.Array, Value: lines}
+	default:
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(handler.ErrUnknownSubcommand, args[0].Value, "LATENCY")}
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"fmt"
+	"plugin"
+
+	"github.com/ashish-kamra/redis-clone/internal/handler"
+)
+
+// DataType is a custom value type. Values are opaque to the server and are
+// stored through DB.SetValue by the type's own commands. Rewrite must return
+// the commands that recreate a value, which is how the type is persisted when
+// the keyspace is serialized; Marshal/Unmarshal optionally provide a compact
+// binary form for snapshots. Encoding, if set, is reported by OBJECT ENCODING.
+// Copy returns a deep copy of a value for COPY; without it values are copied
+// through Marshal/Unmarshal, and can't be copied if those are missing too.
+type DataType struct {
+	Name      string
+	Encoding  func(v interface{}) string
+	Rewrite   func(key string, v interface{}) [][]string
+	Marshal   func(v interface{}) ([]byte, error)
+	Unmarshal func(data []byte) (interface{}, error)
+	Copy      func(v interface{}) interface{}
+}
+
+func RegisterType(t DataType) error {
+	return handler.RegisterType(&handler.DataType{
+		Name:      t.Name,
+		Encoding:  t.Encoding,
+		Rewrite:   t.Rewrite,
+		Marshal:   t.Marshal,
+		Unmarshal: t.Unmarshal,
+		Copy:      t.Copy,
+	})
+}
+
+// LoadPlugin opens a Go plugin built with -buildmode=plugin and calls its
+// exported "Init func() error", which is expected to register the plugin's
+// types and commands.
+func LoadPlugin(path string) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open plugin %s: %w", path, err)
+	}
+	sym, err := p.Lookup("Init")
+	if err != nil {
+		return fmt.Errorf("plugin %s: %w", path, err)
+	}
+	initFn, ok := sym.(func() error)
+	if !ok {
+		return fmt.Errorf("plugin %s: Init has type %T, want func() error", path, sym)
+	}
+	if err := initFn(); err != nil {
+		return fmt.Errorf("plugin %s: init failed: %w", path, err)
+	}
+	return nil
+}
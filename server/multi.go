@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/handler"
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+// queuedCommand is a command queued inside MULTI, or one about to be
+// propagated. argv includes the command name and is already tenant-scoped.
+// ctx is the commandContext the command was sent with.
+type queuedCommand struct {
+	name string
+	cmd  *handler.Command
+	argv []protocol.RESPObject
+	ctx  context.Context
+}
+
+func (s *Server) multi(c *client, args []protocol.RESPObject) protocol.RESPObject {
+	if c.multi {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR MULTI calls can not be nested"}
+	}
+	c.multi = true
+	return protocol.RESPObject{Type: protocol.SimpleString, Value: "OK"}
+}
+
+func (s *Server) discard(c *client, args []protocol.RESPObject) protocol.RESPObject {
+	if !c.multi {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR DISCARD without MULTI"}
+	}
+	c.resetMulti()
+	return protocol.RESPObject{Type: protocol.SimpleString, Value: "OK"}
+}
+
+// exec runs the queued commands while holding writeMu, so no other write
+// interleaves with them, and propagates their effects to the AOF as a single
+// MULTI ... EXEC block that replay applies all or nothing.
+func (s *Server) exec(c *client, args []protocol.RESPObject) protocol.RESPObject {
+	if !c.multi {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR EXEC without MULTI"}
+	}
+	queued, failed := c.queued, c.multiFailed
+	c.resetMulti()
+	if failed {
+		return protocol.RESPObject{Type: protocol.Error, Value: "EXECABORT Transaction discarded because of previous errors."}
+	}
+	if s.crossSlot(queued) {
+		return protocol.RESPObject{Type: protocol.Error, Value: errCrossSlot}
+	}
+
+	s.lockWrites()
+	defer s.writeMu.Unlock()
+	results := make([]protocol.RESPObject, len(queued))
+	var writes []queuedCommand
+	for i, q := range queued {
+		c.touch(q.cmd.Name)
+		start := time.Now()
+		dirty := handler.Dirty()
+		result := s.execute(c, q.name, q.cmd, q.argv[1:])
+		s.recordCommand(q.ctx, c, q.cmd, q.argv, result, time.Since(start))
+		result = s.render(c, result)
+		if q.cmd.HasFlag("write") && result.Type != protocol.Error && handler.Dirty() != dirty {
+			writes = append(writes, queuedCommand{name: q.name, cmd: q.cmd, argv: absoluteExpiry(q.name, q.argv, start), ctx: q.ctx})
+		}
+		if c.tenant != nil {
+			result = unscopeReply(c.tenant, q.name, result)
+		}
+		results[i] = result
+	}
+	s.propagate(c, writes)
+	return protocol.RESPObject{Type: protocol.Array, Value: results}
+}
+
+// flagMultiError makes a pending EXEC fail after a command could not be
+// queued, as Redis does.
+func (c *client) flagMultiError() {
+	if c.multi {
+		c.multiFailed = true
+	}
+}
+
+func (c *client) resetMulti() {
+	c.multi, c.multiFailed, c.queued, c.queuedMem = false, false, nil, 0
+}
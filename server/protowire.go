@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Minimal protobuf wire-format helpers for the messages in
+// api/redisclone.proto, so the gRPC service needs no code generation or
+// third-party runtime.
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+var errTruncated = errors.New("protobuf: truncated message")
+
+func appendTag(b []byte, field int, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+func appendBytesField(b []byte, field int, data []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = appendTag(b, field, wireVarint)
+	return binary.AppendUvarint(b, v)
+}
+
+// decodeFields walks a message, calling fn for each length-delimited field.
+// Varint fields are skipped since none of the decoded messages use them.
+func decodeFields(b []byte, fn func(field int, data []byte) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errTruncated
+		}
+		b = b[n:]
+		field, wireType := int(tag>>3), int(tag&7)
+
+		switch wireType {
+		case wireVarint:
+			if _, n = binary.Uvarint(b); n <= 0 {
+				return errTruncated
+			}
+			b = b[n:]
+		case wireBytes:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < length {
+				return errTruncated
+			}
+			if err := fn(field, b[n:n+int(length)]); err != nil {
+				return err
+			}
+			b = b[n+int(length):]
+		case 1:
+			if len(b) < 8 {
+				return errTruncated
+			}
+			b = b[8:]
+		case 5:
+			if len(b) < 4 {
+				return errTruncated
+			}
+			b = b[4:]
+		default:
+			return fmt.Errorf("protobuf: unsupported wire type %d", wireType)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ashish-kamra/redis-clone/internal/handler"
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+// pubsubBufferLen bounds the messages queued for a subscriber. A client
+// that falls further behind is disconnected, like Redis' pubsub output
+// buffer limit, so a stuck subscriber can't stall publishers.
+const pubsubBufferLen = 1024
+
+type pubsub struct {
+	mu       sync.Mutex
+	channels map[string]map[*client]struct{}
+	patterns map[string]map[*client]struct{}
+}
+
+// Commands a client may run while subscribed; anything else is refused.
+var pubsubModeCommands = map[string]bool{
+	"SUBSCRIBE": true, "PSUBSCRIBE": true, "UNSUBSCRIBE": true, "PUNSUBSCRIBE": true, "PING": true,
+}
+
+// startPush switches the client to queued writes, so messages published to
+// it and its own replies reach the connection in order.
+func (c *client) startPush() {
+	if c.out != nil {
+		return
+	}
+	c.out = make(chan protocol.RESPObject, pubsubBufferLen)
+	go func() {
+		for {
+			select {
+			case obj := <-c.out:
+				err := c.w.Write(obj)
+				c.outMem.Add(-objectSize(obj))
+				if err != nil {
+					c.conn.Close()
+					return
+				}
+			case <-c.closed:
+				return
+			}
+		}
+	}()
+}
+
+// reply sends a reply, through the push queue once the client has one.
+func (c *client) reply(obj protocol.RESPObject) error {
+	size := objectSize(obj)
+	c.outMem.Add(size)
+	if c.out == nil {
+		defer c.outMem.Add(-size)
+		return c.w.Write(obj)
+	}
+	select {
+	case c.out <- obj:
+		return nil
+	case <-c.closed:
+		c.outMem.Add(-size)
+		return errClientClosed
+	}
+}
+
+// push queues a published message without blocking the publisher.
+func (c *client) push(obj protocol.RESPObject) {
+	size := objectSize(obj)
+	c.outMem.Add(size)
+	select {
+	case c.out <- obj:
+	case <-c.closed:
+		c.outMem.Add(-size)
+	default:
+		c.outMem.Add(-size)
+		log.Printf("Closing client %s: pubsub output buffer full", c.addr)
+		c.conn.Close()
+	}
+}
+
+// subscribed reports whether the client is in pubsub mode. Subscriptions
+// only change on the client's own goroutine, which may read them unlocked.
+func (c *client) subscribed() bool {
+	return len(c.subs)+len(c.psubs) > 0
+}
+
+func (s *Server) subscribe(c *client, args []protocol.RESPObject) protocol.RESPObject {
+	return s.subscribeCommand(c, args, "subscribe", false)
+}
+
+func (s *Server) psubscribe(c *client, args []protocol.RESPObject) protocol.RESPObject {
+	return s.subscribeCommand(c, args, "psubscribe", true)
+}
+
+func (s *Server) unsubscribe(c *client, args []protocol.RESPObject) protocol.RESPObject {
+	return s.unsubscribeCommand(c, args, "unsubscribe", false)
+}
+
+func (s *Server) punsubscribe(c *client, args []protocol.RESPObject) protocol.RESPObject {
+	return s.unsubscribeCommand(c, args, "punsubscribe", true)
+}
+
+func (s *Server) subscribeCommand(c *client, args []protocol.RESPObject, kind string, pattern bool) protocol.RESPObject {
+	if c.w == nil {
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf("ERR %s is only available on RESP connections", kind)}
+	}
+	c.startPush()
+	replies := make([]protocol.RESPObject, len(args))
+	s.pubsub.mu.Lock()
+	for i, arg := range args {
+		name := arg.Value.(string)
+		subs, index := c.subs, s.pubsub.channels
+		if pattern {
+			subs, index = c.psubs, s.pubsub.patterns
+		}
+		if _, ok := subs[name]; !ok {
+			subs[name] = struct{}{}
+			if index[name] == nil {
+				index[name] = map[*client]struct{}{}
+			}
+			index[name][c] = struct{}{}
+		}
+		replies[i] = c.pubsubReply(kind, arg, len(c.subs)+len(c.psubs))
+	}
+	s.pubsub.mu.Unlock()
+	return s.sendReplies(c, replies)
+}
+
+// unsubscribeCommand drops the given subscriptions, or all of them of this
+// kind when called without arguments.
+func (s *Server) unsubscribeCommand(c *client, args []protocol.RESPObject, kind string, pattern bool) protocol.RESPObject {
+	s.pubsub.mu.Lock()
+	subs, index := c.subs, s.pubsub.channels
+	if pattern {
+		subs, index = c.psubs, s.pubsub.patterns
+	}
+	if len(args) == 0 {
+		names := make([]string, 0, len(subs))
+		for name := range subs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			args = append(args, protocol.RESPObject{Type: protocol.BulkString, Value: name})
+		}
+	}
+	var replies []protocol.RESPObject
+	for _, arg := range args {
+		name := arg.Value.(string)
+		if _, ok := subs[name]; ok {
+			delete(subs, name)
+			delete(index[name], c)
+			if len(index[name]) == 0 {
+				delete(index, name)
+			}
+		}
+		replies = append(replies, c.pubsubReply(kind, arg, len(c.subs)+len(c.psubs)))
+	}
+	if len(replies) == 0 {
+		replies = append(replies, c.pubsubReply(kind, protocol.RESPObject{Type: protocol.Null}, len(c.subs)+len(c.psubs)))
+	}
+	s.pubsub.mu.Unlock()
+	if c.w == nil {
+		return replies[len(replies)-1]
+	}
+	return s.sendReplies(c, replies)
+}
+
+// sendReplies writes all but the last reply and returns the last one, for
+// commands that answer with one message per argument.
+func (s *Server) sendReplies(c *client, replies []protocol.RESPObject) protocol.RESPObject {
+	for _, r := range replies[:len(replies)-1] {
+		if err := c.reply(r); err != nil {
+			break
+		}
+	}
+	return replies[len(replies)-1]
+}
+
+func (c *client) pubsubReply(kind string, name protocol.RESPObject, count int) protocol.RESPObject {
+	return c.pushFrame(protocol.RESPObject{Type: protocol.BulkString, Value: kind}, name, protocol.RESPObject{Type: protocol.Integer, Value: count})
+}
+
+// pushFrame builds a pub/sub message for c: a push frame under RESP3, which
+// clients tell apart from command replies, and an array under RESP2.
+func (c *client) pushFrame(fields ...protocol.RESPObject) protocol.RESPObject {
+	if c.resp.Load() == 3 {
+		return protocol.RESPObject{Type: protocol.Push, Value: fields}
+	}
+	return protocol.RESPObject{Type: protocol.Array, Value: fields}
+}
+
+// unsubscribeAll drops every subscription of a disconnecting client.
+func (s *Server) unsubscribeAll(c *client) {
+	s.pubsub.mu.Lock()
+	defer s.pubsub.mu.Unlock()
+	for name := range c.subs {
+		delete(s.pubsub.channels[name], c)
+		if len(s.pubsub.channels[name]) == 0 {
+			delete(s.pubsub.channels, name)
+		}
+	}
+	for name := range c.psubs {
+		delete(s.pubsub.patterns[name], c)
+		if len(s.pubsub.patterns[name]) == 0 {
+			delete(s.pubsub.patterns, name)
+		}
+	}
+	c.subs, c.psubs = map[string]struct{}{}, map[string]struct{}{}
+}
+
+func (s *Server) publish(c *client, args []protocol.RESPObject) protocol.RESPObject {
+	channel, message := args[0], args[1]
+	receivers := 0
+	s.pubsub.mu.Lock()
+	for sub := range s.pubsub.channels[channel.Value.(string)] {
+		sub.push(sub.pushFrame(protocol.RESPObject{Type: protocol.BulkString, Value: "message"}, channel, message))
+		receivers++
+	}
+	for pattern, subs := range s.pubsub.patterns {
+		if !handler.MatchPattern(pattern, channel.Value.(string)) {
+			continue
+		}
+		for sub := range subs {
+			sub.push(sub.pushFrame(
+				protocol.RESPObject{Type: protocol.BulkString, Value: "pmessage"},
+				protocol.RESPObject{Type: protocol.BulkString, Value: pattern},
+				channel, message,
+			))
+			receivers++
+		}
+	}
+	s.pubsub.mu.Unlock()
+	return protocol.RESPObject{Type: protocol.Integer, Value: receivers}
+}
+
+// PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT
+func (s *Server) pubsubCommand(c *client, args []protocol.RESPObject) protocol.RESPObject {
+	s.pubsub.mu.Lock()
+	defer s.pubsub.mu.Unlock()
+	sub := strings.ToUpper(args[0].Value.(string))
+	switch {
+	case sub == "CHANNELS" && len(args) <= 2:
+		var names []string
+		for name := range s.pubsub.channels {
+			if len(args) == 1 || handler.MatchPattern(args[1].Value.(string), name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		values := make([]protocol.RESPObject, len(names))
+		for i, name := range names {
+			values[i] = protocol.RESPObject{Type: protocol.BulkString, Value: name}
+		}
+		return protocol.RESPObject{Type: protocol.Array, Value: values}
+	case sub == "NUMSUB":
+		values := make([]protocol.RESPObject, 0, 2*(len(args)-1))
+		for _, arg := range args[1:] {
+			values = append(values, arg, protocol.RESPObject{Type: protocol.Integer, Value: len(s.pubsub.channels[arg.Value.(string)])})
+		}
+		return protocol.RESPObject{Type: protocol.Array, Value: values}
+	case sub == "NUMPAT" && len(args) == 1:
+		return protocol.RESPObject{Type: protocol.Integer, Value: len(s.pubsub.patterns)}
+	case sub == "CHANNELS" || sub == "NUMPAT":
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(handler.ErrWrongArgCount, "pubsub|"+strings.ToLower(sub))}
+	default:
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf(handler.ErrUnknownSubcommand, args[0].Value, "PUBSUB")}
+	}
+}
+
+// pubsubModeReply handles commands sent by a subscribed RESP2 client: PING
+// gets the pubsub-style reply and anything not allowed in this context is
+// refused. RESP3 clients may run any command while subscribed.
+func pubsubModeReply(c *client, command string, cmd *handler.Command, args []protocol.RESPObject) (protocol.RESPObject, bool) {
+	if !c.subscribed() || c.resp.Load() == 3 {
+		return protocol.RESPObject{}, false
+	}
+	if command == "PING" {
+		msg := protocol.RESPObject{Type: protocol.BulkString, Value: ""}
+		if len(args) > 0 {
+			msg = args[0]
+		}
+		return protocol.RESPObject{Type: protocol.Array, Value: []protocol.RESPObject{{Type: protocol.BulkString, Value: "pong"}, msg}}, true
+	}
+	if !pubsubModeCommands[command] {
+		return protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", cmd.Name)}, true
+	}
+	return protocol.RESPObject{}, false
+}
+
+// pubsubCounts returns the number of subscribed clients, channels and
+// patterns.
+func (s *Server) pubsubCounts() (clients, channels, patterns int) {
+	s.pubsub.mu.Lock()
+	defer s.pubsub.mu.Unlock()
+	seen := map[*client]struct{}{}
+	for _, index := range []map[string]map[*client]struct{}{s.pubsub.channels, s.pubsub.patterns} {
+		for _, subs := range index {
+			for c := range subs {
+				seen[c] = struct{}{}
+			}
+		}
+	}
+	return len(seen), len(s.pubsub.channels), len(s.pubsub.patterns)
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+// requestIDAttribute is the RESP3 attribute a client can send ahead of a
+// command to tag it, e.g. |1\r\n+request-id\r\n+abc\r\n before the array.
+const requestIDAttribute = "request-id"
+
+// requestInfo identifies what a command runs on behalf of, so its log lines,
+// slowlog entry and trace can be correlated with the client's own logs.
+type requestInfo struct {
+	clientID  int64
+	user      string
+	requestID string // empty unless the client attached one
+}
+
+type requestKey struct{}
+
+// commandContext derives the context a command runs under from its client's,
+// which is canceled once the client goes away.
+func commandContext(c *client, requestID string) context.Context {
+	return context.WithValue(c.ctx, requestKey{}, requestInfo{clientID: c.id, user: c.user(), requestID: requestID})
+}
+
+func requestFromContext(ctx context.Context) requestInfo {
+	ri, _ := ctx.Value(requestKey{}).(requestInfo)
+	return ri
+}
+
+// logfmt formats ri as logfmt fields, leaving out a missing request ID.
+func (ri requestInfo) logfmt() string {
+	var b strings.Builder
+	b.WriteString("client_id=")
+	b.WriteString(strconv.FormatInt(ri.clientID, 10))
+	b.WriteString(" user=")
+	b.WriteString(ri.user)
+	if ri.requestID != "" {
+		b.WriteString(" request_id=")
+		b.WriteString(strconv.Quote(ri.requestID))
+	}
+	return b.String()
+}
+
+// attributeRequestID returns the request ID in a RESP3 attribute, empty if
+// it has none. Other attributes are ignored.
+func attributeRequestID(attr protocol.RESPObject) string {
+	pairs, _ := attr.Value.([]protocol.RESPObject)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if name, _ := pairs[i].Value.(string); strings.EqualFold(name, requestIDAttribute) {
+			id, _ := pairs[i+1].Value.(string)
+			return id
+		}
+	}
+	return ""
+}
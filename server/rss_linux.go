@@ -0,0 +1,25 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+)
+
+// rss returns the resident set size of the process from /proc, or fallback
+// if it can't be read.
+func rss(fallback uint64) uint64 {
+	data, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return fallback
+	}
+	fields := bytes.Fields(data)
+	if len(fields) < 2 {
+		return fallback
+	}
+	pages, err := strconv.ParseUint(string(fields[1]), 10, 64)
+	if err != nil {
+		return fallback
+	}
+	return pages * uint64(os.Getpagesize())
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package server
+
+// rss has no portable source, so it reports fallback, the memory the Go
+// runtime holds from the OS.
+func rss(fallback uint64) uint64 {
+	return fallback
+}
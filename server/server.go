@@ -0,0 +1,444 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/aof"
+	"github.com/ashish-kamra/redis-clone/internal/failpoint"
+	"github.com/ashish-kamra/redis-clone/internal/handler"
+)
+
+type Config struct {
+	// Addr is the TCP address to listen on. Use "127.0.0.1:0" to pick an
+	// ephemeral port and read it back with Server.Addr.
+	Addr string
+	// UnixSocket optionally also listens on a unix domain socket at this path.
+	UnixSocket string
+	// HTTPAddr optionally starts the HTTP/JSON gateway on this address.
+	HTTPAddr string
+	// GRPCAddr optionally starts the gRPC service on this address. gRPC is
+	// served over HTTP/2 with TLS, so TLSCertFile and TLSKeyFile are required.
+	GRPCAddr    string
+	TLSCertFile string
+	TLSKeyFile  string
+	// AOFPath is the append-only file used for persistence. Leave empty to
+	// run purely in memory.
+	AOFPath string
+	// AOFFsync fsyncs the AOF after every write instead of once per second.
+	AOFFsync bool
+	// RDBPath is where SAVE and BGSAVE write snapshots. Without an AOF the
+	// snapshot is loaded on Start.
+	RDBPath string
+	// SlowlogThreshold is the execution time above which commands are
+	// recorded in the slowlog. Zero means the 10ms default; a negative value
+	// disables the slowlog.
+	SlowlogThreshold time.Duration
+	// AdminPassword protects the /admin dashboard on the HTTP gateway with
+	// basic auth. When empty the dashboard is only served to loopback clients.
+	AdminPassword string
+	// Webhooks receive keyspace events over HTTP, see Webhook.
+	Webhooks []Webhook
+	// KeyHooks, if set, is told about every keyspace change in process,
+	// see KeyHooks.
+	KeyHooks KeyHooks
+	// RequirePass makes connections authenticate with AUTH before running
+	// commands, like Redis' requirepass. Gateway requests use basic auth.
+	RequirePass string
+	// Tenants are users confined to a key prefix, see Tenant. Set
+	// RequirePass too, or unauthenticated clients can reach every tenant's keys.
+	Tenants []Tenant
+	// EnableFailpoints allows DEBUG FAILPOINT to inject faults. Failpoints
+	// are process-wide, so this affects every Server in the process.
+	EnableFailpoints bool
+	// TraceSampleRate logs one in every TraceSampleRate commands with its
+	// latency, keys, client and outcome; zero disables tracing. It can be
+	// changed at runtime with CONFIG SET trace-sample-rate.
+	TraceSampleRate int
+	// BackingStore turns the server into a cache in front of an external
+	// store: read commands load missing keys from it, and string writes are
+	// forwarded to it asynchronously every WriteBehindInterval (100ms by
+	// default). BackingStoreTimeout bounds each call (5s by default).
+	BackingStore        BackingStore
+	BackingStoreTimeout time.Duration
+	WriteBehindInterval time.Duration
+	// ErrorLogBurst caps how many times each kind of per-connection error,
+	// such as a protocol error, is logged per ErrorLogInterval; the rest are
+	// summarized at the end of the interval. Zero means 10 per 10s, a
+	// negative burst logs every error. The burst can be changed at runtime
+	// with CONFIG SET error-log-burst.
+	ErrorLogBurst    int
+	ErrorLogInterval time.Duration
+	// Databases is the number of databases SELECT accepts, 16 by default.
+	// Only database 0 holds keys for now.
+	Databases int
+	// MaxMemoryClients caps the memory held by client connections for
+	// requests, queued transactions and unsent replies, in bytes. Over it,
+	// the clients using the most are disconnected, unless they turned on
+	// CLIENT NO-EVICT. Zero means no limit; CONFIG SET maxmemory-clients
+	// changes it at runtime.
+	MaxMemoryClients int64
+	// ClientOutputBufferLimit is the hard client-output-buffer-limit of
+	// normal clients, in bytes: a client whose unsent replies would grow past
+	// it while a large reply is produced is disconnected instead. Zero means
+	// no limit.
+	ClientOutputBufferLimit int64
+	// ClientEvictionPolicy picks which clients go first once
+	// MaxMemoryClients is exceeded: "largest", the default, disconnects the
+	// clients using the most memory, "idle" those idle the longest.
+	ClientEvictionPolicy string
+	// MaxInflightCommands caps how many commands, over all clients, run at
+	// once. Commands past it are refused with a BUSY error instead of
+	// waiting, keeping latency bounded under overload; admin commands are
+	// never refused. Zero means no limit; CONFIG SET max-inflight-commands
+	// changes it at runtime.
+	MaxInflightCommands int64
+	// ClusterEnabled rejects commands and transactions whose keys hash to
+	// different cluster slots with a CROSSSLOT error, as a Redis Cluster
+	// node does. There are no other nodes: every slot is served locally.
+	ClusterEnabled bool
+}
+
+// Server is an embeddable instance of the RESP server. The keyspace lives in
+// the handler package, so every Server in a process shares the same data.
+type Server struct {
+	cfg        Config
+	listener   net.Listener
+	unixListen net.Listener
+	httpListen net.Listener
+	httpServer *http.Server
+	grpcListen net.Listener
+	grpcServer *http.Server
+	aof        *aof.Aof
+	stats      stats
+	slowlog    slowlog
+	latency    latencyMonitor
+	tenants    map[string]*tenant
+	pubsub     pubsub
+	backing    *backing
+	errLog     *errorLog
+	writeMu    sync.Mutex
+
+	removeKeyHooks func()
+
+	// Runtime-tunable settings, see CONFIG.
+	slowlogThreshold atomic.Int64 // time.Duration
+	traceSampleRate  atomic.Int64
+	traceCounter     atomic.Uint64
+	maxmemoryClients atomic.Int64 // bytes, 0 for no limit
+	outputLimit      atomic.Int64 // bytes, 0 for no limit
+	clientEviction   atomic.Int32 // evictLargest or evictIdle
+	latencyThreshold atomic.Int64 // time.Duration, 0 disables the latency monitor
+	maxInflight      atomic.Int64 // 0 for no limit
+	inflight         atomic.Int64
+
+	saveMu           sync.Mutex
+	bgsaveInProgress atomic.Bool
+	lastSave         atomic.Int64  // unix seconds
+	lastSaveFailed   atomic.Bool   // whether the last SAVE or BGSAVE failed
+	lastBgsaveTime   atomic.Int64  // time.Duration, -1 before the first BGSAVE
+	lastSaveDirty    atomic.Uint64 // handler.Dirty() when the last successful save started
+
+	state    atomic.Int32
+	mu       sync.Mutex
+	conns    map[net.Conn]*client
+	closing  bool
+	done     chan struct{}
+	wg       sync.WaitGroup
+	acceptWg sync.WaitGroup
+}
+
+func New(cfg Config) *Server {
+	if cfg.Addr == "" {
+		cfg.Addr = ":6379"
+	}
+	if cfg.Databases <= 0 {
+		cfg.Databases = 16
+	}
+	if cfg.SlowlogThreshold == 0 {
+		cfg.SlowlogThreshold = 10 * time.Millisecond
+	}
+	s := &Server{
+		cfg:     cfg,
+		conns:   make(map[net.Conn]*client),
+		done:    make(chan struct{}),
+		tenants: make(map[string]*tenant),
+		errLog:  newErrorLog(cfg.ErrorLogBurst, cfg.ErrorLogInterval),
+		pubsub: pubsub{
+			channels: make(map[string]map[*client]struct{}),
+			patterns: make(map[string]map[*client]struct{}),
+		},
+	}
+	for _, t := range cfg.Tenants {
+		s.tenants[t.Name] = &tenant{Tenant: t}
+	}
+	if cfg.BackingStore != nil {
+		s.backing = newBacking(cfg)
+	}
+	s.slowlogThreshold.Store(int64(cfg.SlowlogThreshold))
+	s.traceSampleRate.Store(int64(cfg.TraceSampleRate))
+	s.maxmemoryClients.Store(cfg.MaxMemoryClients)
+	s.outputLimit.Store(cfg.ClientOutputBufferLimit)
+	s.maxInflight.Store(cfg.MaxInflightCommands)
+	if cfg.ClientEvictionPolicy != "" {
+		policy, err := parseClientEvictionPolicy(cfg.ClientEvictionPolicy)
+		if err != nil {
+			log.Printf("%v, using largest", err)
+		}
+		s.clientEviction.Store(policy)
+	}
+	return s
+}
+
+// Server states. Connections are accepted while loading, but only commands
+// flagged "loading" run until the dataset is in memory.
+const (
+	stateLoading int32 = iota
+	stateReady
+	stateShuttingDown
+)
+
+// Start binds the listeners, begins accepting connections in the
+// background and loads the dataset from the AOF or RDB. It returns once the
+// server is ready to serve.
+func (s *Server) Start(ctx context.Context) error {
+	if err := validateWebhooks(s.cfg.Webhooks); err != nil {
+		return err
+	}
+	if err := validateTenants(s.cfg.Tenants); err != nil {
+		return err
+	}
+	if s.cfg.EnableFailpoints {
+		failpoint.SetEnabled(true)
+	}
+	if s.cfg.AOFPath != "" {
+		f, err := aof.NewAof(s.cfg.AOFPath, s.cfg.AOFFsync)
+		if err != nil {
+			return fmt.Errorf("failed to open/create AOF: %w", err)
+		}
+		s.aof = f
+	}
+
+	if err := s.listen(ctx); err != nil {
+		s.closeListeners()
+		if s.aof != nil {
+			s.aof.Close()
+		}
+		return err
+	}
+
+	s.stats.startTime = time.Now()
+	s.lastSave.Store(s.stats.startTime.Unix())
+	s.lastBgsaveTime.Store(-1)
+	s.acceptWg.Add(3)
+	go s.statsLoop()
+	go s.errorLogLoop()
+	go s.acceptLoop(s.listener)
+	if s.unixListen != nil {
+		s.acceptWg.Add(1)
+		go s.acceptLoop(s.unixListen)
+	}
+	if s.httpListen != nil {
+		s.startHTTP()
+	}
+	if s.grpcListen != nil {
+		s.startGRPC()
+	}
+
+	if s.aof != nil {
+		s.rebuildCacheFromAOF()
+	} else if s.cfg.RDBPath != "" {
+		if err := s.loadSnapshot(); err != nil {
+			s.Shutdown(ctx)
+			return err
+		}
+	}
+	// What was just loaded is no change since the last save.
+	s.lastSaveDirty.Store(handler.Dirty())
+	s.startKeyHooks()
+	handler.SetSnapshotWaitHook(s.snapshotWait)
+	s.state.CompareAndSwap(stateLoading, stateReady)
+
+	s.startWebhooks()
+	if len(s.tenants) > 0 {
+		s.acceptWg.Add(1)
+		go s.tenantLoop()
+	}
+	if s.backing != nil {
+		s.acceptWg.Add(1)
+		go s.writeBehindLoop()
+	}
+	if s.aof != nil {
+		handler.SetLazyExpire(false)
+	}
+	s.acceptWg.Add(1)
+	go s.expireLoop()
+	return nil
+}
+
+func (s *Server) listen(ctx context.Context) error {
+	var lc net.ListenConfig
+	var err error
+
+	if s.listener, err = lc.Listen(ctx, "tcp", s.cfg.Addr); err != nil {
+		return fmt.Errorf("failed to bind to %s: %w", s.cfg.Addr, err)
+	}
+	if s.cfg.UnixSocket != "" {
+		os.Remove(s.cfg.UnixSocket)
+		if s.unixListen, err = lc.Listen(ctx, "unix", s.cfg.UnixSocket); err != nil {
+			return fmt.Errorf("failed to bind to unix socket %s: %w", s.cfg.UnixSocket, err)
+		}
+	}
+	if s.cfg.HTTPAddr != "" {
+		if s.httpListen, err = lc.Listen(ctx, "tcp", s.cfg.HTTPAddr); err != nil {
+			return fmt.Errorf("failed to bind HTTP gateway to %s: %w", s.cfg.HTTPAddr, err)
+		}
+	}
+	if s.cfg.GRPCAddr != "" {
+		if s.cfg.TLSCertFile == "" || s.cfg.TLSKeyFile == "" {
+			return fmt.Errorf("the gRPC service requires TLSCertFile and TLSKeyFile")
+		}
+		if s.grpcListen, err = lc.Listen(ctx, "tcp", s.cfg.GRPCAddr); err != nil {
+			return fmt.Errorf("failed to bind gRPC service to %s: %w", s.cfg.GRPCAddr, err)
+		}
+	}
+	return nil
+}
+
+func (s *Server) closeListeners() {
+	for _, l := range []net.Listener{s.listener, s.unixListen, s.httpListen, s.grpcListen} {
+		if l != nil {
+			l.Close()
+		}
+	}
+}
+
+// HTTPAddr returns the address of the HTTP gateway, or nil if it isn't running.
+func (s *Server) HTTPAddr() net.Addr {
+	if s.httpListen == nil {
+		return nil
+	}
+	return s.httpListen.Addr()
+}
+
+// GRPCAddr returns the address of the gRPC service, or nil if it isn't running.
+func (s *Server) GRPCAddr() net.Addr {
+	if s.grpcListen == nil {
+		return nil
+	}
+	return s.grpcListen.Addr()
+}
+
+// Addr returns the address the server is listening on, or nil before Start.
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+func (s *Server) acceptLoop(listener net.Listener) {
+	defer s.acceptWg.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.errLog.printf("accept error", "Error accepting connection: %v", err)
+			continue
+		}
+		s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves a single already-established connection in the
+// background, e.g. one end of a net.Pipe for in-process clients.
+func (s *Server) ServeConn(conn net.Conn) {
+	c := newClient(context.Background(), conn, addrString(conn.RemoteAddr()))
+	if !s.trackClient(c) {
+		conn.Close()
+		return
+	}
+
+	go func() {
+		defer s.untrackClient(c)
+		s.handleConnection(c)
+	}()
+}
+
+// Shutdown stops accepting connections, disconnects clients and closes the
+// AOF once every connection goroutine has returned. If ctx expires first the
+// AOF is still closed and ctx's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closing = true
+	s.state.Store(stateShuttingDown)
+	close(s.done)
+	for conn, c := range s.conns {
+		c.cancel()
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	for _, hs := range []*http.Server{s.httpServer, s.grpcServer} {
+		if hs != nil {
+			if err := hs.Shutdown(ctx); err != nil {
+				hs.Close()
+			}
+		}
+	}
+	s.closeListeners()
+	s.acceptWg.Wait()
+	if s.aof != nil {
+		handler.SetLazyExpire(true)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	if s.removeKeyHooks != nil {
+		s.removeKeyHooks()
+	}
+	handler.SetSnapshotWaitHook(nil)
+	if s.backing != nil {
+		s.backing.flush(ctx)
+		if n := s.backing.pendingLen(); n > 0 {
+			log.Printf("Shutdown dropped %d write-behind keys", n)
+		}
+	}
+	if s.aof != nil {
+		if cerr := s.aof.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (s *Server) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
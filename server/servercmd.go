@@ -0,0 +1,69 @@
+package server
+
+import (
+	"github.com/ashish-kamra/redis-clone/internal/handler"
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+// serverCommands need the Server or the calling client, which the handler
+// package doesn't know about. They are still registered with handler so
+// COMMAND, arity checks and the HTTP gateway see them like any other.
+type serverCommand func(s *Server, c *client, args []protocol.RESPObject) protocol.RESPObject
+
+var serverCommands = map[string]serverCommand{
+	"AUTH":         (*Server).auth,
+	"BGSAVE":       (*Server).bgsave,
+	"CLIENT":       (*Server).clientCommand,
+	"CONFIG":       (*Server).config,
+	"DISCARD":      (*Server).discard,
+	"HELLO":        (*Server).hello,
+	"INFO":         (*Server).info,
+	"LASTSAVE":     (*Server).lastsave,
+	"LATENCY":      (*Server).latencyCommand,
+	"MULTI":        (*Server).multi,
+	"PSUBSCRIBE":   (*Server).psubscribe,
+	"PUBLISH":      (*Server).publish,
+	"PUBSUB":       (*Server).pubsubCommand,
+	"PUNSUBSCRIBE": (*Server).punsubscribe,
+	"SAVE":         (*Server).save,
+	"SELECT":       (*Server).selectDB,
+	"SLOWLOG":      (*Server).slowlogCommand,
+	"SUBSCRIBE":    (*Server).subscribe,
+	"UNSUBSCRIBE":  (*Server).unsubscribe,
+	"WAITAOF":      (*Server).waitAOF,
+}
+
+func init() {
+	// EXEC dispatches through serverCommands itself.
+	serverCommands["EXEC"] = (*Server).exec
+	for _, cmd := range []*handler.Command{
+		{Name: "bgsave", Arity: -1, Flags: []string{"admin", "noscript"}},
+		{Name: "lastsave", Arity: 1, Flags: []string{"loading", "stale", "fast"}},
+		{Name: "latency", Arity: -2, Flags: []string{"admin", "noscript", "loading", "stale"}},
+		{Name: "save", Arity: 1, Flags: []string{"admin", "noscript"}},
+		{Name: "auth", Arity: -2, Flags: []string{"noscript", "loading", "stale", "fast", "no-auth"}},
+		{Name: "hello", Arity: -1, Flags: []string{"noscript", "loading", "stale", "fast", "no-auth"}},
+		{Name: "client", Arity: -2, Flags: []string{"noscript", "loading", "stale"}},
+		{Name: "config", Arity: -2, Flags: []string{"admin", "noscript", "loading", "stale"}},
+		{Name: "info", Arity: -1, Flags: []string{"loading", "stale"}},
+		{Name: "multi", Arity: 1, Flags: []string{"noscript", "loading", "stale", "fast"}},
+		{Name: "exec", Arity: 1, Flags: []string{"noscript", "loading", "stale", "skip-slowlog"}},
+		{Name: "discard", Arity: 1, Flags: []string{"noscript", "loading", "stale", "fast"}},
+		{Name: "subscribe", Arity: -2, Flags: []string{"pubsub", "noscript", "loading", "stale"}},
+		{Name: "unsubscribe", Arity: -1, Flags: []string{"pubsub", "noscript", "loading", "stale"}},
+		{Name: "psubscribe", Arity: -2, Flags: []string{"pubsub", "noscript", "loading", "stale"}},
+		{Name: "punsubscribe", Arity: -1, Flags: []string{"pubsub", "noscript", "loading", "stale"}},
+		{Name: "publish", Arity: 3, Flags: []string{"pubsub", "loading", "stale", "fast"}},
+		{Name: "pubsub", Arity: -2, Flags: []string{"pubsub", "loading", "stale"}},
+		{Name: "select", Arity: 2, Flags: []string{"loading", "stale", "fast"}},
+		{Name: "slowlog", Arity: -2, Flags: []string{"admin", "loading", "stale"}},
+		{Name: "waitaof", Arity: 4, Flags: []string{"noscript"}},
+	} {
+		cmd.Handler = func(args []protocol.RESPObject) protocol.RESPObject {
+			return protocol.RESPObject{Type: protocol.Error, Value: "ERR command is only available on a running server"}
+		}
+		if err := handler.Register(cmd); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/failpoint"
+	"github.com/ashish-kamra/redis-clone/internal/handler"
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+	"github.com/ashish-kamra/redis-clone/internal/rdb"
+)
+
+// saveSnapshot writes the keyspace to Config.RDBPath through a temporary
+// file that is renamed over it once synced, and then syncs the directory so
+// the rename itself survives a crash: the snapshot on disk is always either
+// the old one or the new one in full. There is no fork: keys written during
+// the save may or may not be included.
+func (s *Server) saveSnapshot() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	if s.cfg.RDBPath == "" {
+		return errors.New("no RDB path configured")
+	}
+	if err := failpoint.Eval(failpoint.RDBSave); err != nil {
+		return err
+	}
+	// Changes made during the save may or may not be in it, so they count
+	// as unsaved.
+	dirty := handler.Dirty()
+
+	f, err := os.CreateTemp(filepath.Dir(s.cfg.RDBPath), "temp-*.rdb")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	enc := rdb.NewEncoder(f)
+	enc.WriteAux("redis-ver", version)
+	enc.WriteAux("redis-bits", strconv.Itoa(32<<(^uint(0)>>63)))
+	enc.WriteAux("ctime", strconv.FormatInt(time.Now().Unix(), 10))
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	enc.WriteAux("used-mem", strconv.FormatUint(m.HeapAlloc, 10))
+	keys, expires := handler.KeyspaceSize()
+	enc.SelectDB(0, uint64(keys), uint64(expires))
+
+	handler.Snapshot(func(e handler.Entry) bool {
+		switch v := e.Value.(type) {
+		case string:
+			enc.WriteString(e.Key, v, e.ExpiresAt)
+		case map[string]string:
+			enc.WriteHash(e.Key, v, e.FieldExpires, e.ExpiresAt)
+		case []string:
+			enc.WriteList(e.Key, v, e.ExpiresAt)
+		default:
+			if e.Module == nil || e.Module.Marshal == nil {
+				err = fmt.Errorf("type %s has no Marshal hook, key %q can't be saved", e.Type, e.Key)
+				return false
+			}
+			var data []byte
+			if data, err = e.Module.Marshal(v); err != nil {
+				err = fmt.Errorf("failed to marshal key %q: %w", e.Key, err)
+				return false
+			}
+			enc.WriteModule(e.Key, e.Type, data, e.ExpiresAt)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync snapshot: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close snapshot: %w", err)
+	}
+	if err := os.Rename(f.Name(), s.cfg.RDBPath); err != nil {
+		return fmt.Errorf("failed to rename snapshot: %w", err)
+	}
+	if err := syncDir(filepath.Dir(s.cfg.RDBPath)); err != nil {
+		return fmt.Errorf("failed to sync snapshot directory: %w", err)
+	}
+	s.lastSave.Store(time.Now().Unix())
+	s.lastSaveDirty.Store(dirty)
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// loadSnapshot fills the keyspace from Config.RDBPath, if it exists.
+func (s *Server) loadSnapshot() error {
+	return loadRDB(s.cfg.RDBPath)
+}
+
+// loadRDB fills the keyspace from the RDB file at path, if it exists. Keys
+// of types the server can't hold are skipped with a warning.
+func loadRDB(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open RDB: %w", err)
+	}
+	defer f.Close()
+
+	now := time.Now()
+	loaded, skipped := 0, 0
+	_, err = rdb.Decode(f, rdb.Handler{Key: func(e rdb.Entry) error {
+		if e.DB != 0 || (!e.ExpiresAt.IsZero() && e.ExpiresAt.Before(now)) {
+			skipped++
+			return nil
+		}
+		switch v := e.Value.(type) {
+		case string:
+			handler.SetString(e.Key, v, e.ExpiresAt)
+		case map[string]string:
+			loadedFields := 0
+			for field, value := range v {
+				at, volatile := e.FieldExpires[field]
+				if volatile && !at.After(now) {
+					continue
+				}
+				handler.HashSet(e.Key, field, value)
+				if volatile {
+					handler.HashExpireFields(e.Key, []string{field}, at, nil)
+				}
+				loadedFields++
+			}
+			if loadedFields == 0 {
+				skipped++
+				return nil
+			}
+			handler.SetExpire(e.Key, e.ExpiresAt)
+		case []string:
+			handler.ListPush(e.Key, v, false)
+			handler.SetExpire(e.Key, e.ExpiresAt)
+		case rdb.Module:
+			t, ok := handler.LookupType(v.TypeName)
+			if !ok || t.Unmarshal == nil {
+				return fmt.Errorf("key %q: type %s is not registered or has no Unmarshal hook", e.Key, v.TypeName)
+			}
+			value, err := t.Unmarshal(v.Data)
+			if err != nil {
+				return fmt.Errorf("key %q: %w", e.Key, err)
+			}
+			handler.SetModuleValue(e.Key, t, value)
+			handler.SetExpire(e.Key, e.ExpiresAt)
+		default:
+			skipped++
+			return nil
+		}
+		loaded++
+		return nil
+	}})
+	if err != nil {
+		return fmt.Errorf("failed to load RDB: %w", err)
+	}
+	if skipped > 0 {
+		log.Printf("Skipped %d keys from the RDB (expired, non-zero database or unsupported type)", skipped)
+	}
+	log.Printf("Loaded %d keys from %s", loaded, path)
+	return nil
+}
+
+func (s *Server) save(c *client, args []protocol.RESPObject) protocol.RESPObject {
+	if s.bgsaveInProgress.Load() {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR Background save already in progress"}
+	}
+	err := s.saveSnapshot()
+	s.lastSaveFailed.Store(err != nil)
+	if err != nil {
+		log.Printf("Error saving snapshot: %v", err)
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR " + err.Error()}
+	}
+	return protocol.RESPObject{Type: protocol.SimpleString, Value: "OK"}
+}
+
+func (s *Server) bgsave(c *client, args []protocol.RESPObject) protocol.RESPObject {
+	if s.cfg.RDBPath == "" {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR no RDB path configured"}
+	}
+	if !s.bgsaveInProgress.CompareAndSwap(false, true) {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR Background save already in progress"}
+	}
+	s.acceptWg.Add(1)
+	go func() {
+		defer s.acceptWg.Done()
+		defer s.bgsaveInProgress.Store(false)
+		start := time.Now()
+		err := s.saveSnapshot()
+		d := time.Since(start)
+		s.lastBgsaveTime.Store(int64(d))
+		s.latency.record(latencyBgsave, d, time.Duration(s.latencyThreshold.Load()))
+		s.lastSaveFailed.Store(err != nil)
+		if err != nil {
+			log.Printf("Background saving error: %v", err)
+			return
+		}
+		log.Printf("Background saving terminated with success")
+	}()
+	return protocol.RESPObject{Type: protocol.SimpleString, Value: "Background saving started"}
+}
+
+func (s *Server) lastsave(c *client, args []protocol.RESPObject) protocol.RESPObject {
+	return protocol.RESPObject{Type: protocol.Integer, Value: s.lastSave.Load()}
+}
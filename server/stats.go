@@ -0,0 +1,179 @@
+package server
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	opsSampleInterval = 100 * time.Millisecond
+	opsSamples        = 16
+	memSampleInterval = time.Second
+	slowlogMaxLen     = 128
+	slowlogMaxArgs    = 32
+	slowlogMaxArgLen  = 128
+)
+
+type stats struct {
+	startTime           time.Time
+	commandsProcessed   atomic.Int64
+	connectionsReceived atomic.Int64
+	evictedClients      atomic.Int64
+	outputLimitClients  atomic.Int64
+	shedCommands        atomic.Int64
+
+	// Active expire cycle effort.
+	expireCycleTime      atomic.Int64 // nanoseconds
+	expireTimeCapReached atomic.Int64
+
+	// Time write commands waited for the write lock, and commands for a
+	// value a BGSAVE was copying, in nanoseconds.
+	writeLockWaits  atomic.Int64
+	writeLockWait   atomic.Int64
+	bgsaveLockWaits atomic.Int64
+	bgsaveLockWait  atomic.Int64
+
+	// Ring of command counter samples for instantaneous_ops_per_sec,
+	// sampled the same way Redis does.
+	mu          sync.Mutex
+	samples     [opsSamples]int64
+	sampleIdx   int
+	lastSample  int64
+	lastSampled time.Time
+
+	// Highest memory use seen by INFO or the once a second sample.
+	peakMemory atomic.Uint64
+	peakRSS    atomic.Uint64
+}
+
+type memoryUsage struct {
+	used, sys, rss uint64
+	gcRuns         uint32
+}
+
+// memory measures memory use and records new peaks. used is the Go heap,
+// Redis' used_memory, and rss is what the OS has given the process.
+func (st *stats) memory() memoryUsage {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	u := memoryUsage{used: m.HeapAlloc, sys: m.Sys, rss: rss(m.Sys - m.HeapReleased), gcRuns: m.NumGC}
+	raise(&st.peakMemory, u.used)
+	raise(&st.peakRSS, u.rss)
+	return u
+}
+
+func raise(peak *atomic.Uint64, v uint64) {
+	for {
+		cur := peak.Load()
+		if v <= cur || peak.CompareAndSwap(cur, v) {
+			return
+		}
+	}
+}
+
+func (st *stats) sample() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	now := time.Now()
+	total := st.commandsProcessed.Load()
+	elapsed := now.Sub(st.lastSampled)
+	if elapsed > 0 && !st.lastSampled.IsZero() {
+		st.samples[st.sampleIdx] = int64(float64(total-st.lastSample) / elapsed.Seconds())
+		st.sampleIdx = (st.sampleIdx + 1) % opsSamples
+	}
+	st.lastSample, st.lastSampled = total, now
+}
+
+func (st *stats) opsPerSec() int64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var sum int64
+	for _, v := range st.samples {
+		sum += v
+	}
+	return sum / opsSamples
+}
+
+func (s *Server) statsLoop() {
+	defer s.acceptWg.Done()
+	ticker := time.NewTicker(opsSampleInterval)
+	defer ticker.Stop()
+	mem := time.NewTicker(memSampleInterval)
+	defer mem.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.stats.sample()
+			s.evictClients()
+		case <-mem.C:
+			s.stats.memory()
+		}
+	}
+}
+
+type slowlogEntry struct {
+	id         int64
+	time       time.Time
+	duration   time.Duration
+	args       []string
+	clientAddr string
+	clientName string
+	requestID  string
+}
+
+type slowlog struct {
+	mu      sync.Mutex
+	nextID  int64
+	entries []slowlogEntry // newest first
+}
+
+func (sl *slowlog) add(args []string, d time.Duration, c *client, requestID string) {
+	if len(args) > slowlogMaxArgs {
+		more := len(args) - slowlogMaxArgs + 1
+		args = append(args[:slowlogMaxArgs-1:slowlogMaxArgs-1], "... ("+strconv.Itoa(more)+" more arguments)")
+	}
+	for i, arg := range args {
+		if len(arg) > slowlogMaxArgLen {
+			args[i] = arg[:slowlogMaxArgLen] + "... (" + strconv.Itoa(len(arg)-slowlogMaxArgLen) + " more bytes)"
+		}
+	}
+
+	c.mu.Lock()
+	name := c.name
+	c.mu.Unlock()
+
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	entry := slowlogEntry{id: sl.nextID, time: time.Now(), duration: d, args: args, clientAddr: c.addr, clientName: name, requestID: requestID}
+	sl.nextID++
+	sl.entries = append([]slowlogEntry{entry}, sl.entries...)
+	if len(sl.entries) > slowlogMaxLen {
+		sl.entries = sl.entries[:slowlogMaxLen]
+	}
+}
+
+func (sl *slowlog) get(n int) []slowlogEntry {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if n < 0 || n > len(sl.entries) {
+		n = len(sl.entries)
+	}
+	return append([]slowlogEntry(nil), sl.entries[:n]...)
+}
+
+func (sl *slowlog) len() int {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return len(sl.entries)
+}
+
+func (sl *slowlog) reset() {
+	sl.mu.Lock()
+	sl.entries = nil
+	sl.mu.Unlock()
+}
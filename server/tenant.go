@@ -0,0 +1,264 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/handler"
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+const tenantUsageInterval = time.Second
+
+// Tenant confines a user to the keys under Prefix. Key arguments of its
+// commands are prefixed transparently and key names in replies are stripped,
+// so each application sees what looks like a private keyspace.
+//
+// Quotas are enforced against usage measured once per second, so a burst of
+// writes may overshoot them briefly. Memory is estimated from key and value
+// sizes and ignores allocator overhead.
+type Tenant struct {
+	Name     string
+	Password string
+	Prefix   string
+	// MaxKeys and MaxMemory (bytes) reject writes with an OOM error once
+	// reached; zero means unlimited.
+	MaxKeys   int64
+	MaxMemory int64
+}
+
+type tenant struct {
+	Tenant
+	keys     atomic.Int64
+	memory   atomic.Int64
+	commands atomic.Int64
+}
+
+// Commands without key arguments that are safe for tenants. KEYS and SCAN
+// are scoped by rewriting their pattern, see scopeToTenant.
+var tenantKeylessCommands = map[string]bool{
+	"AUTH": true, "HELLO": true, "PING": true, "ECHO": true, "COMMAND": true, "INFO": true, "KEYS": true, "SCAN": true,
+	"MULTI": true, "EXEC": true, "DISCARD": true, "SELECT": true,
+}
+
+func validateTenants(tenants []Tenant) error {
+	seen := map[string]bool{"default": true}
+	for i, t := range tenants {
+		if t.Name == "" || seen[t.Name] {
+			return fmt.Errorf("tenant %q: name must be unique and not \"default\"", t.Name)
+		}
+		seen[t.Name] = true
+		if t.Prefix == "" {
+			return fmt.Errorf("tenant %s: missing prefix", t.Name)
+		}
+		for _, other := range tenants[:i] {
+			if strings.HasPrefix(t.Prefix, other.Prefix) || strings.HasPrefix(other.Prefix, t.Prefix) {
+				return fmt.Errorf("tenants %s and %s have overlapping prefixes", other.Name, t.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) auth(c *client, args []protocol.RESPObject) protocol.RESPObject {
+	user, pass := "default", args[0].Value.(string)
+	if len(args) == 2 {
+		user, pass = args[0].Value.(string), args[1].Value.(string)
+	} else if len(args) > 2 {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR syntax error"}
+	}
+	if user == "default" && len(args) == 1 && s.cfg.RequirePass == "" {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?"}
+	}
+	if err := s.authenticate(c, user, pass); err != nil {
+		return protocol.RESPObject{Type: protocol.Error, Value: err.Error()}
+	}
+	return protocol.RESPObject{Type: protocol.SimpleString, Value: "OK"}
+}
+
+// authenticate logs c in as user. A failed attempt leaves c as it was.
+func (s *Server) authenticate(c *client, user, pass string) error {
+	if user == "default" {
+		if s.cfg.RequirePass == "" || subtle.ConstantTimeCompare([]byte(pass), []byte(s.cfg.RequirePass)) == 1 {
+			c.authenticated, c.tenant = true, nil
+			return nil
+		}
+	} else if t, ok := s.tenants[user]; ok && subtle.ConstantTimeCompare([]byte(pass), []byte(t.Password)) == 1 {
+		c.authenticated, c.tenant = true, t
+		return nil
+	}
+	return fmt.Errorf("WRONGPASS invalid username-password pair or user is disabled.")
+}
+
+// scopeToTenant rewrites a tenant's command so it only reaches keys under
+// the tenant's prefix, and checks the quotas for commands that may grow the
+// keyspace.
+func (s *Server) scopeToTenant(t *tenant, name string, cmd *handler.Command, argv []protocol.RESPObject) ([]protocol.RESPObject, *protocol.RESPObject) {
+	t.commands.Add(1)
+	args := argv[1:]
+	keyIdx := cmd.KeyIndexes(args)
+	if len(keyIdx) == 0 && !tenantKeylessCommands[name] || cmd.HasFlag("admin") {
+		return nil, &protocol.RESPObject{Type: protocol.Error, Value: fmt.Sprintf("NOPERM User %s has no permissions to run the '%s' command", t.Name, cmd.Name)}
+	}
+
+	scoped := make([]protocol.RESPObject, len(argv))
+	copy(scoped, argv)
+	for _, i := range keyIdx {
+		scoped[i+1] = protocol.RESPObject{Type: protocol.BulkString, Value: t.Prefix + args[i].Value.(string)}
+	}
+	switch name {
+	case "KEYS":
+		scoped[1] = protocol.RESPObject{Type: protocol.BulkString, Value: t.Prefix + args[0].Value.(string)}
+	case "SCAN":
+		matched := false
+		for i := 1; i+1 < len(args); i += 2 {
+			if strings.EqualFold(args[i].Value.(string), "MATCH") {
+				scoped[i+2] = protocol.RESPObject{Type: protocol.BulkString, Value: escapePattern(t.Prefix) + args[i+1].Value.(string)}
+				matched = true
+			}
+		}
+		if !matched {
+			scoped = append(scoped,
+				protocol.RESPObject{Type: protocol.BulkString, Value: "MATCH"},
+				protocol.RESPObject{Type: protocol.BulkString, Value: escapePattern(t.Prefix) + "*"})
+		}
+	case "SORT", "SORT_RO":
+		// BY and GET patterns name keys too; "#" is the element itself.
+		for i := 1; i+1 < len(args); i++ {
+			switch strings.ToUpper(args[i].Value.(string)) {
+			case "BY", "GET":
+				if pattern := args[i+1].Value.(string); pattern != "#" {
+					scoped[i+2] = protocol.RESPObject{Type: protocol.BulkString, Value: t.Prefix + pattern}
+				}
+				i++
+			case "LIMIT":
+				i += 2
+			case "STORE":
+				i++
+			}
+		}
+	}
+
+	if cmd.HasFlag("denyoom") {
+		if t.MaxMemory > 0 && t.memory.Load() >= t.MaxMemory {
+			return nil, &protocol.RESPObject{Type: protocol.Error, Value: "OOM tenant memory quota exceeded"}
+		}
+		if t.MaxKeys > 0 && t.keys.Load() >= t.MaxKeys {
+			for _, i := range keyIdx {
+				if !handler.KeyExists(scoped[i+1].Value.(string)) {
+					return nil, &protocol.RESPObject{Type: protocol.Error, Value: "OOM tenant key quota exceeded"}
+				}
+			}
+		}
+	}
+	return scoped, nil
+}
+
+// unscopeReply strips the tenant prefix from key names in replies.
+func unscopeReply(t *tenant, name string, reply protocol.RESPObject) protocol.RESPObject {
+	if reply.Type != protocol.Array {
+		return reply
+	}
+	switch name {
+	case "KEYS":
+		return stripPrefixes(t.Prefix, reply)
+	case "SCAN":
+		page := reply.Value.([]protocol.RESPObject)
+		return protocol.RESPObject{Type: protocol.Array, Value: []protocol.RESPObject{page[0], stripPrefixes(t.Prefix, page[1])}}
+	}
+	return reply
+}
+
+func stripPrefixes(prefix string, arr protocol.RESPObject) protocol.RESPObject {
+	keys, _ := arr.Value.([]protocol.RESPObject)
+	stripped := make([]protocol.RESPObject, len(keys))
+	for i, k := range keys {
+		stripped[i] = protocol.RESPObject{Type: protocol.BulkString, Value: strings.TrimPrefix(k.Value.(string), prefix)}
+	}
+	return protocol.RESPObject{Type: protocol.Array, Value: stripped}
+}
+
+func escapePattern(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// tenantLoop recomputes every tenant's key count and estimated memory.
+func (s *Server) tenantLoop() {
+	defer s.acceptWg.Done()
+	ticker := time.NewTicker(tenantUsageInterval)
+	defer ticker.Stop()
+	for {
+		s.measureTenants()
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) measureTenants() {
+	keys := map[*tenant]int64{}
+	memory := map[*tenant]int64{}
+	handler.Snapshot(func(e handler.Entry) bool {
+		for _, t := range s.tenants {
+			if strings.HasPrefix(e.Key, t.Prefix) {
+				keys[t]++
+				memory[t] += entrySize(e)
+				break
+			}
+		}
+		return true
+	})
+	for _, t := range s.tenants {
+		t.keys.Store(keys[t])
+		t.memory.Store(memory[t])
+	}
+}
+
+func entrySize(e handler.Entry) int64 {
+	size := int64(len(e.Key))
+	switch v := e.Value.(type) {
+	case string:
+		size += int64(len(v))
+	case map[string]string:
+		for f, fv := range v {
+			size += int64(len(f) + len(fv))
+		}
+	case []string:
+		for _, elem := range v {
+			size += int64(len(elem))
+		}
+	}
+	return size
+}
+
+func (s *Server) tenantInfo(only *tenant) [][2]string {
+	names := make([]string, 0, len(s.tenants))
+	for name := range s.tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields := [][2]string{}
+	for _, name := range names {
+		t := s.tenants[name]
+		if only != nil && t != only {
+			continue
+		}
+		fields = append(fields, [2]string{"tenant_" + name, fmt.Sprintf("prefix=%s,keys=%d,memory=%d,commands=%d,max_keys=%d,max_memory=%d",
+			t.Prefix, t.keys.Load(), t.memory.Load(), t.commands.Load(), t.MaxKeys, t.MaxMemory)})
+	}
+	return fields
+}
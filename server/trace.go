@@ -0,0 +1,54 @@
+package server
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/handler"
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+// trace logs a sample of commands as a logfmt line. Only key names are
+// logged, never values, so traces are safe to ship to a log pipeline.
+func (s *Server) trace(ri requestInfo, c *client, cmd *handler.Command, argv []protocol.RESPObject, result protocol.RESPObject, d time.Duration) {
+	rate := s.traceSampleRate.Load()
+	if rate <= 0 || s.traceCounter.Add(1)%uint64(rate) != 0 {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("trace cmd=")
+	b.WriteString(cmd.Name)
+	args := argv[1:]
+	if idx := cmd.KeyIndexes(args); len(idx) > 0 {
+		keys := make([]string, len(idx))
+		for i, k := range idx {
+			keys[i] = args[k].Value.(string)
+		}
+		b.WriteString(" keys=")
+		b.WriteString(strconv.Quote(strings.Join(keys, ",")))
+	}
+	b.WriteString(" argc=")
+	b.WriteString(strconv.Itoa(len(args)))
+	b.WriteString(" client_id=")
+	b.WriteString(strconv.FormatInt(ri.clientID, 10))
+	b.WriteString(" addr=")
+	b.WriteString(strconv.Quote(c.addr))
+	b.WriteString(" user=")
+	b.WriteString(ri.user)
+	if ri.requestID != "" {
+		b.WriteString(" request_id=")
+		b.WriteString(strconv.Quote(ri.requestID))
+	}
+	b.WriteString(" duration_us=")
+	b.WriteString(strconv.FormatInt(d.Microseconds(), 10))
+	if result.Type == protocol.Error {
+		b.WriteString(" outcome=error err=")
+		b.WriteString(strconv.Quote(result.Value.(string)))
+	} else {
+		b.WriteString(" outcome=ok")
+	}
+	log.Print(b.String())
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/handler"
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+// waitAOF implements WAITAOF numlocal numreplicas timeout: it blocks until
+// the client's last write has been fsynced to the local AOF. There are no
+// replicas, so a non-zero numreplicas always waits for the timeout, which
+// like WAIT is in milliseconds with 0 meaning forever.
+func (s *Server) waitAOF(c *client, args []protocol.RESPObject) protocol.RESPObject {
+	var n [3]int64
+	for i := range n {
+		v, err := strconv.ParseInt(args[i].Value.(string), 10, 64)
+		if err != nil {
+			return protocol.RESPObject{Type: protocol.Error, Value: handler.ErrInvalidInt}
+		}
+		n[i] = v
+	}
+	numLocal, numReplicas, timeout := n[0], n[1], n[2]
+	if timeout < 0 {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR timeout is negative"}
+	}
+	if numLocal > 0 && s.aof == nil {
+		return protocol.RESPObject{Type: protocol.Error, Value: "ERR WAITAOF cannot be used when numlocal is set but appendonly is disabled."}
+	}
+
+	ctx, cancel := s.blockingContext(c)
+	defer cancel()
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Millisecond)
+		defer cancel()
+	}
+
+	local := 0
+	if s.aof != nil {
+		if numLocal > 0 {
+			s.aof.WaitSynced(ctx, c.aofOffset)
+		}
+		if s.aof.SyncedOffset() >= c.aofOffset {
+			local = 1
+		}
+	}
+	if numReplicas > 0 {
+		<-ctx.Done()
+	}
+
+	return protocol.RESPObject{Type: protocol.Array, Value: []protocol.RESPObject{
+		{Type: protocol.Integer, Value: local},
+		{Type: protocol.Integer, Value: 0},
+	}}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/handler"
+)
+
+const (
+	webhookBuffer       = 4096
+	webhookRetryBackoff = 100 * time.Millisecond
+)
+
+// Webhook delivers keyspace events to an HTTP endpoint. Events are POSTed as
+// a JSON object {"events": [{"event": "set", "key": "k", "time": <unix ms>}]}
+// and a batch is retried on network errors, 429 and 5xx responses.
+type Webhook struct {
+	URL string
+	// Events limits delivery to these event names ("set", "hset", "del",
+	// "expired", ..., see handler.KeyspaceEvent); empty means every event.
+	Events []string
+	// Pattern is a glob matched against the key; empty matches every key.
+	Pattern string
+	// BatchSize caps the events per request (default 100) and BatchInterval
+	// is how long a partial batch waits before being sent (default 1s).
+	BatchSize     int
+	BatchInterval time.Duration
+	// MaxRetries is the number of retries after a failed delivery (default 3),
+	// with exponential backoff. The batch is dropped once they are exhausted.
+	MaxRetries int
+	// Timeout bounds each request (default 5s).
+	Timeout time.Duration
+}
+
+type webhookEvent struct {
+	Event string `json:"event"`
+	Key   string `json:"key"`
+	Time  int64  `json:"time"`
+}
+
+func (s *Server) startWebhooks() {
+	for _, wh := range s.cfg.Webhooks {
+		if wh.BatchSize <= 0 {
+			wh.BatchSize = 100
+		}
+		if wh.BatchInterval <= 0 {
+			wh.BatchInterval = time.Second
+		}
+		if wh.MaxRetries < 0 {
+			wh.MaxRetries = 0
+		} else if wh.MaxRetries == 0 {
+			wh.MaxRetries = 3
+		}
+		if wh.Timeout <= 0 {
+			wh.Timeout = 5 * time.Second
+		}
+		s.acceptWg.Add(1)
+		go s.webhookLoop(wh, handler.SubscribeEvents(webhookBuffer))
+	}
+}
+
+// webhookLoop subscribes before Start returns, so no event emitted while the
+// server is running is missed unless the buffer overflows during a slow
+// delivery. Pending events are flushed once, without retries, on shutdown.
+func (s *Server) webhookLoop(wh Webhook, ch chan handler.KeyspaceEvent) {
+	defer s.acceptWg.Done()
+	defer handler.UnsubscribeEvents(ch)
+
+	events := map[string]bool{}
+	for _, e := range wh.Events {
+		events[strings.ToLower(e)] = true
+	}
+	client := &http.Client{Timeout: wh.Timeout}
+	ticker := time.NewTicker(wh.BatchInterval)
+	defer ticker.Stop()
+
+	var batch []webhookEvent
+	for {
+		select {
+		case <-s.done:
+			if len(batch) > 0 {
+				s.deliverWebhook(client, wh.URL, batch, 0)
+			}
+			return
+		case <-ticker.C:
+			if len(batch) > 0 {
+				s.deliverWebhook(client, wh.URL, batch, wh.MaxRetries)
+				batch = nil
+			}
+		case ev := <-ch:
+			if len(events) > 0 && !events[ev.Event] {
+				continue
+			}
+			if wh.Pattern != "" && !handler.MatchPattern(wh.Pattern, ev.Key) {
+				continue
+			}
+			batch = append(batch, webhookEvent{Event: ev.Event, Key: ev.Key, Time: time.Now().UnixMilli()})
+			if len(batch) >= wh.BatchSize {
+				s.deliverWebhook(client, wh.URL, batch, wh.MaxRetries)
+				batch = nil
+			}
+		}
+	}
+}
+
+func (s *Server) deliverWebhook(client *http.Client, target string, batch []webhookEvent, retries int) {
+	body, err := json.Marshal(map[string]interface{}{"events": batch})
+	if err != nil {
+		log.Printf("Error encoding webhook payload: %v", err)
+		return
+	}
+
+	backoff := webhookRetryBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := postWebhook(client, target, body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= retries {
+			log.Printf("Dropping %d events for webhook %s: %v", len(batch), target, err)
+			return
+		}
+		select {
+		case <-s.done:
+			retries = 0
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+}
+
+// postWebhook reports whether a failed delivery is worth retrying.
+func postWebhook(client *http.Client, target string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected status %s", resp.Status)
+	case resp.StatusCode >= 300:
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return false, nil
+}
+
+func validateWebhooks(hooks []Webhook) error {
+	for _, wh := range hooks {
+		u, err := url.Parse(wh.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid webhook URL %q", wh.URL)
+		}
+	}
+	return nil
+}
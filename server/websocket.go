@@ -0,0 +1,274 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ashish-kamra/redis-clone/internal/protocol"
+)
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// handleWebSocket upgrades /ws requests. With the default "resp" subprotocol
+// the socket carries a raw RESP byte stream and is served like any TCP
+// connection; with the "json" subprotocol every text message is a JSON array
+// command answered with the same JSON shape as the HTTP gateway.
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet || !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "expected a WebSocket upgrade"})
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "unsupported WebSocket version"})
+		return
+	}
+
+	subprotocol := ""
+	for _, p := range strings.Split(r.Header.Get("Sec-WebSocket-Protocol"), ",") {
+		if p = strings.TrimSpace(p); p == "resp" || p == "json" {
+			subprotocol = p
+			break
+		}
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "connection cannot be upgraded"})
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		log.Printf("Error upgrading WebSocket connection: %v", err)
+		return
+	}
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n"
+	if subprotocol != "" {
+		resp += "Sec-WebSocket-Protocol: " + subprotocol + "\r\n"
+	}
+	if _, err := rw.WriteString(resp + "\r\n"); err != nil || rw.Flush() != nil {
+		conn.Close()
+		return
+	}
+
+	ws := &wsConn{Conn: conn, rd: rw.Reader}
+	if subprotocol == "json" {
+		s.serveJSONWebSocket(ws)
+		return
+	}
+	s.ServeConn(ws)
+}
+
+func (s *Server) serveJSONWebSocket(ws *wsConn) {
+	c := newClient(context.Background(), ws, addrString(ws.RemoteAddr()))
+	if !s.trackClient(c) {
+		ws.Close()
+		return
+	}
+	defer s.untrackClient(c)
+	defer ws.Close()
+
+	for {
+		msg, err := ws.readMessage()
+		if err != nil {
+			return
+		}
+
+		var reply interface{}
+		var args []string
+		if err := json.Unmarshal(msg, &args); err != nil || len(args) == 0 {
+			reply = map[string]interface{}{"error": "expected a non-empty JSON array of strings"}
+		} else if result := s.execArgs(c, args...); result.Type == protocol.Error {
+			reply = map[string]interface{}{"error": result.Value}
+		} else {
+			reply = map[string]interface{}{"result": toJSON(result)}
+		}
+
+		data, _ := json.Marshal(reply)
+		if err := ws.writeFrame(wsText, data); err != nil || c.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn adapts a WebSocket to net.Conn, so RESP connections can be served
+// over it unchanged. Reads return the payloads of data frames as one byte
+// stream; writes are sent as binary frames.
+type wsConn struct {
+	net.Conn
+	rd      *bufio.Reader
+	pending []byte
+	wmu     sync.Mutex
+	closed  bool
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		msg, err := c.readMessage()
+		if err != nil {
+			return 0, err
+		}
+		c.pending = msg
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(wsBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) Close() error {
+	c.wmu.Lock()
+	if !c.closed {
+		c.closed = true
+		c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+		c.Conn.Write([]byte{0x80 | wsClose, 0})
+	}
+	c.wmu.Unlock()
+	return c.Conn.Close()
+}
+
+// readMessage returns the next complete data message, answering pings and
+// close frames along the way.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return nil, err
+			}
+		case wsPong:
+		case wsClose:
+			c.Close()
+			return nil, io.EOF
+		case wsText, wsBinary, wsContinuation:
+			msg = append(msg, payload...)
+			if len(msg) > maxHTTPBody {
+				return nil, errors.New("websocket message too large")
+			}
+			if fin {
+				return msg, nil
+			}
+		default:
+			return nil, fmt.Errorf("unknown websocket opcode %d", opcode)
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (bool, byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.rd, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, opcode := header[0]&0x80 != 0, header[0]&0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.rd, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.rd, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxHTTPBody {
+		return false, 0, nil, errors.New("websocket frame too large")
+	}
+	if !masked {
+		return false, 0, nil, errors.New("client websocket frames must be masked")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.rd, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.rd, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	if _, err := c.Conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}